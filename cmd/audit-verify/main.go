@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/database"
+	"github.com/franzego/stage08/internal/logger"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/joho/godotenv"
+)
+
+// Walks the audit log and checks that the hash chain is intact.
+// Exits non-zero if any event was modified, removed or reordered.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using system environment variables")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
+
+	appLogger, err := logger.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal("Failed to initialize logger:", err)
+	}
+
+	db, err := database.Connect(&cfg.Database, appLogger)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	recorder := audit.NewRecorder(repository.NewAuditRepository(db, appLogger), appLogger)
+
+	checked, err := recorder.VerifyChain(context.Background())
+	if err != nil {
+		log.Fatalf("❌ Audit chain broken after %d events: %v", checked, err)
+	}
+
+	log.Printf("✅ Audit chain intact (%d events)", checked)
+}
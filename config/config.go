@@ -2,30 +2,56 @@ package config
 
 import (
 	"fmt"
+	netmail "net/mail"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	Google   GoogleOAuthConfig
-	Paystack PaystackConfig
+	Server      ServerConfig
+	Database    DatabaseConfig
+	JWT         JWTConfig
+	Google      GoogleOAuthConfig
+	Payments    PaymentsConfig
+	Paystack    PaystackConfig
+	Flutterwave FlutterwaveConfig
+	TwoFactor   TwoFactorConfig
+	Log         LogConfig
+	Tracing     TracingConfig
+	Health      HealthConfig
+	Webhooks    WebhooksConfig
+	Scheduler   SchedulerConfig
+	Batches     BatchesConfig
+	Mail        MailConfig
+	Admin       AdminConfig
 }
 
 type ServerConfig struct {
-	Port string
+	Port              string
+	PublicURL         string        // Base URL clients reach the service at, used in payment links
+	MetricsToken      string        // Bearer token required to scrape /metrics (optional)
+	RequestTimeout    time.Duration // Deadline for handling a request, inherited by DB and Paystack calls
+	ReadTimeout       time.Duration // Max time to read a whole request, including the body
+	ReadHeaderTimeout time.Duration // Max time to read request headers
+	WriteTimeout      time.Duration // Max time to write a response
+	IdleTimeout       time.Duration // Max time to keep an idle keep-alive connection
+	ShutdownTimeout   time.Duration // How long to drain in-flight requests on shutdown
+	TLSCertFile       string        // Serve HTTPS when both cert and key are set
+	TLSKeyFile        string
+	TrustedProxies    []string // Proxies (IPs or CIDRs) whose X-Forwarded-For is believed; none by default
 }
 
 type DatabaseConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
+	Host             string
+	Port             int
+	User             string
+	Password         string
+	DBName           string
+	SSLMode          string
+	StatementTimeout time.Duration // Server-side limit for a single statement (0 disables)
 }
 
 type JWTConfig struct {
@@ -37,11 +63,122 @@ type GoogleOAuthConfig struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+	Timeout      time.Duration // Deadline for token exchange and user info calls
 }
 
+type PaymentsConfig struct {
+	DefaultProvider string // Provider used when a deposit doesn't name one
+}
+
+// Payment providers
+const (
+	ProviderPaystack    = "paystack"
+	ProviderFlutterwave = "flutterwave"
+)
+
 type PaystackConfig struct {
-	SecretKey string
-	PublicKey string
+	Mode         string // live or simulator
+	SecretKey    string
+	PublicKey    string
+	BaseURL      string
+	Timeout      time.Duration // Deadline for a single Paystack HTTP attempt
+	MaxRetries   int           // Extra attempts for idempotent calls (e.g. verify)
+	RetryBackoff time.Duration // Delay before the first retry, doubled on each retry
+	SimulatorURL string        // Base URL the simulator's checkout pages and webhooks use
+
+	WebhookAllowedIPs []netip.Prefix // Webhook source addresses; nil accepts any
+}
+
+type FlutterwaveConfig struct {
+	SecretKey    string // Flutterwave is enabled when set
+	SecretHash   string // Sent by Flutterwave in the verif-hash header of every webhook
+	BaseURL      string
+	RedirectURL  string        // Where checkout sends the customer when they are done (optional)
+	Timeout      time.Duration // Deadline for a single Flutterwave HTTP attempt
+	MaxRetries   int           // Extra attempts for idempotent calls (e.g. verify)
+	RetryBackoff time.Duration // Delay before the first retry, doubled on each retry
+
+	WebhookAllowedIPs []netip.Prefix // Webhook source addresses; nil accepts any
+}
+
+// Enabled reports whether Flutterwave is configured
+func (c *FlutterwaveConfig) Enabled() bool {
+	return c.SecretKey != ""
+}
+
+// Paystack modes: live talks to the Paystack API, simulator fakes checkout in-process
+const (
+	PaystackModeLive      = "live"
+	PaystackModeSimulator = "simulator"
+)
+
+// PaystackWebhookIPs are the addresses Paystack publishes for webhook delivery
+const PaystackWebhookIPs = "52.31.139.75,52.49.173.169,52.214.14.220"
+
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // json or text
+}
+
+type TracingConfig struct {
+	Exporter    string  // none, stdout or otlp
+	ServiceName string  // service.name resource attribute
+	SampleRatio float64 // Fraction of new traces to sample (0-1)
+}
+
+type WebhooksConfig struct {
+	PollInterval time.Duration // How often the worker looks for due webhook events
+	MaxAttempts  int           // Processing attempts before an event is marked failed
+	Tolerance    time.Duration // Max age of a webhook's paid_at/created_at (0 disables)
+}
+
+type SchedulerConfig struct {
+	PollInterval time.Duration // How often the leader looks for due scheduled transfers
+	RetryDelay   time.Duration // Wait before retrying a run that failed for insufficient funds
+	MaxFailures  int           // Consecutive insufficient-funds failures before a schedule is paused
+	LockKey      int64         // Postgres advisory lock key; instances sharing it elect one scheduler
+}
+
+// DefaultSchedulerLockKey is the advisory lock key the scheduler leader holds by default
+const DefaultSchedulerLockKey int64 = 0x5754_5343 // "WTSC"
+
+type BatchesConfig struct {
+	MaxItems     int           // Most transfers accepted in one batch
+	PollInterval time.Duration // How often the worker looks for unfinished batches
+}
+
+type MailConfig struct {
+	SMTPHost     string // Emails are sent through this server when set, and logged otherwise
+	SMTPPort     int
+	SMTPUsername string // Optional; authenticates with PLAIN, which needs TLS unless the server is local
+	SMTPPassword string
+	From         string        // Sender, e.g. Wallet Service <no-reply@example.com>
+	PollInterval time.Duration // How often the worker looks for queued emails
+	MaxAttempts  int           // Sending attempts before an email is marked failed
+}
+
+// Enabled reports whether a mail server is configured
+func (c *MailConfig) Enabled() bool {
+	return c.SMTPHost != ""
+}
+
+type AdminConfig struct {
+	Emails []string // Users allowed to use /admin endpoints
+}
+
+type HealthConfig struct {
+	CheckTimeout   time.Duration // Per-check deadline for /readyz
+	CheckProviders bool          // Include payment provider reachability in /readyz
+}
+
+type TwoFactorConfig struct {
+	Issuer       string        // Shown in authenticator apps
+	ChallengeTTL time.Duration // How long a login challenge token stays valid
+	MaxAge       time.Duration // How recent a 2FA check must be for sensitive routes
+	Required     bool          // Block sensitive routes for users without 2FA
+
+	MaxAttempts int           // Failed code checks allowed before verification is locked
+	Lockout     time.Duration // How long verification stays locked
 }
 
 // Load configuration from environment variables
@@ -51,17 +188,228 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid DB_PORT: %w", err)
 	}
 
+	twoFactorMaxAge, err := time.ParseDuration(getEnv("TWO_FACTOR_MAX_AGE", "15m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TWO_FACTOR_MAX_AGE: %w", err)
+	}
+
+	twoFactorRequired, err := strconv.ParseBool(getEnv("TWO_FACTOR_REQUIRED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TWO_FACTOR_REQUIRED: %w", err)
+	}
+
+	twoFactorMaxAttempts, err := strconv.Atoi(getEnv("TWO_FACTOR_MAX_ATTEMPTS", "5"))
+	if err != nil || twoFactorMaxAttempts < 1 {
+		return nil, fmt.Errorf("invalid TWO_FACTOR_MAX_ATTEMPTS: must be a positive integer")
+	}
+
+	twoFactorLockout, err := time.ParseDuration(getEnv("TWO_FACTOR_LOCKOUT", "15m"))
+	if err != nil || twoFactorLockout <= 0 {
+		return nil, fmt.Errorf("invalid TWO_FACTOR_LOCKOUT: must be a positive duration")
+	}
+
+	sampleRatio, err := strconv.ParseFloat(getEnv("TRACING_SAMPLE_RATIO", "1"), 64)
+	if err != nil || sampleRatio < 0 || sampleRatio > 1 {
+		return nil, fmt.Errorf("invalid TRACING_SAMPLE_RATIO: must be between 0 and 1")
+	}
+
+	readTimeout, err := time.ParseDuration(getEnv("SERVER_READ_TIMEOUT", "15s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SERVER_READ_TIMEOUT: %w", err)
+	}
+
+	readHeaderTimeout, err := time.ParseDuration(getEnv("SERVER_READ_HEADER_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SERVER_READ_HEADER_TIMEOUT: %w", err)
+	}
+
+	writeTimeout, err := time.ParseDuration(getEnv("SERVER_WRITE_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SERVER_WRITE_TIMEOUT: %w", err)
+	}
+
+	idleTimeout, err := time.ParseDuration(getEnv("SERVER_IDLE_TIMEOUT", "60s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SERVER_IDLE_TIMEOUT: %w", err)
+	}
+
+	shutdownTimeout, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+	}
+
+	requestTimeout, err := time.ParseDuration(getEnv("REQUEST_TIMEOUT", "25s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REQUEST_TIMEOUT: %w", err)
+	}
+
+	statementTimeout, err := time.ParseDuration(getEnv("DB_STATEMENT_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_STATEMENT_TIMEOUT: %w", err)
+	}
+
+	googleTimeout, err := time.ParseDuration(getEnv("GOOGLE_HTTP_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GOOGLE_HTTP_TIMEOUT: %w", err)
+	}
+
+	paystackTimeout, err := time.ParseDuration(getEnv("PAYSTACK_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PAYSTACK_TIMEOUT: %w", err)
+	}
+
+	paystackMaxRetries, err := strconv.Atoi(getEnv("PAYSTACK_MAX_RETRIES", "2"))
+	if err != nil || paystackMaxRetries < 0 {
+		return nil, fmt.Errorf("invalid PAYSTACK_MAX_RETRIES: must be a non-negative integer")
+	}
+
+	paystackRetryBackoff, err := time.ParseDuration(getEnv("PAYSTACK_RETRY_BACKOFF", "200ms"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PAYSTACK_RETRY_BACKOFF: %w", err)
+	}
+
+	paystackMode := getEnv("PAYSTACK_MODE", PaystackModeLive)
+
+	// The simulator delivers webhooks from wherever the service runs
+	defaultWebhookIPs := PaystackWebhookIPs
+	if paystackMode == PaystackModeSimulator {
+		defaultWebhookIPs = "*"
+	}
+	var paystackWebhookIPs []netip.Prefix
+	if webhookIPs := getEnv("PAYSTACK_WEBHOOK_IPS", defaultWebhookIPs); webhookIPs != "*" {
+		paystackWebhookIPs, err = parsePrefixes(webhookIPs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PAYSTACK_WEBHOOK_IPS: %w", err)
+		}
+	}
+
+	flutterwaveTimeout, err := time.ParseDuration(getEnv("FLUTTERWAVE_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FLUTTERWAVE_TIMEOUT: %w", err)
+	}
+
+	flutterwaveMaxRetries, err := strconv.Atoi(getEnv("FLUTTERWAVE_MAX_RETRIES", "2"))
+	if err != nil || flutterwaveMaxRetries < 0 {
+		return nil, fmt.Errorf("invalid FLUTTERWAVE_MAX_RETRIES: must be a non-negative integer")
+	}
+
+	flutterwaveRetryBackoff, err := time.ParseDuration(getEnv("FLUTTERWAVE_RETRY_BACKOFF", "200ms"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FLUTTERWAVE_RETRY_BACKOFF: %w", err)
+	}
+
+	// Flutterwave doesn't publish webhook addresses; the verif-hash is the check
+	var flutterwaveWebhookIPs []netip.Prefix
+	if webhookIPs := getEnv("FLUTTERWAVE_WEBHOOK_IPS", "*"); webhookIPs != "*" {
+		flutterwaveWebhookIPs, err = parsePrefixes(webhookIPs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLUTTERWAVE_WEBHOOK_IPS: %w", err)
+		}
+	}
+
+	webhookTolerance, err := time.ParseDuration(getEnv("WEBHOOK_TOLERANCE", "96h"))
+	if err != nil || webhookTolerance < 0 {
+		return nil, fmt.Errorf("invalid WEBHOOK_TOLERANCE: must be a non-negative duration")
+	}
+
+	trustedProxies := splitList(getEnv("TRUSTED_PROXIES", ""))
+	if _, err := parsePrefixes(strings.Join(trustedProxies, ",")); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	healthCheckTimeout, err := time.ParseDuration(getEnv("HEALTH_CHECK_TIMEOUT", "2s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEALTH_CHECK_TIMEOUT: %w", err)
+	}
+
+	healthCheckProviders, err := strconv.ParseBool(getEnv("HEALTH_CHECK_PROVIDERS", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEALTH_CHECK_PROVIDERS: %w", err)
+	}
+
+	webhookPollInterval, err := time.ParseDuration(getEnv("WEBHOOK_POLL_INTERVAL", "5s"))
+	if err != nil || webhookPollInterval <= 0 {
+		return nil, fmt.Errorf("invalid WEBHOOK_POLL_INTERVAL: must be a positive duration")
+	}
+
+	webhookMaxAttempts, err := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "8"))
+	if err != nil || webhookMaxAttempts < 1 {
+		return nil, fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS: must be a positive integer")
+	}
+
+	schedulerPollInterval, err := time.ParseDuration(getEnv("SCHEDULER_POLL_INTERVAL", "30s"))
+	if err != nil || schedulerPollInterval <= 0 {
+		return nil, fmt.Errorf("invalid SCHEDULER_POLL_INTERVAL: must be a positive duration")
+	}
+
+	schedulerRetryDelay, err := time.ParseDuration(getEnv("SCHEDULER_RETRY_DELAY", "1h"))
+	if err != nil || schedulerRetryDelay <= 0 {
+		return nil, fmt.Errorf("invalid SCHEDULER_RETRY_DELAY: must be a positive duration")
+	}
+
+	schedulerMaxFailures, err := strconv.Atoi(getEnv("SCHEDULER_MAX_FAILURES", "3"))
+	if err != nil || schedulerMaxFailures < 1 {
+		return nil, fmt.Errorf("invalid SCHEDULER_MAX_FAILURES: must be a positive integer")
+	}
+
+	schedulerLockKey, err := strconv.ParseInt(getEnv("SCHEDULER_LOCK_KEY", strconv.FormatInt(DefaultSchedulerLockKey, 10)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCHEDULER_LOCK_KEY: must be an integer")
+	}
+
+	batchMaxItems, err := strconv.Atoi(getEnv("BATCH_MAX_ITEMS", "1000"))
+	if err != nil || batchMaxItems < 1 {
+		return nil, fmt.Errorf("invalid BATCH_MAX_ITEMS: must be a positive integer")
+	}
+
+	batchPollInterval, err := time.ParseDuration(getEnv("BATCH_POLL_INTERVAL", "5s"))
+	if err != nil || batchPollInterval <= 0 {
+		return nil, fmt.Errorf("invalid BATCH_POLL_INTERVAL: must be a positive duration")
+	}
+
+	smtpPort, err := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+	}
+
+	mailPollInterval, err := time.ParseDuration(getEnv("MAIL_POLL_INTERVAL", "10s"))
+	if err != nil || mailPollInterval <= 0 {
+		return nil, fmt.Errorf("invalid MAIL_POLL_INTERVAL: must be a positive duration")
+	}
+
+	mailMaxAttempts, err := strconv.Atoi(getEnv("MAIL_MAX_ATTEMPTS", "8"))
+	if err != nil || mailMaxAttempts < 1 {
+		return nil, fmt.Errorf("invalid MAIL_MAX_ATTEMPTS: must be a positive integer")
+	}
+
+	var adminEmails []string
+	for _, email := range splitList(getEnv("ADMIN_EMAILS", "")) {
+		adminEmails = append(adminEmails, strings.ToLower(email))
+	}
+
 	cfg := &Config{
 		Server: ServerConfig{
-			Port: getEnv("PORT", "8080"),
+			Port:              getEnv("PORT", "8080"),
+			PublicURL:         strings.TrimSuffix(getEnv("PUBLIC_BASE_URL", "http://localhost:"+getEnv("PORT", "8080")), "/"),
+			MetricsToken:      getEnv("METRICS_TOKEN", ""),
+			RequestTimeout:    requestTimeout,
+			ReadTimeout:       readTimeout,
+			ReadHeaderTimeout: readHeaderTimeout,
+			WriteTimeout:      writeTimeout,
+			IdleTimeout:       idleTimeout,
+			ShutdownTimeout:   shutdownTimeout,
+			TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
+			TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
+			TrustedProxies:    trustedProxies,
 		},
 		Database: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     dbPort,
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", ""),
-			DBName:   getEnv("DB_NAME", "wallet_service"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+			Host:             getEnv("DB_HOST", "localhost"),
+			Port:             dbPort,
+			User:             getEnv("DB_USER", "postgres"),
+			Password:         getEnv("DB_PASSWORD", ""),
+			DBName:           getEnv("DB_NAME", "wallet_service"),
+			SSLMode:          getEnv("DB_SSLMODE", "disable"),
+			StatementTimeout: statementTimeout,
 		},
 		JWT: JWTConfig{
 			Secret:     getEnv("JWT_SECRET", ""),
@@ -71,33 +419,174 @@ func Load() (*Config, error) {
 			ClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
 			ClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
 			RedirectURL:  getEnv("GOOGLE_REDIRECT_URL", ""),
+			Timeout:      googleTimeout,
+		},
+		Payments: PaymentsConfig{
+			DefaultProvider: getEnv("PAYMENT_DEFAULT_PROVIDER", ProviderPaystack),
 		},
 		Paystack: PaystackConfig{
-			SecretKey: getEnv("PAYSTACK_SECRET_KEY", ""),
-			PublicKey: getEnv("PAYSTACK_PUBLIC_KEY", ""),
+			Mode:         paystackMode,
+			SecretKey:    getEnv("PAYSTACK_SECRET_KEY", ""),
+			PublicKey:    getEnv("PAYSTACK_PUBLIC_KEY", ""),
+			BaseURL:      getEnv("PAYSTACK_BASE_URL", "https://api.paystack.co"),
+			Timeout:      paystackTimeout,
+			MaxRetries:   paystackMaxRetries,
+			RetryBackoff: paystackRetryBackoff,
+			SimulatorURL: getEnv("PAYSTACK_SIMULATOR_URL", "http://localhost:"+getEnv("PORT", "8080")),
+
+			WebhookAllowedIPs: paystackWebhookIPs,
+		},
+		Flutterwave: FlutterwaveConfig{
+			SecretKey:    getEnv("FLUTTERWAVE_SECRET_KEY", ""),
+			SecretHash:   getEnv("FLUTTERWAVE_SECRET_HASH", ""),
+			BaseURL:      getEnv("FLUTTERWAVE_BASE_URL", "https://api.flutterwave.com/v3"),
+			RedirectURL:  getEnv("FLUTTERWAVE_REDIRECT_URL", ""),
+			Timeout:      flutterwaveTimeout,
+			MaxRetries:   flutterwaveMaxRetries,
+			RetryBackoff: flutterwaveRetryBackoff,
+
+			WebhookAllowedIPs: flutterwaveWebhookIPs,
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:       getEnv("TWO_FACTOR_ISSUER", "Wallet Service"),
+			ChallengeTTL: 5 * time.Minute,
+			MaxAge:       twoFactorMaxAge,
+			Required:     twoFactorRequired,
+			MaxAttempts:  twoFactorMaxAttempts,
+			Lockout:      twoFactorLockout,
+		},
+		Log: LogConfig{
+			Level:  getEnv("LOG_LEVEL", "info"),
+			Format: getEnv("LOG_FORMAT", "json"),
+		},
+		Tracing: TracingConfig{
+			Exporter:    getEnv("TRACING_EXPORTER", "none"),
+			ServiceName: getEnv("OTEL_SERVICE_NAME", "wallet-service"),
+			SampleRatio: sampleRatio,
+		},
+		Health: HealthConfig{
+			CheckTimeout:   healthCheckTimeout,
+			CheckProviders: healthCheckProviders,
+		},
+		Webhooks: WebhooksConfig{
+			PollInterval: webhookPollInterval,
+			MaxAttempts:  webhookMaxAttempts,
+			Tolerance:    webhookTolerance,
+		},
+		Scheduler: SchedulerConfig{
+			PollInterval: schedulerPollInterval,
+			RetryDelay:   schedulerRetryDelay,
+			MaxFailures:  schedulerMaxFailures,
+			LockKey:      schedulerLockKey,
+		},
+		Batches: BatchesConfig{
+			MaxItems:     batchMaxItems,
+			PollInterval: batchPollInterval,
+		},
+		Mail: MailConfig{
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     smtpPort,
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			From:         getEnv("MAIL_FROM", "Wallet Service <no-reply@localhost>"),
+			PollInterval: mailPollInterval,
+			MaxAttempts:  mailMaxAttempts,
+		},
+		Admin: AdminConfig{
+			Emails: adminEmails,
 		},
 	}
 
 	// Validate required fields
+	if _, err := netmail.ParseAddress(cfg.Mail.From); err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
 	if cfg.JWT.Secret == "" {
 		return nil, fmt.Errorf("JWT_SECRET is required")
 	}
 	if cfg.Google.ClientID == "" || cfg.Google.ClientSecret == "" {
 		return nil, fmt.Errorf("Google OAuth credentials are required")
 	}
-	if cfg.Paystack.SecretKey == "" {
-		return nil, fmt.Errorf("Paystack secret key is required")
+	switch cfg.Paystack.Mode {
+	case PaystackModeLive:
+		if cfg.Paystack.SecretKey == "" {
+			return nil, fmt.Errorf("Paystack secret key is required")
+		}
+	case PaystackModeSimulator:
+		// Webhooks are signed and checked with this key; any value works locally
+		if cfg.Paystack.SecretKey == "" {
+			cfg.Paystack.SecretKey = "sk_test_simulator"
+		}
+	default:
+		return nil, fmt.Errorf("invalid PAYSTACK_MODE: must be %s or %s", PaystackModeLive, PaystackModeSimulator)
+	}
+	if cfg.Flutterwave.Enabled() && cfg.Flutterwave.SecretHash == "" {
+		return nil, fmt.Errorf("FLUTTERWAVE_SECRET_HASH is required when FLUTTERWAVE_SECRET_KEY is set")
+	}
+	switch cfg.Payments.DefaultProvider {
+	case ProviderPaystack:
+	case ProviderFlutterwave:
+		if !cfg.Flutterwave.Enabled() {
+			return nil, fmt.Errorf("PAYMENT_DEFAULT_PROVIDER is flutterwave but FLUTTERWAVE_SECRET_KEY is not set")
+		}
+	default:
+		return nil, fmt.Errorf("invalid PAYMENT_DEFAULT_PROVIDER: must be %s or %s", ProviderPaystack, ProviderFlutterwave)
+	}
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
 	}
 
 	return cfg, nil
 }
 
+// TLSEnabled reports whether the server should serve HTTPS
+func (c *ServerConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
 // GetDSN returns PostgreSQL connection string
 func (c *DatabaseConfig) GetDSN() string {
-	return fmt.Sprintf(
+	dsn := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode,
 	)
+	if c.StatementTimeout > 0 {
+		dsn += fmt.Sprintf(" options='-c statement_timeout=%d'", c.StatementTimeout.Milliseconds())
+	}
+	return dsn
+}
+
+// splitList splits a comma-separated value, dropping blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePrefixes parses a comma-separated list of IPs and CIDRs. A bare IP matches only itself.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(value) {
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,153 @@
+// Package apiclient is the HTTP round trip shared by the payment provider clients: bearer
+// authentication, a timeout per attempt and retries with backoff for idempotent requests.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/franzego/stage08/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config describes a payment provider's API
+type Config struct {
+	Name         string // Provider name, used in span names and errors
+	BaseURL      string
+	SecretKey    string // Sent as a bearer token
+	Timeout      time.Duration
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Observe records the latency and outcome of each call, retries included
+	Observe func(operation string, duration time.Duration, err error)
+}
+
+// Client sends authenticated JSON requests to a payment provider's API. The provider clients
+// build on it; it is safe for concurrent use and should be shared so connections are pooled.
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+}
+
+func New(cfg Config) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 20
+	transport.TLSHandshakeTimeout = 5 * time.Second
+	transport.ResponseHeaderTimeout = cfg.Timeout
+
+	return &Client{
+		cfg: cfg,
+		// The traced transport adds an HTTP client span and a traceparent header to API calls.
+		// Requests outside a trace (e.g. reachability probes) are not traced.
+		httpClient: &http.Client{Transport: otelhttp.NewTransport(transport,
+			otelhttp.WithFilter(func(r *http.Request) bool {
+				return trace.SpanContextFromContext(r.Context()).IsValid()
+			}),
+		)},
+	}
+}
+
+// Do sends a request and passes the response body to decode, recording a span and the call's
+// outcome. Idempotent requests are retried with exponential backoff on network errors, 429
+// and 5xx responses; errors from decode are final.
+func (c *Client) Do(ctx context.Context, operation, method, path string, body []byte, idempotent bool, decode func([]byte) error) (err error) {
+	ctx, span := tracing.Start(ctx, c.cfg.Name+"."+operation,
+		attribute.String(c.cfg.Name+".operation", operation),
+	)
+	start := time.Now()
+	defer func() {
+		if c.cfg.Observe != nil {
+			c.cfg.Observe(operation, time.Since(start), err)
+		}
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	attempts := 1
+	if idempotent {
+		attempts += c.cfg.MaxRetries
+	}
+
+	backoff := c.cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := c.attempt(ctx, method, path, body, decode)
+		if err == nil || !retryable || attempt >= attempts {
+			span.SetAttributes(attribute.Int(c.cfg.Name+".attempts", attempt))
+			return err
+		}
+
+		span.AddEvent("retry", trace.WithAttributes(attribute.String("error", err.Error())))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("%w (retry cancelled: %v)", err, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// attempt performs a single HTTP round trip bounded by the client timeout.
+// It reports whether a failure is worth retrying.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, decode func([]byte) error) (bool, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(attemptCtx, method, c.cfg.BaseURL+path, reader)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.cfg.SecretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Retry attempt timeouts and connection errors, but not once the caller has gone away
+		return ctx.Err() == nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return true, fmt.Errorf("%s returned %d", c.cfg.Name, resp.StatusCode)
+	}
+
+	return false, decode(respBody)
+}
+
+// Ping checks that the API is reachable. Any response below 500 counts as reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.cfg.BaseURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s unreachable: %w", c.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s returned %d", c.cfg.Name, resp.StatusCode)
+	}
+
+	return nil
+}
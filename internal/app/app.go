@@ -0,0 +1,590 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/flutterwave"
+	"github.com/franzego/stage08/internal/handlers"
+	"github.com/franzego/stage08/internal/health"
+	"github.com/franzego/stage08/internal/mail"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/payment"
+	"github.com/franzego/stage08/internal/paystack"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/franzego/stage08/internal/tracing"
+	"github.com/franzego/stage08/internal/worker"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// App is the wired service: the HTTP router and the background workers behind it
+type App struct {
+	Router  *gin.Engine
+	Workers *worker.Group
+}
+
+// New builds repositories, services and handlers on top of a migrated database and
+// registers every route. It is used by main and by the integration tests.
+func New(cfg *config.Config, db *sqlx.DB, logger *slog.Logger) *App {
+	// Initialize repositories
+	userRepo := repository.NewUserRepository(db, logger)
+	apiKeyRepo := repository.NewAPIKeyRepository(db, logger)
+	walletRepo := repository.NewWalletRepository(db, logger)
+	txRepo := repository.NewTransactionRepository(db, logger)
+	twoFactorRepo := repository.NewTwoFactorRepository(db, logger)
+	auditRepo := repository.NewAuditRepository(db, logger)
+	webhookRepo := repository.NewWebhookEventRepository(db, logger)
+	paymentMethodRepo := repository.NewPaymentMethodRepository(db, logger)
+	scheduledTransferRepo := repository.NewScheduledTransferRepository(db, logger)
+	paymentRequestRepo := repository.NewPaymentRequestRepository(db, logger)
+	paymentLinkRepo := repository.NewPaymentLinkRepository(db, logger)
+	transferBatchRepo := repository.NewTransferBatchRepository(db, logger)
+	disputeRepo := repository.NewDisputeRepository(db, logger)
+	escrowRepo := repository.NewEscrowRepository(db, logger)
+	orgRepo := repository.NewOrganizationRepository(db, logger)
+	emailRepo := repository.NewEmailRepository(db, logger)
+	txManager := repository.NewTxManager(db, logger)
+
+	// Initialize audit recorder
+	auditor := audit.NewRecorder(auditRepo, logger)
+
+	// Background workers are stopped together on shutdown
+	workers := worker.NewGroup(logger)
+
+	// Initialize Paystack client, or the in-process simulator for local development
+	var paystackClient paystack.API
+	var simulator *paystack.Simulator
+	if cfg.Paystack.Mode == config.PaystackModeSimulator {
+		logger.Warn("Paystack simulator enabled; payments are approved by hand and no real money moves", "checkout_url", cfg.Paystack.SimulatorURL+"/simulator/checkout/")
+		simulator = paystack.NewSimulator(cfg.Paystack.SecretKey, cfg.Paystack.SimulatorURL, logger)
+		paystackClient = simulator
+	} else {
+		paystackClient = paystack.NewClient(&cfg.Paystack)
+	}
+
+	// Payment providers deposits can be made through; Flutterwave only when configured
+	providerList := []payment.Provider{payment.NewPaystack(paystackClient)}
+	webhookAllowedIPs := map[string][]netip.Prefix{config.ProviderPaystack: cfg.Paystack.WebhookAllowedIPs}
+	if cfg.Flutterwave.Enabled() {
+		providerList = append(providerList, payment.NewFlutterwave(flutterwave.NewClient(&cfg.Flutterwave)))
+		webhookAllowedIPs[config.ProviderFlutterwave] = cfg.Flutterwave.WebhookAllowedIPs
+	}
+	providers := payment.NewRegistry(cfg.Payments.DefaultProvider, providerList...)
+
+	// Initialize readiness checks
+	healthChecker := health.NewChecker(cfg.Health.CheckTimeout)
+	healthChecker.AddCheck("database", true, health.DatabaseCheck(db))
+	healthChecker.AddCheck("migrations", true, health.MigrationsCheck(db))
+	if cfg.Health.CheckProviders {
+		// A provider being down shouldn't take every instance out of rotation
+		for _, provider := range providers.All() {
+			healthChecker.AddCheck(provider.Name(), false, health.ProviderCheck(provider))
+		}
+	}
+
+	// Outgoing emails go through the configured mail server, or to the log without one
+	sender, err := mail.NewSender(&cfg.Mail, logger)
+	if err != nil {
+		logger.Error("Invalid mail configuration, logging emails instead", "error", err)
+		sender = mail.NewLogSender(logger)
+	}
+
+	// Initialize services
+	emailService := service.NewEmailService(emailRepo, sender, cfg.Mail.MaxAttempts, logger)
+	walletService := service.NewWalletService(txManager, walletRepo, txRepo, auditor, logger)
+	depositService := service.NewDepositService(txManager, txRepo, userRepo, paymentMethodRepo, providers, auditor, logger)
+	paymentMethodService := service.NewPaymentMethodService(txManager, paymentMethodRepo, auditor, logger)
+	scheduledTransferService := service.NewScheduledTransferService(txManager, scheduledTransferRepo, walletRepo, walletService, auditor, cfg.Scheduler.RetryDelay, cfg.Scheduler.MaxFailures, logger)
+	paymentRequestService := service.NewPaymentRequestService(txManager, paymentRequestRepo, walletRepo, userRepo, walletService, auditor, logger)
+	paymentLinkService := service.NewPaymentLinkService(txManager, paymentLinkRepo, walletRepo, userRepo, depositService, cfg.Server.PublicURL, auditor, logger)
+	batchTransferService := service.NewBatchTransferService(txManager, transferBatchRepo, walletRepo, auditor, cfg.Batches.MaxItems, logger)
+	disputeService := service.NewDisputeService(txManager, disputeRepo, auditor, logger)
+	escrowService := service.NewEscrowService(txManager, escrowRepo, walletRepo, auditor, logger)
+	apiKeyService := service.NewAPIKeyService(txManager, apiKeyRepo, auditor, logger)
+	orgService := service.NewOrganizationService(txManager, orgRepo, userRepo, apiKeyService, emailService, cfg.Server.PublicURL, auditor, logger)
+	webhookService := service.NewWebhookService(txManager, webhookRepo, providers, depositService, cfg.Webhooks.MaxAttempts, cfg.Webhooks.Tolerance, auditor, logger)
+
+	// Process stored webhooks in the background. A batch beats per event, so the worker
+	// only goes quiet if a single event hangs.
+	webhookHeartbeat := healthChecker.RegisterWorker("webhooks", 2*cfg.Webhooks.PollInterval+time.Minute)
+	workers.Go("webhooks", func(ctx context.Context) {
+		defer webhookHeartbeat.Stop()
+		webhookService.Run(ctx, cfg.Webhooks.PollInterval, webhookHeartbeat.Beat)
+	})
+
+	// Run due scheduled transfers on whichever instance holds the scheduler lock. The other
+	// instances keep trying the lock, so one takes over if the leader goes away.
+	schedulerLeader := worker.NewLeader(db, cfg.Scheduler.LockKey, logger)
+	schedulerHeartbeat := healthChecker.RegisterWorker("scheduler", 2*cfg.Scheduler.PollInterval+time.Minute)
+	workers.Go("scheduler", func(ctx context.Context) {
+		defer schedulerHeartbeat.Stop()
+		defer func() {
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			schedulerLeader.Release(releaseCtx)
+		}()
+		scheduledTransferService.Run(ctx, schedulerLeader.Acquire, cfg.Scheduler.PollInterval, schedulerHeartbeat.Beat)
+	})
+
+	// Pay bulk transfer batches in the background. Every instance runs the worker; a batch
+	// is leased to one of them at a time.
+	batchHeartbeat := healthChecker.RegisterWorker("batches", 2*cfg.Batches.PollInterval+time.Minute)
+	workers.Go("batches", func(ctx context.Context) {
+		defer batchHeartbeat.Stop()
+		batchTransferService.Run(ctx, cfg.Batches.PollInterval, batchHeartbeat.Beat)
+	})
+
+	// Send queued emails in the background. Every instance runs the worker; an email is
+	// leased to one of them at a time.
+	emailHeartbeat := healthChecker.RegisterWorker("emails", 2*cfg.Mail.PollInterval+time.Minute)
+	workers.Go("emails", func(ctx context.Context) {
+		defer emailHeartbeat.Stop()
+		emailService.Run(ctx, cfg.Mail.PollInterval, emailHeartbeat.Beat)
+	})
+
+	// Initialize handlers
+	authHandler := handlers.NewAuthHandler(txManager, userRepo, twoFactorRepo, auditor, cfg, logger)
+	twoFactorHandler := handlers.NewTwoFactorHandler(txManager, twoFactorRepo, userRepo, auditor, cfg, logger)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, logger)
+	orgHandler := handlers.NewOrganizationHandler(orgService, logger)
+	walletHandler := handlers.NewWalletHandler(walletService, logger)
+	depositHandler := handlers.NewDepositHandler(providers, depositService, webhookService, logger)
+	paymentMethodHandler := handlers.NewPaymentMethodHandler(paymentMethodService, logger)
+	scheduledTransferHandler := handlers.NewScheduledTransferHandler(scheduledTransferService, logger)
+	paymentRequestHandler := handlers.NewPaymentRequestHandler(paymentRequestService, logger)
+	paymentLinkHandler := handlers.NewPaymentLinkHandler(paymentLinkService, logger)
+	batchTransferHandler := handlers.NewBatchTransferHandler(batchTransferService, logger)
+	disputeHandler := handlers.NewDisputeHandler(disputeService, logger)
+	escrowHandler := handlers.NewEscrowHandler(escrowService, logger)
+	webhookHandler := handlers.NewWebhookHandler(providers, webhookService, logger)
+	auditHandler := handlers.NewAuditHandler(auditRepo, logger)
+	healthHandler := handlers.NewHealthHandler(healthChecker, logger)
+
+	// Initialize Gin router
+	router := gin.New()
+	// Only believe X-Forwarded-For from configured proxies; otherwise the peer address is the client
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Error("Invalid trusted proxies, trusting none", "error", err)
+		router.SetTrustedProxies(nil)
+	}
+	router.Use(tracing.Middleware(cfg.Tracing.ServiceName))
+	router.Use(middleware.RequestID())
+	router.Use(middleware.RequestLogger(logger))
+	router.Use(middleware.Recovery(logger))
+	router.Use(middleware.RequestTimeout(cfg.Server.RequestTimeout))
+	router.Use(metrics.Middleware())
+	router.Use(middleware.ErrorHandler(logger))
+
+	// Enable CORS
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "x-api-key", "x-paystack-signature", middleware.RequestIDHeader, "traceparent", "tracestate"},
+		ExposeHeaders:    []string{"Content-Length", middleware.RequestIDHeader},
+		AllowCredentials: true,
+	}))
+
+	// Unknown routes get the same problem format as every other error
+	router.NoRoute(func(c *gin.Context) {
+		middleware.WriteProblem(c, 404, "not_found", "Route not found")
+	})
+
+	// Health check endpoints
+	router.GET("/livez", healthHandler.Livez)
+	router.GET("/readyz", healthHandler.Readyz)
+	router.GET("/health", healthHandler.Livez) // Kept for existing monitors
+
+	// Prometheus metrics endpoint
+	router.GET("/metrics", middleware.StaticBearerToken(cfg.Server.MetricsToken), gin.WrapH(metrics.Handler()))
+
+	// Swagger documentation endpoint
+	router.GET("/swagger.yaml", func(c *gin.Context) {
+		data, err := os.ReadFile("swagger.yaml")
+		if err != nil {
+			middleware.WriteProblem(c, 404, "not_found", "Swagger file not found")
+			return
+		}
+		c.Data(200, "application/x-yaml", data)
+	})
+
+	// Swagger UI endpoint
+	router.GET("/swagger", func(c *gin.Context) {
+		c.Header("Content-Type", "text/html")
+		c.String(200, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Wallet Service API Documentation</title>
+    <link rel="stylesheet" type="text/css" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+        window.onload = function() {
+            SwaggerUIBundle({
+                url: '/swagger.yaml',
+                dom_id: '#swagger-ui',
+                deepLinking: true,
+                presets: [
+                    SwaggerUIBundle.presets.apis,
+                    SwaggerUIBundle.SwaggerUIStandalonePreset
+                ]
+            })
+        }
+    </script>
+</body>
+</html>`)
+	})
+
+	// Auth routes (no authentication required)
+	authGroup := router.Group("/auth")
+	{
+		authGroup.GET("/google", authHandler.GoogleLogin)
+		authGroup.GET("/google/callback", authHandler.GoogleCallback)
+		authGroup.POST("/2fa/verify", twoFactorHandler.VerifyChallenge)
+	}
+
+	// Require a recent 2FA check on sensitive routes
+	requireTwoFactor := middleware.RequireRecentTwoFactor(cfg.TwoFactor.MaxAge, cfg.TwoFactor.Required, twoFactorRepo, logger)
+
+	// Two-factor management routes (JWT required)
+	twoFactorGroup := router.Group("/auth/2fa")
+	twoFactorGroup.Use(middleware.JWTAuth(cfg.JWT.Secret))
+	{
+		twoFactorGroup.GET("", twoFactorHandler.Status)
+		twoFactorGroup.POST("/setup", twoFactorHandler.Setup)
+		twoFactorGroup.POST("/confirm", twoFactorHandler.Confirm)
+		twoFactorGroup.POST("/step-up", twoFactorHandler.StepUp)
+		twoFactorGroup.POST("/disable", twoFactorHandler.Disable)
+		twoFactorGroup.POST("/recovery-codes", middleware.RequireRecentTwoFactor(cfg.TwoFactor.MaxAge, true, twoFactorRepo, logger), twoFactorHandler.RegenerateRecoveryCodes)
+	}
+
+	// API Key routes (JWT required)
+	keysGroup := router.Group("/keys")
+	keysGroup.Use(middleware.JWTAuth(cfg.JWT.Secret))
+	{
+		keysGroup.POST("/create", requireTwoFactor, apiKeyHandler.CreateAPIKey)
+		keysGroup.POST("/rollover", requireTwoFactor, apiKeyHandler.RolloverAPIKey)
+		keysGroup.GET("/list", apiKeyHandler.ListAPIKeys)
+		keysGroup.POST("/revoke", apiKeyHandler.RevokeAPIKey)
+	}
+
+	// Organization routes (JWT required). Members act on an organization's wallets through the
+	// wallet routes by sending the X-Organization-ID header.
+	orgGroup := router.Group("/organizations")
+	orgGroup.Use(middleware.JWTAuth(cfg.JWT.Secret))
+	{
+		orgGroup.POST("", orgHandler.CreateOrganization)
+		orgGroup.GET("", orgHandler.ListOrganizations)
+		orgGroup.GET("/invitations", orgHandler.ListMyInvitations)
+		orgGroup.POST("/invitations/:invitation_id/accept", orgHandler.AcceptInvitation)
+		orgGroup.POST("/invitations/:invitation_id/decline", orgHandler.DeclineInvitation)
+		orgGroup.GET("/:id", orgHandler.GetOrganization)
+		orgGroup.GET("/:id/members", orgHandler.ListMembers)
+		orgGroup.POST("/:id/members/:user_id/role", requireTwoFactor, orgHandler.ChangeMemberRole)
+		orgGroup.DELETE("/:id/members/:user_id", orgHandler.RemoveMember)
+		orgGroup.POST("/:id/invitations", requireTwoFactor, orgHandler.CreateInvitation)
+		orgGroup.GET("/:id/invitations", orgHandler.ListInvitations)
+		orgGroup.POST("/:id/invitations/:invitation_id/revoke", orgHandler.RevokeInvitation)
+		orgGroup.POST("/:id/keys", requireTwoFactor, orgHandler.CreateAPIKey)
+		orgGroup.GET("/:id/keys", orgHandler.ListAPIKeys)
+		orgGroup.POST("/:id/keys/:key_id/revoke", orgHandler.RevokeAPIKey)
+	}
+
+	// Wallet routes (JWT or API key required)
+	walletGroup := router.Group("/wallet")
+	walletGroup.Use(middleware.AuthMiddleware(cfg.JWT.Secret, apiKeyRepo, orgRepo, logger))
+	{
+		// Balance endpoint - requires 'read' permission
+		walletGroup.GET("/balance",
+			middleware.RequirePermission("read"),
+			walletHandler.GetBalance,
+		)
+
+		// Transaction history - requires 'read' permission
+		walletGroup.GET("/transactions",
+			middleware.RequirePermission("read"),
+			walletHandler.GetTransactions,
+		)
+
+		// Deposit endpoint - requires 'deposit' permission
+		walletGroup.POST("/deposit",
+			middleware.RequirePermission("deposit"),
+			depositHandler.InitializeDeposit,
+		)
+
+		// Saved card top-up - requires 'deposit' permission
+		walletGroup.POST("/deposit/charge",
+			middleware.RequirePermission("deposit"),
+			depositHandler.ChargeSavedCard,
+		)
+
+		// Saved cards - listing requires 'read', deleting requires 'deposit'
+		walletGroup.GET("/payment-methods",
+			middleware.RequirePermission("read"),
+			paymentMethodHandler.ListPaymentMethods,
+		)
+		walletGroup.DELETE("/payment-methods/:id",
+			middleware.RequirePermission("deposit"),
+			paymentMethodHandler.DeletePaymentMethod,
+		)
+
+		// Transfer endpoint - requires 'transfer' permission and a recent 2FA check
+		walletGroup.POST("/transfer",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			walletHandler.Transfer,
+		)
+
+		// Sub-wallets - money never leaves the user, so opening wallets and moving between
+		// them need 'transfer' but no 2FA check; listing needs 'read'
+		walletGroup.GET("/wallets",
+			middleware.RequirePermission("read"),
+			walletHandler.ListWallets,
+		)
+		walletGroup.POST("/wallets",
+			middleware.RequirePermission("transfer"),
+			walletHandler.CreateWallet,
+		)
+		walletGroup.POST("/wallets/:wallet_number/default",
+			middleware.RequirePermission("transfer"),
+			walletHandler.SetDefaultWallet,
+		)
+		walletGroup.POST("/move",
+			middleware.RequirePermission("transfer"),
+			walletHandler.Move,
+		)
+
+		// Scheduled transfers - creating and resuming move money later, so they need
+		// 'transfer' and a recent 2FA check like a transfer does; reading needs 'read'
+		walletGroup.POST("/scheduled-transfers",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			scheduledTransferHandler.CreateScheduledTransfer,
+		)
+		walletGroup.GET("/scheduled-transfers",
+			middleware.RequirePermission("read"),
+			scheduledTransferHandler.ListScheduledTransfers,
+		)
+		walletGroup.GET("/scheduled-transfers/:id",
+			middleware.RequirePermission("read"),
+			scheduledTransferHandler.GetScheduledTransfer,
+		)
+		walletGroup.POST("/scheduled-transfers/:id/pause",
+			middleware.RequirePermission("transfer"),
+			scheduledTransferHandler.PauseScheduledTransfer,
+		)
+		walletGroup.POST("/scheduled-transfers/:id/resume",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			scheduledTransferHandler.ResumeScheduledTransfer,
+		)
+		walletGroup.DELETE("/scheduled-transfers/:id",
+			middleware.RequirePermission("transfer"),
+			scheduledTransferHandler.CancelScheduledTransfer,
+		)
+
+		// Bulk transfers - uploading a batch moves money, so it needs 'transfer' and a recent
+		// 2FA check like a transfer does; following its progress needs 'read'
+		walletGroup.POST("/batches",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			batchTransferHandler.CreateBatch,
+		)
+		walletGroup.GET("/batches",
+			middleware.RequirePermission("read"),
+			batchTransferHandler.ListBatches,
+		)
+		walletGroup.GET("/batches/:id",
+			middleware.RequirePermission("read"),
+			batchTransferHandler.GetBatch,
+		)
+		walletGroup.GET("/batches/:id/items",
+			middleware.RequirePermission("read"),
+			batchTransferHandler.ListBatchItems,
+		)
+		walletGroup.GET("/batches/:id/result.csv",
+			middleware.RequirePermission("read"),
+			batchTransferHandler.DownloadBatchResult,
+		)
+
+		// Payment requests - accepting one pays it, so it needs 'transfer' and a recent 2FA
+		// check like a transfer does; asking, declining and cancelling move no money
+		walletGroup.POST("/payment-requests",
+			middleware.RequirePermission("transfer"),
+			paymentRequestHandler.CreatePaymentRequest,
+		)
+		walletGroup.GET("/payment-requests",
+			middleware.RequirePermission("read"),
+			paymentRequestHandler.ListPaymentRequests,
+		)
+		walletGroup.GET("/payment-requests/:id",
+			middleware.RequirePermission("read"),
+			paymentRequestHandler.GetPaymentRequest,
+		)
+		walletGroup.POST("/payment-requests/:id/accept",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			paymentRequestHandler.AcceptPaymentRequest,
+		)
+		walletGroup.POST("/payment-requests/:id/decline",
+			middleware.RequirePermission("transfer"),
+			paymentRequestHandler.DeclinePaymentRequest,
+		)
+		walletGroup.DELETE("/payment-requests/:id",
+			middleware.RequirePermission("transfer"),
+			paymentRequestHandler.CancelPaymentRequest,
+		)
+
+		// Disputes - approving one sends money back out of the recipient's wallet, so it needs
+		// 'transfer' and a recent 2FA check like a transfer does
+		walletGroup.POST("/disputes",
+			middleware.RequirePermission("transfer"),
+			disputeHandler.OpenDispute,
+		)
+		walletGroup.GET("/disputes",
+			middleware.RequirePermission("read"),
+			disputeHandler.ListDisputes,
+		)
+		walletGroup.GET("/disputes/:id",
+			middleware.RequirePermission("read"),
+			disputeHandler.GetDispute,
+		)
+		walletGroup.POST("/disputes/:id/approve",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			disputeHandler.ApproveDispute,
+		)
+		walletGroup.POST("/disputes/:id/reject",
+			middleware.RequirePermission("transfer"),
+			disputeHandler.RejectDispute,
+		)
+		walletGroup.DELETE("/disputes/:id",
+			middleware.RequirePermission("transfer"),
+			disputeHandler.CancelDispute,
+		)
+
+		// Escrows - funding and releasing move money out of the caller's control, so they need
+		// a recent 2FA check like a transfer does. A refund only returns money to the buyer.
+		walletGroup.POST("/escrows",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			escrowHandler.CreateEscrow,
+		)
+		walletGroup.GET("/escrows",
+			middleware.RequirePermission("read"),
+			escrowHandler.ListEscrows,
+		)
+		walletGroup.GET("/escrows/:id",
+			middleware.RequirePermission("read"),
+			escrowHandler.GetEscrow,
+		)
+		walletGroup.GET("/escrows/:id/events",
+			middleware.RequirePermission("read"),
+			escrowHandler.ListEscrowEvents,
+		)
+		walletGroup.POST("/escrows/:id/release",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			escrowHandler.ReleaseEscrow,
+		)
+		walletGroup.POST("/escrows/:id/refund",
+			middleware.RequirePermission("transfer"),
+			escrowHandler.RefundEscrow,
+		)
+		walletGroup.POST("/escrows/:id/split",
+			middleware.RequirePermission("transfer"),
+			requireTwoFactor,
+			escrowHandler.SplitEscrow,
+		)
+
+		// Payment links bring money in, so managing them needs 'deposit'; listing needs 'read'
+		walletGroup.POST("/payment-links",
+			middleware.RequirePermission("deposit"),
+			paymentLinkHandler.CreatePaymentLink,
+		)
+		walletGroup.GET("/payment-links",
+			middleware.RequirePermission("read"),
+			paymentLinkHandler.ListPaymentLinks,
+		)
+		walletGroup.DELETE("/payment-links/:id",
+			middleware.RequirePermission("deposit"),
+			paymentLinkHandler.DeactivatePaymentLink,
+		)
+
+		// Deposit status check - requires 'read' permission
+		walletGroup.GET("/deposit/:reference/status",
+			middleware.RequirePermission("read"),
+			depositHandler.GetDepositStatus,
+		)
+	}
+
+	// Audit log routes (JWT or API key required). The log is the acting account's, so a
+	// request with X-Organization-ID lists the organization's events.
+	auditGroup := router.Group("/audit")
+	auditGroup.Use(middleware.AuthMiddleware(cfg.JWT.Secret, apiKeyRepo, orgRepo, logger))
+	{
+		auditGroup.GET("", middleware.RequirePermission("read"), auditHandler.ListEvents)
+	}
+
+	// Admin routes (JWT required, email listed in ADMIN_EMAILS)
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(middleware.JWTAuth(cfg.JWT.Secret), middleware.RequireAdmin(cfg.Admin.Emails))
+	{
+		adminGroup.GET("/webhooks", webhookHandler.ListEvents)
+		adminGroup.GET("/webhooks/:id", webhookHandler.GetEvent)
+		adminGroup.POST("/webhooks/replay", requireTwoFactor, webhookHandler.ReplayEvent)
+		adminGroup.POST("/webhooks/:id/reprocess", requireTwoFactor, webhookHandler.ReprocessEvent)
+		adminGroup.GET("/disputes", disputeHandler.AdminListDisputes)
+		adminGroup.GET("/disputes/:id", disputeHandler.AdminGetDispute)
+		adminGroup.POST("/disputes/:id/reverse", requireTwoFactor, disputeHandler.ForceReversal)
+	}
+
+	// Provider webhooks (no authentication - validated by source address and signature)
+	for _, provider := range providers.All() {
+		name := provider.Name()
+		router.POST("/wallet/"+name+"/webhook", middleware.AllowSourceIPs(webhookAllowedIPs[name]), depositHandler.Webhook(name))
+	}
+
+	// Payment link pages (no authentication - anyone with the link can pay through it)
+	payGroup := router.Group("/pay")
+	{
+		payGroup.GET("/:code", paymentLinkHandler.GetLink)
+		payGroup.POST("/:code", paymentLinkHandler.PayLink)
+		payGroup.GET("/:code/qr.png", paymentLinkHandler.LinkQRCode)
+	}
+
+	// Simulated checkout pages (PAYSTACK_MODE=simulator only)
+	if simulator != nil {
+		simulatorHandler := gin.WrapH(simulator.Handler())
+		router.GET("/simulator/checkout/:reference", simulatorHandler)
+		router.POST("/simulator/checkout/:reference", simulatorHandler)
+	}
+
+	// Protected routes (JWT required) - for testing
+	protectedGroup := router.Group("/")
+	protectedGroup.Use(middleware.JWTAuth(cfg.JWT.Secret))
+	{
+		// Test protected endpoint
+		protectedGroup.GET("/me", func(c *gin.Context) {
+			userID, _ := middleware.GetUserID(c)
+			email := middleware.GetUserEmail(c)
+
+			c.JSON(200, gin.H{
+				"user_id": userID,
+				"email":   email,
+			})
+		})
+	}
+
+	return &App{Router: router, Workers: workers}
+}
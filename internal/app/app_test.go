@@ -0,0 +1,1868 @@
+package app_test
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/franzego/stage08/internal/testutil"
+	"github.com/franzego/stage08/internal/utils"
+	"github.com/google/uuid"
+)
+
+func TestDepositCreditsWalletOnce(t *testing.T) {
+	h := testutil.NewHarness(t)
+	user := h.CreateUser(t, "alice")
+
+	reference := h.Deposit(t, user, 50000)
+
+	tx, ok := h.Paystack.Transaction(reference)
+	if !ok {
+		t.Fatalf("deposit %s was not initialized with Paystack", reference)
+	}
+	if tx.Amount != 50000 || tx.Email != user.Email {
+		t.Fatalf("paystack transaction = %+v, want amount 50000 for %s", tx, user.Email)
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/deposit/"+reference+"/status", nil, testutil.Bearer(user.Token)), http.StatusOK, &status)
+	if status.Status != "pending" {
+		t.Fatalf("status before payment = %q, want pending", status.Status)
+	}
+
+	body, signature := h.Paystack.ChargeSuccess(t, reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+
+	// Paystack retries webhooks; a redelivery must not credit twice
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+
+	if got := h.Balance(t, user); got != 50000 {
+		t.Fatalf("balance = %d, want 50000", got)
+	}
+
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/deposit/"+reference+"/status", nil, testutil.Bearer(user.Token)), http.StatusOK, &status)
+	if status.Status != "success" {
+		t.Fatalf("status after payment = %q, want success", status.Status)
+	}
+
+	// The deposit records what Paystack reported about the payment
+	var history []struct {
+		Metadata struct {
+			Paystack struct {
+				Channel string `json:"channel"`
+				PaidAt  string `json:"paid_at"`
+				Fees    int64  `json:"fees"`
+			} `json:"paystack"`
+		} `json:"metadata"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/transactions", nil, testutil.Bearer(user.Token)), http.StatusOK, &history)
+	if len(history) != 1 {
+		t.Fatalf("history has %d entries, want 1", len(history))
+	}
+	if paystack := history[0].Metadata.Paystack; paystack.Channel != "card" || paystack.PaidAt == "" || paystack.Fees != 750 {
+		t.Fatalf("deposit metadata = %+v, want card payment with paid_at and 750 kobo fees", paystack)
+	}
+}
+
+func TestDepositRejectsBadWebhooks(t *testing.T) {
+	h := testutil.NewHarness(t)
+	user := h.CreateUser(t, "alice")
+	reference := h.Deposit(t, user, 20000)
+
+	body, _ := h.Paystack.ChargeSuccess(t, reference)
+
+	testutil.ExpectStatus(t, h.Webhook(t, body, ""), http.StatusUnauthorized)
+	testutil.ExpectStatus(t, h.Webhook(t, body, "deadbeef"), http.StatusUnauthorized)
+
+	// A correctly signed event whose amount doesn't match fails the deposit
+	mismatched, signature := h.Paystack.ChargeSuccess(t, reference, 99999)
+	testutil.ExpectStatus(t, h.Webhook(t, mismatched, signature), http.StatusOK)
+
+	// So does a payment in another currency or by another customer
+	wrongCurrency := h.Deposit(t, user, 20000)
+	body, signature = h.Paystack.ChargeSuccessWith(t, wrongCurrency, map[string]interface{}{"currency": "USD"})
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+
+	wrongCustomer := h.Deposit(t, user, 20000)
+	body, signature = h.Paystack.ChargeSuccessWith(t, wrongCustomer, map[string]interface{}{"customer": map[string]string{"email": "mallory@example.com"}})
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+
+	h.WaitForWebhooks(t)
+
+	if got := h.Balance(t, user); got != 0 {
+		t.Fatalf("balance after mismatched payments = %d, want 0", got)
+	}
+
+	for _, ref := range []string{reference, wrongCurrency, wrongCustomer} {
+		var status struct {
+			Status string `json:"status"`
+		}
+		testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/deposit/"+ref+"/status", nil, testutil.Bearer(user.Token)), http.StatusOK, &status)
+		if status.Status != "failed" {
+			t.Fatalf("deposit %s: status = %q, want failed", ref, status.Status)
+		}
+		// The customer was charged for a deposit that can't be credited, so they get it back
+		if checkout, _ := h.Paystack.Transaction(ref); checkout.Status != "reversed" {
+			t.Fatalf("deposit %s: payment status = %q, want reversed", ref, checkout.Status)
+		}
+	}
+}
+
+func TestDepositRetriedAfterFailedCharge(t *testing.T) {
+	h := testutil.NewHarness(t)
+	user := h.CreateUser(t, "alice")
+	reference := h.Deposit(t, user, 20000)
+
+	status := func() string {
+		t.Helper()
+		var resp struct {
+			Status string `json:"status"`
+		}
+		testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/deposit/"+reference+"/status", nil, testutil.Bearer(user.Token)), http.StatusOK, &resp)
+		return resp.Status
+	}
+
+	body, signature := h.Paystack.ChargeFailed(t, reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := status(); got != "failed" {
+		t.Fatalf("status after declined attempt = %q, want failed", got)
+	}
+
+	// The customer retries under the same reference and pays
+	body, signature = h.Paystack.ChargeSuccess(t, reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := status(); got != "success" {
+		t.Fatalf("status after successful retry = %q, want success", got)
+	}
+	if got := h.Balance(t, user); got != 20000 {
+		t.Fatalf("balance after successful retry = %d, want 20000", got)
+	}
+
+	// Another declined attempt can't undo the settled deposit
+	body, signature = h.Paystack.ChargeFailed(t, reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := status(); got != "success" {
+		t.Fatalf("status after late declined attempt = %q, want success", got)
+	}
+}
+
+func TestDepositValidation(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]int64{"amount": 50}, testutil.Bearer(alice.Token)), http.StatusBadRequest, "invalid_amount")
+
+	h.Paystack.FailInitialize(true)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]int64{"amount": 5000}, testutil.Bearer(alice.Token)), http.StatusBadGateway, "payment_provider_error")
+	h.Paystack.FailInitialize(false)
+
+	// Deposit references are private to their owner
+	reference := h.Deposit(t, alice, 5000)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/deposit/"+reference+"/status", nil, testutil.Bearer(bob.Token)), http.StatusNotFound, "transaction_not_found")
+}
+
+func TestWebhookEventStore(t *testing.T) {
+	h := testutil.NewHarness(t)
+	admin := h.CreateAdmin(t)
+	alice := h.CreateUser(t, "alice")
+
+	type event struct {
+		ID        string `json:"id"`
+		EventType string `json:"event_type"`
+		Status    string `json:"status"`
+		Attempts  int    `json:"attempts"`
+	}
+	list := func(status string) []event {
+		var resp struct {
+			Events []event `json:"events"`
+			Total  int     `json:"total"`
+		}
+		testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/admin/webhooks?status="+status, nil, testutil.Bearer(admin.Token)), http.StatusOK, &resp)
+		if resp.Total != len(resp.Events) {
+			t.Fatalf("total = %d, listed %d", resp.Total, len(resp.Events))
+		}
+		return resp.Events
+	}
+
+	// Redeliveries of the same event are stored once
+	reference := h.Deposit(t, alice, 30000)
+	body, signature := h.Paystack.ChargeSuccess(t, reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+
+	// An event for an unknown reference can never succeed and fails without retrying
+	unknown := []byte(`{"event":"charge.success","data":{"id":424242,"reference":"DEP_unknown","amount":1000,"status":"success"}}`)
+	testutil.ExpectStatus(t, h.Webhook(t, unknown, testutil.SignWebhook(unknown)), http.StatusOK)
+
+	ignored := []byte(`{"event":"transfer.success","data":{"id":7}}`)
+	testutil.ExpectStatus(t, h.Webhook(t, ignored, testutil.SignWebhook(ignored)), http.StatusOK)
+
+	h.WaitForWebhooks(t)
+
+	if got := len(list("")); got != 3 {
+		t.Fatalf("stored %d events, want 3", got)
+	}
+	processed := list("processed")
+	if len(processed) != 1 || processed[0].EventType != "charge.success" {
+		t.Fatalf("processed events = %+v, want the charge.success", processed)
+	}
+	if got := list("ignored"); len(got) != 1 || got[0].EventType != "transfer.success" {
+		t.Fatalf("ignored events = %+v, want the transfer.success", got)
+	}
+	failed := list("failed")
+	if len(failed) != 1 || failed[0].Attempts != 1 {
+		t.Fatalf("failed events = %+v, want one after a single attempt", failed)
+	}
+
+	// Reprocessing is idempotent and runs again whatever the status
+	var replayed event
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/admin/webhooks/"+processed[0].ID+"/reprocess", nil, testutil.Bearer(admin.Token)), http.StatusOK, &replayed)
+	if replayed.Status != "processed" || replayed.Attempts != 2 {
+		t.Fatalf("replayed event = %+v, want processed after 2 attempts", replayed)
+	}
+	if got := h.Balance(t, alice); got != 30000 {
+		t.Fatalf("balance after replay = %d, want 30000", got)
+	}
+
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/admin/webhooks/"+failed[0].ID+"/reprocess", nil, testutil.Bearer(admin.Token)), http.StatusOK, &replayed)
+	if replayed.Status != "failed" || replayed.Attempts != 2 {
+		t.Fatalf("replayed event = %+v, want failed after 2 attempts", replayed)
+	}
+
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/admin/webhooks?status=bogus", nil, testutil.Bearer(admin.Token)), http.StatusBadRequest, "invalid_status")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/admin/webhooks/00000000-0000-0000-0000-000000000000/reprocess", nil, testutil.Bearer(admin.Token)), http.StatusNotFound, "webhook_event_not_found")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/admin/webhooks", nil, testutil.Bearer(alice.Token)), http.StatusForbidden, "admin_required")
+}
+
+func TestFlutterwaveDeposit(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+
+	var deposit struct {
+		Reference        string `json:"reference"`
+		Provider         string `json:"provider"`
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]interface{}{"amount": 25000, "provider": "flutterwave"}, testutil.Bearer(alice.Token)), http.StatusOK, &deposit)
+	if deposit.Provider != "flutterwave" || deposit.AuthorizationURL == "" {
+		t.Fatalf("deposit = %+v, want a flutterwave checkout", deposit)
+	}
+	tx, ok := h.Flutterwave.Transaction(deposit.Reference)
+	if !ok || tx.Amount != 25000 || tx.Email != alice.Email {
+		t.Fatalf("flutterwave transaction = %+v, want amount 25000 for %s", tx, alice.Email)
+	}
+	if _, ok := h.Paystack.Transaction(deposit.Reference); ok {
+		t.Fatalf("deposit %s was also initialized with Paystack", deposit.Reference)
+	}
+
+	body, hash := h.Flutterwave.ChargeCompleted(t, deposit.Reference)
+	webhook := func(path, hash string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, path, body, testutil.Header("verif-hash", hash))
+	}
+	testutil.ExpectStatus(t, webhook("/wallet/flutterwave/webhook", "wrong-hash"), http.StatusUnauthorized)
+
+	// A Flutterwave payment delivered as a Paystack webhook is refused by its signature check
+	testutil.ExpectStatus(t, webhook("/wallet/paystack/webhook", hash), http.StatusUnauthorized)
+
+	testutil.ExpectStatus(t, webhook("/wallet/flutterwave/webhook", hash), http.StatusOK)
+	h.WaitForWebhooks(t)
+
+	if got := h.Balance(t, alice); got != 25000 {
+		t.Fatalf("balance = %d, want 25000", got)
+	}
+
+	var status struct {
+		Status   string `json:"status"`
+		Provider string `json:"provider"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/deposit/"+deposit.Reference+"/status", nil, testutil.Bearer(alice.Token)), http.StatusOK, &status)
+	if status.Status != "success" || status.Provider != "flutterwave" {
+		t.Fatalf("status = %+v, want a successful flutterwave deposit", status)
+	}
+
+	var history []struct {
+		Metadata struct {
+			Flutterwave struct {
+				ID   string `json:"id"`
+				Fees int64  `json:"fees"`
+			} `json:"flutterwave"`
+		} `json:"metadata"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/transactions", nil, testutil.Bearer(alice.Token)), http.StatusOK, &history)
+	if len(history) != 1 || history[0].Metadata.Flutterwave.ID == "" || history[0].Metadata.Flutterwave.Fees != 350 {
+		t.Fatalf("history = %+v, want the flutterwave payment with 350 kobo fees", history)
+	}
+
+	// The verif-hash is the same for every webhook, so a payload claiming an unpaid deposit
+	// was paid is checked with Flutterwave and not credited
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]interface{}{"amount": 25000, "provider": "flutterwave"}, testutil.Bearer(alice.Token)), http.StatusOK, &deposit)
+	forged := map[string]interface{}{
+		"event": "charge.completed",
+		"data": map[string]interface{}{
+			"id":         999999,
+			"tx_ref":     deposit.Reference,
+			"amount":     250,
+			"currency":   "NGN",
+			"status":     "successful",
+			"customer":   map[string]string{"email": alice.Email},
+			"created_at": time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/wallet/flutterwave/webhook", forged, testutil.Header("verif-hash", hash)), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := h.Balance(t, alice); got != 25000 {
+		t.Fatalf("balance after forged webhook = %d, want 25000", got)
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/deposit/"+deposit.Reference+"/status", nil, testutil.Bearer(alice.Token)), http.StatusOK, &status)
+	if status.Status != "pending" {
+		t.Fatalf("status after forged webhook = %q, want pending", status.Status)
+	}
+
+	// Deposits without a provider use the default one
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]interface{}{"amount": 10000}, testutil.Bearer(alice.Token)), http.StatusOK, &deposit)
+	if deposit.Provider != "paystack" {
+		t.Fatalf("default provider = %q, want paystack", deposit.Provider)
+	}
+
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]interface{}{"amount": 10000, "provider": "stripe"}, testutil.Bearer(alice.Token)), http.StatusBadRequest, "unsupported_provider")
+}
+
+func TestSavedCards(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+
+	// Paying twice with the same card saves it once
+	h.Fund(t, alice, 20000)
+	h.Fund(t, alice, 20000)
+
+	rec := h.Do(t, http.MethodGet, "/wallet/payment-methods", nil, testutil.Bearer(alice.Token))
+	if strings.Contains(rec.Body.String(), "AUTH_") {
+		t.Fatalf("payment methods expose the authorization code: %s", rec.Body.String())
+	}
+	var list struct {
+		PaymentMethods []struct {
+			ID       string `json:"id"`
+			Last4    string `json:"last4"`
+			Brand    string `json:"brand"`
+			CardType string `json:"card_type"`
+			Reusable bool   `json:"reusable"`
+		} `json:"payment_methods"`
+	}
+	testutil.ExpectJSON(t, rec, http.StatusOK, &list)
+	if len(list.PaymentMethods) != 1 || list.PaymentMethods[0].Last4 != "4081" || list.PaymentMethods[0].CardType != "visa" || !list.PaymentMethods[0].Reusable {
+		t.Fatalf("payment methods = %+v, want one reusable visa ending 4081", list.PaymentMethods)
+	}
+	card := list.PaymentMethods[0].ID
+
+	charge := func(user *testutil.User, amount int64) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/deposit/charge", map[string]interface{}{"payment_method_id": card, "amount": amount}, testutil.Bearer(user.Token))
+	}
+
+	var deposit struct {
+		Reference string `json:"reference"`
+		Status    string `json:"status"`
+	}
+	testutil.ExpectJSON(t, charge(alice, 5000), http.StatusOK, &deposit)
+	if deposit.Status != "success" {
+		t.Fatalf("charge status = %q, want success", deposit.Status)
+	}
+	if got := h.Balance(t, alice); got != 45000 {
+		t.Fatalf("balance = %d, want 45000", got)
+	}
+
+	// The charge.success webhook that follows doesn't credit the deposit again
+	body, signature := h.Paystack.ChargeSuccess(t, deposit.Reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := h.Balance(t, alice); got != 45000 {
+		t.Fatalf("balance after webhook = %d, want 45000", got)
+	}
+
+	// A declined charge fails the deposit
+	h.Paystack.DeclineCharges(true)
+	testutil.ExpectJSON(t, charge(alice, 5000), http.StatusOK, &deposit)
+	h.Paystack.DeclineCharges(false)
+	if deposit.Status != "failed" {
+		t.Fatalf("declined charge status = %q, want failed", deposit.Status)
+	}
+	if got := h.Balance(t, alice); got != 45000 {
+		t.Fatalf("balance after decline = %d, want 45000", got)
+	}
+
+	testutil.ExpectProblem(t, charge(alice, 50), http.StatusBadRequest, "invalid_amount")
+
+	// Other users can't see, charge or delete the card
+	testutil.ExpectProblem(t, charge(bob, 5000), http.StatusNotFound, "payment_method_not_found")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodDelete, "/wallet/payment-methods/"+card, nil, testutil.Bearer(bob.Token)), http.StatusNotFound, "payment_method_not_found")
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-methods", nil, testutil.Bearer(bob.Token)), http.StatusOK, &list)
+	if len(list.PaymentMethods) != 0 {
+		t.Fatalf("bob's payment methods = %+v, want none", list.PaymentMethods)
+	}
+
+	testutil.ExpectStatus(t, h.Do(t, http.MethodDelete, "/wallet/payment-methods/"+card, nil, testutil.Bearer(alice.Token)), http.StatusOK)
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-methods", nil, testutil.Bearer(alice.Token)), http.StatusOK, &list)
+	if len(list.PaymentMethods) != 0 {
+		t.Fatalf("payment methods after delete = %+v, want none", list.PaymentMethods)
+	}
+	testutil.ExpectProblem(t, charge(alice, 5000), http.StatusNotFound, "payment_method_not_found")
+}
+
+func TestWebhookReplayWindow(t *testing.T) {
+	h := testutil.NewHarness(t)
+	admin := h.CreateAdmin(t)
+	alice := h.CreateUser(t, "alice")
+
+	// A payment from long ago is refused and nothing is stored
+	reference := h.Deposit(t, alice, 20000)
+	paidAt := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	body, signature := h.Paystack.ChargeSuccessWith(t, reference, map[string]interface{}{"paid_at": paidAt})
+	testutil.ExpectProblem(t, h.Webhook(t, body, signature), http.StatusBadRequest, "stale_webhook")
+
+	var stored int
+	if err := h.DB.Get(&stored, `SELECT COUNT(*) FROM webhook_events`); err != nil {
+		t.Fatalf("count webhooks: %v", err)
+	}
+	if stored != 0 {
+		t.Fatalf("stored %d events, want 0", stored)
+	}
+
+	// Replaying needs Paystack's signature, then the event is processed right away
+	replay := func(signature string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/admin/webhooks/replay", body, testutil.Bearer(admin.Token), testutil.Header("x-paystack-signature", signature))
+	}
+	testutil.ExpectStatus(t, replay("bogus"), http.StatusUnauthorized)
+
+	var event struct {
+		Status   string `json:"status"`
+		Attempts int    `json:"attempts"`
+	}
+	testutil.ExpectJSON(t, replay(signature), http.StatusOK, &event)
+	if event.Status != "processed" || event.Attempts != 1 {
+		t.Fatalf("replayed event = %+v, want processed after 1 attempt", event)
+	}
+	if got := h.Balance(t, alice); got != 20000 {
+		t.Fatalf("balance after replay = %d, want 20000", got)
+	}
+
+	// Replaying it again reprocesses the stored event without crediting twice
+	testutil.ExpectJSON(t, replay(signature), http.StatusOK, &event)
+	if event.Status != "processed" || event.Attempts != 2 {
+		t.Fatalf("replayed event = %+v, want processed after 2 attempts", event)
+	}
+	if got := h.Balance(t, alice); got != 20000 {
+		t.Fatalf("balance after second replay = %d, want 20000", got)
+	}
+
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/admin/webhooks/replay", body, testutil.Bearer(alice.Token), testutil.Header("x-paystack-signature", signature)), http.StatusForbidden, "admin_required")
+}
+
+func TestWebhookSourceIPs(t *testing.T) {
+	h := testutil.NewHarness(t, func(cfg *config.Config) {
+		cfg.Server.TrustedProxies = []string{"192.0.2.1"} // httptest's client address
+		cfg.Paystack.WebhookAllowedIPs = []netip.Prefix{netip.MustParsePrefix("52.31.139.75/32")}
+	})
+	alice := h.CreateUser(t, "alice")
+
+	reference := h.Deposit(t, alice, 10000)
+	body, signature := h.Paystack.ChargeSuccess(t, reference)
+
+	// The proxy itself isn't Paystack
+	testutil.ExpectProblem(t, h.Webhook(t, body, signature), http.StatusForbidden, "ip_not_allowed")
+
+	deliver := func(forwardedFor string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/paystack/webhook", body,
+			testutil.Header("x-paystack-signature", signature), testutil.Header("X-Forwarded-For", forwardedFor))
+	}
+	testutil.ExpectProblem(t, deliver("203.0.113.9"), http.StatusForbidden, "ip_not_allowed")
+	testutil.ExpectStatus(t, deliver("52.31.139.75"), http.StatusOK)
+
+	h.WaitForWebhooks(t)
+	if got := h.Balance(t, alice); got != 10000 {
+		t.Fatalf("balance = %d, want 10000", got)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+	h.Fund(t, alice, 10000)
+
+	transfer := func(user *testutil.User, walletNumber string, amount int64) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{
+			"wallet_number": walletNumber,
+			"amount":        amount,
+		}, testutil.Bearer(user.Token))
+	}
+
+	testutil.ExpectStatus(t, transfer(alice, bob.Wallet.WalletNumber, 3000), http.StatusOK)
+
+	if got := h.Balance(t, alice); got != 7000 {
+		t.Fatalf("alice balance = %d, want 7000", got)
+	}
+	if got := h.Balance(t, bob); got != 3000 {
+		t.Fatalf("bob balance = %d, want 3000", got)
+	}
+
+	testutil.ExpectProblem(t, transfer(alice, bob.Wallet.WalletNumber, 8000), http.StatusBadRequest, "insufficient_funds")
+	testutil.ExpectProblem(t, transfer(alice, alice.Wallet.WalletNumber, 1000), http.StatusBadRequest, "self_transfer")
+	testutil.ExpectProblem(t, transfer(alice, "0000000000000", 1000), http.StatusNotFound, "recipient_not_found")
+	testutil.ExpectProblem(t, transfer(alice, bob.Wallet.WalletNumber, 50), http.StatusBadRequest, "invalid_amount")
+
+	// Failed transfers leave both balances untouched
+	if got := h.Balance(t, alice); got != 7000 {
+		t.Fatalf("alice balance after failed transfers = %d, want 7000", got)
+	}
+
+	var history []struct {
+		Type   string `json:"type"`
+		Amount int64  `json:"amount"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/transactions", nil, testutil.Bearer(bob.Token)), http.StatusOK, &history)
+	if len(history) != 1 || history[0].Type != "transfer_in" || history[0].Amount != 3000 {
+		t.Fatalf("bob history = %+v, want one transfer_in of 3000", history)
+	}
+}
+
+func TestScheduledTransfers(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+	h.Fund(t, alice, 10000)
+
+	type scheduledTransfer struct {
+		ID           string  `json:"id"`
+		Status       string  `json:"status"`
+		PauseReason  string  `json:"pause_reason"`
+		Occurrences  int     `json:"occurrences"`
+		FailureCount int     `json:"failure_count"`
+		NextRunAt    *string `json:"next_run_at"`
+	}
+	type details struct {
+		ScheduledTransfer scheduledTransfer `json:"scheduled_transfer"`
+		Runs              []struct {
+			Status    string `json:"status"`
+			Reference string `json:"reference"`
+			ErrorCode string `json:"error_code"`
+		} `json:"runs"`
+	}
+
+	create := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		body["wallet_number"] = bob.Wallet.WalletNumber
+		return h.Do(t, http.MethodPost, "/wallet/scheduled-transfers", body, testutil.Bearer(alice.Token))
+	}
+	get := func(user *testutil.User, id string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodGet, "/wallet/scheduled-transfers/"+id, nil, testutil.Bearer(user.Token))
+	}
+	// waitFor polls the scheduled transfer until the scheduler has moved it to status
+	waitFor := func(id, status string) details {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			var d details
+			testutil.ExpectJSON(t, get(alice, id), http.StatusOK, &d)
+			if d.ScheduledTransfer.Status == status {
+				return d
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("scheduled transfer %s is %+v, want status %s", id, d.ScheduledTransfer, status)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	testutil.ExpectProblem(t, create(map[string]interface{}{"amount": 1000, "cron": "61 * * * *"}), http.StatusBadRequest, "invalid_schedule")
+	testutil.ExpectProblem(t, create(map[string]interface{}{"amount": 1000, "cron": "@daily", "interval": "1D"}), http.StatusBadRequest, "invalid_schedule")
+	testutil.ExpectProblem(t, create(map[string]interface{}{"amount": 1000}), http.StatusBadRequest, "invalid_schedule")
+	testutil.ExpectProblem(t, create(map[string]interface{}{"amount": 1000, "interval": "1D", "max_occurrences": 0}), http.StatusBadRequest, "invalid_schedule")
+	testutil.ExpectProblem(t, create(map[string]interface{}{"amount": 1000, "interval": "1D", "start_at": time.Now().Add(-time.Hour)}), http.StatusBadRequest, "invalid_schedule")
+	testutil.ExpectProblem(t, create(map[string]interface{}{"amount": 50, "interval": "1D"}), http.StatusBadRequest, "invalid_amount")
+
+	// An interval schedule starting now runs straight away, and completes after its last occurrence
+	var once scheduledTransfer
+	testutil.ExpectJSON(t, create(map[string]interface{}{"amount": 3000, "interval": "1D", "max_occurrences": 1}), http.StatusCreated, &once)
+	d := waitFor(once.ID, "completed")
+	if d.ScheduledTransfer.Occurrences != 1 || len(d.Runs) != 1 || d.Runs[0].Status != "success" || d.Runs[0].Reference == "" {
+		t.Fatalf("completed schedule = %+v, want one successful run", d)
+	}
+	if got := h.Balance(t, alice); got != 7000 {
+		t.Fatalf("alice balance = %d, want 7000", got)
+	}
+	if got := h.Balance(t, bob); got != 3000 {
+		t.Fatalf("bob balance = %d, want 3000", got)
+	}
+
+	// Repeated insufficient-funds failures pause the schedule without moving money
+	var broke scheduledTransfer
+	testutil.ExpectJSON(t, create(map[string]interface{}{"amount": 8000, "interval": "1H"}), http.StatusCreated, &broke)
+	d = waitFor(broke.ID, "paused")
+	if d.ScheduledTransfer.PauseReason != "insufficient_funds" || d.ScheduledTransfer.FailureCount != h.Config.Scheduler.MaxFailures || d.ScheduledTransfer.NextRunAt != nil {
+		t.Fatalf("paused schedule = %+v, want paused for insufficient_funds after %d failures", d.ScheduledTransfer, h.Config.Scheduler.MaxFailures)
+	}
+	if len(d.Runs) != h.Config.Scheduler.MaxFailures || d.Runs[0].Status != "failed" || d.Runs[0].ErrorCode != "insufficient_funds" {
+		t.Fatalf("runs = %+v, want %d insufficient_funds failures", d.Runs, h.Config.Scheduler.MaxFailures)
+	}
+	if got := h.Balance(t, alice); got != 7000 {
+		t.Fatalf("alice balance after failures = %d, want 7000", got)
+	}
+
+	// Resuming skips the missed occurrence and waits for the next one
+	var resumed scheduledTransfer
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/scheduled-transfers/"+broke.ID+"/resume", nil, testutil.Bearer(alice.Token)), http.StatusOK, &resumed)
+	if resumed.Status != "active" || resumed.FailureCount != 0 || resumed.NextRunAt == nil {
+		t.Fatalf("resumed schedule = %+v, want active with a next run", resumed)
+	}
+
+	// Status changes only apply in the right state, and only for the owner
+	path := "/wallet/scheduled-transfers/" + broke.ID
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, path+"/resume", nil, testutil.Bearer(alice.Token)), http.StatusConflict, "invalid_scheduled_transfer_state")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, path+"/pause", nil, testutil.Bearer(bob.Token)), http.StatusNotFound, "scheduled_transfer_not_found")
+	testutil.ExpectProblem(t, get(bob, broke.ID), http.StatusNotFound, "scheduled_transfer_not_found")
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, path+"/pause", nil, testutil.Bearer(alice.Token)), http.StatusOK)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, path+"/pause", nil, testutil.Bearer(alice.Token)), http.StatusConflict, "invalid_scheduled_transfer_state")
+	testutil.ExpectStatus(t, h.Do(t, http.MethodDelete, path, nil, testutil.Bearer(alice.Token)), http.StatusOK)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, path+"/resume", nil, testutil.Bearer(alice.Token)), http.StatusConflict, "invalid_scheduled_transfer_state")
+
+	var list struct {
+		ScheduledTransfers []scheduledTransfer `json:"scheduled_transfers"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/scheduled-transfers", nil, testutil.Bearer(alice.Token)), http.StatusOK, &list)
+	if len(list.ScheduledTransfers) != 2 || list.ScheduledTransfers[0].Status != "cancelled" || list.ScheduledTransfers[1].Status != "completed" {
+		t.Fatalf("scheduled transfers = %+v, want cancelled and completed", list.ScheduledTransfers)
+	}
+}
+
+func TestPaymentRequests(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+	h.Fund(t, bob, 10000)
+
+	type paymentRequest struct {
+		ID                string `json:"id"`
+		Status            string `json:"status"`
+		Amount            int64  `json:"amount"`
+		TransferReference string `json:"transfer_reference"`
+	}
+	request := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/payment-requests", body, testutil.Bearer(alice.Token))
+	}
+	answer := func(user *testutil.User, id, action string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/payment-requests/"+id+"/"+action, nil, testutil.Bearer(user.Token))
+	}
+
+	testutil.ExpectProblem(t, request(map[string]interface{}{"amount": 1000}), http.StatusBadRequest, "invalid_payment_request")
+	testutil.ExpectProblem(t, request(map[string]interface{}{"amount": 1000, "wallet_number": bob.Wallet.WalletNumber, "email": bob.Email}), http.StatusBadRequest, "invalid_payment_request")
+	testutil.ExpectProblem(t, request(map[string]interface{}{"amount": 1000, "wallet_number": "0000000000000"}), http.StatusNotFound, "payer_not_found")
+	testutil.ExpectProblem(t, request(map[string]interface{}{"amount": 1000, "wallet_number": alice.Wallet.WalletNumber}), http.StatusBadRequest, "self_transfer")
+	testutil.ExpectProblem(t, request(map[string]interface{}{"amount": 1000, "email": bob.Email, "expiry": "1X"}), http.StatusBadRequest, "invalid_expiry")
+	testutil.ExpectProblem(t, request(map[string]interface{}{"amount": 50, "email": bob.Email}), http.StatusBadRequest, "invalid_amount")
+
+	// Accepting pays the requester through a transfer, once
+	var paid paymentRequest
+	testutil.ExpectJSON(t, request(map[string]interface{}{"amount": 4000, "wallet_number": bob.Wallet.WalletNumber, "memo": "Dinner"}), http.StatusCreated, &paid)
+	testutil.ExpectProblem(t, answer(alice, paid.ID, "accept"), http.StatusForbidden, "payment_request_forbidden")
+	testutil.ExpectJSON(t, answer(bob, paid.ID, "accept"), http.StatusOK, &paid)
+	if paid.Status != "accepted" || paid.TransferReference == "" {
+		t.Fatalf("accepted request = %+v, want accepted with a transfer reference", paid)
+	}
+	testutil.ExpectProblem(t, answer(bob, paid.ID, "accept"), http.StatusConflict, "invalid_payment_request_state")
+	if got := h.Balance(t, alice); got != 4000 {
+		t.Fatalf("alice balance = %d, want 4000", got)
+	}
+	if got := h.Balance(t, bob); got != 6000 {
+		t.Fatalf("bob balance = %d, want 6000", got)
+	}
+
+	// A request the payer can't afford stays pending
+	var big paymentRequest
+	testutil.ExpectJSON(t, request(map[string]interface{}{"amount": 9000, "email": strings.ToUpper(bob.Email)}), http.StatusCreated, &big)
+	testutil.ExpectProblem(t, answer(bob, big.ID, "accept"), http.StatusBadRequest, "insufficient_funds")
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-requests/"+big.ID, nil, testutil.Bearer(bob.Token)), http.StatusOK, &big)
+	if big.Status != "pending" {
+		t.Fatalf("unaffordable request is %s, want pending", big.Status)
+	}
+	testutil.ExpectJSON(t, answer(bob, big.ID, "decline"), http.StatusOK, &big)
+	if big.Status != "declined" {
+		t.Fatalf("declined request is %s, want declined", big.Status)
+	}
+
+	// Expired requests can't be answered
+	var expired paymentRequest
+	testutil.ExpectJSON(t, request(map[string]interface{}{"amount": 1000, "wallet_number": bob.Wallet.WalletNumber, "expiry": "1H"}), http.StatusCreated, &expired)
+	if _, err := h.DB.Exec(`UPDATE payment_requests SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, expired.ID); err != nil {
+		t.Fatalf("expire request: %v", err)
+	}
+	testutil.ExpectProblem(t, answer(bob, expired.ID, "accept"), http.StatusConflict, "invalid_payment_request_state")
+
+	// A request to an email nobody has signed up with reaches them once they do
+	email := "carol-" + strings.ToLower(alice.Wallet.WalletNumber) + "@example.com"
+	var early paymentRequest
+	testutil.ExpectJSON(t, request(map[string]interface{}{"amount": 1000, "email": email}), http.StatusCreated, &early)
+	carol := h.CreateUserWithEmail(t, "carol", email)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/payment-requests/"+early.ID, nil, testutil.Bearer(bob.Token)), http.StatusNotFound, "payment_request_not_found")
+	testutil.ExpectProblem(t, answer(carol, early.ID, "accept"), http.StatusBadRequest, "insufficient_funds")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodDelete, "/wallet/payment-requests/"+early.ID, nil, testutil.Bearer(carol.Token)), http.StatusForbidden, "payment_request_forbidden")
+	testutil.ExpectJSON(t, h.Do(t, http.MethodDelete, "/wallet/payment-requests/"+early.ID, nil, testutil.Bearer(alice.Token)), http.StatusOK, &early)
+	if early.Status != "cancelled" {
+		t.Fatalf("cancelled request is %s, want cancelled", early.Status)
+	}
+
+	var list struct {
+		PaymentRequests []paymentRequest `json:"payment_requests"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-requests?direction=incoming", nil, testutil.Bearer(bob.Token)), http.StatusOK, &list)
+	if len(list.PaymentRequests) != 3 || list.PaymentRequests[0].Status != "expired" {
+		t.Fatalf("bob's incoming requests = %+v, want three, newest expired", list.PaymentRequests)
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-requests?direction=outgoing&status=accepted", nil, testutil.Bearer(alice.Token)), http.StatusOK, &list)
+	if len(list.PaymentRequests) != 1 || list.PaymentRequests[0].ID != paid.ID {
+		t.Fatalf("alice's accepted requests = %+v, want the paid one", list.PaymentRequests)
+	}
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/payment-requests?direction=sideways", nil, testutil.Bearer(alice.Token)), http.StatusBadRequest, "invalid_payment_request")
+}
+
+func TestPaymentLinks(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+
+	type paymentLink struct {
+		ID            string `json:"id"`
+		Code          string `json:"code"`
+		URL           string `json:"url"`
+		IsActive      bool   `json:"is_active"`
+		PaymentCount  int    `json:"payment_count"`
+		TotalReceived int64  `json:"total_received"`
+	}
+	type checkout struct {
+		Reference        string `json:"reference"`
+		Amount           int64  `json:"amount"`
+		AuthorizationURL string `json:"authorization_url"`
+	}
+
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/payment-links", map[string]interface{}{"amount": 50}, testutil.Bearer(alice.Token)), http.StatusBadRequest, "invalid_amount")
+
+	var open, fixed paymentLink
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/payment-links", map[string]interface{}{"description": "Tips"}, testutil.Bearer(alice.Token)), http.StatusCreated, &open)
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/payment-links", map[string]interface{}{"amount": 2500, "expiry": "1D"}, testutil.Bearer(alice.Token)), http.StatusCreated, &fixed)
+	if open.URL != "http://wallet.test/pay/"+open.Code || !open.IsActive {
+		t.Fatalf("payment link = %+v, want an active link under the public URL", open)
+	}
+
+	// Anyone can see and pay through a link, without signing in
+	var info struct {
+		RecipientName string `json:"recipient_name"`
+		Active        bool   `json:"active"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/pay/"+open.Code, nil), http.StatusOK, &info)
+	if info.RecipientName != "alice" || !info.Active {
+		t.Fatalf("link info = %+v, want an active link paying alice", info)
+	}
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/pay/nope", nil), http.StatusNotFound, "payment_link_not_found")
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/pay/"+open.Code, map[string]interface{}{"email": "not-an-email", "amount": 1000}), http.StatusBadRequest)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/pay/"+open.Code, map[string]interface{}{"email": "payer@example.com"}), http.StatusBadRequest, "invalid_amount")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/pay/"+fixed.Code, map[string]interface{}{"email": "payer@example.com", "amount": 1000}), http.StatusBadRequest, "invalid_amount")
+
+	var paid checkout
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/pay/"+fixed.Code, map[string]interface{}{"email": "payer@example.com"}), http.StatusOK, &paid)
+	if paid.Amount != 2500 || paid.AuthorizationURL == "" {
+		t.Fatalf("checkout = %+v, want the link's fixed amount", paid)
+	}
+
+	// The payer's charge settles into the owner's wallet, without saving the payer's card
+	body, signature := h.Paystack.ChargeSuccess(t, paid.Reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := h.Balance(t, alice); got != 2500 {
+		t.Fatalf("alice balance = %d, want 2500", got)
+	}
+	if rec := h.Do(t, http.MethodGet, "/wallet/payment-methods", nil, testutil.Bearer(alice.Token)); strings.Contains(rec.Body.String(), "4081") {
+		t.Fatalf("payer's card was saved for the link owner: %s", rec.Body.String())
+	}
+
+	var list struct {
+		PaymentLinks []paymentLink `json:"payment_links"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-links", nil, testutil.Bearer(alice.Token)), http.StatusOK, &list)
+	if len(list.PaymentLinks) != 2 || list.PaymentLinks[0].ID != fixed.ID || list.PaymentLinks[0].PaymentCount != 1 || list.PaymentLinks[0].TotalReceived != 2500 {
+		t.Fatalf("payment links = %+v, want the fixed link with one 2500 payment", list.PaymentLinks)
+	}
+
+	// The QR code is a PNG of the link
+	rec := h.Do(t, http.MethodGet, "/pay/"+open.Code+"/qr.png", nil)
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	if _, err := png.Decode(rec.Body); err != nil || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("QR code is not a PNG (%s): %v", rec.Header().Get("Content-Type"), err)
+	}
+
+	// A deactivated link stops taking payments
+	testutil.ExpectStatus(t, h.Do(t, http.MethodDelete, "/wallet/payment-links/"+open.ID, nil, testutil.Bearer(alice.Token)), http.StatusOK)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodDelete, "/wallet/payment-links/"+open.ID, nil, testutil.Bearer(alice.Token)), http.StatusConflict, "payment_link_inactive")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/pay/"+open.Code, map[string]interface{}{"email": "payer@example.com", "amount": 1000}), http.StatusConflict, "payment_link_inactive")
+}
+
+func TestBatchTransfers(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+	carol := h.CreateUser(t, "carol")
+	h.Fund(t, alice, 10000)
+
+	type batchResponse struct {
+		Batch struct {
+			ID              string `json:"id"`
+			Mode            string `json:"mode"`
+			Status          string `json:"status"`
+			TotalItems      int    `json:"total_items"`
+			TotalAmount     int64  `json:"total_amount"`
+			SucceededCount  int    `json:"succeeded_count"`
+			FailedCount     int    `json:"failed_count"`
+			SucceededAmount int64  `json:"succeeded_amount"`
+		} `json:"batch"`
+		Progress struct {
+			Processed int `json:"processed"`
+			Total     int `json:"total"`
+			Percent   int `json:"percent"`
+		} `json:"progress"`
+		ResultURL string `json:"result_url"`
+	}
+	type line map[string]interface{}
+
+	create := func(mode string, transfers ...line) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/batches", map[string]interface{}{"mode": mode, "transfers": transfers}, testutil.Bearer(alice.Token))
+	}
+	// waitFor polls the batch until the worker has moved it to status
+	waitFor := func(id, status string) batchResponse {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			var b batchResponse
+			testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/batches/"+id, nil, testutil.Bearer(alice.Token)), http.StatusOK, &b)
+			if b.Batch.Status == status {
+				return b
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("batch %s is %+v, want status %s", id, b.Batch, status)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// Every line is validated; an all-or-nothing batch with any bad line, or a batch the
+	// wallet can't cover, is refused outright
+	testutil.ExpectProblem(t, create("all_or_nothing"), http.StatusBadRequest, "invalid_batch")
+	testutil.ExpectProblem(t, create("sideways", line{"wallet_number": bob.Wallet.WalletNumber, "amount": 1000}), http.StatusBadRequest, "invalid_batch")
+	testutil.ExpectProblem(t, create("all_or_nothing",
+		line{"wallet_number": bob.Wallet.WalletNumber, "amount": 1000},
+		line{"wallet_number": "0000000000000", "amount": 1000},
+	), http.StatusBadRequest, "invalid_batch")
+	testutil.ExpectProblem(t, create("best_effort", line{"wallet_number": alice.Wallet.WalletNumber, "amount": 1000}), http.StatusBadRequest, "invalid_batch")
+	testutil.ExpectProblem(t, create("all_or_nothing", line{"wallet_number": bob.Wallet.WalletNumber, "amount": 20000}), http.StatusBadRequest, "insufficient_funds")
+	tooMany := make([]line, h.Config.Batches.MaxItems+1)
+	for i := range tooMany {
+		tooMany[i] = line{"wallet_number": bob.Wallet.WalletNumber, "amount": 100}
+	}
+	testutil.ExpectProblem(t, create("best_effort", tooMany...), http.StatusBadRequest, "invalid_batch")
+	csvLines := "wallet_number,amount\n" + strings.Repeat(bob.Wallet.WalletNumber+",100\n", h.Config.Batches.MaxItems+1)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/batches", []byte(csvLines),
+		testutil.Bearer(alice.Token), testutil.Header("Content-Type", "text/csv")), http.StatusBadRequest, "invalid_batch")
+
+	// Uploads are capped in bytes too, so a huge body isn't read before the line count is checked
+	padding := strings.Repeat("x", 1<<20)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/batches", []byte("wallet_number,amount,description\n"+bob.Wallet.WalletNumber+",100,"+padding+"\n"),
+		testutil.Bearer(alice.Token), testutil.Header("Content-Type", "text/csv")), http.StatusRequestEntityTooLarge, "upload_too_large")
+	testutil.ExpectProblem(t, create("best_effort", line{"wallet_number": bob.Wallet.WalletNumber, "amount": 100, "description": padding}),
+		http.StatusRequestEntityTooLarge, "upload_too_large")
+	if got := h.Balance(t, alice); got != 10000 {
+		t.Fatalf("alice balance after refused batches = %d, want 10000", got)
+	}
+
+	// The total is reserved on upload, then every line is paid in the background
+	var atomic batchResponse
+	testutil.ExpectJSON(t, create("all_or_nothing",
+		line{"wallet_number": bob.Wallet.WalletNumber, "amount": 1000, "description": "October"},
+		line{"wallet_number": carol.Wallet.WalletNumber, "amount": 2000},
+	), http.StatusAccepted, &atomic)
+	if atomic.Batch.TotalItems != 2 || atomic.Batch.TotalAmount != 3000 || atomic.Progress.Total != 2 {
+		t.Fatalf("batch = %+v, want 2 items reserving 3000", atomic)
+	}
+	if got := h.Balance(t, alice); got != 7000 {
+		t.Fatalf("alice balance after reserving = %d, want 7000", got)
+	}
+	done := waitFor(atomic.Batch.ID, "completed")
+	if done.Batch.SucceededCount != 2 || done.Batch.SucceededAmount != 3000 || done.Progress.Percent != 100 {
+		t.Fatalf("completed batch = %+v, want both items paid", done)
+	}
+	if h.Balance(t, bob) != 1000 || h.Balance(t, carol) != 2000 || h.Balance(t, alice) != 7000 {
+		t.Fatalf("balances = alice %d, bob %d, carol %d; want 7000, 1000, 2000", h.Balance(t, alice), h.Balance(t, bob), h.Balance(t, carol))
+	}
+
+	// A best-effort CSV upload records bad lines as failed and pays the rest
+	upload := "wallet_number,amount,description\n" +
+		bob.Wallet.WalletNumber + ",500,Bonus\n" +
+		"0000000000000,300,Nobody\n" +
+		carol.Wallet.WalletNumber + ",lots,Typo\n"
+	var partial batchResponse
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/batches?mode=best_effort", []byte(upload),
+		testutil.Bearer(alice.Token), testutil.Header("Content-Type", "text/csv")), http.StatusAccepted, &partial)
+	if partial.Batch.Mode != "best_effort" || partial.Batch.TotalItems != 3 || partial.Batch.TotalAmount != 500 || partial.Batch.FailedCount != 2 {
+		t.Fatalf("batch = %+v, want 3 lines with 2 rejected and 500 reserved", partial.Batch)
+	}
+	done = waitFor(partial.Batch.ID, "completed")
+	if done.Batch.SucceededCount != 1 || done.Batch.FailedCount != 2 || done.Progress.Processed != 3 {
+		t.Fatalf("completed batch = %+v, want 1 paid and 2 failed", done)
+	}
+	if h.Balance(t, alice) != 6500 || h.Balance(t, bob) != 1500 {
+		t.Fatalf("balances = alice %d, bob %d; want 6500, 1500", h.Balance(t, alice), h.Balance(t, bob))
+	}
+
+	var failed struct {
+		Items []struct {
+			Line      int    `json:"line"`
+			ErrorCode string `json:"error_code"`
+		} `json:"items"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/batches/"+partial.Batch.ID+"/items?status=failed", nil, testutil.Bearer(alice.Token)), http.StatusOK, &failed)
+	if len(failed.Items) != 2 || failed.Items[0].ErrorCode != "recipient_not_found" || failed.Items[1].ErrorCode != "invalid_amount" {
+		t.Fatalf("failed items = %+v, want recipient_not_found and invalid_amount", failed.Items)
+	}
+
+	// The result file has every line with its outcome
+	rec := h.Do(t, http.MethodGet, partial.ResultURL, nil, testutil.Bearer(alice.Token))
+	testutil.ExpectStatus(t, rec, http.StatusOK)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(rows) != 4 || rows[1][4] != "success" || rows[1][5] == "" || rows[2][6] != "recipient_not_found" {
+		t.Fatalf("result file = %v (%v), want a header and 3 lines", rows, err)
+	}
+
+	// Batches are private to their owner
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/batches/"+partial.Batch.ID, nil, testutil.Bearer(bob.Token)), http.StatusNotFound, "batch_not_found")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, partial.ResultURL, nil, testutil.Bearer(bob.Token)), http.StatusNotFound, "batch_not_found")
+}
+
+func TestDisputes(t *testing.T) {
+	h := testutil.NewHarness(t)
+	admin := h.CreateAdmin(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+	carol := h.CreateUser(t, "carol")
+	h.Fund(t, alice, 10000)
+
+	type dispute struct {
+		ID                string `json:"id"`
+		TransferReference string `json:"transfer_reference"`
+		Amount            int64  `json:"amount"`
+		Status            string `json:"status"`
+		Resolution        string `json:"resolution"`
+		ResolutionNote    string `json:"resolution_note"`
+		ReversalReference string `json:"reversal_reference"`
+	}
+	transfer := func(from, to *testutil.User, amount int64) string {
+		t.Helper()
+		var resp struct {
+			Reference string `json:"reference"`
+		}
+		testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{
+			"wallet_number": to.Wallet.WalletNumber,
+			"amount":        amount,
+		}, testutil.Bearer(from.Token)), http.StatusOK, &resp)
+		return resp.Reference
+	}
+	open := func(user *testutil.User, reference string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/disputes", map[string]string{"reference": reference, "reason": "Sent to the wrong wallet"}, testutil.Bearer(user.Token))
+	}
+	act := func(user *testutil.User, id, action string, body interface{}) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/disputes/"+id+"/"+action, body, testutil.Bearer(user.Token))
+	}
+	expectBalances := func(want map[*testutil.User]int64) {
+		t.Helper()
+		for user, balance := range want {
+			if got := h.Balance(t, user); got != balance {
+				t.Fatalf("%s balance = %d, want %d", user.Name, got, balance)
+			}
+		}
+	}
+
+	reference := transfer(alice, bob, 3000)
+
+	// Only the sender of a completed transfer can dispute it, once
+	testutil.ExpectProblem(t, open(alice, "TRF_unknown"), http.StatusNotFound, "transfer_not_found")
+	testutil.ExpectProblem(t, open(bob, reference), http.StatusNotFound, "transfer_not_found")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/disputes", map[string]string{"reference": reference, "reason": "  "}, testutil.Bearer(alice.Token)), http.StatusBadRequest, "invalid_dispute")
+	var first dispute
+	testutil.ExpectJSON(t, open(alice, reference), http.StatusCreated, &first)
+	if first.Status != "open" || first.Amount != 3000 || first.TransferReference != reference {
+		t.Fatalf("dispute = %+v, want an open dispute of 3000", first)
+	}
+	testutil.ExpectProblem(t, open(alice, reference), http.StatusConflict, "dispute_exists")
+	testutil.ExpectProblem(t, act(alice, first.ID, "approve", nil), http.StatusForbidden, "dispute_forbidden")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/disputes/"+first.ID, nil, testutil.Bearer(carol.Token)), http.StatusNotFound, "dispute_not_found")
+
+	// The recipient can only approve what their balance covers, and may reject instead
+	transfer(bob, carol, 2500)
+	testutil.ExpectProblem(t, act(bob, first.ID, "approve", nil), http.StatusBadRequest, "insufficient_funds")
+	testutil.ExpectJSON(t, act(bob, first.ID, "reject", map[string]string{"note": "It was for rent"}), http.StatusOK, &first)
+	if first.Status != "rejected" || first.ResolutionNote != "It was for rent" {
+		t.Fatalf("rejected dispute = %+v, want rejected with the note", first)
+	}
+	testutil.ExpectProblem(t, act(bob, first.ID, "approve", nil), http.StatusConflict, "invalid_dispute_state")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodDelete, "/wallet/disputes/"+first.ID, nil, testutil.Bearer(alice.Token)), http.StatusConflict, "invalid_dispute_state")
+
+	// An admin can still force the reversal once the recipient can cover it
+	force := func(user *testutil.User, id string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/admin/disputes/"+id+"/reverse", map[string]string{"note": "Confirmed with the bank"}, testutil.Bearer(user.Token))
+	}
+	testutil.ExpectProblem(t, force(alice, first.ID), http.StatusForbidden, "admin_required")
+	h.Fund(t, bob, 3000)
+	testutil.ExpectJSON(t, force(admin, first.ID), http.StatusOK, &first)
+	if first.Status != "reversed" || first.Resolution != "forced" || first.ReversalReference == "" {
+		t.Fatalf("forced dispute = %+v, want reversed by force with a reversal reference", first)
+	}
+	testutil.ExpectProblem(t, force(admin, first.ID), http.StatusConflict, "invalid_dispute_state")
+	expectBalances(map[*testutil.User]int64{alice: 10000, bob: 500, carol: 2500})
+
+	// The recipient approving sends the money straight back
+	var second dispute
+	testutil.ExpectJSON(t, open(alice, transfer(alice, carol, 1000)), http.StatusCreated, &second)
+	testutil.ExpectJSON(t, act(carol, second.ID, "approve", nil), http.StatusOK, &second)
+	if second.Status != "reversed" || second.Resolution != "approved" {
+		t.Fatalf("approved dispute = %+v, want reversed by approval", second)
+	}
+	expectBalances(map[*testutil.User]int64{alice: 10000, carol: 2500})
+
+	// Reversals can't be disputed, and a cancelled dispute can be opened again
+	testutil.ExpectProblem(t, open(carol, second.ReversalReference), http.StatusBadRequest, "invalid_dispute")
+	small := transfer(alice, carol, 500)
+	var third dispute
+	testutil.ExpectJSON(t, open(alice, small), http.StatusCreated, &third)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodDelete, "/wallet/disputes/"+third.ID, nil, testutil.Bearer(carol.Token)), http.StatusForbidden, "dispute_forbidden")
+	testutil.ExpectJSON(t, h.Do(t, http.MethodDelete, "/wallet/disputes/"+third.ID, nil, testutil.Bearer(alice.Token)), http.StatusOK, &third)
+	if third.Status != "cancelled" {
+		t.Fatalf("cancelled dispute is %s, want cancelled", third.Status)
+	}
+	testutil.ExpectStatus(t, open(alice, small), http.StatusCreated)
+
+	// The original entries are kept; each reversal entry points at the one it compensates
+	var linked int
+	if err := h.DB.Get(&linked, `
+		SELECT COUNT(*) FROM transactions r JOIN transactions o ON o.id = r.reversal_of
+		WHERE r.wallet_id = o.wallet_id AND r.type <> o.type AND o.status = 'success'`); err != nil {
+		t.Fatalf("count reversal entries: %v", err)
+	}
+	if linked != 4 {
+		t.Fatalf("linked reversal entries = %d, want 4", linked)
+	}
+
+	var list struct {
+		Disputes []dispute `json:"disputes"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/disputes?status=reversed", nil, testutil.Bearer(alice.Token)), http.StatusOK, &list)
+	if len(list.Disputes) != 2 {
+		t.Fatalf("alice's reversed disputes = %+v, want two", list.Disputes)
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/disputes?role=recipient", nil, testutil.Bearer(carol.Token)), http.StatusOK, &list)
+	if len(list.Disputes) != 3 || list.Disputes[0].Status != "open" {
+		t.Fatalf("carol's disputes = %+v, want three, newest open", list.Disputes)
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/admin/disputes?status=open", nil, testutil.Bearer(admin.Token)), http.StatusOK, &list)
+	if len(list.Disputes) != 1 {
+		t.Fatalf("open disputes = %+v, want one", list.Disputes)
+	}
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/disputes?role=sideways", nil, testutil.Bearer(alice.Token)), http.StatusBadRequest, "invalid_dispute")
+}
+
+func TestEscrow(t *testing.T) {
+	h := testutil.NewHarness(t)
+	buyer := h.CreateUser(t, "buyer")
+	seller := h.CreateUser(t, "seller")
+	arbiter := h.CreateUser(t, "arbiter")
+	h.Fund(t, buyer, 10000)
+
+	type escrow struct {
+		ID               string `json:"id"`
+		Amount           int64  `json:"amount"`
+		ReleasedAmount   int64  `json:"released_amount"`
+		RefundedAmount   int64  `json:"refunded_amount"`
+		Status           string `json:"status"`
+		FundingReference string `json:"funding_reference"`
+	}
+	create := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/escrows", body, testutil.Bearer(buyer.Token))
+	}
+	fund := func(amount int64, withArbiter bool) escrow {
+		t.Helper()
+		body := map[string]interface{}{
+			"seller_wallet_number": seller.Wallet.WalletNumber,
+			"amount":               amount,
+			"conditions":           "Goods delivered",
+			"deadline":             "7D",
+		}
+		if withArbiter {
+			body["arbiter_wallet_number"] = arbiter.Wallet.WalletNumber
+		}
+		var created escrow
+		testutil.ExpectJSON(t, create(body), http.StatusCreated, &created)
+		return created
+	}
+	act := func(user *testutil.User, id, action string, body interface{}) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/escrows/"+id+"/"+action, body, testutil.Bearer(user.Token))
+	}
+	expectBalances := func(want map[*testutil.User]int64) {
+		t.Helper()
+		for user, balance := range want {
+			if got := h.Balance(t, user); got != balance {
+				t.Fatalf("%s balance = %d, want %d", user.Name, got, balance)
+			}
+		}
+	}
+
+	// Funding validates the parties and holds the amount out of the buyer's wallet
+	testutil.ExpectProblem(t, create(map[string]interface{}{"seller_wallet_number": buyer.Wallet.WalletNumber, "amount": 1000}), http.StatusBadRequest, "self_transfer")
+	testutil.ExpectProblem(t, create(map[string]interface{}{"seller_wallet_number": seller.Wallet.WalletNumber, "arbiter_wallet_number": seller.Wallet.WalletNumber, "amount": 1000}), http.StatusBadRequest, "invalid_escrow")
+	testutil.ExpectProblem(t, create(map[string]interface{}{"seller_wallet_number": seller.Wallet.WalletNumber, "amount": 20000}), http.StatusBadRequest, "insufficient_funds")
+	released := fund(3000, false)
+	if released.Status != "funded" || released.Amount != 3000 || released.FundingReference == "" {
+		t.Fatalf("escrow = %+v, want funded with 3000", released)
+	}
+	expectBalances(map[*testutil.User]int64{buyer: 7000, seller: 0})
+
+	// Funding isn't a transfer to the seller, so it can't be disputed and reversed
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/disputes", map[string]string{"reference": released.FundingReference, "reason": "Changed my mind"}, testutil.Bearer(buyer.Token)), http.StatusBadRequest, "invalid_dispute")
+
+	// The escrow wallet can't be paid directly
+	var vault string
+	if err := h.DB.Get(&vault, `SELECT wallet_number FROM wallets WHERE is_system`); err != nil {
+		t.Fatalf("find escrow wallet: %v", err)
+	}
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{"wallet_number": vault, "amount": 100}, testutil.Bearer(buyer.Token)), http.StatusNotFound, "recipient_not_found")
+
+	// Only the buyer releases an escrow without an arbiter; strangers don't see it
+	testutil.ExpectProblem(t, act(seller, released.ID, "release", nil), http.StatusForbidden, "escrow_forbidden")
+	testutil.ExpectProblem(t, act(arbiter, released.ID, "release", nil), http.StatusNotFound, "escrow_not_found")
+	testutil.ExpectJSON(t, act(buyer, released.ID, "release", map[string]string{"note": "Arrived"}), http.StatusOK, &released)
+	if released.Status != "released" || released.ReleasedAmount != 3000 {
+		t.Fatalf("released escrow = %+v, want 3000 released", released)
+	}
+	testutil.ExpectProblem(t, act(buyer, released.ID, "release", nil), http.StatusConflict, "invalid_escrow_state")
+	expectBalances(map[*testutil.User]int64{buyer: 7000, seller: 3000})
+
+	// The seller can refund; the buyer only once the deadline has passed
+	refunded := fund(2000, false)
+	testutil.ExpectProblem(t, act(buyer, refunded.ID, "refund", nil), http.StatusForbidden, "escrow_forbidden")
+	testutil.ExpectJSON(t, act(seller, refunded.ID, "refund", nil), http.StatusOK, &refunded)
+	if refunded.Status != "refunded" || refunded.RefundedAmount != 2000 {
+		t.Fatalf("refunded escrow = %+v, want 2000 refunded", refunded)
+	}
+	expired := fund(1000, false)
+	if _, err := h.DB.Exec(`UPDATE escrows SET deadline = NOW() - INTERVAL '1 minute' WHERE id = $1`, expired.ID); err != nil {
+		t.Fatalf("expire escrow: %v", err)
+	}
+	testutil.ExpectJSON(t, act(buyer, expired.ID, "refund", nil), http.StatusOK, &expired)
+	expectBalances(map[*testutil.User]int64{buyer: 7000, seller: 3000})
+
+	// Only the arbiter can split, and both sides must get something
+	split := fund(4000, true)
+	testutil.ExpectProblem(t, act(buyer, split.ID, "split", map[string]interface{}{"seller_amount": 1500}), http.StatusForbidden, "escrow_forbidden")
+	testutil.ExpectProblem(t, act(arbiter, split.ID, "split", map[string]interface{}{"seller_amount": 4000}), http.StatusBadRequest, "invalid_escrow")
+	testutil.ExpectJSON(t, act(arbiter, split.ID, "split", map[string]interface{}{"seller_amount": 1500, "note": "Half delivered"}), http.StatusOK, &split)
+	if split.Status != "split" || split.ReleasedAmount != 1500 || split.RefundedAmount != 2500 {
+		t.Fatalf("split escrow = %+v, want 1500 released and 2500 refunded", split)
+	}
+	expectBalances(map[*testutil.User]int64{buyer: 5500, seller: 4500, arbiter: 0})
+
+	// Every step is in the history, and nothing is left in the escrow wallet
+	var history struct {
+		Events []struct {
+			Action       string `json:"action"`
+			SellerAmount int64  `json:"seller_amount"`
+			BuyerAmount  int64  `json:"buyer_amount"`
+			Note         string `json:"note"`
+		} `json:"events"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/escrows/"+split.ID+"/events", nil, testutil.Bearer(seller.Token)), http.StatusOK, &history)
+	if len(history.Events) != 2 || history.Events[0].Action != "funded" || history.Events[1].Action != "split" || history.Events[1].Note != "Half delivered" {
+		t.Fatalf("escrow history = %+v, want funded then split", history.Events)
+	}
+	var held int64
+	if err := h.DB.Get(&held, `SELECT balance FROM wallets WHERE is_system`); err != nil {
+		t.Fatalf("read escrow wallet: %v", err)
+	}
+	if held != 0 {
+		t.Fatalf("escrow wallet holds %d, want 0", held)
+	}
+
+	var list struct {
+		Escrows []escrow `json:"escrows"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/escrows?role=arbiter", nil, testutil.Bearer(arbiter.Token)), http.StatusOK, &list)
+	if len(list.Escrows) != 1 || list.Escrows[0].ID != split.ID {
+		t.Fatalf("arbitrated escrows = %+v, want the split one", list.Escrows)
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/escrows?status=refunded", nil, testutil.Bearer(buyer.Token)), http.StatusOK, &list)
+	if len(list.Escrows) != 2 {
+		t.Fatalf("buyer's refunded escrows = %+v, want two", list.Escrows)
+	}
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/escrows?role=owner", nil, testutil.Bearer(buyer.Token)), http.StatusBadRequest, "invalid_escrow")
+}
+
+func TestMultipleWallets(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+	h.Fund(t, alice, 10000)
+	primary := alice.Wallet.WalletNumber
+
+	type wallet struct {
+		WalletNumber string `json:"wallet_number"`
+		Name         string `json:"name"`
+		Balance      int64  `json:"balance"`
+		IsDefault    bool   `json:"is_default"`
+	}
+	create := func(user *testutil.User, name string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/wallets", map[string]string{"name": name}, testutil.Bearer(user.Token))
+	}
+	move := func(from, to string, amount int64) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/move", map[string]interface{}{
+			"from_wallet_number": from,
+			"to_wallet_number":   to,
+			"amount":             amount,
+		}, testutil.Bearer(alice.Token))
+	}
+	balance := func(user *testutil.User, walletNumber string) int64 {
+		t.Helper()
+		var resp struct {
+			Balance int64 `json:"balance"`
+		}
+		testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/balance?wallet_number="+walletNumber, nil, testutil.Bearer(user.Token)), http.StatusOK, &resp)
+		return resp.Balance
+	}
+
+	// Every user starts with one default wallet and can open more, with unique names
+	var savings wallet
+	testutil.ExpectJSON(t, create(alice, "Savings"), http.StatusCreated, &savings)
+	if savings.Name != "Savings" || savings.IsDefault || savings.Balance != 0 || savings.WalletNumber == "" {
+		t.Fatalf("new wallet = %+v, want an empty, non-default wallet called Savings", savings)
+	}
+	testutil.ExpectProblem(t, create(alice, "savings"), http.StatusConflict, "wallet_name_taken")
+	testutil.ExpectProblem(t, create(alice, "   "), http.StatusBadRequest, "invalid_wallet_name")
+	testutil.ExpectStatus(t, create(bob, "Savings"), http.StatusCreated)
+
+	// Moves between a user's own wallets are free; other users' wallets can't be used
+	testutil.ExpectStatus(t, move(primary, savings.WalletNumber, 4000), http.StatusOK)
+	testutil.ExpectProblem(t, move(primary, primary, 100), http.StatusBadRequest, "self_transfer")
+	testutil.ExpectProblem(t, move(primary, bob.Wallet.WalletNumber, 100), http.StatusNotFound, "wallet_not_found")
+	testutil.ExpectProblem(t, move(savings.WalletNumber, primary, 5000), http.StatusBadRequest, "insufficient_funds")
+	if got := h.Balance(t, alice); got != 6000 {
+		t.Fatalf("default wallet balance = %d, want 6000", got)
+	}
+	if got := balance(alice, savings.WalletNumber); got != 4000 {
+		t.Fatalf("savings balance = %d, want 4000", got)
+	}
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/balance?wallet_number="+primary, nil, testutil.Bearer(bob.Token)), http.StatusNotFound, "wallet_not_found")
+
+	// Transfers can be sent from any of the user's wallets
+	transfer := func(from string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{
+			"wallet_number":      bob.Wallet.WalletNumber,
+			"amount":             1000,
+			"from_wallet_number": from,
+		}, testutil.Bearer(alice.Token))
+	}
+	testutil.ExpectStatus(t, transfer(savings.WalletNumber), http.StatusOK)
+	testutil.ExpectProblem(t, transfer(bob.Wallet.WalletNumber), http.StatusNotFound, "wallet_not_found")
+	if got := balance(alice, savings.WalletNumber); got != 3000 {
+		t.Fatalf("savings balance after transfer = %d, want 3000", got)
+	}
+	var history []struct {
+		Type   string `json:"type"`
+		Amount int64  `json:"amount"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/transactions?wallet_number="+savings.WalletNumber, nil, testutil.Bearer(alice.Token)), http.StatusOK, &history)
+	if len(history) != 2 || history[0].Type != "transfer_out" || history[1].Type != "transfer_in" {
+		t.Fatalf("savings history = %+v, want the move in and the transfer out", history)
+	}
+
+	// Deposits land in the chosen wallet
+	var deposit struct {
+		Reference string `json:"reference"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]interface{}{"amount": 500, "wallet_number": savings.WalletNumber}, testutil.Bearer(alice.Token)), http.StatusOK, &deposit)
+	body, signature := h.Paystack.ChargeSuccess(t, deposit.Reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := balance(alice, savings.WalletNumber); got != 3500 {
+		t.Fatalf("savings balance after deposit = %d, want 3500", got)
+	}
+
+	// A scheduled transfer pays from the wallet it was set up with, the default one if none is named
+	schedule := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		body["wallet_number"] = bob.Wallet.WalletNumber
+		body["amount"] = 1000
+		body["interval"] = "1D"
+		body["max_occurrences"] = 1
+		body["start_at"] = time.Now().Add(time.Hour)
+		return h.Do(t, http.MethodPost, "/wallet/scheduled-transfers", body, testutil.Bearer(alice.Token))
+	}
+	var standing struct {
+		ID               string `json:"id"`
+		FromWalletNumber string `json:"from_wallet_number"`
+	}
+	testutil.ExpectJSON(t, schedule(map[string]interface{}{}), http.StatusCreated, &standing)
+	if standing.FromWalletNumber != primary {
+		t.Fatalf("scheduled transfer pays from %s, want the default wallet %s", standing.FromWalletNumber, primary)
+	}
+	testutil.ExpectProblem(t, schedule(map[string]interface{}{"from_wallet_number": bob.Wallet.WalletNumber}), http.StatusNotFound, "wallet_not_found")
+
+	// Changing the default changes what requests without a wallet number use
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/wallets/"+bob.Wallet.WalletNumber+"/default", nil, testutil.Bearer(alice.Token)), http.StatusNotFound, "wallet_not_found")
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/wallet/wallets/"+savings.WalletNumber+"/default", nil, testutil.Bearer(alice.Token)), http.StatusOK)
+	if got := h.Balance(t, alice); got != 3500 {
+		t.Fatalf("balance of the new default wallet = %d, want 3500", got)
+	}
+
+	// ...but not what an existing scheduled transfer pays from
+	if _, err := h.DB.Exec(`UPDATE scheduled_transfers SET occurrence_at = NOW(), next_run_at = NOW() WHERE id = $1`, standing.ID); err != nil {
+		t.Fatalf("make scheduled transfer due: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for balance(alice, primary) != 5000 {
+		if time.Now().After(deadline) {
+			t.Fatalf("old default wallet balance = %d, want 5000 after the scheduled transfer", balance(alice, primary))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := h.Balance(t, alice); got != 3500 {
+		t.Fatalf("new default wallet balance after the scheduled transfer = %d, want 3500", got)
+	}
+	var list struct {
+		Wallets []wallet `json:"wallets"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/wallets", nil, testutil.Bearer(alice.Token)), http.StatusOK, &list)
+	if len(list.Wallets) != 2 || list.Wallets[0].WalletNumber != savings.WalletNumber || !list.Wallets[0].IsDefault || list.Wallets[1].IsDefault {
+		t.Fatalf("wallets = %+v, want Savings as the only default, listed first", list.Wallets)
+	}
+
+	// A user can have at most service.MaxWallets wallets
+	for i := len(list.Wallets); i < service.MaxWallets; i++ {
+		testutil.ExpectStatus(t, create(alice, fmt.Sprintf("Pocket %d", i)), http.StatusCreated)
+	}
+	testutil.ExpectProblem(t, create(alice, "One too many"), http.StatusBadRequest, "wallet_limit_reached")
+}
+
+func TestOrganizations(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUserWithEmail(t, "bob", "bob@acme.test")
+	carol := h.CreateUserWithEmail(t, "carol", "carol@acme.test")
+	dave := h.CreateUser(t, "dave")
+	h.Fund(t, alice, 10000)
+
+	var org struct {
+		ID           string `json:"id"`
+		Name         string `json:"name"`
+		WalletNumber string `json:"wallet_number"`
+		Role         string `json:"role"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/organizations", map[string]string{"name": "Acme"}, testutil.Bearer(alice.Token)), http.StatusCreated, &org)
+	if org.Name != "Acme" || org.Role != "owner" || org.WalletNumber == "" {
+		t.Fatalf("organization = %+v, want Acme owned by alice with a wallet", org)
+	}
+	asOrg := testutil.Header("X-Organization-ID", org.ID)
+	orgPath := "/organizations/" + org.ID
+
+	// Invitations are addressed to an email and only its owner can answer them
+	invite := func(user *testutil.User, email, role string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, orgPath+"/invitations", map[string]string{"email": email, "role": role}, testutil.Bearer(user.Token))
+	}
+	var bobInvite, carolInvite struct {
+		ID string `json:"id"`
+	}
+	testutil.ExpectJSON(t, invite(alice, "Bob@acme.test", "finance"), http.StatusCreated, &bobInvite)
+	testutil.ExpectJSON(t, invite(alice, "carol@acme.test", "viewer"), http.StatusCreated, &carolInvite)
+	testutil.ExpectProblem(t, invite(alice, "bob@acme.test", "viewer"), http.StatusConflict, "invitation_exists")
+	testutil.ExpectProblem(t, invite(alice, "erin@acme.test", "auditor"), http.StatusBadRequest, "invalid_organization")
+	testutil.ExpectProblem(t, invite(dave, "erin@acme.test", "viewer"), http.StatusNotFound, "organization_not_found")
+
+	var pending struct {
+		Invitations []struct {
+			ID               string `json:"id"`
+			OrganizationName string `json:"organization_name"`
+		} `json:"invitations"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/organizations/invitations", nil, testutil.Bearer(bob.Token)), http.StatusOK, &pending)
+	if len(pending.Invitations) != 1 || pending.Invitations[0].ID != bobInvite.ID || pending.Invitations[0].OrganizationName != "Acme" {
+		t.Fatalf("bob's invitations = %+v, want the Acme invitation", pending.Invitations)
+	}
+
+	// The invitee is emailed how to answer
+	email := h.SMTP.WaitForEmail(t, "bob@acme.test")
+	if email.Subject != "You're invited to join Acme" || !strings.Contains(email.Body, "as finance") ||
+		!strings.Contains(email.Body, "http://wallet.test/organizations/invitations/"+bobInvite.ID+"/accept") {
+		t.Fatalf("invitation email = %+v, want the Acme invitation with its accept link", email)
+	}
+	accept := func(user *testutil.User, id string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/organizations/invitations/"+id+"/accept", nil, testutil.Bearer(user.Token))
+	}
+	testutil.ExpectProblem(t, accept(carol, bobInvite.ID), http.StatusNotFound, "invitation_not_found")
+	testutil.ExpectStatus(t, accept(bob, bobInvite.ID), http.StatusOK)
+	testutil.ExpectProblem(t, accept(bob, bobInvite.ID), http.StatusConflict, "invalid_invitation_state")
+	testutil.ExpectStatus(t, accept(carol, carolInvite.ID), http.StatusOK)
+	testutil.ExpectProblem(t, invite(alice, "bob@acme.test", "viewer"), http.StatusConflict, "already_member")
+
+	var members struct {
+		Members []struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		} `json:"members"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, orgPath+"/members", nil, testutil.Bearer(carol.Token)), http.StatusOK, &members)
+	if len(members.Members) != 3 || members.Members[1].Role != "finance" || members.Members[2].Role != "viewer" {
+		t.Fatalf("members = %+v, want alice, bob (finance) and carol (viewer)", members.Members)
+	}
+
+	// Members share the organization's wallet with the permissions of their role
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{
+		"wallet_number": org.WalletNumber,
+		"amount":        5000,
+	}, testutil.Bearer(alice.Token)), http.StatusOK)
+	orgTransfer := func(user *testutil.User, amount int64) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{
+			"wallet_number": dave.Wallet.WalletNumber,
+			"amount":        amount,
+		}, testutil.Bearer(user.Token), asOrg)
+	}
+	orgBalance := func(opts ...testutil.RequestOption) int64 {
+		t.Helper()
+		var resp struct {
+			Balance int64 `json:"balance"`
+		}
+		testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/balance", nil, opts...), http.StatusOK, &resp)
+		return resp.Balance
+	}
+	testutil.ExpectStatus(t, orgTransfer(bob, 1000), http.StatusOK)
+	testutil.ExpectProblem(t, orgTransfer(carol, 1000), http.StatusForbidden, "permission_denied")
+	testutil.ExpectProblem(t, orgTransfer(dave, 1000), http.StatusForbidden, "not_organization_member")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/balance", nil, testutil.Bearer(bob.Token), testutil.Header("X-Organization-ID", "acme")), http.StatusBadRequest, "invalid_organization_id")
+	if got := orgBalance(testutil.Bearer(carol.Token), asOrg); got != 4000 {
+		t.Fatalf("organization balance = %d, want 4000", got)
+	}
+	if got := h.Balance(t, bob); got != 0 {
+		t.Fatalf("bob's own balance = %d, want 0", got)
+	}
+	if got := h.Balance(t, dave); got != 1000 {
+		t.Fatalf("dave's balance = %d, want 1000", got)
+	}
+
+	// The audit log keeps the member who acted for the organization
+	var actor uuid.UUID
+	if err := h.DB.Get(&actor, `
+		SELECT e.actor_user_id FROM audit_events e JOIN organizations o ON o.account_user_id = e.owner_user_id
+		WHERE o.id = $1 AND e.action = 'transfer.create'`, org.ID); err != nil {
+		t.Fatalf("find organization transfer audit event: %v", err)
+	}
+	if actor != bob.ID {
+		t.Fatalf("transfer actor = %s, want bob %s", actor, bob.ID)
+	}
+
+	// Members list the organization's audit log by acting for it; their own log doesn't have it
+	type auditLog struct {
+		Events []struct {
+			Action      string     `json:"action"`
+			ActorUserID *uuid.UUID `json:"actor_user_id"`
+		} `json:"events"`
+	}
+	var orgLog, aliceLog auditLog
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/audit", nil, testutil.Bearer(carol.Token), asOrg), http.StatusOK, &orgLog)
+	actions := map[string]*uuid.UUID{}
+	for _, event := range orgLog.Events {
+		actions[event.Action] = event.ActorUserID
+	}
+	if created := actions["organization.create"]; created == nil || *created != alice.ID {
+		t.Fatalf("organization audit log = %+v, want organization.create by alice", orgLog.Events)
+	}
+	if transferred := actions["transfer.create"]; transferred == nil || *transferred != bob.ID {
+		t.Fatalf("organization audit log = %+v, want transfer.create by bob", orgLog.Events)
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/audit", nil, testutil.Bearer(alice.Token)), http.StatusOK, &aliceLog)
+	for _, event := range aliceLog.Events {
+		if event.Action == "organization.create" {
+			t.Fatalf("alice's audit log has the organization's events: %+v", aliceLog.Events)
+		}
+	}
+
+	// A member deposits for the organization with their own email, and the card they paid
+	// with is saved for them, not for the organization
+	var deposit struct {
+		Reference string `json:"reference"`
+		Status    string `json:"status"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]int64{"amount": 3000}, testutil.Bearer(bob.Token), asOrg), http.StatusOK, &deposit)
+	if checkout, _ := h.Paystack.Transaction(deposit.Reference); checkout.Email != "bob@acme.test" {
+		t.Fatalf("organization checkout email = %q, want bob@acme.test", checkout.Email)
+	}
+	body, signature := h.Paystack.ChargeSuccess(t, deposit.Reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+	if got := orgBalance(testutil.Bearer(bob.Token), asOrg); got != 7000 {
+		t.Fatalf("organization balance after deposit = %d, want 7000", got)
+	}
+
+	var cards struct {
+		PaymentMethods []struct {
+			ID string `json:"id"`
+		} `json:"payment_methods"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-methods", nil, testutil.Bearer(bob.Token), asOrg), http.StatusOK, &cards)
+	if len(cards.PaymentMethods) != 0 {
+		t.Fatalf("organization cards = %d, want 0", len(cards.PaymentMethods))
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/payment-methods", nil, testutil.Bearer(bob.Token)), http.StatusOK, &cards)
+	if len(cards.PaymentMethods) != 1 {
+		t.Fatalf("bob's cards = %d, want 1", len(cards.PaymentMethods))
+	}
+
+	// Neither another member nor bob himself can charge bob's card for the organization
+	chargeBobsCard := func(opts ...testutil.RequestOption) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/wallet/deposit/charge", map[string]interface{}{
+			"payment_method_id": cards.PaymentMethods[0].ID,
+			"amount":            2000,
+		}, opts...)
+	}
+	testutil.ExpectProblem(t, chargeBobsCard(testutil.Bearer(alice.Token), asOrg), http.StatusNotFound, "payment_method_not_found")
+	testutil.ExpectProblem(t, chargeBobsCard(testutil.Bearer(bob.Token), asOrg), http.StatusNotFound, "payment_method_not_found")
+	testutil.ExpectJSON(t, chargeBobsCard(testutil.Bearer(bob.Token)), http.StatusOK, &deposit)
+	if deposit.Status != "success" {
+		t.Fatalf("bob's saved card deposit = %s, want success", deposit.Status)
+	}
+	if got := orgBalance(testutil.Bearer(bob.Token), asOrg); got != 7000 {
+		t.Fatalf("organization balance after bob's card deposit = %d, want 7000", got)
+	}
+	if got := h.Balance(t, bob); got != 2000 {
+		t.Fatalf("bob's own balance after his card deposit = %d, want 2000", got)
+	}
+
+	// A card saved for the organization before cards were kept for the member who paid is
+	// still not chargeable for it
+	if _, err := h.DB.Exec(`
+		UPDATE payment_methods SET user_id = (SELECT account_user_id FROM organizations WHERE id = $1)
+		WHERE id = $2`, org.ID, cards.PaymentMethods[0].ID); err != nil {
+		t.Fatalf("move bob's card to the organization: %v", err)
+	}
+	testutil.ExpectProblem(t, chargeBobsCard(testutil.Bearer(alice.Token), asOrg), http.StatusNotFound, "payment_method_not_found")
+
+	// API keys belong to the organization, not to the member who created them
+	createKey := func(user *testutil.User) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, orgPath+"/keys", map[string]interface{}{
+			"name":        "payouts",
+			"permissions": []string{"read"},
+			"expiry":      "1D",
+		}, testutil.Bearer(user.Token))
+	}
+	testutil.ExpectProblem(t, createKey(bob), http.StatusForbidden, "organization_forbidden")
+	var key struct {
+		APIKey string `json:"api_key"`
+	}
+	testutil.ExpectJSON(t, createKey(alice), http.StatusCreated, &key)
+	if got := orgBalance(testutil.APIKey(key.APIKey)); got != 7000 {
+		t.Fatalf("organization balance via API key = %d, want 7000", got)
+	}
+	var keys struct {
+		Keys []struct {
+			ID string `json:"id"`
+		} `json:"keys"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/keys/list", nil, testutil.Bearer(alice.Token)), http.StatusOK, &keys)
+	if len(keys.Keys) != 0 {
+		t.Fatalf("alice's own keys = %d, want 0", len(keys.Keys))
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, orgPath+"/keys", nil, testutil.Bearer(alice.Token)), http.StatusOK, &keys)
+	if len(keys.Keys) != 1 {
+		t.Fatalf("organization keys = %d, want 1", len(keys.Keys))
+	}
+
+	// Roles: only owners and admins manage members, only owners touch owners, and the last
+	// owner stays
+	changeRole := func(user, member *testutil.User, role string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, orgPath+"/members/"+member.ID.String()+"/role", map[string]string{"role": role}, testutil.Bearer(user.Token))
+	}
+	testutil.ExpectProblem(t, changeRole(bob, carol, "finance"), http.StatusForbidden, "organization_forbidden")
+	testutil.ExpectStatus(t, changeRole(alice, bob, "admin"), http.StatusOK)
+	testutil.ExpectProblem(t, changeRole(bob, carol, "owner"), http.StatusForbidden, "organization_forbidden")
+	testutil.ExpectProblem(t, changeRole(alice, alice, "admin"), http.StatusConflict, "last_owner")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodDelete, orgPath+"/members/"+alice.ID.String(), nil, testutil.Bearer(alice.Token)), http.StatusConflict, "last_owner")
+
+	// Removed members lose access straight away, and the organization's key keeps working
+	testutil.ExpectStatus(t, h.Do(t, http.MethodDelete, orgPath+"/members/"+carol.ID.String(), nil, testutil.Bearer(bob.Token)), http.StatusOK)
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/balance", nil, testutil.Bearer(carol.Token), asOrg), http.StatusForbidden, "not_organization_member")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, orgPath, nil, testutil.Bearer(carol.Token)), http.StatusNotFound, "organization_not_found")
+	testutil.ExpectStatus(t, h.Do(t, http.MethodDelete, orgPath+"/members/"+bob.ID.String(), nil, testutil.Bearer(bob.Token)), http.StatusOK)
+	if got := orgBalance(testutil.APIKey(key.APIKey)); got != 7000 {
+		t.Fatalf("organization balance via API key after bob left = %d, want 7000", got)
+	}
+}
+
+func TestAPIKeyAuthAndPermissions(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	bob := h.CreateUser(t, "bob")
+	h.Fund(t, alice, 10000)
+
+	var created struct {
+		APIKey string `json:"api_key"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/keys/create", map[string]interface{}{
+		"name":        "reporting",
+		"permissions": []string{"read"},
+		"expiry":      "1D",
+	}, testutil.Bearer(alice.Token)), http.StatusCreated, &created)
+
+	// The key can read...
+	var balance struct {
+		Balance int64 `json:"balance"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/balance", nil, testutil.APIKey(created.APIKey)), http.StatusOK, &balance)
+	if balance.Balance != 10000 {
+		t.Fatalf("balance via API key = %d, want 10000", balance.Balance)
+	}
+
+	// ...but not move money
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{
+		"wallet_number": bob.Wallet.WalletNumber,
+		"amount":        1000,
+	}, testutil.APIKey(created.APIKey)), http.StatusForbidden, "permission_denied")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]int64{"amount": 1000}, testutil.APIKey(created.APIKey)), http.StatusForbidden, "permission_denied")
+
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/balance", nil, testutil.APIKey("sk_live_not_a_key")), http.StatusUnauthorized, "invalid_api_key")
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/balance", nil), http.StatusUnauthorized, "authentication_required")
+
+	// API keys can't manage keys
+	testutil.ExpectStatus(t, h.Do(t, http.MethodGet, "/keys/list", nil, testutil.APIKey(created.APIKey)), http.StatusUnauthorized)
+
+	var list struct {
+		Keys []struct {
+			ID string `json:"id"`
+		} `json:"keys"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodGet, "/keys/list", nil, testutil.Bearer(alice.Token)), http.StatusOK, &list)
+	if len(list.Keys) != 1 {
+		t.Fatalf("listed %d keys, want 1", len(list.Keys))
+	}
+	keyID := list.Keys[0].ID
+
+	// Only the owner can revoke
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/keys/revoke", map[string]string{"key_id": keyID}, testutil.Bearer(bob.Token)), http.StatusForbidden, "api_key_not_owned")
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/keys/revoke", map[string]string{"key_id": keyID}, testutil.Bearer(alice.Token)), http.StatusOK)
+
+	testutil.ExpectProblem(t, h.Do(t, http.MethodGet, "/wallet/balance", nil, testutil.APIKey(created.APIKey)), http.StatusUnauthorized, "invalid_api_key")
+}
+
+func TestAPIKeyDeposit(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUserWithEmail(t, "alice", "alice@example.com")
+
+	var created struct {
+		APIKey string `json:"api_key"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/keys/create", map[string]interface{}{
+		"name":        "checkout",
+		"permissions": []string{"deposit", "read"},
+		"expiry":      "1D",
+	}, testutil.Bearer(alice.Token)), http.StatusCreated, &created)
+
+	// An API key has no session email, so the checkout is opened with the owner's
+	var resp struct {
+		Reference string `json:"reference"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/wallet/deposit", map[string]int64{"amount": 5000}, testutil.APIKey(created.APIKey)), http.StatusOK, &resp)
+	checkout, ok := h.Paystack.Transaction(resp.Reference)
+	if !ok {
+		t.Fatalf("no checkout for %s", resp.Reference)
+	}
+	if checkout.Email != "alice@example.com" {
+		t.Fatalf("checkout email = %q, want alice@example.com", checkout.Email)
+	}
+
+	body, signature := h.Paystack.ChargeSuccess(t, resp.Reference)
+	testutil.ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+
+	if got := h.Balance(t, alice); got != 5000 {
+		t.Fatalf("balance after API key deposit = %d, want 5000", got)
+	}
+}
+
+func TestAPIKeyLimitAndValidation(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+
+	create := func(permissions []string, expiry string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/keys/create", map[string]interface{}{
+			"name":        "key",
+			"permissions": permissions,
+			"expiry":      expiry,
+		}, testutil.Bearer(alice.Token))
+	}
+
+	testutil.ExpectProblem(t, create([]string{"admin"}, "1D"), http.StatusBadRequest, "invalid_permissions")
+	testutil.ExpectProblem(t, create([]string{"read"}, "2X"), http.StatusBadRequest, "invalid_expiry")
+
+	for i := 0; i < 5; i++ {
+		testutil.ExpectStatus(t, create([]string{"read", "deposit", "transfer"}, "1M"), http.StatusCreated)
+	}
+	testutil.ExpectProblem(t, create([]string{"read"}, "1D"), http.StatusBadRequest, "api_key_limit_reached")
+}
+
+// enrollTwoFactor sets up and confirms TOTP for a user, returning the secret, recovery codes
+// and the 2FA-verified session token from the confirmation
+func enrollTwoFactor(t *testing.T, h *testutil.Harness, user *testutil.User) (string, []string, string) {
+	t.Helper()
+
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/auth/2fa/setup", nil, testutil.Bearer(user.Token)), http.StatusOK, &setup)
+
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+		Token         string   `json:"token"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/auth/2fa/confirm", map[string]string{"code": totpCode(t, setup.Secret, time.Now())}, testutil.Bearer(user.Token)), http.StatusOK, &confirmed)
+
+	return setup.Secret, confirmed.RecoveryCodes, confirmed.Token
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, at)
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+func TestTwoFactorStepUp(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	secret, _, verified := enrollTwoFactor(t, h, alice)
+
+	createKey := func(token string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/keys/create", map[string]interface{}{
+			"name":        "ci",
+			"permissions": []string{"read"},
+			"expiry":      "1D",
+		}, testutil.Bearer(token))
+	}
+
+	// The token issued before enrollment carries no 2FA claim, but alice is enrolled now
+	testutil.ExpectProblem(t, createKey(alice.Token), http.StatusForbidden, "two_factor_step_up_required")
+	testutil.ExpectStatus(t, createKey(verified), http.StatusCreated)
+
+	// Stepping up the old session with the next code unlocks it; the confirm code can't be reused
+	var stepped struct {
+		Token string `json:"token"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/auth/2fa/step-up", map[string]string{"code": totpCode(t, secret, time.Now().Add(30*time.Second))}, testutil.Bearer(alice.Token)), http.StatusOK, &stepped)
+	testutil.ExpectStatus(t, createKey(stepped.Token), http.StatusCreated)
+}
+
+func TestTwoFactorChallengeAndLockout(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+	secret, recoveryCodes, _ := enrollTwoFactor(t, h, alice)
+
+	challenge, err := utils.GenerateChallengeJWT(alice.ID, alice.Email, alice.Name, h.Config.JWT.Secret, h.Config.TwoFactor.ChallengeTTL)
+	if err != nil {
+		t.Fatalf("GenerateChallengeJWT: %v", err)
+	}
+
+	// A challenge completes one login, even with a different valid factor the second time
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/auth/2fa/verify", map[string]string{
+		"challenge_token": challenge,
+		"code":            totpCode(t, secret, time.Now().Add(30*time.Second)),
+	}), http.StatusOK)
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/auth/2fa/verify", map[string]string{
+		"challenge_token": challenge,
+		"recovery_code":   recoveryCodes[0],
+	}), http.StatusUnauthorized)
+
+	// The replay didn't spend the recovery code, and a wrong code leaves a challenge usable
+	retry, err := utils.GenerateChallengeJWT(alice.ID, alice.Email, alice.Name, h.Config.JWT.Secret, h.Config.TwoFactor.ChallengeTTL)
+	if err != nil {
+		t.Fatalf("GenerateChallengeJWT: %v", err)
+	}
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/auth/2fa/verify", map[string]string{
+		"challenge_token": retry,
+		"recovery_code":   "aaaaa-aaaaa",
+	}), http.StatusUnauthorized)
+	testutil.ExpectStatus(t, h.Do(t, http.MethodPost, "/auth/2fa/verify", map[string]string{
+		"challenge_token": retry,
+		"recovery_code":   recoveryCodes[0],
+	}), http.StatusOK)
+
+	stepUp := func(recoveryCode string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/auth/2fa/step-up", map[string]string{"recovery_code": recoveryCode}, testutil.Bearer(alice.Token))
+	}
+
+	// Five wrong guesses lock verification, after which even a valid code is refused
+	for i := 0; i < h.Config.TwoFactor.MaxAttempts; i++ {
+		testutil.ExpectStatus(t, stepUp("aaaaa-aaaaa"), http.StatusUnauthorized)
+	}
+	locked := stepUp(recoveryCodes[1])
+	testutil.ExpectProblem(t, locked, http.StatusTooManyRequests, "two_factor_locked")
+	if locked.Header().Get("Retry-After") == "" {
+		t.Fatal("locked response has no Retry-After header")
+	}
+	testutil.ExpectProblem(t, h.Do(t, http.MethodPost, "/auth/2fa/disable", map[string]string{"recovery_code": recoveryCodes[1]}, testutil.Bearer(alice.Token)), http.StatusTooManyRequests, "two_factor_locked")
+
+	// Once the lock runs out the refused code still works, and the count starts again
+	if _, err := h.DB.Exec(`UPDATE user_two_factor SET locked_until = NOW() - INTERVAL '1 second' WHERE user_id = $1`, alice.ID); err != nil {
+		t.Fatalf("expire lock: %v", err)
+	}
+	testutil.ExpectStatus(t, stepUp(recoveryCodes[1]), http.StatusOK)
+
+	var attempts int
+	if err := h.DB.Get(&attempts, `SELECT failed_attempts FROM user_two_factor WHERE user_id = $1`, alice.ID); err != nil {
+		t.Fatalf("count attempts: %v", err)
+	}
+	if attempts != 0 {
+		t.Fatalf("failed_attempts after success = %d, want 0", attempts)
+	}
+}
+
+func TestTwoFactorConfirmLockout(t *testing.T) {
+	h := testutil.NewHarness(t)
+	alice := h.CreateUser(t, "alice")
+
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/auth/2fa/setup", nil, testutil.Bearer(alice.Token)), http.StatusOK, &setup)
+
+	confirm := func(code string) *httptest.ResponseRecorder {
+		return h.Do(t, http.MethodPost, "/auth/2fa/confirm", map[string]string{"code": code}, testutil.Bearer(alice.Token))
+	}
+
+	// Guessing the pending secret is limited like any other check, and a new secret doesn't reset the count
+	wrong := totpCode(t, setup.Secret, time.Now().Add(-time.Hour))
+	for i := 0; i < h.Config.TwoFactor.MaxAttempts; i++ {
+		testutil.ExpectStatus(t, confirm(wrong), http.StatusBadRequest)
+	}
+	testutil.ExpectJSON(t, h.Do(t, http.MethodPost, "/auth/2fa/setup", nil, testutil.Bearer(alice.Token)), http.StatusOK, &setup)
+	testutil.ExpectProblem(t, confirm(totpCode(t, setup.Secret, time.Now())), http.StatusTooManyRequests, "two_factor_locked")
+}
@@ -0,0 +1,220 @@
+package app_test
+
+import (
+	"flag"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/franzego/stage08/internal/testutil"
+)
+
+var (
+	stressWallets     = flag.Int("stress.wallets", 40, "wallets taking part in the stress test")
+	stressTransfers   = flag.Int("stress.transfers", 2000, "concurrent transfers fired by the stress test")
+	stressDeposits    = flag.Int("stress.deposits", 200, "deposits settled during the stress test")
+	stressDuplicates  = flag.Int("stress.duplicates", 4, "times each deposit webhook is delivered")
+	stressConcurrency = flag.Int("stress.concurrency", 64, "requests in flight at once")
+)
+
+// TestStressInvariants fires transfers, deposits and duplicate webhooks concurrently across
+// many wallets, then checks that money is conserved, no balance is negative and no deposit
+// reference is credited more than once. Run a heavier round with e.g.
+//
+//	go test ./internal/app -run Stress -stress.transfers=20000 -stress.concurrency=200
+func TestStressInvariants(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test skipped in -short mode")
+	}
+
+	if *stressWallets < 2 {
+		t.Fatal("-stress.wallets must be at least 2")
+	}
+
+	h := testutil.NewHarness(t)
+
+	users := make([]*testutil.User, *stressWallets)
+	for i := range users {
+		users[i] = h.CreateUser(t, "stress")
+	}
+
+	// Every wallet starts with one deposit; more deposits are settled while transfers run.
+	// A fraction of the extra deposits only ever receive a webhook with the wrong amount and
+	// must never be credited.
+	type deposit struct {
+		reference string
+		amount    int64
+		tampered  bool
+	}
+	var deposits []deposit
+	for _, user := range users {
+		deposits = append(deposits, deposit{reference: h.Deposit(t, user, 100_000), amount: 100_000})
+	}
+	var expectedTotal int64
+	for i := 0; i < *stressDeposits; i++ {
+		amount := int64(100 + rand.IntN(50_000))
+		d := deposit{reference: h.Deposit(t, users[rand.IntN(len(users))], amount), amount: amount, tampered: i%10 == 0}
+		deposits = append(deposits, d)
+	}
+	for _, d := range deposits {
+		if !d.tampered {
+			expectedTotal += d.amount
+		}
+	}
+
+	// Build every webhook up front: the fake marks payments from the test goroutine
+	type webhook struct {
+		body      []byte
+		signature string
+	}
+	var webhooks []webhook
+	for _, d := range deposits {
+		var body []byte
+		var signature string
+		if d.tampered {
+			body, signature = h.Paystack.ChargeSuccess(t, d.reference, d.amount+1)
+		} else {
+			body, signature = h.Paystack.ChargeSuccess(t, d.reference)
+		}
+		for i := 0; i < *stressDuplicates; i++ {
+			webhooks = append(webhooks, webhook{body: body, signature: signature})
+		}
+	}
+
+	// Settle the opening deposits first so transfers have money to move
+	opening := webhooks[:len(users)*(*stressDuplicates)]
+	rest := webhooks[len(opening):]
+	run(t, *stressConcurrency, len(opening), func(i int) {
+		if rec := h.Webhook(t, opening[i].body, opening[i].signature); rec.Code != http.StatusOK {
+			t.Errorf("opening webhook: status %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+	h.WaitForWebhooks(t)
+
+	var succeeded, rejected atomic.Int64
+	ops := *stressTransfers + len(rest)
+	order := rand.Perm(ops)
+	run(t, *stressConcurrency, ops, func(i int) {
+		op := order[i]
+		if op >= *stressTransfers {
+			w := rest[op-*stressTransfers]
+			if rec := h.Webhook(t, w.body, w.signature); rec.Code != http.StatusOK {
+				t.Errorf("webhook: status %d: %s", rec.Code, rec.Body.String())
+			}
+			return
+		}
+
+		from := rand.IntN(len(users))
+		to := (from + 1 + rand.IntN(len(users)-1)) % len(users)
+		sender, recipient := users[from], users[to]
+
+		rec := h.Do(t, http.MethodPost, "/wallet/transfer", map[string]interface{}{
+			"wallet_number": recipient.Wallet.WalletNumber,
+			"amount":        100 + rand.IntN(20_000),
+		}, testutil.Bearer(sender.Token))
+
+		switch rec.Code {
+		case http.StatusOK:
+			succeeded.Add(1)
+		case http.StatusBadRequest:
+			rejected.Add(1) // insufficient funds is expected as wallets drain
+		default:
+			t.Errorf("transfer: status %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	h.WaitForWebhooks(t)
+	t.Logf("%d transfers succeeded, %d rejected, %d webhooks delivered", succeeded.Load(), rejected.Load(), len(webhooks))
+
+	// No balance is negative
+	var negative int
+	if err := h.DB.Get(&negative, `SELECT COUNT(*) FROM wallets WHERE balance < 0`); err != nil {
+		t.Fatal(err)
+	}
+	if negative > 0 {
+		t.Errorf("%d wallets have a negative balance", negative)
+	}
+
+	// Money is conserved: transfers only move it, so the total equals what was deposited
+	var total int64
+	if err := h.DB.Get(&total, `SELECT COALESCE(SUM(balance), 0) FROM wallets`); err != nil {
+		t.Fatal(err)
+	}
+	if total != expectedTotal {
+		t.Errorf("total balance = %d, want %d (sum of untampered deposits)", total, expectedTotal)
+	}
+
+	// Every balance matches its ledger
+	var drifted []struct {
+		WalletNumber string `db:"wallet_number"`
+		Balance      int64  `db:"balance"`
+		Ledger       int64  `db:"ledger"`
+	}
+	err := h.DB.Select(&drifted, `
+		SELECT w.wallet_number, w.balance, COALESCE(SUM(
+			CASE WHEN t.type = 'transfer_out' THEN -t.amount ELSE t.amount END
+		) FILTER (WHERE t.status = 'success'), 0) AS ledger
+		FROM wallets w
+		LEFT JOIN transactions t ON t.wallet_id = w.id
+		GROUP BY w.id
+		HAVING w.balance <> COALESCE(SUM(
+			CASE WHEN t.type = 'transfer_out' THEN -t.amount ELSE t.amount END
+		) FILTER (WHERE t.status = 'success'), 0)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range drifted {
+		t.Errorf("wallet %s: balance %d, ledger %d", d.WalletNumber, d.Balance, d.Ledger)
+	}
+
+	// Each deposit reference is credited at most once, and tampered ones never
+	var credited []struct {
+		TargetID string `db:"target_id"`
+		Count    int    `db:"count"`
+	}
+	err = h.DB.Select(&credited, `
+		SELECT target_id, COUNT(*) AS count FROM audit_events
+		WHERE action = 'deposit.settle' AND after::jsonb->>'status' = 'success'
+		GROUP BY target_id HAVING COUNT(*) > 1
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range credited {
+		t.Errorf("deposit %s was credited %d times", c.TargetID, c.Count)
+	}
+
+	for _, d := range deposits {
+		var status string
+		if err := h.DB.Get(&status, `SELECT status FROM transactions WHERE reference = $1`, d.reference); err != nil {
+			t.Fatal(err)
+		}
+		want := "success"
+		if d.tampered {
+			want = "failed"
+		}
+		if status != want {
+			t.Errorf("deposit %s: status %s, want %s", d.reference, status, want)
+		}
+	}
+}
+
+// run calls fn(0..n-1) from at most concurrency goroutines and waits for all of them
+func run(t *testing.T, concurrency, n int, fn func(i int)) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,264 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Audit actions
+const (
+	ActionLogin                   = "auth.login"
+	ActionTwoFactorEnable         = "two_factor.enable"
+	ActionTwoFactorDisable        = "two_factor.disable"
+	ActionRecoveryCodesRegenerate = "two_factor.recovery_codes_regenerate"
+	ActionAPIKeyCreate            = "api_key.create"
+	ActionAPIKeyRollover          = "api_key.rollover"
+	ActionAPIKeyRevoke            = "api_key.revoke"
+	ActionDepositInitialize       = "deposit.initialize"
+	ActionDepositSettle           = "deposit.settle"
+	ActionDepositCharge           = "deposit.charge"
+	ActionDepositRefund           = "deposit.refund"
+	ActionPaymentMethodSave       = "payment_method.save"
+	ActionPaymentMethodDelete     = "payment_method.delete"
+	ActionTransferCreate          = "transfer.create"
+	ActionTransferMove            = "transfer.move"
+	ActionWalletCreate            = "wallet.create"
+	ActionWalletSetDefault        = "wallet.set_default"
+	ActionScheduledTransferCreate = "scheduled_transfer.create"
+	ActionScheduledTransferPause  = "scheduled_transfer.pause"
+	ActionScheduledTransferResume = "scheduled_transfer.resume"
+	ActionScheduledTransferCancel = "scheduled_transfer.cancel"
+	ActionPaymentRequestCreate    = "payment_request.create"
+	ActionPaymentRequestAccept    = "payment_request.accept"
+	ActionPaymentRequestDecline   = "payment_request.decline"
+	ActionPaymentRequestCancel    = "payment_request.cancel"
+	ActionPaymentLinkCreate       = "payment_link.create"
+	ActionPaymentLinkDeactivate   = "payment_link.deactivate"
+	ActionTransferBatchCreate     = "transfer_batch.create"
+	ActionTransferBatchComplete   = "transfer_batch.complete"
+	ActionTransferReverse         = "transfer.reverse"
+	ActionDisputeOpen             = "dispute.open"
+	ActionDisputeApprove          = "dispute.approve"
+	ActionDisputeReject           = "dispute.reject"
+	ActionDisputeCancel           = "dispute.cancel"
+	ActionDisputeForce            = "dispute.force"
+	ActionEscrowCreate            = "escrow.create"
+	ActionEscrowRelease           = "escrow.release"
+	ActionEscrowRefund            = "escrow.refund"
+	ActionEscrowSplit             = "escrow.split"
+	ActionOrganizationCreate      = "organization.create"
+	ActionOrganizationInvite      = "organization.invite"
+	ActionInvitationRevoke        = "organization.invitation_revoke"
+	ActionInvitationAccept        = "organization.invitation_accept"
+	ActionInvitationDecline       = "organization.invitation_decline"
+	ActionMemberRoleChange        = "organization.member_role_change"
+	ActionMemberRemove            = "organization.member_remove"
+	ActionWebhookReprocess        = "webhook.reprocess"
+	ActionWebhookReplay           = "webhook.replay"
+)
+
+// Target types
+const (
+	TargetUser              = "user"
+	TargetAPIKey            = "api_key"
+	TargetTransaction       = "transaction"
+	TargetWallet            = "wallet"
+	TargetWebhookEvent      = "webhook_event"
+	TargetPaymentMethod     = "payment_method"
+	TargetScheduledTransfer = "scheduled_transfer"
+	TargetPaymentRequest    = "payment_request"
+	TargetPaymentLink       = "payment_link"
+	TargetTransferBatch     = "transfer_batch"
+	TargetDispute           = "dispute"
+	TargetEscrow            = "escrow"
+	TargetOrganization      = "organization"
+	TargetInvitation        = "organization_invitation"
+	TargetMember            = "organization_member"
+)
+
+// Event describes something worth auditing. Actor details are filled in by the Recorder.
+type Event struct {
+	OwnerUserID uuid.UUID   // Account the event belongs to
+	Action      string      // One of the Action constants
+	TargetType  string      // One of the Target constants
+	TargetID    string      // ID of the affected entity
+	Before      interface{} // State before the change (marshalled to JSON)
+	After       interface{} // State after the change (marshalled to JSON)
+}
+
+// APIKeyState is the part of an API key recorded in the audit log (never the hash)
+func APIKeyState(key *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          key.ID,
+		"name":        key.Name,
+		"key_prefix":  key.KeyPrefix,
+		"permissions": key.Permissions,
+		"is_active":   key.IsActive,
+		"expires_at":  key.ExpiresAt,
+	}
+}
+
+// Actor is who performs the audited actions of a request
+type Actor struct {
+	UserID    uuid.UUID  // The authenticated user, or the member acting for an organization
+	APIKeyID  *uuid.UUID // Set when the request authenticated with an API key
+	IPAddress string
+	UserAgent string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose audited actions are attributed to actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// RequestActor describes the caller of a request from what authentication stored in c.
+// UserID is empty for unauthenticated requests.
+func RequestActor(c *gin.Context) Actor {
+	actor := Actor{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if memberID, ok := contextUUID(c, "member_user_id"); ok {
+		// A member acting for an organization
+		actor.UserID = memberID
+	} else if userID, ok := contextUUID(c, "user_id"); ok {
+		actor.UserID = userID
+	}
+	if apiKeyID, ok := contextUUID(c, "api_key_id"); ok {
+		actor.APIKeyID = &apiKeyID
+	}
+	return actor
+}
+
+// Recorder writes audit events to the append-only audit log
+type Recorder struct {
+	auditRepo repository.AuditRepository
+	logger    *slog.Logger
+}
+
+func NewRecorder(auditRepo repository.AuditRepository, logger *slog.Logger) *Recorder {
+	return &Recorder{auditRepo: auditRepo, logger: logger}
+}
+
+// Record stores an event performed by the actor in ctx (see WithActor) in the unit of work's
+// transaction, so it is committed or rolled back with the change it describes. An event
+// without an actor, such as a login, is attributed to its owner.
+func (r *Recorder) Record(ctx context.Context, uow *repository.UnitOfWork, event Event) error {
+	record := r.build(event)
+
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	if actor.UserID != uuid.Nil {
+		record.ActorUserID = &actor.UserID
+	} else {
+		record.ActorUserID = record.OwnerUserID
+	}
+	record.ActorAPIKeyID = actor.APIKeyID
+	record.IPAddress = optionalString(actor.IPAddress)
+	record.UserAgent = optionalString(actor.UserAgent)
+
+	return r.append(ctx, uow, record)
+}
+
+// RecordSystem stores an event that was not initiated by a user (e.g. payment webhooks) in the
+// unit of work's transaction
+func (r *Recorder) RecordSystem(ctx context.Context, uow *repository.UnitOfWork, event Event) error {
+	return r.append(ctx, uow, r.build(event))
+}
+
+func (r *Recorder) build(event Event) *models.AuditEvent {
+	record := &models.AuditEvent{
+		Action:     event.Action,
+		TargetType: optionalString(event.TargetType),
+		TargetID:   optionalString(event.TargetID),
+		Before:     r.marshal(event.Before),
+		After:      r.marshal(event.After),
+		// Postgres stores microseconds; truncate so the stored value hashes the same
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if event.OwnerUserID != uuid.Nil {
+		owner := event.OwnerUserID
+		record.OwnerUserID = &owner
+	}
+	return record
+}
+
+// append stores the event. A failed write fails the transaction, so an audited change is
+// never committed without its event.
+func (r *Recorder) append(ctx context.Context, uow *repository.UnitOfWork, record *models.AuditEvent) error {
+	if err := uow.Audit.Append(ctx, record); err != nil {
+		return fmt.Errorf("failed to record %s audit event: %w", record.Action, err)
+	}
+	return nil
+}
+
+// VerifyChain walks the whole audit log and checks every hash link.
+// It returns the number of events checked and an error describing the first broken link.
+func (r *Recorder) VerifyChain(ctx context.Context) (int, error) {
+	const batchSize = 500
+
+	prevHash := repository.GenesisHash
+	var lastID int64
+	checked := 0
+
+	for {
+		events, err := r.auditRepo.ListAfter(ctx, lastID, batchSize)
+		if err != nil {
+			return checked, err
+		}
+
+		for i := range events {
+			event := &events[i]
+			if event.PrevHash != prevHash {
+				return checked, fmt.Errorf("audit event %d does not link to the previous event", event.ID)
+			}
+			if event.ComputeHash() != event.Hash {
+				return checked, fmt.Errorf("audit event %d has been modified", event.ID)
+			}
+			prevHash = event.Hash
+			lastID = event.ID
+			checked++
+		}
+
+		if len(events) < batchSize {
+			return checked, nil
+		}
+	}
+}
+
+func contextUUID(c *gin.Context, key string) (uuid.UUID, bool) {
+	value, exists := c.Get(key)
+	if !exists {
+		return uuid.Nil, false
+	}
+	id, ok := value.(uuid.UUID)
+	return id, ok
+}
+
+func (r *Recorder) marshal(v interface{}) models.JSON {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		r.logger.Error("Failed to marshal audit state", "error", err)
+		return nil
+	}
+	return data
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -1,23 +1,55 @@
 package database
 
 import (
+	"context"
+	"database/sql/driver"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/XSAM/otelsql"
 	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/migrations"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Connect establishes a connection to PostgreSQL using sqlx
-func Connect(cfg *config.DatabaseConfig) (*sqlx.DB, error) {
-	dsn := cfg.GetDSN()
+func Connect(cfg *config.DatabaseConfig, logger *slog.Logger) (*sqlx.DB, error) {
+	db, err := Open(cfg.GetDSN(), semconv.DBNamespace(cfg.DBName))
+	if err != nil {
+		return nil, err
+	}
 
-	db, err := sqlx.Connect("postgres", dsn)
+	logger.Info("Database connection established", "host", cfg.Host, "database", cfg.DBName)
+	return db, nil
+}
+
+// Open connects using a key=value DSN or a postgres:// URL and checks the connection.
+// The driver is wrapped so every query made with a context becomes a child span of that context.
+func Open(dsn string, attrs ...attribute.KeyValue) (*sqlx.DB, error) {
+	sqlDB, err := otelsql.Open("postgres", dsn,
+		otelsql.WithAttributes(append([]attribute.KeyValue{semconv.DBSystemNamePostgreSQL}, attrs...)...),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			OmitConnectorConnect: true,
+			// Only trace queries that belong to a trace (skips migrations and health probes)
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	db := sqlx.NewDb(sqlDB, "postgres")
 
 	// Set connection pool settings
 	db.SetMaxOpenConns(25)
@@ -25,24 +57,59 @@ func Connect(cfg *config.DatabaseConfig) (*sqlx.DB, error) {
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Println("✅ Database connection established")
 	return db, nil
 }
 
-// RunMigrations executes SQL migration files
-func RunMigrations(db *sqlx.DB) error {
-	migrations := []string{
-		"migrations/001_create_users_table.up.sql",
-		"migrations/002_create_wallets_table.up.sql",
-		"migrations/003_create_transactions_table.up.sql",
-		"migrations/004_create_api_keys_table.up.sql",
+// migrationFiles are applied in order on every startup, so each file must be idempotent.
+// They are read from the migrations package, which embeds the SQL files.
+var migrationFiles = []string{
+	"001_create_users_table.up.sql",
+	"002_create_wallets_table.up.sql",
+	"003_create_transactions_table.up.sql",
+	"004_create_api_keys_table.up.sql",
+	"005_create_two_factor_tables.up.sql",
+	"006_create_audit_events_table.up.sql",
+	"007_create_webhook_events_table.up.sql",
+	"008_add_transaction_provider.up.sql",
+	"009_create_payment_methods_table.up.sql",
+	"010_create_scheduled_transfers_tables.up.sql",
+	"011_create_payment_requests_tables.up.sql",
+	"012_create_transfer_batches_tables.up.sql",
+	"013_create_disputes_table.up.sql",
+	"014_create_escrows_tables.up.sql",
+	"015_add_wallet_names.up.sql",
+	"016_create_organizations_tables.up.sql",
+	"017_add_two_factor_lockout.up.sql",
+	"018_create_emails_table.up.sql",
+	"019_add_scheduled_transfer_wallet.up.sql",
+}
+
+// schemaMigrationsTable records which migration versions have been applied
+const schemaMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	)
+`
+
+// RunMigrations executes SQL migration files and records each applied version
+func RunMigrations(db *sqlx.DB, logger *slog.Logger) error {
+	if _, err := db.Exec(schemaMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
 	}
 
-	for _, migration := range migrations {
-		log.Printf("Running migration: %s", migration)
+	for _, migration := range migrationFiles {
+		version, err := migrationVersion(migration)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("Running migration", "file", migration)
 		content, err := readMigrationFile(migration)
 		if err != nil {
 			return fmt.Errorf("failed to read migration %s: %w", migration, err)
@@ -51,15 +118,53 @@ func RunMigrations(db *sqlx.DB) error {
 		if _, err := db.Exec(content); err != nil {
 			return fmt.Errorf("failed to execute migration %s: %w", migration, err)
 		}
+
+		recordQuery := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING`
+		if _, err := db.Exec(recordQuery, version, migration); err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", migration, err)
+		}
 	}
 
-	log.Println("✅ All migrations completed successfully")
+	logger.Info("All migrations completed successfully", "version", LatestMigrationVersion())
 	return nil
 }
 
-func readMigrationFile(path string) (string, error) {
-	// use golang migrate
-	data, err := os.ReadFile(path)
+// LatestMigrationVersion returns the version of the newest migration this binary knows about
+func LatestMigrationVersion() int {
+	version, _ := migrationVersion(migrationFiles[len(migrationFiles)-1])
+	return version
+}
+
+// AppliedMigrationVersion returns the highest migration version recorded in the database
+func AppliedMigrationVersion(ctx context.Context, db *sqlx.DB) (int, error) {
+	var version int
+	query := `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+
+	if err := db.GetContext(ctx, &version, query); err != nil {
+		return 0, fmt.Errorf("failed to read migration version: %w", err)
+	}
+
+	return version, nil
+}
+
+// migrationVersion parses the numeric prefix of a migration file name (e.g. 006_create_x.up.sql -> 6)
+func migrationVersion(path string) (int, error) {
+	name := filepath.Base(path)
+	prefix, _, found := strings.Cut(name, "_")
+	if !found {
+		return 0, fmt.Errorf("migration %s has no version prefix", name)
+	}
+
+	version, err := strconv.Atoi(prefix)
+	if err != nil {
+		return 0, fmt.Errorf("migration %s has an invalid version prefix: %w", name, err)
+	}
+
+	return version, nil
+}
+
+func readMigrationFile(name string) (string, error) {
+	data, err := migrations.FS.ReadFile(name)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,199 @@
+package flutterwave
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/apiclient"
+	"github.com/franzego/stage08/internal/metrics"
+)
+
+// Currency is the currency every payment is initialized in
+const Currency = "NGN"
+
+// SignatureHeader carries the secret hash on every webhook
+const SignatureHeader = "verif-hash"
+
+// Client talks to the Flutterwave v3 API. Flutterwave amounts are in naira, so methods take
+// kobo and convert; responses keep Flutterwave's amounts (see Kobo).
+type Client struct {
+	SecretKey   string
+	SecretHash  string
+	BaseURL     string
+	RedirectURL string
+	api         *apiclient.Client
+}
+
+// NewClient creates a Flutterwave client. The client is safe for concurrent use and should
+// be shared so connections to Flutterwave are pooled.
+func NewClient(cfg *config.FlutterwaveConfig) *Client {
+	return &Client{
+		SecretKey:   cfg.SecretKey,
+		SecretHash:  cfg.SecretHash,
+		BaseURL:     cfg.BaseURL,
+		RedirectURL: cfg.RedirectURL,
+		api: apiclient.New(apiclient.Config{
+			Name:         "flutterwave",
+			BaseURL:      cfg.BaseURL,
+			SecretKey:    cfg.SecretKey,
+			Timeout:      cfg.Timeout,
+			MaxRetries:   cfg.MaxRetries,
+			RetryBackoff: cfg.RetryBackoff,
+			Observe:      metrics.ObserveFlutterwaveRequest,
+		}),
+	}
+}
+
+// InitializePayment creates a hosted checkout for amount kobo.
+// It is not retried: a timed-out attempt may still have created the payment.
+func (c *Client) InitializePayment(ctx context.Context, email string, amount int64, reference string) (*InitializeResponse, error) {
+	payload := map[string]interface{}{
+		"tx_ref":   reference,
+		"amount":   naira(amount),
+		"currency": Currency,
+		"customer": map[string]string{"email": email},
+	}
+	if c.RedirectURL != "" {
+		payload["redirect_url"] = c.RedirectURL
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var result InitializeResponse
+	if err := c.do(ctx, "initialize_payment", "POST", "/payments", body, false, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// VerifyTransaction looks a transaction up by our reference. Transient failures are retried.
+func (c *Client) VerifyTransaction(ctx context.Context, reference string) (*VerifyResponse, error) {
+	var result VerifyResponse
+	path := "/transactions/verify_by_reference?tx_ref=" + url.QueryEscape(reference)
+	if err := c.do(ctx, "verify_transaction", "GET", path, nil, true, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Refund refunds amount kobo of the transaction with Flutterwave's id. It is not retried:
+// a timed-out attempt may still have created the refund.
+func (c *Client) Refund(ctx context.Context, transactionID int64, amount int64) (*RefundResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{"amount": naira(amount)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var result RefundResponse
+	path := "/transactions/" + strconv.FormatInt(transactionID, 10) + "/refund"
+	if err := c.do(ctx, "refund", "POST", path, body, false, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Ping checks that the Flutterwave API is reachable. Any response below 500 counts as reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.api.Ping(ctx)
+}
+
+// VerifyWebhookSignature checks the verif-hash header against the secret hash configured
+// in the Flutterwave dashboard
+func (c *Client) VerifyWebhookSignature(hash string) bool {
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(c.SecretHash)) == 1
+}
+
+// do sends an authenticated request and decodes the JSON response into out.
+// Responses with "status": "error", often with a 4xx status, are returned as errors.
+func (c *Client) do(ctx context.Context, operation, method, path string, body []byte, idempotent bool, out apiResponse) error {
+	return c.api.Do(ctx, operation, method, path, body, idempotent, func(respBody []byte) error {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		if !out.ok() {
+			return fmt.Errorf("flutterwave error: %s", out.message())
+		}
+		return nil
+	})
+}
+
+// Kobo converts a Flutterwave amount in naira to kobo
+func Kobo(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func naira(amount int64) float64 {
+	return float64(amount) / 100
+}
+
+// apiResponse is implemented by every Flutterwave response envelope
+type apiResponse interface {
+	ok() bool
+	message() string
+}
+
+// envelope is the wrapper around every Flutterwave response
+type envelope struct {
+	Status  string `json:"status"` // success or error
+	Message string `json:"message"`
+}
+
+func (e *envelope) ok() bool        { return e.Status == "success" }
+func (e *envelope) message() string { return e.Message }
+
+// Transaction is a Flutterwave transaction as returned by verify and sent in webhooks
+type Transaction struct {
+	ID                int64   `json:"id"`
+	TxRef             string  `json:"tx_ref"`
+	FlwRef            string  `json:"flw_ref"`
+	Amount            float64 `json:"amount"` // In naira
+	Currency          string  `json:"currency"`
+	ChargedAmount     float64 `json:"charged_amount"`
+	AppFee            float64 `json:"app_fee"` // Flutterwave's charge, in naira
+	ProcessorResponse string  `json:"processor_response"`
+	Status            string  `json:"status"` // successful, failed or pending
+	PaymentType       string  `json:"payment_type"`
+	CreatedAt         string  `json:"created_at"`
+	Customer          struct {
+		Email string `json:"email"`
+	} `json:"customer"`
+}
+
+// Response structures
+type InitializeResponse struct {
+	envelope
+	Data struct {
+		Link string `json:"link"`
+	} `json:"data"`
+}
+
+type VerifyResponse struct {
+	envelope
+	Data Transaction `json:"data"`
+}
+
+type RefundResponse struct {
+	envelope
+	Data struct {
+		ID             int64   `json:"id"`
+		AmountRefunded float64 `json:"amount_refunded"`
+		Status         string  `json:"status"`
+	} `json:"data"`
+}
+
+type WebhookEvent struct {
+	Event string      `json:"event"` // e.g. charge.completed
+	Data  Transaction `json:"data"`
+}
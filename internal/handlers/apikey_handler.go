@@ -1,23 +1,24 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/franzego/stage08/internal/middleware"
-	"github.com/franzego/stage08/internal/repository"
-	"github.com/franzego/stage08/internal/utils"
+	"github.com/franzego/stage08/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 type APIKeyHandler struct {
-	apiKeyRepo *repository.APIKeyRepository
+	apiKeyService *service.APIKeyService
+	logger        *slog.Logger
 }
 
-func NewAPIKeyHandler(apiKeyRepo *repository.APIKeyRepository) *APIKeyHandler {
+func NewAPIKeyHandler(apiKeyService *service.APIKeyService, logger *slog.Logger) *APIKeyHandler {
 	return &APIKeyHandler{
-		apiKeyRepo: apiKeyRepo,
+		apiKeyService: apiKeyService,
+		logger:        logger,
 	}
 }
 
@@ -26,7 +27,7 @@ func NewAPIKeyHandler(apiKeyRepo *repository.APIKeyRepository) *APIKeyHandler {
 func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -37,41 +38,13 @@ func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		respondError(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	// Validate permissions
-	if err := utils.ValidatePermissions(req.Permissions); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Parse expiry
-	expiresAt, err := utils.ParseExpiry(req.Expiry)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Check if user has reached the limit of 5 active keys
-	count, err := h.apiKeyRepo.CountActiveByUser(userID)
-	if err != nil {
-		log.Printf("Failed to count API keys: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	}
-
-	if count >= 5 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum 5 active API keys allowed per user"})
-		return
-	}
-
-	// Create API key
-	apiKey, rawKey, err := h.apiKeyRepo.Create(userID, req.Name, req.Permissions, expiresAt)
+	apiKey, rawKey, err := h.apiKeyService.Create(c.Request.Context(), userID, req.Name, req.Permissions, req.Expiry)
 	if err != nil {
-		log.Printf("Failed to create API key: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -86,7 +59,7 @@ func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
 func (h *APIKeyHandler) RolloverAPIKey(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -96,67 +69,20 @@ func (h *APIKeyHandler) RolloverAPIKey(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		respondError(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Parse expired key ID
 	expiredKeyID, err := uuid.Parse(req.ExpiredKeyID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expired_key_id"})
+		respondError(c, http.StatusBadRequest, "Invalid expired_key_id")
 		return
 	}
 
-	// Find the expired key
-	expiredKey, err := h.apiKeyRepo.FindByID(expiredKeyID)
+	apiKey, rawKey, err := h.apiKeyService.Rollover(c.Request.Context(), userID, expiredKeyID, req.Expiry)
 	if err != nil {
-		log.Printf("Failed to find API key: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	}
-
-	if expiredKey == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
-		return
-	}
-
-	// Verify ownership
-	if expiredKey.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not own this API key"})
-		return
-	}
-
-	// Verify it's actually expired
-	if !expiredKey.IsExpired() {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "API key is not expired yet"})
-		return
-	}
-
-	// Parse new expiry
-	expiresAt, err := utils.ParseExpiry(req.Expiry)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Check active key limit
-	count, err := h.apiKeyRepo.CountActiveByUser(userID)
-	if err != nil {
-		log.Printf("Failed to count API keys: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	}
-
-	if count >= 5 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum 5 active API keys allowed per user"})
-		return
-	}
-
-	// Create new API key with same permissions
-	apiKey, rawKey, err := h.apiKeyRepo.Create(userID, expiredKey.Name, expiredKey.Permissions, expiresAt)
-	if err != nil {
-		log.Printf("Failed to create API key: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		c.Error(err)
 		return
 	}
 
@@ -171,14 +97,13 @@ func (h *APIKeyHandler) RolloverAPIKey(c *gin.Context) {
 func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	keys, err := h.apiKeyRepo.ListByUser(userID)
+	keys, err := h.apiKeyService.List(c.Request.Context(), userID)
 	if err != nil {
-		log.Printf("Failed to list API keys: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Error(err)
 		return
 	}
 
@@ -205,7 +130,7 @@ func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
 func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -214,39 +139,19 @@ func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		respondError(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	keyID, err := uuid.Parse(req.KeyID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key_id"})
+		respondError(c, http.StatusBadRequest, "Invalid key_id")
 		return
 	}
 
-	// Find the key
-	key, err := h.apiKeyRepo.FindByID(keyID)
+	err = h.apiKeyService.Revoke(c.Request.Context(), userID, keyID)
 	if err != nil {
-		log.Printf("Failed to find API key: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	}
-
-	if key == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
-		return
-	}
-
-	// Verify ownership
-	if key.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not own this API key"})
-		return
-	}
-
-	// Revoke the key
-	if err := h.apiKeyRepo.Revoke(keyID); err != nil {
-		log.Printf("Failed to revoke API key: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		c.Error(err)
 		return
 	}
 
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditRepo repository.AuditRepository
+	logger    *slog.Logger
+}
+
+func NewAuditHandler(auditRepo repository.AuditRepository, logger *slog.Logger) *AuditHandler {
+	return &AuditHandler{
+		auditRepo: auditRepo,
+		logger:    logger,
+	}
+}
+
+// ListEvents returns the audit trail for the acting account: the user's own, or the
+// organization's for a request made for one
+// GET /audit
+func (h *AuditHandler) ListEvents(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.auditRepo.ListByOwner(c.Request.Context(), userID, limit, offset)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to list audit events", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	total, err := h.auditRepo.CountByOwner(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to count audit events", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"limit":  limit,
+		"offset": offset,
+		"total":  total,
+	})
+}
+
+// paginationParams reads ?limit= and ?offset= (defaults 50 and 0, limit capped at 100)
+func paginationParams(c *gin.Context) (int, int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		respondError(c, http.StatusBadRequest, "limit must be between 1 and 100")
+		return 0, 0, false
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, "offset must be a non-negative integer")
+		return 0, 0, false
+	}
+
+	return limit, offset, true
+}
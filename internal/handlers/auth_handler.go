@@ -4,11 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/audit"
 	"github.com/franzego/stage08/internal/repository"
 	"github.com/franzego/stage08/internal/utils"
 	"github.com/gin-gonic/gin"
@@ -17,13 +18,19 @@ import (
 )
 
 type AuthHandler struct {
-	userRepo      *repository.UserRepository
+	txManager     repository.TxManager
+	userRepo      repository.UserRepository
+	twoFactorRepo repository.TwoFactorRepository
 	oauthConfig   *oauth2.Config
+	httpClient    *http.Client
 	jwtSecret     string
 	jwtExpiration time.Duration
+	challengeTTL  time.Duration
+	auditor       *audit.Recorder
+	logger        *slog.Logger
 }
 
-func NewAuthHandler(userRepo *repository.UserRepository, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(txManager repository.TxManager, userRepo repository.UserRepository, twoFactorRepo repository.TwoFactorRepository, auditor *audit.Recorder, cfg *config.Config, logger *slog.Logger) *AuthHandler {
 	oauthConfig := &oauth2.Config{
 		ClientID:     cfg.Google.ClientID,
 		ClientSecret: cfg.Google.ClientSecret,
@@ -36,10 +43,16 @@ func NewAuthHandler(userRepo *repository.UserRepository, cfg *config.Config) *Au
 	}
 
 	return &AuthHandler{
+		txManager:     txManager,
 		userRepo:      userRepo,
+		twoFactorRepo: twoFactorRepo,
 		oauthConfig:   oauthConfig,
+		httpClient:    &http.Client{Timeout: cfg.Google.Timeout},
 		jwtSecret:     cfg.JWT.Secret,
 		jwtExpiration: cfg.JWT.Expiration,
+		challengeTTL:  cfg.TwoFactor.ChallengeTTL,
+		auditor:       auditor,
+		logger:        logger,
 	}
 }
 
@@ -51,8 +64,6 @@ func (h *AuthHandler) GoogleLogin(c *gin.Context) {
 	// Store state in session or cookie (simplified here)
 	c.SetCookie("oauth_state", state, 600, "/", "", false, true)
 
-	log.Printf("Generated state: %s", state)
-
 	url := h.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
 	c.Redirect(http.StatusTemporaryRedirect, url)
 }
@@ -62,44 +73,36 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 	// Verify state
 	state := c.Query("state")
 	savedState, err := c.Cookie("oauth_state")
-
-	log.Printf("Received state: %s", state)
-	log.Printf("Saved state: %s", savedState)
-	log.Printf("Cookie error: %v", err)
-
 	if err != nil || state != savedState {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid state parameter",
-			"debug": gin.H{
-				"received_state": state,
-				"cookie_error":   fmt.Sprintf("%v", err),
-			},
-		})
+		h.logger.WarnContext(c.Request.Context(), "OAuth state mismatch", "cookie_present", err == nil)
+		respondError(c, http.StatusBadRequest, "Invalid state parameter")
 		return
 	}
 
 	// Exchange code for token
 	code := c.Query("code")
-	token, err := h.oauthConfig.Exchange(context.Background(), code)
+	// The oauth2 package picks up the HTTP client (and its timeout) from the context
+	oauthCtx := context.WithValue(c.Request.Context(), oauth2.HTTPClient, h.httpClient)
+	token, err := h.oauthConfig.Exchange(oauthCtx, code)
 	if err != nil {
-		log.Printf("Failed to exchange token: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange token"})
+		h.logger.ErrorContext(c.Request.Context(), "Failed to exchange token", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to exchange token")
 		return
 	}
 
 	// Get user info from Google
-	userInfo, err := h.getUserInfo(token.AccessToken)
+	userInfo, err := h.getUserInfo(oauthCtx, token)
 	if err != nil {
-		log.Printf("Failed to get user info: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
+		h.logger.ErrorContext(c.Request.Context(), "Failed to get user info", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to get user info")
 		return
 	}
 
 	// Find or create user
-	user, err := h.userRepo.FindByGoogleID(userInfo.ID)
+	user, err := h.userRepo.FindByGoogleID(c.Request.Context(), userInfo.ID)
 	if err != nil {
-		log.Printf("Database error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find user", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
 		return
 	}
 
@@ -110,20 +113,60 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 			picture = nil
 		}
 
-		user, err = h.userRepo.Create(userInfo.ID, userInfo.Email, userInfo.Name, picture)
+		user, err = h.userRepo.Create(c.Request.Context(), userInfo.ID, userInfo.Email, userInfo.Name, picture)
 		if err != nil {
-			log.Printf("Failed to create user: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			h.logger.ErrorContext(c.Request.Context(), "Failed to create user", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to create user")
 			return
 		}
-		log.Printf("✅ New user created: %s", user.Email)
+		h.logger.InfoContext(c.Request.Context(), "New user created", "user_id", user.ID)
+	}
+
+	// Users with 2FA enabled must complete a challenge before getting a session
+	twoFactor, err := h.twoFactorRepo.FindByUserID(c.Request.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find two-factor enrollment", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if twoFactor != nil && twoFactor.Enabled {
+		challengeToken, err := utils.GenerateChallengeJWT(user.ID, user.Email, user.Name, h.jwtSecret, h.challengeTTL)
+		if err != nil {
+			h.logger.ErrorContext(c.Request.Context(), "Failed to generate challenge token", "error", err)
+			respondError(c, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+		})
+		return
 	}
 
 	// Generate JWT
 	jwtToken, err := utils.GenerateJWT(user.ID, user.Email, user.Name, h.jwtSecret, h.jwtExpiration)
 	if err != nil {
-		log.Printf("Failed to generate JWT: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		h.logger.ErrorContext(c.Request.Context(), "Failed to generate JWT", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	// The request has no session yet, so the login is attributed to the user
+	ctx := audit.WithActor(c.Request.Context(), audit.RequestActor(c))
+	err = h.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		return h.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: user.ID,
+			Action:      audit.ActionLogin,
+			TargetType:  audit.TargetUser,
+			TargetID:    user.ID.String(),
+			After:       gin.H{"two_factor": false},
+		})
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record login", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to record login")
 		return
 	}
 
@@ -145,13 +188,23 @@ type GoogleUserInfo struct {
 	Picture string `json:"picture"`
 }
 
-func (h *AuthHandler) getUserInfo(accessToken string) (*GoogleUserInfo, error) {
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + accessToken)
+func (h *AuthHandler) getUserInfo(ctx context.Context, token *oauth2.Token) (*GoogleUserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// The token is sent in the Authorization header rather than the query string
+	resp, err := h.oauthConfig.Client(ctx, token).Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user info request returned %d", resp.StatusCode)
+	}
+
 	var userInfo GoogleUserInfo
 	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
 		return nil, fmt.Errorf("failed to decode user info: %w", err)
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	batchUploadLineBytes = 512      // Room for one upload line, in any of the formats
+	batchUploadOverhead  = 64 << 10 // Room for the CSV header, JSON wrapping and multipart headers
+)
+
+// BatchTransferHandler accepts bulk transfer uploads and reports on their progress
+type BatchTransferHandler struct {
+	batchTransferService *service.BatchTransferService
+	logger               *slog.Logger
+}
+
+func NewBatchTransferHandler(batchTransferService *service.BatchTransferService, logger *slog.Logger) *BatchTransferHandler {
+	return &BatchTransferHandler{
+		batchTransferService: batchTransferService,
+		logger:               logger,
+	}
+}
+
+// CreateBatch validates a bulk upload, reserves its total and queues it for payment. The
+// upload is a JSON body, a text/csv body, or a multipart form with a CSV file field.
+// POST /wallet/batches
+func (h *BatchTransferHandler) CreateBatch(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	mode, inputs, ok := h.readUpload(c)
+	if !ok {
+		return
+	}
+
+	batch, err := h.batchTransferService.Create(c.Request.Context(), userID, models.TransferBatchMode(mode), inputs)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, batchResponse(batch))
+}
+
+// readUpload reads the mode and lines of a batch upload in any of the accepted formats. The
+// body is capped at what a batch of the largest allowed size needs, so an oversized upload is
+// refused before it is read into memory.
+func (h *BatchTransferHandler) readUpload(c *gin.Context) (string, []service.BatchTransferInput, bool) {
+	maxItems := h.batchTransferService.MaxItems()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxItems)*batchUploadLineBytes+batchUploadOverhead)
+
+	switch c.ContentType() {
+	case "text/csv":
+		inputs, err := service.ParseBatchCSV(c.Request.Body, maxItems)
+		if err != nil {
+			respondUploadError(c, err)
+			return "", nil, false
+		}
+		return c.Query("mode"), inputs, true
+
+	case "multipart/form-data":
+		header, err := c.FormFile("file")
+		if tooLarge(err) {
+			respondUploadError(c, err)
+			return "", nil, false
+		}
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid request. A CSV file field is required")
+			return "", nil, false
+		}
+		file, err := header.Open()
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "Invalid request. Could not read the uploaded file")
+			return "", nil, false
+		}
+		defer file.Close()
+
+		inputs, err := service.ParseBatchCSV(file, maxItems)
+		if err != nil {
+			respondUploadError(c, err)
+			return "", nil, false
+		}
+		return c.PostForm("mode"), inputs, true
+
+	default:
+		var req struct {
+			Mode      string `json:"mode"` // all_or_nothing (default) or best_effort
+			Transfers []struct {
+				WalletNumber string `json:"wallet_number"`
+				Amount       int64  `json:"amount"` // In kobo
+				Description  string `json:"description"`
+			} `json:"transfers" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			if tooLarge(err) {
+				respondUploadError(c, err)
+				return "", nil, false
+			}
+			respondError(c, http.StatusBadRequest, "Invalid request. transfers is required")
+			return "", nil, false
+		}
+
+		inputs := make([]service.BatchTransferInput, len(req.Transfers))
+		for i, transfer := range req.Transfers {
+			inputs[i] = service.BatchTransferInput{
+				WalletNumber: transfer.WalletNumber,
+				Amount:       transfer.Amount,
+				Description:  transfer.Description,
+			}
+		}
+		return req.Mode, inputs, true
+	}
+}
+
+// respondUploadError reports an upload that couldn't be read: 413 if it is over the size cap,
+// the domain error if its content is invalid, and 400 otherwise
+func respondUploadError(c *gin.Context, err error) {
+	var domainErr *service.Error
+	switch {
+	case tooLarge(err):
+		middleware.WriteProblem(c, http.StatusRequestEntityTooLarge, "upload_too_large", "Upload is too large for a batch")
+	case errors.As(err, &domainErr):
+		c.Error(err)
+	default:
+		respondError(c, http.StatusBadRequest, "Invalid request. Could not read the upload")
+	}
+}
+
+func tooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// ListBatches lists the user's batches
+// GET /wallet/batches
+func (h *BatchTransferHandler) ListBatches(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	batches, err := h.batchTransferService.List(c.Request.Context(), userID, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"batches": batches,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetBatch returns one of the user's batches with its progress
+// GET /wallet/batches/:id
+func (h *BatchTransferHandler) GetBatch(c *gin.Context) {
+	userID, id, ok := batchParams(c)
+	if !ok {
+		return
+	}
+
+	batch, err := h.batchTransferService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, batchResponse(batch))
+}
+
+// ListBatchItems lists the lines of one of the user's batches, optionally filtered by status
+// GET /wallet/batches/:id/items
+func (h *BatchTransferHandler) ListBatchItems(c *gin.Context) {
+	userID, id, ok := batchParams(c)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	items, err := h.batchTransferService.ListItems(c.Request.Context(), userID, id, c.Query("status"), limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":  items,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// DownloadBatchResult returns every line of one of the user's batches with its outcome as CSV
+// GET /wallet/batches/:id/result.csv
+func (h *BatchTransferHandler) DownloadBatchResult(c *gin.Context) {
+	userID, id, ok := batchParams(c)
+	if !ok {
+		return
+	}
+
+	batch, items, err := h.batchTransferService.Result(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="batch-%s.csv"`, batch.ID))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"line", "wallet_number", "amount", "description", "status", "reference", "error_code", "error_message"})
+	for _, item := range items {
+		writer.Write([]string{
+			strconv.Itoa(item.Line),
+			item.RecipientWalletNumber,
+			strconv.FormatInt(item.Amount, 10),
+			valueOf(item.Description),
+			string(item.Status),
+			valueOf(item.Reference),
+			valueOf(item.ErrorCode),
+			valueOf(item.ErrorMessage),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		h.logger.WarnContext(c.Request.Context(), "Failed to write batch result", "batch_id", batch.ID, "error", err)
+	}
+}
+
+// batchResponse describes a batch with how far the worker has got through it
+func batchResponse(batch *models.TransferBatch) gin.H {
+	processed := batch.Processed()
+	return gin.H{
+		"batch": batch,
+		"progress": gin.H{
+			"processed": processed,
+			"total":     batch.TotalItems,
+			"percent":   processed * 100 / batch.TotalItems,
+		},
+		"result_url": "/wallet/batches/" + batch.ID.String() + "/result.csv",
+	}
+}
+
+// batchParams reads the caller and the :id path parameter
+func batchParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid batch id")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, id, true
+}
+
+// valueOf returns the string s points to, or "" for nil
+func valueOf(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/payment"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DepositHandler starts deposits with a payment provider and receives the providers' webhooks
+type DepositHandler struct {
+	providers      *payment.Registry
+	depositService *service.DepositService
+	webhookService *service.WebhookService
+	logger         *slog.Logger
+}
+
+func NewDepositHandler(providers *payment.Registry, depositService *service.DepositService, webhookService *service.WebhookService, logger *slog.Logger) *DepositHandler {
+	return &DepositHandler{
+		providers:      providers,
+		depositService: depositService,
+		webhookService: webhookService,
+		logger:         logger,
+	}
+}
+
+// InitializeDeposit initializes a deposit with the requested or the default provider
+// POST /wallet/deposit
+func (h *DepositHandler) InitializeDeposit(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Amount       int64  `json:"amount" binding:"required"` // In kobo
+		Provider     string `json:"provider"`                  // Optional; PAYMENT_DEFAULT_PROVIDER if empty
+		WalletNumber string `json:"wallet_number"`             // Optional; the default wallet if empty
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. Amount must be at least 100 kobo")
+		return
+	}
+
+	// A member depositing for an organization pays with their own email
+	payerID, err := middleware.GetActorID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	deposit, err := h.depositService.Initialize(c.Request.Context(), userID, payerID, req.WalletNumber, req.Amount, req.Provider)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reference":         *deposit.Transaction.Reference,
+		"provider":          *deposit.Transaction.Provider,
+		"authorization_url": deposit.AuthorizationURL,
+	})
+}
+
+// ChargeSavedCard deposits by charging one of the user's saved cards, without a checkout
+// POST /wallet/deposit/charge
+func (h *DepositHandler) ChargeSavedCard(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		PaymentMethodID string `json:"payment_method_id" binding:"required"`
+		Amount          int64  `json:"amount" binding:"required"` // In kobo
+		WalletNumber    string `json:"wallet_number"`             // Optional; the default wallet if empty
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. payment_method_id and amount are required")
+		return
+	}
+
+	paymentMethodID, err := uuid.Parse(req.PaymentMethodID)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid payment_method_id")
+		return
+	}
+
+	tx, _, err := h.depositService.ChargeSavedCard(c.Request.Context(), userID, paymentMethodID, req.WalletNumber, req.Amount)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reference": *tx.Reference,
+		"provider":  *tx.Provider,
+		"status":    tx.Status,
+		"amount":    tx.Amount,
+	})
+}
+
+// Webhook returns the handler for the named provider's webhooks. It stores a verified webhook
+// and acknowledges it; the event is processed in the background from the webhook event store.
+// POST /wallet/{provider}/webhook
+func (h *DepositHandler) Webhook(provider string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := h.providers.Get(provider)
+		if !ok {
+			respondError(c, http.StatusNotFound, "Unknown payment provider")
+			return
+		}
+
+		// Read raw body for signature verification
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			h.logger.ErrorContext(c.Request.Context(), "Failed to read webhook body", "provider", provider, "error", err)
+			respondError(c, http.StatusBadRequest, "Failed to read request")
+			return
+		}
+
+		if !p.VerifyWebhook(c.Request.Header, body) {
+			h.logger.WarnContext(c.Request.Context(), "Invalid webhook signature", "provider", provider, "client_ip", c.ClientIP())
+			metrics.RecordWebhookEvent("", metrics.WebhookResultInvalidSignature)
+			respondError(c, http.StatusUnauthorized, "Invalid signature")
+			return
+		}
+
+		// Store the event; if this fails the provider gets a 5xx and redelivers it
+		event, duplicate, err := h.webhookService.Receive(c.Request.Context(), provider, body)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrInvalidWebhookPayload):
+				h.logger.WarnContext(c.Request.Context(), "Failed to parse webhook", "provider", provider)
+				metrics.RecordWebhookEvent("", metrics.WebhookResultInvalidPayload)
+			case errors.Is(err, service.ErrStaleWebhook):
+				metrics.RecordWebhookEvent("", metrics.WebhookResultStale)
+			}
+			c.Error(err)
+			return
+		}
+
+		if duplicate {
+			h.logger.InfoContext(c.Request.Context(), "Duplicate webhook dropped", "provider", provider, "event_type", event.EventType, "event_id", event.EventID)
+			metrics.RecordWebhookEvent(event.EventType, metrics.WebhookResultDuplicate)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": true})
+	}
+}
+
+// GetDepositStatus checks the status of one of the user's deposits
+// GET /wallet/deposit/:reference/status
+func (h *DepositHandler) GetDepositStatus(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	reference := c.Param("reference")
+
+	tx, err := h.depositService.Status(c.Request.Context(), userID, reference)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reference": reference,
+		"status":    tx.Status,
+		"amount":    tx.Amount,
+		"provider":  tx.Provider,
+	})
+}
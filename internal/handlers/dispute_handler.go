@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DisputeHandler lets senders dispute transfers, recipients answer those disputes and
+// admins force reversals
+type DisputeHandler struct {
+	disputeService *service.DisputeService
+	logger         *slog.Logger
+}
+
+func NewDisputeHandler(disputeService *service.DisputeService, logger *slog.Logger) *DisputeHandler {
+	return &DisputeHandler{
+		disputeService: disputeService,
+		logger:         logger,
+	}
+}
+
+// OpenDispute disputes a transfer the user sent
+// POST /wallet/disputes
+func (h *DisputeHandler) OpenDispute(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Reference string `json:"reference" binding:"required"` // As returned by POST /wallet/transfer
+		Reason    string `json:"reason" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. reference and reason are required")
+		return
+	}
+
+	dispute, err := h.disputeService.Open(c.Request.Context(), userID, req.Reference, req.Reason)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, dispute)
+}
+
+// ListDisputes lists disputes the user opened (role=sender, the default) or that are
+// against transfers they received (role=recipient), optionally filtered by status
+// GET /wallet/disputes
+func (h *DisputeHandler) ListDisputes(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+	role := c.DefaultQuery("role", service.DisputeRoleSender)
+
+	disputes, err := h.disputeService.List(c.Request.Context(), userID, role, c.Query("status"), limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"disputes": disputes,
+		"role":     role,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// GetDispute returns a dispute the user is the sender or recipient of
+// GET /wallet/disputes/:id
+func (h *DisputeHandler) GetDispute(c *gin.Context) {
+	userID, id, ok := disputeParams(c)
+	if !ok {
+		return
+	}
+
+	dispute, err := h.disputeService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// ApproveDispute returns the disputed amount to the sender from the user's wallet
+// POST /wallet/disputes/:id/approve
+func (h *DisputeHandler) ApproveDispute(c *gin.Context) {
+	userID, id, ok := disputeParams(c)
+	if !ok {
+		return
+	}
+
+	dispute, err := h.disputeService.Approve(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// RejectDispute refuses a dispute against a transfer the user received
+// POST /wallet/disputes/:id/reject
+func (h *DisputeHandler) RejectDispute(c *gin.Context) {
+	userID, id, ok := disputeParams(c)
+	if !ok {
+		return
+	}
+
+	note, ok := resolutionNote(c)
+	if !ok {
+		return
+	}
+
+	dispute, err := h.disputeService.Reject(c.Request.Context(), userID, id, note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// CancelDispute withdraws a dispute the user opened
+// DELETE /wallet/disputes/:id
+func (h *DisputeHandler) CancelDispute(c *gin.Context) {
+	userID, id, ok := disputeParams(c)
+	if !ok {
+		return
+	}
+
+	dispute, err := h.disputeService.Cancel(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// AdminListDisputes lists every dispute, optionally filtered by status
+// GET /admin/disputes
+func (h *DisputeHandler) AdminListDisputes(c *gin.Context) {
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	disputes, err := h.disputeService.ListAll(c.Request.Context(), c.Query("status"), limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"disputes": disputes,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// AdminGetDispute returns any dispute
+// GET /admin/disputes/:id
+func (h *DisputeHandler) AdminGetDispute(c *gin.Context) {
+	_, id, ok := disputeParams(c)
+	if !ok {
+		return
+	}
+
+	dispute, err := h.disputeService.GetAny(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// ForceReversal reverses an open or rejected dispute whatever the recipient says
+// POST /admin/disputes/:id/reverse
+func (h *DisputeHandler) ForceReversal(c *gin.Context) {
+	userID, id, ok := disputeParams(c)
+	if !ok {
+		return
+	}
+
+	note, ok := resolutionNote(c)
+	if !ok {
+		return
+	}
+
+	dispute, _, err := h.disputeService.Force(c.Request.Context(), userID, id, note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+// resolutionNote reads the optional {"note": "..."} body of a reject or forced reversal
+func resolutionNote(c *gin.Context) (string, bool) {
+	var req struct {
+		Note string `json:"note"`
+	}
+
+	// An empty body is the same as no note
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return "", false
+	}
+
+	return req.Note, true
+}
+
+// disputeParams reads the caller and the :id path parameter
+func disputeParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid dispute id")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, id, true
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// EscrowHandler lets buyers hold a payment in escrow and the parties settle it
+type EscrowHandler struct {
+	escrowService *service.EscrowService
+	logger        *slog.Logger
+}
+
+func NewEscrowHandler(escrowService *service.EscrowService, logger *slog.Logger) *EscrowHandler {
+	return &EscrowHandler{
+		escrowService: escrowService,
+		logger:        logger,
+	}
+}
+
+// CreateEscrow funds an escrow from the user's wallet
+// POST /wallet/escrows
+func (h *EscrowHandler) CreateEscrow(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		SellerWalletNumber  string `json:"seller_wallet_number" binding:"required"`
+		ArbiterWalletNumber string `json:"arbiter_wallet_number"`
+		Amount              int64  `json:"amount" binding:"required"` // In kobo
+		Description         string `json:"description"`
+		Conditions          string `json:"conditions"`
+		Deadline            string `json:"deadline"` // e.g. 7D; omit for no deadline
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. seller_wallet_number and amount are required")
+		return
+	}
+
+	escrow, err := h.escrowService.Create(c.Request.Context(), userID, service.EscrowInput{
+		SellerWalletNumber:  req.SellerWalletNumber,
+		ArbiterWalletNumber: req.ArbiterWalletNumber,
+		Amount:              req.Amount,
+		Description:         req.Description,
+		Conditions:          req.Conditions,
+		Deadline:            req.Deadline,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, escrow)
+}
+
+// ListEscrows lists escrows the user funded (role=buyer, the default), is to be paid from
+// (role=seller) or arbitrates (role=arbiter), optionally filtered by status
+// GET /wallet/escrows
+func (h *EscrowHandler) ListEscrows(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+	role := c.DefaultQuery("role", service.EscrowRoleBuyer)
+
+	escrows, err := h.escrowService.List(c.Request.Context(), userID, role, c.Query("status"), limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"escrows": escrows,
+		"role":    role,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetEscrow returns an escrow the user is a party to
+// GET /wallet/escrows/:id
+func (h *EscrowHandler) GetEscrow(c *gin.Context) {
+	userID, id, ok := escrowParams(c)
+	if !ok {
+		return
+	}
+
+	escrow, err := h.escrowService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, escrow)
+}
+
+// ListEscrowEvents returns an escrow's history, oldest first
+// GET /wallet/escrows/:id/events
+func (h *EscrowHandler) ListEscrowEvents(c *gin.Context) {
+	userID, id, ok := escrowParams(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.escrowService.Events(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// ReleaseEscrow pays an escrow to the seller
+// POST /wallet/escrows/:id/release
+func (h *EscrowHandler) ReleaseEscrow(c *gin.Context) {
+	userID, id, ok := escrowParams(c)
+	if !ok {
+		return
+	}
+
+	note, ok := resolutionNote(c)
+	if !ok {
+		return
+	}
+
+	escrow, err := h.escrowService.Release(c.Request.Context(), userID, id, note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, escrow)
+}
+
+// RefundEscrow returns an escrow to the buyer
+// POST /wallet/escrows/:id/refund
+func (h *EscrowHandler) RefundEscrow(c *gin.Context) {
+	userID, id, ok := escrowParams(c)
+	if !ok {
+		return
+	}
+
+	note, ok := resolutionNote(c)
+	if !ok {
+		return
+	}
+
+	escrow, err := h.escrowService.Refund(c.Request.Context(), userID, id, note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, escrow)
+}
+
+// SplitEscrow divides an escrow between the seller and the buyer
+// POST /wallet/escrows/:id/split
+func (h *EscrowHandler) SplitEscrow(c *gin.Context) {
+	userID, id, ok := escrowParams(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		SellerAmount int64  `json:"seller_amount" binding:"required"` // In kobo; the buyer gets the rest
+		Note         string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. seller_amount is required")
+		return
+	}
+
+	escrow, err := h.escrowService.Split(c.Request.Context(), userID, id, req.SellerAmount, req.Note)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, escrow)
+}
+
+// escrowParams reads the caller and the :id path parameter
+func escrowParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid escrow id")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, id, true
+}
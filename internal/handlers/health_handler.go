@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/franzego/stage08/internal/health"
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	checker   *health.Checker
+	startedAt time.Time
+	logger    *slog.Logger
+}
+
+func NewHealthHandler(checker *health.Checker, logger *slog.Logger) *HealthHandler {
+	return &HealthHandler{
+		checker:   checker,
+		startedAt: time.Now(),
+		logger:    logger,
+	}
+}
+
+// Livez reports whether the process is running. It never checks dependencies,
+// so an outage elsewhere doesn't get healthy instances restarted.
+// GET /livez
+func (h *HealthHandler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":         health.StatusOK,
+		"uptime_seconds": int64(time.Since(h.startedAt).Seconds()),
+	})
+}
+
+// Readyz reports whether the service can take traffic, with a status per component.
+// It returns 503 when any critical component is down.
+// GET /readyz
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	report := h.checker.Run(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status == health.StatusUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+
+	if report.Status != health.StatusOK {
+		for name, component := range report.Components {
+			if component.Status != health.StatusUp {
+				h.logger.WarnContext(c.Request.Context(), "Readiness check failed", "component", name, "critical", component.Critical, "error", component.Error)
+			}
+		}
+	}
+
+	c.JSON(status, report)
+}
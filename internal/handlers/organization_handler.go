@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// OrganizationHandler manages organizations, their members, invitations and API keys
+type OrganizationHandler struct {
+	orgService *service.OrganizationService
+	logger     *slog.Logger
+}
+
+func NewOrganizationHandler(orgService *service.OrganizationService, logger *slog.Logger) *OrganizationHandler {
+	return &OrganizationHandler{
+		orgService: orgService,
+		logger:     logger,
+	}
+}
+
+// CreateOrganization opens an organization with its own wallet, owned by the caller
+// POST /organizations
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. name is required")
+		return
+	}
+
+	org, err := h.orgService.Create(c.Request.Context(), userID, req.Name)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, org)
+}
+
+// ListOrganizations lists the organizations the caller belongs to
+// GET /organizations
+func (h *OrganizationHandler) ListOrganizations(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	orgs, err := h.orgService.List(c.Request.Context(), userID, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organizations": orgs,
+		"limit":         limit,
+		"offset":        offset,
+	})
+}
+
+// GetOrganization returns an organization the caller belongs to
+// GET /organizations/:id
+func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	org, err := h.orgService.Get(c.Request.Context(), orgID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, org)
+}
+
+// ListMembers lists an organization's members
+// GET /organizations/:id/members
+func (h *OrganizationHandler) ListMembers(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	members, err := h.orgService.Members(c.Request.Context(), orgID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// ChangeMemberRole gives a member a new role
+// POST /organizations/:id/members/:user_id/role
+func (h *OrganizationHandler) ChangeMemberRole(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	memberUserID, ok := pathUUID(c, "user_id", "Invalid user id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. role is required")
+		return
+	}
+
+	after, err := h.orgService.ChangeRole(c.Request.Context(), orgID, userID, memberUserID, models.OrganizationRole(req.Role))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, after)
+}
+
+// RemoveMember takes a member out of an organization; members may remove themselves to leave
+// DELETE /organizations/:id/members/:user_id
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	memberUserID, ok := pathUUID(c, "user_id", "Invalid user id")
+	if !ok {
+		return
+	}
+
+	err := h.orgService.RemoveMember(c.Request.Context(), orgID, userID, memberUserID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// CreateInvitation invites an email address to join the organization
+// POST /organizations/:id/invitations
+func (h *OrganizationHandler) CreateInvitation(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Email string `json:"email" binding:"required"`
+		Role  string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. email and role are required")
+		return
+	}
+
+	invitation, err := h.orgService.Invite(c.Request.Context(), orgID, userID, req.Email, models.OrganizationRole(req.Role))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// ListInvitations lists an organization's invitations, optionally filtered by status
+// GET /organizations/:id/invitations
+func (h *OrganizationHandler) ListInvitations(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	invitations, err := h.orgService.Invitations(c.Request.Context(), orgID, userID, c.Query("status"), limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invitations": invitations,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+// RevokeInvitation withdraws a pending invitation
+// POST /organizations/:id/invitations/:invitation_id/revoke
+func (h *OrganizationHandler) RevokeInvitation(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	invitationID, ok := pathUUID(c, "invitation_id", "Invalid invitation id")
+	if !ok {
+		return
+	}
+
+	invitation, err := h.orgService.RevokeInvitation(c.Request.Context(), orgID, userID, invitationID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitation)
+}
+
+// ListMyInvitations lists the pending invitations addressed to the caller's email
+// GET /organizations/invitations
+func (h *OrganizationHandler) ListMyInvitations(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	invitations, err := h.orgService.PendingInvitations(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// AcceptInvitation joins the organization an invitation is for
+// POST /organizations/invitations/:invitation_id/accept
+func (h *OrganizationHandler) AcceptInvitation(c *gin.Context) {
+	userID, invitationID, ok := invitationParams(c)
+	if !ok {
+		return
+	}
+
+	org, member, err := h.orgService.AcceptInvitation(c.Request.Context(), userID, invitationID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	org.Role = member.Role
+	c.JSON(http.StatusOK, gin.H{
+		"organization": org,
+		"member":       member,
+	})
+}
+
+// DeclineInvitation turns down an invitation
+// POST /organizations/invitations/:invitation_id/decline
+func (h *OrganizationHandler) DeclineInvitation(c *gin.Context) {
+	userID, invitationID, ok := invitationParams(c)
+	if !ok {
+		return
+	}
+
+	invitation, err := h.orgService.DeclineInvitation(c.Request.Context(), userID, invitationID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitation)
+}
+
+// CreateAPIKey issues an API key that belongs to the organization
+// POST /organizations/:id/keys
+func (h *OrganizationHandler) CreateAPIKey(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name        string   `json:"name" binding:"required"`
+		Permissions []string `json:"permissions" binding:"required"`
+		Expiry      string   `json:"expiry" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	org, apiKey, rawKey, err := h.orgService.CreateAPIKey(c.Request.Context(), orgID, userID, req.Name, req.Permissions, req.Expiry)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key":         rawKey,
+		"organization_id": org.ID,
+		"expires_at":      apiKey.ExpiresAt,
+	})
+}
+
+// ListAPIKeys lists the organization's API keys (without revealing the actual keys)
+// GET /organizations/:id/keys
+func (h *OrganizationHandler) ListAPIKeys(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	keys, err := h.orgService.APIKeys(c.Request.Context(), orgID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// KeyHash is never serialised
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// RevokeAPIKey deactivates one of the organization's API keys
+// POST /organizations/:id/keys/:key_id/revoke
+func (h *OrganizationHandler) RevokeAPIKey(c *gin.Context) {
+	userID, orgID, ok := organizationParams(c)
+	if !ok {
+		return
+	}
+
+	keyID, ok := pathUUID(c, "key_id", "Invalid key id")
+	if !ok {
+		return
+	}
+
+	err := h.orgService.RevokeAPIKey(c.Request.Context(), orgID, userID, keyID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// organizationParams reads the caller and the :id path parameter
+func organizationParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	orgID, ok := pathUUID(c, "id", "Invalid organization id")
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, orgID, true
+}
+
+// invitationParams reads the caller and the :invitation_id path parameter
+func invitationParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	invitationID, ok := pathUUID(c, "invitation_id", "Invalid invitation id")
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, invitationID, true
+}
+
+// pathUUID parses a UUID path parameter, responding with message if it isn't one
+func pathUUID(c *gin.Context, name, message string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(name))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, message)
+		return uuid.Nil, false
+	}
+	return id, true
+}
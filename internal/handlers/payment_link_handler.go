@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PaymentLinkHandler manages users' payment links and serves the public pages that pay
+// through them
+type PaymentLinkHandler struct {
+	paymentLinkService *service.PaymentLinkService
+	logger             *slog.Logger
+}
+
+func NewPaymentLinkHandler(paymentLinkService *service.PaymentLinkService, logger *slog.Logger) *PaymentLinkHandler {
+	return &PaymentLinkHandler{
+		paymentLinkService: paymentLinkService,
+		logger:             logger,
+	}
+}
+
+// CreatePaymentLink issues a public link anyone can pay into the user's wallet through
+// POST /wallet/payment-links
+func (h *PaymentLinkHandler) CreatePaymentLink(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Amount      *int64 `json:"amount"` // Fixed amount in kobo; omit to let the payer choose
+		Description string `json:"description"`
+		Expiry      string `json:"expiry"` // e.g. 1D, 1M; omit for a link that never expires
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	link, err := h.paymentLinkService.Create(c.Request.Context(), userID, service.PaymentLinkInput{
+		Amount:      req.Amount,
+		Description: req.Description,
+		Expiry:      req.Expiry,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, link)
+}
+
+// ListPaymentLinks lists the user's payment links with what was paid through them
+// GET /wallet/payment-links
+func (h *PaymentLinkHandler) ListPaymentLinks(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	links, err := h.paymentLinkService.List(c.Request.Context(), userID, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_links": links,
+		"limit":         limit,
+		"offset":        offset,
+	})
+}
+
+// DeactivatePaymentLink stops one of the user's payment links from accepting payments
+// DELETE /wallet/payment-links/:id
+func (h *PaymentLinkHandler) DeactivatePaymentLink(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid payment link id")
+		return
+	}
+
+	link, err := h.paymentLinkService.Deactivate(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+// GetLink describes a payment link to someone about to pay through it
+// GET /pay/:code
+func (h *PaymentLinkHandler) GetLink(c *gin.Context) {
+	link, recipient, err := h.paymentLinkService.Lookup(c.Request.Context(), c.Param("code"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Only what a payer needs; totals and the owner's identifiers stay private
+	c.JSON(http.StatusOK, gin.H{
+		"code":           link.Code,
+		"url":            link.URL,
+		"recipient_name": recipient,
+		"amount":         link.Amount,
+		"description":    link.Description,
+		"expires_at":     link.ExpiresAt,
+		"active":         link.Usable(time.Now()),
+	})
+}
+
+// PayLink starts a Paystack checkout that pays into the link owner's wallet
+// POST /pay/:code
+func (h *PaymentLinkHandler) PayLink(c *gin.Context) {
+	var req struct {
+		Email  string `json:"email" binding:"required,email"`
+		Amount int64  `json:"amount"` // In kobo; required unless the link has a fixed amount
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. A valid email is required")
+		return
+	}
+
+	deposit, err := h.paymentLinkService.Pay(c.Request.Context(), c.Param("code"), req.Email, req.Amount)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reference":         *deposit.Transaction.Reference,
+		"amount":            deposit.Transaction.Amount,
+		"authorization_url": deposit.AuthorizationURL,
+	})
+}
+
+// LinkQRCode returns a QR code of the payment link's URL
+// GET /pay/:code/qr.png
+func (h *PaymentLinkHandler) LinkQRCode(c *gin.Context) {
+	image, err := h.paymentLinkService.QRCode(c.Request.Context(), c.Param("code"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/png", image)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PaymentMethodHandler lists and deletes the cards users have saved
+type PaymentMethodHandler struct {
+	paymentMethodService *service.PaymentMethodService
+	logger               *slog.Logger
+}
+
+func NewPaymentMethodHandler(paymentMethodService *service.PaymentMethodService, logger *slog.Logger) *PaymentMethodHandler {
+	return &PaymentMethodHandler{
+		paymentMethodService: paymentMethodService,
+		logger:               logger,
+	}
+}
+
+// ListPaymentMethods lists the user's saved cards
+// GET /wallet/payment-methods
+func (h *PaymentMethodHandler) ListPaymentMethods(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	methods, err := h.paymentMethodService.List(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment_methods": methods})
+}
+
+// DeletePaymentMethod forgets one of the user's saved cards
+// DELETE /wallet/payment-methods/:id
+func (h *PaymentMethodHandler) DeletePaymentMethod(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid payment method id")
+		return
+	}
+
+	if err := h.paymentMethodService.Delete(c.Request.Context(), userID, id); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment method deleted"})
+}
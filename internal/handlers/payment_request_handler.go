@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PaymentRequestHandler lets users request money from each other and answer those requests
+type PaymentRequestHandler struct {
+	paymentRequestService *service.PaymentRequestService
+	logger                *slog.Logger
+}
+
+func NewPaymentRequestHandler(paymentRequestService *service.PaymentRequestService, logger *slog.Logger) *PaymentRequestHandler {
+	return &PaymentRequestHandler{
+		paymentRequestService: paymentRequestService,
+		logger:                logger,
+	}
+}
+
+// CreatePaymentRequest asks a wallet owner, by wallet number or email, to pay the user
+// POST /wallet/payment-requests
+func (h *PaymentRequestHandler) CreatePaymentRequest(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		WalletNumber string `json:"wallet_number"`
+		Email        string `json:"email"`
+		Amount       int64  `json:"amount" binding:"required"` // In kobo
+		Memo         string `json:"memo"`
+		Expiry       string `json:"expiry"` // e.g. 1H, 7D; defaults to 7D
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. amount and one of wallet_number or email are required")
+		return
+	}
+
+	request, err := h.paymentRequestService.Create(c.Request.Context(), userID, service.PaymentRequestInput{
+		WalletNumber: req.WalletNumber,
+		Email:        req.Email,
+		Amount:       req.Amount,
+		Memo:         req.Memo,
+		Expiry:       req.Expiry,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, request)
+}
+
+// ListPaymentRequests lists requests addressed to the user (direction=incoming, the default)
+// or sent by them (direction=outgoing), optionally filtered by status
+// GET /wallet/payment-requests
+func (h *PaymentRequestHandler) ListPaymentRequests(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+	direction := c.DefaultQuery("direction", service.PaymentRequestsIncoming)
+
+	requests, err := h.paymentRequestService.List(c.Request.Context(), userID, direction, c.Query("status"), limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_requests": requests,
+		"direction":        direction,
+		"limit":            limit,
+		"offset":           offset,
+	})
+}
+
+// GetPaymentRequest returns a request the user sent or that is addressed to them
+// GET /wallet/payment-requests/:id
+func (h *PaymentRequestHandler) GetPaymentRequest(c *gin.Context) {
+	userID, id, ok := paymentRequestParams(c)
+	if !ok {
+		return
+	}
+
+	request, err := h.paymentRequestService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// AcceptPaymentRequest pays a request addressed to the user
+// POST /wallet/payment-requests/:id/accept
+func (h *PaymentRequestHandler) AcceptPaymentRequest(c *gin.Context) {
+	userID, id, ok := paymentRequestParams(c)
+	if !ok {
+		return
+	}
+
+	request, err := h.paymentRequestService.Accept(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// DeclinePaymentRequest refuses a request addressed to the user
+// POST /wallet/payment-requests/:id/decline
+func (h *PaymentRequestHandler) DeclinePaymentRequest(c *gin.Context) {
+	h.answer(c, h.paymentRequestService.Decline)
+}
+
+// CancelPaymentRequest withdraws a request the user sent
+// DELETE /wallet/payment-requests/:id
+func (h *PaymentRequestHandler) CancelPaymentRequest(c *gin.Context) {
+	h.answer(c, h.paymentRequestService.Cancel)
+}
+
+// answer applies a decline or cancel to a payment request
+func (h *PaymentRequestHandler) answer(c *gin.Context, respond func(ctx context.Context, userID, id uuid.UUID) (*models.PaymentRequest, error)) {
+	userID, id, ok := paymentRequestParams(c)
+	if !ok {
+		return
+	}
+
+	request, err := respond(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// paymentRequestParams reads the caller and the :id path parameter
+func paymentRequestParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid payment request id")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, id, true
+}
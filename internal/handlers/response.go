@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// respondError writes a problem response whose code is derived from the status.
+// Service errors should go through c.Error instead so they keep their domain code.
+func respondError(c *gin.Context, status int, message string) {
+	middleware.WriteProblem(c, status, middleware.StatusCode(status), message)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ScheduledTransferHandler manages users' standing orders
+type ScheduledTransferHandler struct {
+	scheduledTransferService *service.ScheduledTransferService
+	logger                   *slog.Logger
+}
+
+func NewScheduledTransferHandler(scheduledTransferService *service.ScheduledTransferService, logger *slog.Logger) *ScheduledTransferHandler {
+	return &ScheduledTransferHandler{
+		scheduledTransferService: scheduledTransferService,
+		logger:                   logger,
+	}
+}
+
+// CreateScheduledTransfer sets up a transfer that repeats on a cron or interval schedule
+// POST /wallet/scheduled-transfers
+func (h *ScheduledTransferHandler) CreateScheduledTransfer(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		FromWalletNumber string     `json:"from_wallet_number"` // Optional; the default wallet if empty
+		WalletNumber     string     `json:"wallet_number" binding:"required"`
+		Amount           int64      `json:"amount" binding:"required"` // In kobo
+		Description      string     `json:"description"`
+		Cron             string     `json:"cron"`
+		Interval         string     `json:"interval"`
+		StartAt          *time.Time `json:"start_at"`
+		EndAt            *time.Time `json:"end_at"`
+		MaxOccurrences   *int       `json:"max_occurrences"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. wallet_number, amount and one of cron or interval are required")
+		return
+	}
+
+	transfer, err := h.scheduledTransferService.Create(c.Request.Context(), userID, service.ScheduledTransferInput{
+		FromWalletNumber:      req.FromWalletNumber,
+		RecipientWalletNumber: req.WalletNumber,
+		Amount:                req.Amount,
+		Description:           req.Description,
+		Cron:                  req.Cron,
+		Interval:              req.Interval,
+		StartAt:               req.StartAt,
+		EndAt:                 req.EndAt,
+		MaxOccurrences:        req.MaxOccurrences,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, transfer)
+}
+
+// ListScheduledTransfers lists the user's scheduled transfers
+// GET /wallet/scheduled-transfers
+func (h *ScheduledTransferHandler) ListScheduledTransfers(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	transfers, err := h.scheduledTransferService.List(c.Request.Context(), userID, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"scheduled_transfers": transfers,
+		"limit":               limit,
+		"offset":              offset,
+	})
+}
+
+// GetScheduledTransfer returns one of the user's scheduled transfers and its recent runs
+// GET /wallet/scheduled-transfers/:id
+func (h *ScheduledTransferHandler) GetScheduledTransfer(c *gin.Context) {
+	userID, id, ok := scheduledTransferParams(c)
+	if !ok {
+		return
+	}
+
+	transfer, runs, err := h.scheduledTransferService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"scheduled_transfer": transfer,
+		"runs":               runs,
+	})
+}
+
+// PauseScheduledTransfer stops a scheduled transfer from running until it is resumed
+// POST /wallet/scheduled-transfers/:id/pause
+func (h *ScheduledTransferHandler) PauseScheduledTransfer(c *gin.Context) {
+	h.changeStatus(c, h.scheduledTransferService.Pause)
+}
+
+// ResumeScheduledTransfer reactivates a paused scheduled transfer
+// POST /wallet/scheduled-transfers/:id/resume
+func (h *ScheduledTransferHandler) ResumeScheduledTransfer(c *gin.Context) {
+	h.changeStatus(c, h.scheduledTransferService.Resume)
+}
+
+// CancelScheduledTransfer permanently stops a scheduled transfer
+// DELETE /wallet/scheduled-transfers/:id
+func (h *ScheduledTransferHandler) CancelScheduledTransfer(c *gin.Context) {
+	h.changeStatus(c, h.scheduledTransferService.Cancel)
+}
+
+// changeStatus applies a status change to one of the user's scheduled transfers
+func (h *ScheduledTransferHandler) changeStatus(c *gin.Context, change func(ctx context.Context, userID, id uuid.UUID) (*models.ScheduledTransfer, error)) {
+	userID, id, ok := scheduledTransferParams(c)
+	if !ok {
+		return
+	}
+
+	transfer, err := change(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, transfer)
+}
+
+// scheduledTransferParams reads the caller and the :id path parameter
+func scheduledTransferParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid scheduled transfer id")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, id, true
+}
@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const recoveryCodeCount = 10
+
+// errInvalidSecondFactor rolls back a challenge that was completed with a wrong code, so the
+// challenge can be retried
+var errInvalidSecondFactor = errors.New("invalid second factor")
+
+type TwoFactorHandler struct {
+	txManager     repository.TxManager
+	twoFactorRepo repository.TwoFactorRepository
+	userRepo      repository.UserRepository
+	issuer        string
+	jwtSecret     string
+	jwtExpiration time.Duration
+	maxAttempts   int
+	lockout       time.Duration
+	auditor       *audit.Recorder
+	logger        *slog.Logger
+}
+
+func NewTwoFactorHandler(txManager repository.TxManager, twoFactorRepo repository.TwoFactorRepository, userRepo repository.UserRepository, auditor *audit.Recorder, cfg *config.Config, logger *slog.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		txManager:     txManager,
+		twoFactorRepo: twoFactorRepo,
+		userRepo:      userRepo,
+		auditor:       auditor,
+		issuer:        cfg.TwoFactor.Issuer,
+		jwtSecret:     cfg.JWT.Secret,
+		jwtExpiration: cfg.JWT.Expiration,
+		maxAttempts:   cfg.TwoFactor.MaxAttempts,
+		lockout:       cfg.TwoFactor.Lockout,
+		logger:        logger,
+	}
+}
+
+// secondFactorRequest carries either a TOTP code or a recovery code
+type secondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Status returns the user's 2FA enrollment state
+// GET /auth/2fa
+func (h *TwoFactorHandler) Status(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tf, err := h.twoFactorRepo.FindByUserID(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find two-factor enrollment", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if tf == nil || !tf.Enabled {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	remaining, err := h.twoFactorRepo.CountUnusedRecoveryCodes(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to count recovery codes", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  true,
+		"confirmed_at":             tf.ConfirmedAt,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Setup starts TOTP enrollment by generating a new secret
+// POST /auth/2fa/setup
+func (h *TwoFactorHandler) Setup(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	existing, err := h.twoFactorRepo.FindByUserID(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find two-factor enrollment", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if existing != nil && existing.Enabled {
+		respondError(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to generate TOTP secret", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	if err := h.twoFactorRepo.UpsertPending(c.Request.Context(), userID, secret); err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to store TOTP secret", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_url": utils.TOTPURI(h.issuer, middleware.GetUserEmail(c), secret),
+	})
+}
+
+// Confirm finishes enrollment once the user proves their authenticator produces valid codes
+// POST /auth/2fa/confirm
+func (h *TwoFactorHandler) Confirm(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	tf, err := h.twoFactorRepo.FindByUserID(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find two-factor enrollment", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if tf == nil || tf.Enabled {
+		respondError(c, http.StatusBadRequest, "No pending two-factor enrollment")
+		return
+	}
+
+	// Confirming counts towards the same limit as other checks, so a pending secret can't be guessed
+	if !h.reserveAttempt(c, tf) {
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(tf.Secret, req.Code, time.Now())
+	if !ok {
+		respondError(c, http.StatusBadRequest, "Invalid verification code")
+		return
+	}
+
+	recoveryCodes := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	err = h.txManager.WithinTx(c.Request.Context(), func(uow *repository.UnitOfWork) error {
+		if err := uow.TwoFactor.Enable(c.Request.Context(), userID, step, recoveryCodes); err != nil {
+			return err
+		}
+
+		return h.auditor.Record(c.Request.Context(), uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionTwoFactorEnable,
+			TargetType:  audit.TargetUser,
+			TargetID:    userID.String(),
+			Before:      gin.H{"enabled": false},
+			After:       gin.H{"enabled": true},
+		})
+	})
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to enable two-factor", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	h.resetAttempts(c, userID)
+
+	token, ok := h.issueVerifiedToken(c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": recoveryCodes,
+		"token":          token,
+	})
+}
+
+// VerifyChallenge completes a login for users with 2FA enabled
+// POST /auth/2fa/verify
+func (h *TwoFactorHandler) VerifyChallenge(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		secondFactorRequest
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	claims, err := utils.ValidateChallengeJWT(req.ChallengeToken, h.jwtSecret)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Invalid or expired challenge token")
+		return
+	}
+
+	challengeID, err := uuid.Parse(claims.ID)
+	if err != nil || claims.ExpiresAt == nil {
+		respondError(c, http.StatusUnauthorized, "Invalid or expired challenge token")
+		return
+	}
+
+	tf, ok := h.reserveSecondFactor(c, claims.UserID, req.secondFactorRequest)
+	if !ok {
+		return
+	}
+
+	// A challenge completes one login; replaying it is refused before the code is checked, so a
+	// replay doesn't spend the code. A wrong code rolls the challenge back for another try. The
+	// login is audited with the challenge, attributed to the user since the request has no session.
+	ctx := audit.WithActor(c.Request.Context(), audit.RequestActor(c))
+	var fresh bool
+	err = h.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		fresh, err = uow.TwoFactor.UseChallenge(ctx, challengeID, claims.UserID, claims.ExpiresAt.Time)
+		if err != nil || !fresh {
+			return err
+		}
+
+		valid, err := verifySecondFactor(ctx, uow.TwoFactor, tf, req.secondFactorRequest)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return errInvalidSecondFactor
+		}
+
+		return h.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: claims.UserID,
+			Action:      audit.ActionLogin,
+			TargetType:  audit.TargetUser,
+			TargetID:    claims.UserID.String(),
+			After:       gin.H{"two_factor": true, "recovery_code": req.Code == ""},
+		})
+	})
+	if errors.Is(err, errInvalidSecondFactor) {
+		respondError(c, http.StatusUnauthorized, "Invalid verification code")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to verify login challenge", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !fresh {
+		respondError(c, http.StatusUnauthorized, "Challenge token has already been used")
+		return
+	}
+	h.resetAttempts(c, claims.UserID)
+
+	token, ok := h.issueVerifiedToken(c, claims.UserID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// StepUp re-verifies an existing session so it can access sensitive routes
+// POST /auth/2fa/step-up
+func (h *TwoFactorHandler) StepUp(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req secondFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !h.checkSecondFactor(c, userID, req) {
+		return
+	}
+
+	token, ok := h.issueVerifiedToken(c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// Disable turns off 2FA after verifying a current code
+// POST /auth/2fa/disable
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req secondFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !h.checkSecondFactor(c, userID, req) {
+		return
+	}
+
+	err = h.txManager.WithinTx(c.Request.Context(), func(uow *repository.UnitOfWork) error {
+		if err := uow.TwoFactor.Disable(c.Request.Context(), userID); err != nil {
+			return err
+		}
+
+		return h.auditor.Record(c.Request.Context(), uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionTwoFactorDisable,
+			TargetType:  audit.TargetUser,
+			TargetID:    userID.String(),
+			Before:      gin.H{"enabled": true},
+			After:       gin.H{"enabled": false},
+		})
+	})
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to disable two-factor", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes with a new set
+// POST /auth/2fa/recovery-codes
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tf, err := h.twoFactorRepo.FindByUserID(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find two-factor enrollment", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if tf == nil || !tf.Enabled {
+		respondError(c, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	recoveryCodes := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	err = h.txManager.WithinTx(c.Request.Context(), func(uow *repository.UnitOfWork) error {
+		if err := uow.TwoFactor.ReplaceRecoveryCodes(c.Request.Context(), userID, recoveryCodes); err != nil {
+			return err
+		}
+
+		return h.auditor.Record(c.Request.Context(), uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionRecoveryCodesRegenerate,
+			TargetType:  audit.TargetUser,
+			TargetID:    userID.String(),
+		})
+	})
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to replace recovery codes", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+// checkSecondFactor verifies a TOTP or recovery code and writes an error response on failure.
+// Every attempt counts towards the user's limit; once it is reached, codes are refused until the
+// lockout ends, and a successful check clears the count.
+func (h *TwoFactorHandler) checkSecondFactor(c *gin.Context, userID uuid.UUID, req secondFactorRequest) bool {
+	tf, ok := h.reserveSecondFactor(c, userID, req)
+	if !ok {
+		return false
+	}
+
+	valid, err := verifySecondFactor(c.Request.Context(), h.twoFactorRepo, tf, req)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to verify second factor", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return false
+	}
+
+	if !valid {
+		respondError(c, http.StatusUnauthorized, "Invalid verification code")
+		return false
+	}
+
+	h.resetAttempts(c, userID)
+	return true
+}
+
+// reserveSecondFactor loads the user's enabled enrollment and counts an attempt against it,
+// writing an error response if the request can't be checked
+func (h *TwoFactorHandler) reserveSecondFactor(c *gin.Context, userID uuid.UUID, req secondFactorRequest) (*models.UserTwoFactor, bool) {
+	if req.Code == "" && req.RecoveryCode == "" {
+		respondError(c, http.StatusBadRequest, "code or recovery_code is required")
+		return nil, false
+	}
+
+	tf, err := h.twoFactorRepo.FindByUserID(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find two-factor enrollment", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+
+	if tf == nil || !tf.Enabled {
+		respondError(c, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return nil, false
+	}
+
+	if !h.reserveAttempt(c, tf) {
+		return nil, false
+	}
+
+	return tf, true
+}
+
+// reserveAttempt counts an attempt towards the user's limit before a code is checked, and writes
+// a 429 while verification is locked
+func (h *TwoFactorHandler) reserveAttempt(c *gin.Context, tf *models.UserTwoFactor) bool {
+	allowed, err := h.twoFactorRepo.ReserveAttempt(c.Request.Context(), tf.UserID, h.maxAttempts, h.lockout)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to record two-factor attempt", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return false
+	}
+
+	if !allowed {
+		retryAfter := h.lockout
+		if tf.LockedUntil != nil && time.Until(*tf.LockedUntil) > 0 {
+			retryAfter = time.Until(*tf.LockedUntil)
+		}
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		middleware.WriteProblem(c, http.StatusTooManyRequests, "two_factor_locked", "Too many failed verification attempts, try again later")
+		return false
+	}
+
+	return true
+}
+
+// resetAttempts clears the attempt count after a successful check
+func (h *TwoFactorHandler) resetAttempts(c *gin.Context, userID uuid.UUID) {
+	if err := h.twoFactorRepo.ResetAttempts(c.Request.Context(), userID); err != nil {
+		// The code was valid and is already spent, so don't fail the request over the counter
+		h.logger.ErrorContext(c.Request.Context(), "Failed to reset two-factor attempts", "error", err)
+	}
+}
+
+// verifySecondFactor checks a TOTP or recovery code and spends it through repo
+func verifySecondFactor(ctx context.Context, repo repository.TwoFactorRepository, tf *models.UserTwoFactor, req secondFactorRequest) (bool, error) {
+	if req.Code != "" {
+		step, ok := utils.ValidateTOTP(tf.Secret, req.Code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		// Each code can only be used once
+		return repo.MarkStepUsed(ctx, tf.UserID, step)
+	}
+
+	return repo.UseRecoveryCode(ctx, tf.UserID, utils.NormalizeRecoveryCode(req.RecoveryCode))
+}
+
+// issueVerifiedToken generates a session token marked as 2FA-verified now
+func (h *TwoFactorHandler) issueVerifiedToken(c *gin.Context, userID uuid.UUID) (string, bool) {
+	user, err := h.userRepo.FindByID(c.Request.Context(), userID)
+	if err != nil || user == nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to find user", "error", err)
+		respondError(c, http.StatusInternalServerError, "Database error")
+		return "", false
+	}
+
+	token, err := utils.GenerateTwoFactorJWT(user.ID, user.Email, user.Name, time.Now(), h.jwtSecret, h.jwtExpiration)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "Failed to generate JWT", "error", err)
+		respondError(c, http.StatusInternalServerError, "Failed to generate token")
+		return "", false
+	}
+
+	return token, true
+}
@@ -1,62 +1,56 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/franzego/stage08/internal/middleware"
-	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/service"
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
 )
 
 type WalletHandler struct {
-	walletRepo *repository.WalletRepository
-	txRepo     *repository.TransactionRepository
-	db         *sqlx.DB
+	walletService *service.WalletService
+	logger        *slog.Logger
 }
 
-func NewWalletHandler(walletRepo *repository.WalletRepository, txRepo *repository.TransactionRepository, db *sqlx.DB) *WalletHandler {
+func NewWalletHandler(walletService *service.WalletService, logger *slog.Logger) *WalletHandler {
 	return &WalletHandler{
-		walletRepo: walletRepo,
-		txRepo:     txRepo,
-		db:         db,
+		walletService: walletService,
+		logger:        logger,
 	}
 }
 
-// GetBalance returns the user's wallet balance
+// GetBalance returns the balance of the user's wallet named by ?wallet_number, or of their
+// default wallet
 // GET /wallet/balance
 func (h *WalletHandler) GetBalance(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	wallet, err := h.walletRepo.FindByUserID(userID)
+	wallet, err := h.walletService.GetWallet(c.Request.Context(), userID, c.Query("wallet_number"))
 	if err != nil {
-		log.Printf("Failed to find wallet: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	}
-
-	if wallet == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"balance":       wallet.Balance,
 		"wallet_number": wallet.WalletNumber,
+		"name":          wallet.Name,
 	})
 }
 
-// GetTransactions returns the user's transaction history
+// GetTransactions returns the transaction history of the user's wallet named by
+// ?wallet_number, or of all their wallets
 // GET /wallet/transactions
 func (h *WalletHandler) GetTransactions(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -64,10 +58,9 @@ func (h *WalletHandler) GetTransactions(c *gin.Context) {
 	limit := 50
 	offset := 0
 
-	transactions, err := h.txRepo.ListByUser(userID, limit, offset)
+	transactions, err := h.walletService.ListTransactions(c.Request.Context(), userID, c.Query("wallet_number"), limit, offset)
 	if err != nil {
-		log.Printf("Failed to list transactions: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Error(err)
 		return
 	}
 
@@ -88,71 +81,127 @@ func (h *WalletHandler) GetTransactions(c *gin.Context) {
 func (h *WalletHandler) Transfer(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
 	var req struct {
-		WalletNumber string `json:"wallet_number" binding:"required"`
-		Amount       int64  `json:"amount" binding:"required,min=100"`
+		WalletNumber     string `json:"wallet_number" binding:"required"` // The recipient's
+		Amount           int64  `json:"amount" binding:"required"`
+		FromWalletNumber string `json:"from_wallet_number"` // Optional; the default wallet if empty
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		respondError(c, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	// Debit, credit and both ledger entries commit together
+	result, err := h.walletService.Transfer(c.Request.Context(), userID, req.FromWalletNumber, req.WalletNumber, req.Amount)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"message":   "Transfer completed",
+		"reference": result.Reference,
+	})
+}
+
+// ListWallets returns the user's wallets, the default one first
+// GET /wallet/wallets
+func (h *WalletHandler) ListWallets(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	// Get sender wallet
-	senderWallet, err := h.walletRepo.FindByUserID(userID)
+	wallets, err := h.walletService.ListWallets(c.Request.Context(), userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"wallets": wallets})
+}
+
+// CreateWallet opens another named wallet for the user
+// POST /wallet/wallets
+func (h *WalletHandler) CreateWallet(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		log.Printf("Failed to find sender wallet: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	// Get recipient wallet
-	recipientWallet, err := h.walletRepo.FindByWalletNumber(req.WalletNumber)
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. name is required")
+		return
+	}
+
+	wallet, err := h.walletService.CreateWallet(c.Request.Context(), userID, req.Name)
 	if err != nil {
-		log.Printf("Failed to find recipient wallet: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Error(err)
 		return
 	}
 
-	if recipientWallet == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Recipient wallet not found"})
+	c.JSON(http.StatusCreated, wallet)
+}
+
+// SetDefaultWallet makes one of the user's wallets their default
+// POST /wallet/wallets/:wallet_number/default
+func (h *WalletHandler) SetDefaultWallet(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	// Cannot transfer to self
-	if senderWallet.ID == recipientWallet.ID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot transfer to yourself"})
+	wallet, err := h.walletService.SetDefaultWallet(c.Request.Context(), userID, c.Param("wallet_number"))
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Check balance
-	if senderWallet.Balance < req.Amount {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+	c.JSON(http.StatusOK, wallet)
+}
+
+// Move moves money between two of the user's own wallets
+// POST /wallet/move
+func (h *WalletHandler) Move(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	// Debit sender
-	if err := h.walletRepo.Debit(senderWallet.ID, req.Amount); err != nil {
-		log.Printf("Failed to debit sender: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+	var req struct {
+		FromWalletNumber string `json:"from_wallet_number" binding:"required"`
+		ToWalletNumber   string `json:"to_wallet_number" binding:"required"`
+		Amount           int64  `json:"amount" binding:"required"` // In kobo
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request. from_wallet_number, to_wallet_number and amount are required")
 		return
 	}
 
-	// Credit recipient
-	if err := h.walletRepo.Credit(recipientWallet.ID, req.Amount); err != nil {
-		log.Printf("Failed to credit recipient: %v", err)
-		// Rollback: credit back sender
-		h.walletRepo.Credit(senderWallet.ID, req.Amount)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transfer failed"})
+	result, err := h.walletService.Move(c.Request.Context(), userID, req.FromWalletNumber, req.ToWalletNumber, req.Amount)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":  "success",
-		"message": "Transfer completed",
+		"status":    "success",
+		"message":   "Move completed",
+		"reference": result.Reference,
 	})
 }
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/internal/middleware"
+	"github.com/franzego/stage08/internal/payment"
+	"github.com/franzego/stage08/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// WebhookHandler lets admins inspect and replay stored webhook events
+type WebhookHandler struct {
+	providers      *payment.Registry
+	webhookService *service.WebhookService
+	logger         *slog.Logger
+}
+
+func NewWebhookHandler(providers *payment.Registry, webhookService *service.WebhookService, logger *slog.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		providers:      providers,
+		webhookService: webhookService,
+		logger:         logger,
+	}
+}
+
+// ListEvents lists stored webhook events, newest first, optionally filtered by ?status=
+// GET /admin/webhooks
+func (h *WebhookHandler) ListEvents(c *gin.Context) {
+	limit, offset, ok := paginationParams(c)
+	if !ok {
+		return
+	}
+
+	events, total, err := h.webhookService.List(c.Request.Context(), c.Query("status"), limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"limit":  limit,
+		"offset": offset,
+		"total":  total,
+	})
+}
+
+// GetEvent returns a stored webhook event with its payload
+// GET /admin/webhooks/:id
+func (h *WebhookHandler) GetEvent(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid webhook event id")
+		return
+	}
+
+	event, err := h.webhookService.Get(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+// ReprocessEvent processes a stored webhook event again and returns the outcome
+// POST /admin/webhooks/:id/reprocess
+func (h *WebhookHandler) ReprocessEvent(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid webhook event id")
+		return
+	}
+
+	event, err := h.webhookService.Reprocess(c.Request.Context(), userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+// ReplayEvent processes a provider webhook submitted by an admin, e.g. one that was refused
+// for being outside the replay window. The body and signature header must be exactly as the
+// provider (?provider=, the default provider if omitted) sent them.
+// POST /admin/webhooks/replay
+func (h *WebhookHandler) ReplayEvent(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Failed to read request")
+		return
+	}
+
+	provider, ok := h.providers.Get(c.Query("provider"))
+	if !ok {
+		c.Error(service.ErrUnsupportedProvider)
+		return
+	}
+
+	// An admin can't inject events the provider never signed
+	if !provider.VerifyWebhook(c.Request.Header, body) {
+		respondError(c, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+
+	event, err := h.webhookService.Replay(c.Request.Context(), userID, provider.Name(), body)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/franzego/stage08/internal/database"
+	"github.com/franzego/stage08/internal/payment"
+	"github.com/jmoiron/sqlx"
+)
+
+// DatabaseCheck pings the connection pool and reports its usage
+func DatabaseCheck(db *sqlx.DB) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		if err := db.PingContext(ctx); err != nil {
+			return nil, fmt.Errorf("ping failed: %w", err)
+		}
+
+		stats := db.Stats()
+		return map[string]interface{}{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+		}, nil
+	}
+}
+
+// MigrationsCheck fails until the database schema is at the version this binary expects
+func MigrationsCheck(db *sqlx.DB) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		applied, err := database.AppliedMigrationVersion(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+
+		expected := database.LatestMigrationVersion()
+		details := map[string]interface{}{
+			"applied_version":  applied,
+			"expected_version": expected,
+		}
+		if applied < expected {
+			return details, fmt.Errorf("schema is at version %d, expected %d", applied, expected)
+		}
+
+		return details, nil
+	}
+}
+
+// ProviderCheck reports whether a payment provider's API can be reached
+func ProviderCheck(provider payment.Provider) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, provider.Ping(ctx)
+	}
+}
@@ -0,0 +1,193 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Component statuses
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Overall readiness statuses
+const (
+	StatusOK          = "ok"          // every check passed
+	StatusDegraded    = "degraded"    // only non-critical checks failed
+	StatusUnavailable = "unavailable" // a critical check failed
+)
+
+// CheckFunc probes a dependency. It may return details to include in the report.
+type CheckFunc func(ctx context.Context) (map[string]interface{}, error)
+
+// ComponentStatus is the result of a single check
+type ComponentStatus struct {
+	Status    string                 `json:"status"`
+	Critical  bool                   `json:"critical"`
+	LatencyMS int64                  `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the readiness document returned by /readyz
+type Report struct {
+	Status     string                     `json:"status"`
+	CheckedAt  time.Time                  `json:"checked_at"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// Checker runs readiness checks and tracks background worker heartbeats
+type Checker struct {
+	timeout time.Duration
+
+	mu      sync.RWMutex
+	checks  []check
+	workers []*Heartbeat
+}
+
+// NewChecker creates a checker that gives each check at most timeout to finish
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// AddCheck registers a dependency check.
+// A failing critical check makes the service unavailable; a failing non-critical one only degrades it.
+func (c *Checker) AddCheck(name string, critical bool, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check{name: name, critical: critical, fn: fn})
+}
+
+// RegisterWorker registers a background worker that must call Beat at least every maxSilence.
+// Workers are reported as "worker:<name>" and are always critical.
+func (c *Checker) RegisterWorker(name string, maxSilence time.Duration) *Heartbeat {
+	hb := &Heartbeat{name: name, maxSilence: maxSilence, started: time.Now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers = append(c.workers, hb)
+	return hb
+}
+
+// Run executes every check concurrently and builds the readiness report
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]check(nil), c.checks...)
+	workers := append([]*Heartbeat(nil), c.workers...)
+	c.mu.RUnlock()
+
+	report := Report{
+		Status:     StatusOK,
+		CheckedAt:  time.Now().UTC(),
+		Components: make(map[string]ComponentStatus, len(checks)+len(workers)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, chk := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := c.runCheck(ctx, chk)
+			mu.Lock()
+			report.Components[chk.name] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for _, hb := range workers {
+		report.Components["worker:"+hb.name] = hb.status()
+	}
+
+	for _, component := range report.Components {
+		if component.Status == StatusUp {
+			continue
+		}
+		if component.Critical {
+			report.Status = StatusUnavailable
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+
+	return report
+}
+
+func (c *Checker) runCheck(ctx context.Context, chk check) ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	details, err := chk.fn(ctx)
+
+	status := ComponentStatus{
+		Status:    StatusUp,
+		Critical:  chk.critical,
+		LatencyMS: time.Since(start).Milliseconds(),
+		Details:   details,
+	}
+	if err != nil {
+		status.Status = StatusDown
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// Heartbeat lets a background worker prove it is still making progress
+type Heartbeat struct {
+	name       string
+	maxSilence time.Duration
+	started    time.Time
+
+	mu       sync.Mutex
+	lastBeat time.Time
+	stopped  bool
+}
+
+// Beat records that the worker completed a loop iteration
+func (h *Heartbeat) Beat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastBeat = time.Now()
+}
+
+// Stop marks the worker as intentionally stopped (e.g. during shutdown)
+func (h *Heartbeat) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopped = true
+}
+
+func (h *Heartbeat) status() ComponentStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := ComponentStatus{Status: StatusUp, Critical: true, Details: map[string]interface{}{}}
+
+	// A worker that has not beaten yet is given maxSilence from registration to start
+	last := h.lastBeat
+	if last.IsZero() {
+		last = h.started
+	} else {
+		status.Details["last_beat"] = h.lastBeat.UTC()
+	}
+
+	switch {
+	case h.stopped:
+		status.Status = StatusDown
+		status.Error = "worker stopped"
+	case time.Since(last) > h.maxSilence:
+		status.Status = StatusDown
+		status.Error = "no heartbeat within " + h.maxSilence.String()
+	}
+	return status
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute names whose values are always dropped
+var sensitiveKeys = map[string]bool{
+	"api_key":         true,
+	"x-api-key":       true,
+	"token":           true,
+	"access_token":    true,
+	"challenge_token": true,
+	"authorization":   true,
+	"secret":          true,
+	"secret_key":      true,
+	"password":        true,
+	"code":            true,
+	"recovery_code":   true,
+	"state":           true,
+	"signature":       true,
+}
+
+var (
+	// Paystack and wallet API keys: sk_live_..., sk_test_..., pk_live_..., pk_test_...
+	apiKeyPattern = regexp.MustCompile(`\b[sp]k_(?:live|test)_[A-Za-z0-9_\-=]+`)
+	// JWTs: three base64url segments, the first starting with eyJ ({")
+	jwtPattern = regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`)
+	// Bearer credentials in headers or error messages
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9_\-.=]+`)
+	emailPattern  = regexp.MustCompile(`\b([A-Za-z0-9._%+\-])[A-Za-z0-9._%+\-]*@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})\b`)
+)
+
+// Redact masks API keys, tokens and email addresses in s
+func Redact(s string) string {
+	s = apiKeyPattern.ReplaceAllString(s, redacted)
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	s = emailPattern.ReplaceAllString(s, "$1***@$2")
+	return s
+}
+
+// redactAttr is a slog ReplaceAttr hook applied to every attribute, including the message
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+
+	return a
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "wallet"
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	paystackRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "paystack_request_duration_seconds",
+		Help:      "Paystack API call latency by operation and outcome.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	}, []string{"operation", "outcome"})
+
+	paystackErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "paystack_errors_total",
+		Help:      "Failed Paystack API calls by operation.",
+	}, []string{"operation"})
+
+	flutterwaveRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "flutterwave_request_duration_seconds",
+		Help:      "Flutterwave API call latency by operation and outcome.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	}, []string{"operation", "outcome"})
+
+	flutterwaveErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flutterwave_errors_total",
+		Help:      "Failed Flutterwave API calls by operation.",
+	}, []string{"operation"})
+
+	webhookEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Payment webhook events received by event type and processing result.",
+	}, []string{"event", "result"})
+
+	depositsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deposits_total",
+		Help:      "Deposits by status (initialized, success, failed).",
+	}, []string{"status"})
+
+	transfersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfers_total",
+		Help:      "Wallet-to-wallet transfers by status (success, failed).",
+	}, []string{"status"})
+
+	scheduledTransferRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduled_transfer_runs_total",
+		Help:      "Scheduled transfer runs by result (success, failed, paused).",
+	}, []string{"result"})
+
+	transferBatchItemsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_batch_items_total",
+		Help:      "Transfer batch items paid or failed by the batch worker, by result (success, failed).",
+	}, []string{"result"})
+
+	schedulerLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduler_leader",
+		Help:      "1 if this instance holds the scheduler lock and runs scheduled transfers.",
+	})
+
+	volumeKoboTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "volume_kobo_total",
+		Help:      "Money moved by successful operations, in kobo.",
+	}, []string{"type"})
+)
+
+// Webhook processing results
+const (
+	WebhookResultProcessed        = "processed"
+	WebhookResultIgnored          = "ignored"
+	WebhookResultDuplicate        = "duplicate"
+	WebhookResultStale            = "stale"
+	WebhookResultInvalidSignature = "invalid_signature"
+	WebhookResultInvalidPayload   = "invalid_payload"
+	WebhookResultError            = "error"
+)
+
+// Handler serves the Prometheus scrape endpoint
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records request counts and latency per route.
+// The matched route template is used as the label so path parameters don't explode cardinality.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		httpRequestsTotal.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// RegisterDBStats exposes connection pool statistics (open, in use, idle, wait count...)
+func RegisterDBStats(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// ObservePaystackRequest records the latency and outcome of a Paystack API call
+func ObservePaystackRequest(operation string, duration time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		paystackErrorsTotal.WithLabelValues(operation).Inc()
+	}
+	paystackRequestDuration.WithLabelValues(operation, outcome).Observe(duration.Seconds())
+}
+
+// ObserveFlutterwaveRequest records the latency and outcome of a Flutterwave API call
+func ObserveFlutterwaveRequest(operation string, duration time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		flutterwaveErrorsTotal.WithLabelValues(operation).Inc()
+	}
+	flutterwaveRequestDuration.WithLabelValues(operation, outcome).Observe(duration.Seconds())
+}
+
+// RecordWebhookEvent counts a webhook event and how it was handled
+func RecordWebhookEvent(event, result string) {
+	if event == "" {
+		event = "unknown"
+	}
+	webhookEventsTotal.WithLabelValues(event, result).Inc()
+}
+
+// RecordDeposit counts a deposit state change; successful deposits add to the volume
+func RecordDeposit(status string, amount int64) {
+	depositsTotal.WithLabelValues(status).Inc()
+	if status == "success" {
+		volumeKoboTotal.WithLabelValues("deposit").Add(float64(amount))
+	}
+}
+
+// RecordTransfer counts a transfer attempt; successful transfers add to the volume
+func RecordTransfer(status string, amount int64) {
+	transfersTotal.WithLabelValues(status).Inc()
+	if status == "success" {
+		volumeKoboTotal.WithLabelValues("transfer").Add(float64(amount))
+	}
+}
+
+// RecordScheduledTransferRun counts a scheduled transfer run by its result
+func RecordScheduledTransferRun(result string) {
+	scheduledTransferRunsTotal.WithLabelValues(result).Inc()
+}
+
+// RecordTransferBatchItem counts a transfer batch item by its outcome
+func RecordTransferBatchItem(result string) {
+	transferBatchItemsTotal.WithLabelValues(result).Inc()
+}
+
+// SetSchedulerLeader records whether this instance is the scheduler leader
+func SetSchedulerLeader(leader bool) {
+	if leader {
+		schedulerLeader.Set(1)
+	} else {
+		schedulerLeader.Set(0)
+	}
+}
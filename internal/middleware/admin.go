@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin allows only JWT-authenticated users whose email is in emails.
+// Must run after JWTAuth. An empty list locks the admin endpoints entirely.
+func RequireAdmin(emails []string) gin.HandlerFunc {
+	admins := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		admins[strings.ToLower(email)] = true
+	}
+
+	return func(c *gin.Context) {
+		email, _ := c.Get("user_email")
+		if address, ok := email.(string); !ok || !admins[strings.ToLower(address)] {
+			abortWithProblem(c, http.StatusForbidden, "admin_required", "Admin access required")
+			return
+		}
+
+		c.Next()
+	}
+}
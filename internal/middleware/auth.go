@@ -1,27 +1,35 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/models"
 	"github.com/franzego/stage08/internal/repository"
 	"github.com/franzego/stage08/internal/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
+// OrganizationHeader selects an organization for a JWT-authenticated request. The request
+// then acts on the organization's wallets with the permissions of the caller's role in it.
+const OrganizationHeader = "X-Organization-ID"
+
 // AuthMiddleware handles both JWT and API key authentication
-func AuthMiddleware(jwtSecret string, apiKeyRepo *repository.APIKeyRepository) gin.HandlerFunc {
+func AuthMiddleware(jwtSecret string, apiKeyRepo repository.APIKeyRepository, orgRepo repository.OrganizationRepository, logger *slog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check for API key first (x-api-key header)
 		apiKey := c.GetHeader("x-api-key")
 		if apiKey != "" {
-			if err := validateAPIKey(c, apiKey, apiKeyRepo); err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-				c.Abort()
+			if err := validateAPIKey(c, apiKey, apiKeyRepo, logger); err != nil {
+				abortWithProblem(c, http.StatusUnauthorized, "invalid_api_key", err.Error())
 				return
 			}
+			setAuditActor(c)
 			c.Next()
 			return
 		}
@@ -29,16 +37,14 @@ func AuthMiddleware(jwtSecret string, apiKeyRepo *repository.APIKeyRepository) g
 		// Fall back to JWT authentication
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header or x-api-key required"})
-			c.Abort()
+			abortWithProblem(c, http.StatusUnauthorized, "authentication_required", "Authorization header or x-api-key required")
 			return
 		}
 
 		// Extract token from "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
+			abortWithProblem(c, http.StatusUnauthorized, "invalid_authorization_header", "Invalid authorization header format")
 			return
 		}
 
@@ -47,8 +53,7 @@ func AuthMiddleware(jwtSecret string, apiKeyRepo *repository.APIKeyRepository) g
 		// Validate JWT
 		claims, err := utils.ValidateJWT(token, jwtSecret)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+			abortWithProblem(c, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
 			return
 		}
 
@@ -57,19 +62,29 @@ func AuthMiddleware(jwtSecret string, apiKeyRepo *repository.APIKeyRepository) g
 		c.Set("user_email", claims.Email)
 		c.Set("user_name", claims.Name)
 		c.Set("auth_type", "jwt")
+		if claims.TwoFactorAt != nil {
+			c.Set("two_factor_at", claims.TwoFactorAt.Time)
+		}
 		c.Set("permissions", []string{"deposit", "transfer", "read"}) // JWT has all permissions
 
+		if orgHeader := c.GetHeader(OrganizationHeader); orgHeader != "" {
+			if !actAsOrganization(c, orgHeader, claims.UserID, orgRepo, logger) {
+				return
+			}
+		}
+
+		setAuditActor(c)
 		c.Next()
 	}
 }
 
 // validateAPIKey validates an API key and sets user context
-func validateAPIKey(c *gin.Context, rawKey string, apiKeyRepo *repository.APIKeyRepository) error {
+func validateAPIKey(c *gin.Context, rawKey string, apiKeyRepo repository.APIKeyRepository, logger *slog.Logger) error {
 	// Find the API key
-	apiKey, err := apiKeyRepo.FindByKey(rawKey)
+	apiKey, err := apiKeyRepo.FindByKey(c.Request.Context(), rawKey)
 	if err != nil {
-		log.Printf("Failed to find API key: %v", err)
-		return err
+		logger.ErrorContext(c.Request.Context(), "Failed to find API key", "error", err)
+		return fmt.Errorf("failed to validate API key")
 	}
 
 	if apiKey == nil {
@@ -86,32 +101,95 @@ func validateAPIKey(c *gin.Context, rawKey string, apiKeyRepo *repository.APIKey
 		return fmt.Errorf("API key has expired")
 	}
 
-	// Update last used timestamp (async)
-	go apiKeyRepo.UpdateLastUsed(apiKey.ID)
+	// Update last used timestamp (async, outliving the request but staying in its trace)
+	ctx := context.WithoutCancel(c.Request.Context())
+	go func() {
+		if err := apiKeyRepo.UpdateLastUsed(ctx, apiKey.ID); err != nil {
+			logger.WarnContext(ctx, "Failed to update API key last used time", "api_key_id", apiKey.ID, "error", err)
+		}
+	}()
 
 	// Store user info and permissions in context
 	c.Set("user_id", apiKey.UserID)
 	c.Set("auth_type", "apikey")
-	c.Set("permissions", apiKey.Permissions)
+	c.Set("permissions", []string(apiKey.Permissions))
 	c.Set("api_key_id", apiKey.ID)
+	if apiKey.OrganizationID != nil {
+		c.Set("organization_id", *apiKey.OrganizationID)
+	}
 
 	return nil
 }
 
+// actAsOrganization switches the request to the organization's account user, with the wallet
+// permissions of the caller's role. The caller stays recorded as the member acting. It aborts
+// the request and returns false if the caller isn't a member.
+func actAsOrganization(c *gin.Context, rawID string, userID uuid.UUID, orgRepo repository.OrganizationRepository, logger *slog.Logger) bool {
+	orgID, err := uuid.Parse(rawID)
+	if err != nil {
+		abortWithProblem(c, http.StatusBadRequest, "invalid_organization_id", "Invalid "+OrganizationHeader+" header")
+		return false
+	}
+
+	ctx := c.Request.Context()
+	org, err := orgRepo.FindByID(ctx, orgID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to find organization", "organization_id", orgID, "error", err)
+		abortWithProblem(c, http.StatusInternalServerError, "internal_error", "Failed to check organization membership")
+		return false
+	}
+
+	var member *models.OrganizationMember
+	if org != nil {
+		member, err = orgRepo.FindMember(ctx, orgID, userID)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to find organization member", "organization_id", orgID, "error", err)
+			abortWithProblem(c, http.StatusInternalServerError, "internal_error", "Failed to check organization membership")
+			return false
+		}
+	}
+	if member == nil {
+		abortWithProblem(c, http.StatusForbidden, "not_organization_member", "You are not a member of this organization")
+		return false
+	}
+
+	c.Set("user_id", org.AccountUserID)
+	c.Set("member_user_id", userID)
+	c.Set("organization_id", org.ID)
+	c.Set("organization_role", string(member.Role))
+	c.Set("permissions", member.Role.Permissions())
+	return true
+}
+
+// setAuditActor stores the authenticated caller in the request context, so the audit events
+// written while handling the request are attributed to them
+func setAuditActor(c *gin.Context) {
+	c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), audit.RequestActor(c)))
+}
+
+// GetActorID retrieves the user acting on the request: the member for a request made for an
+// organization, otherwise the authenticated user
+func GetActorID(c *gin.Context) (uuid.UUID, error) {
+	if memberID, ok := c.Get("member_user_id"); ok {
+		if id, ok := memberID.(uuid.UUID); ok {
+			return id, nil
+		}
+	}
+	return GetUserID(c)
+}
+
 // RequirePermission middleware checks if the user has a specific permission
 func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		permissions, exists := c.Get("permissions")
 		if !exists {
-			c.JSON(http.StatusForbidden, gin.H{"error": "No permissions found"})
-			c.Abort()
+			abortWithProblem(c, http.StatusForbidden, "permission_denied", "No permissions found")
 			return
 		}
 
 		perms, ok := permissions.([]string)
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid permissions format"})
-			c.Abort()
+			abortWithProblem(c, http.StatusInternalServerError, "internal_error", "Invalid permissions format")
 			return
 		}
 
@@ -125,10 +203,7 @@ func RequirePermission(permission string) gin.HandlerFunc {
 		}
 
 		if !hasPermission {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": fmt.Sprintf("Permission '%s' required", permission),
-			})
-			c.Abort()
+			abortWithProblem(c, http.StatusForbidden, "permission_denied", fmt.Sprintf("Permission '%s' required", permission))
 			return
 		}
 
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/franzego/stage08/internal/service"
+	"github.com/franzego/stage08/internal/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the media type of every error response (RFC 9457)
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details document. Code is a stable, machine-readable
+// identifier clients should branch on; Detail is meant for humans and may change.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// statusByKind maps domain error kinds to HTTP status codes
+var statusByKind = map[service.Kind]int{
+	service.KindInvalid:     http.StatusBadRequest,
+	service.KindNotFound:    http.StatusNotFound,
+	service.KindForbidden:   http.StatusForbidden,
+	service.KindConflict:    http.StatusConflict,
+	service.KindUnavailable: http.StatusBadGateway,
+}
+
+// ErrorHandler turns the last error a handler attached with c.Error into a problem response.
+// Domain errors keep their code; anything else is logged and reported as a generic 500 so
+// internal details never reach the client.
+func ErrorHandler(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+
+		var domainErr *service.Error
+		switch {
+		case errors.As(err, &domainErr) && domainErr.Kind != service.KindInternal:
+			WriteProblem(c, statusByKind[domainErr.Kind], domainErr.Code, domainErr.Message)
+		case errors.Is(err, context.DeadlineExceeded):
+			logger.WarnContext(c.Request.Context(), "Request timed out", "error", err)
+			WriteProblem(c, http.StatusGatewayTimeout, "request_timeout", "The request took too long to complete")
+		default:
+			logger.ErrorContext(c.Request.Context(), "Request failed", "error", err)
+			WriteProblem(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		}
+	}
+}
+
+// WriteProblem writes a problem response carrying the request and trace IDs for support lookups
+func WriteProblem(c *gin.Context, status int, code, detail string) {
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		Code:      code,
+		RequestID: GetRequestID(c),
+		TraceID:   tracing.TraceID(c.Request.Context()),
+	}
+
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(status, problem)
+}
+
+// StatusCode derives a problem code from an HTTP status, e.g. 404 -> "not_found"
+func StatusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// abortWithProblem writes a problem response and stops the chain
+func abortWithProblem(c *gin.Context, status int, code, detail string) {
+	c.Abort()
+	WriteProblem(c, status, code, detail)
+}
@@ -15,16 +15,14 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			abortWithProblem(c, http.StatusUnauthorized, "authentication_required", "Authorization header required")
 			return
 		}
 
 		// Extract token from "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
+			abortWithProblem(c, http.StatusUnauthorized, "invalid_authorization_header", "Invalid authorization header format")
 			return
 		}
 
@@ -33,8 +31,7 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 		// Validate token
 		claims, err := utils.ValidateJWT(token, jwtSecret)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+			abortWithProblem(c, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
 			return
 		}
 
@@ -43,7 +40,11 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 		c.Set("user_email", claims.Email)
 		c.Set("user_name", claims.Name)
 		c.Set("auth_type", "jwt")
+		if claims.TwoFactorAt != nil {
+			c.Set("two_factor_at", claims.TwoFactorAt.Time)
+		}
 
+		setAuditActor(c)
 		c.Next()
 	}
 }
@@ -63,8 +64,10 @@ func GetUserID(c *gin.Context) (uuid.UUID, error) {
 	return uid, nil
 }
 
-// GetUserEmail retrieves the user email from context
+// GetUserEmail retrieves the user email from context. Only JWT sessions carry one; it is
+// empty for API keys.
 func GetUserEmail(c *gin.Context) string {
 	email, _ := c.Get("user_email")
-	return email.(string)
+	value, _ := email.(string)
+	return value
 }
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AllowSourceIPs rejects requests whose client IP is outside allowed. The client IP is
+// taken from X-Forwarded-For only when the connection comes from a trusted proxy
+// (see gin.Engine.SetTrustedProxies). An empty list allows every address.
+func AllowSourceIPs(allowed []netip.Prefix) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(allowed) == 0 {
+			c.Next()
+			return
+		}
+
+		addr, err := netip.ParseAddr(c.ClientIP())
+		if err == nil {
+			addr = addr.Unmap()
+			for _, prefix := range allowed {
+				if prefix.Contains(addr) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		abortWithProblem(c, http.StatusForbidden, "ip_not_allowed", "Source address not allowed")
+	}
+}
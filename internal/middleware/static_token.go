@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StaticBearerToken protects operational endpoints (e.g. /metrics) with a shared token.
+// An empty token disables the check.
+func StaticBearerToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		expected := []byte("Bearer " + token)
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			abortWithProblem(c, http.StatusUnauthorized, "invalid_token", "Invalid or missing token")
+			return
+		}
+
+		c.Next()
+	}
+}
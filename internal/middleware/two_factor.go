@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRecentTwoFactor protects sensitive routes by requiring that a JWT session passed
+// a 2FA check within maxAge. Without a recent check the user's enrollment decides: enrolled
+// users must step up, since their token may predate enrollment, and users who have not
+// enrolled are only blocked when required is true. A member acting for an organization is
+// checked as themselves. API keys are not checked; they have no session to step up, and are
+// limited by their own permissions instead.
+func RequireRecentTwoFactor(maxAge time.Duration, required bool, twoFactorRepo repository.TwoFactorRepository, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_type") != "jwt" {
+			c.Next()
+			return
+		}
+
+		if verifiedAt, exists := c.Get("two_factor_at"); exists {
+			if at, ok := verifiedAt.(time.Time); ok && time.Since(at) <= maxAge {
+				c.Next()
+				return
+			}
+		}
+
+		userID, err := GetActorID(c)
+		if err != nil {
+			abortWithProblem(c, http.StatusUnauthorized, "authentication_required", "Unauthorized")
+			return
+		}
+
+		tf, err := twoFactorRepo.FindByUserID(c.Request.Context(), userID)
+		if err != nil {
+			logger.ErrorContext(c.Request.Context(), "Failed to find two-factor enrollment", "error", err)
+			abortWithProblem(c, http.StatusInternalServerError, "internal_error", "Failed to check two-factor enrollment")
+			return
+		}
+
+		if tf == nil || !tf.Enabled {
+			if required {
+				abortWithProblem(c, http.StatusForbidden, "two_factor_enrollment_required", "Two-factor authentication must be enabled for this action")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		abortWithProblem(c, http.StatusForbidden, "two_factor_step_up_required", "Recent two-factor verification required")
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,12 +21,16 @@ type User struct {
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
 }
 
-// Wallet represents a user's wallet
+// Wallet represents one of a user's wallets. Every user has a default wallet and may open
+// more, each with its own name and wallet number.
 type Wallet struct {
 	ID           uuid.UUID `db:"id" json:"id"`
 	UserID       uuid.UUID `db:"user_id" json:"user_id"`
 	WalletNumber string    `db:"wallet_number" json:"wallet_number"`
-	Balance      int64     `db:"balance" json:"balance"` // in kobo
+	Name         string    `db:"name" json:"name"`
+	Balance      int64     `db:"balance" json:"balance"`       // in kobo
+	IsDefault    bool      `db:"is_default" json:"is_default"` // Used when a request doesn't name a wallet
+	IsSystem     bool      `db:"is_system" json:"-"`           // Internal account, e.g. escrow; not addressable by wallet number
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
 }
@@ -56,24 +63,48 @@ type Transaction struct {
 	Status      TransactionStatus `db:"status" json:"status"`
 	Reference   *string           `db:"reference" json:"reference,omitempty"`
 	Description *string           `db:"description" json:"description,omitempty"`
-	Metadata    []byte            `db:"metadata" json:"metadata,omitempty"` // JSONB
+	Provider    *string           `db:"provider" json:"provider,omitempty"`       // Payment provider, for deposits
+	Metadata    JSON              `db:"metadata" json:"metadata,omitempty"`       // JSONB
+	ReversalOf  *uuid.UUID        `db:"reversal_of" json:"reversal_of,omitempty"` // Entry this one compensates, for dispute reversals
 	CreatedAt   time.Time         `db:"created_at" json:"created_at"`
 	UpdatedAt   time.Time         `db:"updated_at" json:"updated_at"`
 }
 
+// PaymentMethod is a card saved from a successful deposit, which can be charged again
+// without a checkout
+type PaymentMethod struct {
+	ID                uuid.UUID  `db:"id" json:"id"`
+	UserID            uuid.UUID  `db:"user_id" json:"user_id"`
+	Provider          string     `db:"provider" json:"provider"`
+	AuthorizationCode string     `db:"authorization_code" json:"-"` // Never expose; it charges the card
+	Signature         string     `db:"signature" json:"-"`
+	Email             string     `db:"email" json:"-"`
+	Last4             string     `db:"last4" json:"last4"`
+	Brand             string     `db:"brand" json:"brand"`
+	CardType          *string    `db:"card_type" json:"card_type,omitempty"`
+	Bank              *string    `db:"bank" json:"bank,omitempty"`
+	ExpMonth          string     `db:"exp_month" json:"exp_month"`
+	ExpYear           string     `db:"exp_year" json:"exp_year"`
+	Reusable          bool       `db:"reusable" json:"reusable"`
+	LastUsedAt        *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt         time.Time  `db:"updated_at" json:"updated_at"`
+}
+
 // APIKey represents an API key for service-to-service access
 type APIKey struct {
-	ID          uuid.UUID      `db:"id" json:"id"`
-	UserID      uuid.UUID      `db:"user_id" json:"user_id"`
-	Name        string         `db:"name" json:"name"`
-	KeyHash     string         `db:"key_hash" json:"-"` // Never expose hash
-	KeyPrefix   string         `db:"key_prefix" json:"key_prefix"`
-	Permissions pq.StringArray `db:"permissions" json:"permissions"`
-	IsActive    bool           `db:"is_active" json:"is_active"`
-	ExpiresAt   time.Time      `db:"expires_at" json:"expires_at"`
-	LastUsedAt  *time.Time     `db:"last_used_at" json:"last_used_at,omitempty"`
-	CreatedAt   time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt   time.Time      `db:"updated_at" json:"updated_at"`
+	ID             uuid.UUID      `db:"id" json:"id"`
+	UserID         uuid.UUID      `db:"user_id" json:"user_id"`
+	OrganizationID *uuid.UUID     `db:"organization_id" json:"organization_id,omitempty"` // Set for keys issued by an organization
+	Name           string         `db:"name" json:"name"`
+	KeyHash        string         `db:"key_hash" json:"-"` // Never expose hash
+	KeyPrefix      string         `db:"key_prefix" json:"key_prefix"`
+	Permissions    pq.StringArray `db:"permissions" json:"permissions"`
+	IsActive       bool           `db:"is_active" json:"is_active"`
+	ExpiresAt      time.Time      `db:"expires_at" json:"expires_at"`
+	LastUsedAt     *time.Time     `db:"last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt      time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time      `db:"updated_at" json:"updated_at"`
 }
 
 // IsExpired checks if the API key has expired
@@ -90,3 +121,473 @@ func (a *APIKey) HasPermission(permission string) bool {
 	}
 	return false
 }
+
+// UserTwoFactor holds a user's TOTP enrollment
+type UserTwoFactor struct {
+	UserID       uuid.UUID  `db:"user_id" json:"user_id"`
+	Secret       string     `db:"secret" json:"-"` // Never expose secret after enrollment
+	Enabled      bool       `db:"enabled" json:"enabled"`
+	LastUsedStep int64      `db:"last_used_step" json:"-"`
+	ConfirmedAt  *time.Time `db:"confirmed_at" json:"confirmed_at,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
+
+	FailedAttempts int        `db:"failed_attempts" json:"-"` // Since the last successful check
+	LockedUntil    *time.Time `db:"locked_until" json:"-"`
+}
+
+// AuditEvent is an append-only, hash-chained record of a security or money event
+type AuditEvent struct {
+	ID            int64      `db:"id" json:"id"`
+	OwnerUserID   *uuid.UUID `db:"owner_user_id" json:"owner_user_id,omitempty"`
+	ActorUserID   *uuid.UUID `db:"actor_user_id" json:"actor_user_id,omitempty"`
+	ActorAPIKeyID *uuid.UUID `db:"actor_api_key_id" json:"actor_api_key_id,omitempty"`
+	IPAddress     *string    `db:"ip_address" json:"ip_address,omitempty"`
+	UserAgent     *string    `db:"user_agent" json:"user_agent,omitempty"`
+	Action        string     `db:"action" json:"action"`
+	TargetType    *string    `db:"target_type" json:"target_type,omitempty"`
+	TargetID      *string    `db:"target_id" json:"target_id,omitempty"`
+	Before        JSON       `db:"before" json:"before,omitempty"`
+	After         JSON       `db:"after" json:"after,omitempty"`
+	PrevHash      string     `db:"prev_hash" json:"prev_hash"`
+	Hash          string     `db:"hash" json:"hash"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ComputeHash returns the chain hash of the event: SHA256 over PrevHash and every recorded field
+func (e *AuditEvent) ComputeHash() string {
+	canonical, _ := json.Marshal(struct {
+		PrevHash      string     `json:"prev_hash"`
+		OwnerUserID   *uuid.UUID `json:"owner_user_id"`
+		ActorUserID   *uuid.UUID `json:"actor_user_id"`
+		ActorAPIKeyID *uuid.UUID `json:"actor_api_key_id"`
+		IPAddress     *string    `json:"ip_address"`
+		UserAgent     *string    `json:"user_agent"`
+		Action        string     `json:"action"`
+		TargetType    *string    `json:"target_type"`
+		TargetID      *string    `json:"target_id"`
+		Before        string     `json:"before"`
+		After         string     `json:"after"`
+		CreatedAt     string     `json:"created_at"`
+	}{
+		PrevHash:      e.PrevHash,
+		OwnerUserID:   e.OwnerUserID,
+		ActorUserID:   e.ActorUserID,
+		ActorAPIKeyID: e.ActorAPIKeyID,
+		IPAddress:     e.IPAddress,
+		UserAgent:     e.UserAgent,
+		Action:        e.Action,
+		TargetType:    e.TargetType,
+		TargetID:      e.TargetID,
+		Before:        string(e.Before),
+		After:         string(e.After),
+		CreatedAt:     e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// Webhook event statuses
+type WebhookEventStatus string
+
+const (
+	WebhookEventStatusPending   WebhookEventStatus = "pending"   // waiting for (another) processing attempt
+	WebhookEventStatusProcessed WebhookEventStatus = "processed" // handled successfully
+	WebhookEventStatusIgnored   WebhookEventStatus = "ignored"   // event type the service doesn't act on
+	WebhookEventStatusFailed    WebhookEventStatus = "failed"    // gave up; can be reprocessed by an admin
+)
+
+// WebhookEvent is a verified provider webhook, stored as received
+type WebhookEvent struct {
+	ID            uuid.UUID          `db:"id" json:"id"`
+	Provider      string             `db:"provider" json:"provider"`
+	EventID       string             `db:"event_id" json:"event_id"`
+	EventType     string             `db:"event_type" json:"event_type"`
+	Payload       JSON               `db:"payload" json:"payload"`
+	Status        WebhookEventStatus `db:"status" json:"status"`
+	Attempts      int                `db:"attempts" json:"attempts"`
+	LastError     *string            `db:"last_error" json:"last_error,omitempty"`
+	NextAttemptAt time.Time          `db:"next_attempt_at" json:"next_attempt_at"`
+	ProcessedAt   *time.Time         `db:"processed_at" json:"processed_at,omitempty"`
+	ReceivedAt    time.Time          `db:"received_at" json:"received_at"`
+	UpdatedAt     time.Time          `db:"updated_at" json:"updated_at"`
+}
+
+// Email statuses
+type EmailStatus string
+
+const (
+	EmailStatusPending EmailStatus = "pending" // waiting for (another) sending attempt
+	EmailStatusSent    EmailStatus = "sent"    // accepted by the mail server
+	EmailStatusFailed  EmailStatus = "failed"  // gave up after repeated failures
+)
+
+// Email is an outgoing email, queued with the change it announces and sent by a worker
+type Email struct {
+	ID            uuid.UUID   `db:"id" json:"id"`
+	Recipient     string      `db:"recipient" json:"recipient"`
+	Subject       string      `db:"subject" json:"subject"`
+	Body          string      `db:"body" json:"body"`
+	Status        EmailStatus `db:"status" json:"status"`
+	Attempts      int         `db:"attempts" json:"attempts"`
+	LastError     *string     `db:"last_error" json:"last_error,omitempty"`
+	NextAttemptAt time.Time   `db:"next_attempt_at" json:"next_attempt_at"`
+	SentAt        *time.Time  `db:"sent_at" json:"sent_at,omitempty"`
+	CreatedAt     time.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time   `db:"updated_at" json:"updated_at"`
+}
+
+// Scheduled transfer statuses
+type ScheduledTransferStatus string
+
+const (
+	ScheduledTransferStatusActive    ScheduledTransferStatus = "active"    // the scheduler runs it when due
+	ScheduledTransferStatusPaused    ScheduledTransferStatus = "paused"    // by the owner, or after repeated failures
+	ScheduledTransferStatusCompleted ScheduledTransferStatus = "completed" // no occurrences left
+	ScheduledTransferStatusCancelled ScheduledTransferStatus = "cancelled" // by the owner; final
+)
+
+// ScheduledTransfer is a standing order: a transfer repeated on a cron or interval schedule
+type ScheduledTransfer struct {
+	ID                    uuid.UUID               `db:"id" json:"id"`
+	UserID                uuid.UUID               `db:"user_id" json:"user_id"`
+	WalletID              uuid.UUID               `db:"wallet_id" json:"-"`
+	FromWalletNumber      string                  `db:"from_wallet_number" json:"from_wallet_number"` // Paid from
+	RecipientWalletNumber string                  `db:"recipient_wallet_number" json:"recipient_wallet_number"`
+	Amount                int64                   `db:"amount" json:"amount"` // in kobo
+	Description           *string                 `db:"description" json:"description,omitempty"`
+	Cron                  *string                 `db:"cron_expr" json:"cron,omitempty"`
+	Interval              *string                 `db:"interval_spec" json:"interval,omitempty"`
+	StartAt               time.Time               `db:"start_at" json:"start_at"`
+	EndAt                 *time.Time              `db:"end_at" json:"end_at,omitempty"`
+	MaxOccurrences        *int                    `db:"max_occurrences" json:"max_occurrences,omitempty"`
+	Occurrences           int                     `db:"occurrences" json:"occurrences"`
+	Status                ScheduledTransferStatus `db:"status" json:"status"`
+	PauseReason           *string                 `db:"pause_reason" json:"pause_reason,omitempty"`
+	OccurrenceAt          *time.Time              `db:"occurrence_at" json:"-"`
+	NextRunAt             *time.Time              `db:"next_run_at" json:"next_run_at,omitempty"`
+	FailureCount          int                     `db:"failure_count" json:"failure_count"`
+	LastRunAt             *time.Time              `db:"last_run_at" json:"last_run_at,omitempty"`
+	CreatedAt             time.Time               `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time               `db:"updated_at" json:"updated_at"`
+}
+
+// Scheduled transfer run statuses
+type ScheduledTransferRunStatus string
+
+const (
+	ScheduledTransferRunStatusSuccess ScheduledTransferRunStatus = "success"
+	ScheduledTransferRunStatusFailed  ScheduledTransferRunStatus = "failed"
+)
+
+// ScheduledTransferRun records one attempt at a scheduled transfer's occurrence
+type ScheduledTransferRun struct {
+	ID                  uuid.UUID                  `db:"id" json:"id"`
+	ScheduledTransferID uuid.UUID                  `db:"scheduled_transfer_id" json:"scheduled_transfer_id"`
+	OccurrenceAt        time.Time                  `db:"occurrence_at" json:"occurrence_at"`
+	Status              ScheduledTransferRunStatus `db:"status" json:"status"`
+	Reference           *string                    `db:"reference" json:"reference,omitempty"`
+	ErrorCode           *string                    `db:"error_code" json:"error_code,omitempty"`
+	ErrorMessage        *string                    `db:"error_message" json:"error_message,omitempty"`
+	CreatedAt           time.Time                  `db:"created_at" json:"created_at"`
+}
+
+// Payment request statuses
+type PaymentRequestStatus string
+
+const (
+	PaymentRequestStatusPending   PaymentRequestStatus = "pending"   // waiting for the payer
+	PaymentRequestStatusAccepted  PaymentRequestStatus = "accepted"  // paid by the payer
+	PaymentRequestStatusDeclined  PaymentRequestStatus = "declined"  // by the payer
+	PaymentRequestStatusCancelled PaymentRequestStatus = "cancelled" // by the requester
+	PaymentRequestStatusExpired   PaymentRequestStatus = "expired"   // pending past expires_at; never stored
+)
+
+// PaymentRequest asks a payer, addressed by wallet number or email, to pay into the
+// requester's wallet
+type PaymentRequest struct {
+	ID                    uuid.UUID            `db:"id" json:"id"`
+	RequesterUserID       uuid.UUID            `db:"requester_user_id" json:"requester_user_id"`
+	RequesterWalletNumber string               `db:"requester_wallet_number" json:"requester_wallet_number"`
+	PayerUserID           *uuid.UUID           `db:"payer_user_id" json:"payer_user_id,omitempty"`
+	PayerWalletNumber     *string              `db:"payer_wallet_number" json:"payer_wallet_number,omitempty"`
+	PayerEmail            *string              `db:"payer_email" json:"payer_email,omitempty"`
+	Amount                int64                `db:"amount" json:"amount"` // in kobo
+	Memo                  *string              `db:"memo" json:"memo,omitempty"`
+	Status                PaymentRequestStatus `db:"status" json:"status"`
+	ExpiresAt             time.Time            `db:"expires_at" json:"expires_at"`
+	TransferReference     *string              `db:"transfer_reference" json:"transfer_reference,omitempty"`
+	RespondedAt           *time.Time           `db:"responded_at" json:"responded_at,omitempty"`
+	CreatedAt             time.Time            `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time            `db:"updated_at" json:"updated_at"`
+}
+
+// Dispute statuses
+type DisputeStatus string
+
+const (
+	DisputeStatusOpen      DisputeStatus = "open"      // waiting for the recipient
+	DisputeStatusReversed  DisputeStatus = "reversed"  // the money went back to the sender
+	DisputeStatusRejected  DisputeStatus = "rejected"  // by the recipient; an admin can still force the reversal
+	DisputeStatusCancelled DisputeStatus = "cancelled" // by the sender
+)
+
+// Dispute resolutions, set when a dispute is reversed
+type DisputeResolution string
+
+const (
+	DisputeResolutionApproved DisputeResolution = "approved" // by the recipient
+	DisputeResolutionForced   DisputeResolution = "forced"   // by an admin
+)
+
+// Dispute is a sender's request to undo a transfer. Reversing it writes a compensating
+// transfer from the recipient back to the sender; the original entries are kept as they are.
+type Dispute struct {
+	ID                  uuid.UUID          `db:"id" json:"id"`
+	TransferReference   string             `db:"transfer_reference" json:"transfer_reference"`
+	DebitTransactionID  uuid.UUID          `db:"debit_transaction_id" json:"-"`
+	CreditTransactionID uuid.UUID          `db:"credit_transaction_id" json:"-"`
+	SenderUserID        uuid.UUID          `db:"sender_user_id" json:"sender_user_id"`
+	SenderWalletID      uuid.UUID          `db:"sender_wallet_id" json:"-"`
+	RecipientUserID     uuid.UUID          `db:"recipient_user_id" json:"recipient_user_id"`
+	RecipientWalletID   uuid.UUID          `db:"recipient_wallet_id" json:"-"`
+	Amount              int64              `db:"amount" json:"amount"` // in kobo
+	Reason              string             `db:"reason" json:"reason"`
+	Status              DisputeStatus      `db:"status" json:"status"`
+	Resolution          *DisputeResolution `db:"resolution" json:"resolution,omitempty"`
+	ResolutionNote      *string            `db:"resolution_note" json:"resolution_note,omitempty"`
+	ResolvedByUserID    *uuid.UUID         `db:"resolved_by_user_id" json:"resolved_by_user_id,omitempty"`
+	ReversalReference   *string            `db:"reversal_reference" json:"reversal_reference,omitempty"`
+	ResolvedAt          *time.Time         `db:"resolved_at" json:"resolved_at,omitempty"`
+	CreatedAt           time.Time          `db:"created_at" json:"created_at"`
+	UpdatedAt           time.Time          `db:"updated_at" json:"updated_at"`
+}
+
+// SystemEscrowUserID owns the system wallet that holds escrowed money. Migration 014 creates it.
+var SystemEscrowUserID = uuid.MustParse("00000000-0000-4000-8000-00000000e5c0")
+
+// Escrow statuses
+type EscrowStatus string
+
+const (
+	EscrowStatusFunded   EscrowStatus = "funded"   // held, waiting for release or refund
+	EscrowStatusReleased EscrowStatus = "released" // paid to the seller
+	EscrowStatusRefunded EscrowStatus = "refunded" // returned to the buyer
+	EscrowStatusSplit    EscrowStatus = "split"    // divided between seller and buyer by the arbiter
+)
+
+// Escrow holds a buyer's payment in the system escrow wallet until it is settled. The buyer
+// or arbiter releases it to the seller; the seller or arbiter refunds it to the buyer (as can
+// the buyer, after the deadline); the arbiter can split it.
+type Escrow struct {
+	ID                 uuid.UUID    `db:"id" json:"id"`
+	BuyerUserID        uuid.UUID    `db:"buyer_user_id" json:"buyer_user_id"`
+	BuyerWalletID      uuid.UUID    `db:"buyer_wallet_id" json:"-"`
+	BuyerWalletNumber  string       `db:"buyer_wallet_number" json:"buyer_wallet_number"`
+	SellerUserID       uuid.UUID    `db:"seller_user_id" json:"seller_user_id"`
+	SellerWalletID     uuid.UUID    `db:"seller_wallet_id" json:"-"`
+	SellerWalletNumber string       `db:"seller_wallet_number" json:"seller_wallet_number"`
+	ArbiterUserID      *uuid.UUID   `db:"arbiter_user_id" json:"arbiter_user_id,omitempty"`
+	Amount             int64        `db:"amount" json:"amount"` // in kobo
+	ReleasedAmount     int64        `db:"released_amount" json:"released_amount"`
+	RefundedAmount     int64        `db:"refunded_amount" json:"refunded_amount"`
+	Description        *string      `db:"description" json:"description,omitempty"`
+	Conditions         *string      `db:"conditions" json:"conditions,omitempty"`
+	Deadline           *time.Time   `db:"deadline" json:"deadline,omitempty"`
+	Status             EscrowStatus `db:"status" json:"status"`
+	FundingReference   string       `db:"funding_reference" json:"funding_reference"`
+	SettledAt          *time.Time   `db:"settled_at" json:"settled_at,omitempty"`
+	CreatedAt          time.Time    `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time    `db:"updated_at" json:"updated_at"`
+}
+
+// EscrowEvent is one entry in an escrow's history
+type EscrowEvent struct {
+	ID           uuid.UUID    `db:"id" json:"id"`
+	EscrowID     uuid.UUID    `db:"escrow_id" json:"escrow_id"`
+	Action       EscrowStatus `db:"action" json:"action"` // The status the escrow moved to
+	ActorUserID  *uuid.UUID   `db:"actor_user_id" json:"actor_user_id,omitempty"`
+	SellerAmount int64        `db:"seller_amount" json:"seller_amount"`
+	BuyerAmount  int64        `db:"buyer_amount" json:"buyer_amount"`
+	Reference    *string      `db:"reference" json:"reference,omitempty"`
+	Note         *string      `db:"note" json:"note,omitempty"`
+	CreatedAt    time.Time    `db:"created_at" json:"created_at"`
+}
+
+// PaymentLink is a public link that anyone can pay into the owner's wallet through
+type PaymentLink struct {
+	ID            uuid.UUID  `db:"id" json:"id"`
+	UserID        uuid.UUID  `db:"user_id" json:"user_id"`
+	Code          string     `db:"code" json:"code"`
+	URL           string     `db:"-" json:"url"`
+	Amount        *int64     `db:"amount" json:"amount,omitempty"` // Fixed amount in kobo; nil lets the payer choose
+	Description   *string    `db:"description" json:"description,omitempty"`
+	IsActive      bool       `db:"is_active" json:"is_active"`
+	ExpiresAt     *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	PaymentCount  int        `db:"payment_count" json:"payment_count"`
+	TotalReceived int64      `db:"total_received" json:"total_received"` // in kobo
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// Usable reports whether the link still accepts payments
+func (l *PaymentLink) Usable(now time.Time) bool {
+	return l.IsActive && (l.ExpiresAt == nil || now.Before(*l.ExpiresAt))
+}
+
+// Transfer batch modes
+type TransferBatchMode string
+
+const (
+	TransferBatchModeAllOrNothing TransferBatchMode = "all_or_nothing" // every line is paid, or none is
+	TransferBatchModeBestEffort   TransferBatchMode = "best_effort"    // valid lines are paid even if others fail
+)
+
+// Transfer batch statuses
+type TransferBatchStatus string
+
+const (
+	TransferBatchStatusPending    TransferBatchStatus = "pending"    // reserved, waiting for the worker
+	TransferBatchStatusProcessing TransferBatchStatus = "processing" // items are being paid
+	TransferBatchStatusCompleted  TransferBatchStatus = "completed"  // every item has an outcome
+	TransferBatchStatusFailed     TransferBatchStatus = "failed"     // all-or-nothing batch rolled back; nothing was paid
+)
+
+// TransferBatch is a bulk upload of transfers from one wallet. The total of its valid
+// lines is reserved on upload, and items that fail are refunded.
+type TransferBatch struct {
+	ID              uuid.UUID           `db:"id" json:"id"`
+	UserID          uuid.UUID           `db:"user_id" json:"user_id"`
+	WalletID        uuid.UUID           `db:"wallet_id" json:"wallet_id"`
+	Mode            TransferBatchMode   `db:"mode" json:"mode"`
+	Status          TransferBatchStatus `db:"status" json:"status"`
+	TotalItems      int                 `db:"total_items" json:"total_items"`
+	TotalAmount     int64               `db:"total_amount" json:"total_amount"` // Reserved, in kobo
+	SucceededCount  int                 `db:"succeeded_count" json:"succeeded_count"`
+	FailedCount     int                 `db:"failed_count" json:"failed_count"`
+	SucceededAmount int64               `db:"succeeded_amount" json:"succeeded_amount"`
+	RefundedAmount  int64               `db:"refunded_amount" json:"refunded_amount"`
+	ClaimedUntil    *time.Time          `db:"claimed_until" json:"-"`
+	CompletedAt     *time.Time          `db:"completed_at" json:"completed_at,omitempty"`
+	CreatedAt       time.Time           `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time           `db:"updated_at" json:"updated_at"`
+}
+
+// Processed returns how many items have an outcome
+func (b *TransferBatch) Processed() int {
+	return b.SucceededCount + b.FailedCount
+}
+
+// Transfer batch item statuses
+type TransferBatchItemStatus string
+
+const (
+	TransferBatchItemStatusPending TransferBatchItemStatus = "pending"
+	TransferBatchItemStatusSuccess TransferBatchItemStatus = "success"
+	TransferBatchItemStatusFailed  TransferBatchItemStatus = "failed"
+)
+
+// TransferBatchItem is one line of a transfer batch
+type TransferBatchItem struct {
+	ID                    uuid.UUID               `db:"id" json:"id"`
+	BatchID               uuid.UUID               `db:"batch_id" json:"batch_id"`
+	Line                  int                     `db:"line" json:"line"`
+	RecipientWalletNumber string                  `db:"recipient_wallet_number" json:"recipient_wallet_number"`
+	Amount                int64                   `db:"amount" json:"amount"` // in kobo
+	Description           *string                 `db:"description" json:"description,omitempty"`
+	Status                TransferBatchItemStatus `db:"status" json:"status"`
+	DebitTransactionID    *uuid.UUID              `db:"debit_transaction_id" json:"-"`
+	Reference             *string                 `db:"reference" json:"reference,omitempty"`
+	ErrorCode             *string                 `db:"error_code" json:"error_code,omitempty"`
+	ErrorMessage          *string                 `db:"error_message" json:"error_message,omitempty"`
+	ProcessedAt           *time.Time              `db:"processed_at" json:"processed_at,omitempty"`
+	CreatedAt             time.Time               `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time               `db:"updated_at" json:"updated_at"`
+}
+
+// Organization roles. Each maps to a set of wallet permissions.
+type OrganizationRole string
+
+const (
+	OrganizationRoleOwner   OrganizationRole = "owner"   // everything, including managing owners
+	OrganizationRoleAdmin   OrganizationRole = "admin"   // manages members, invitations and API keys
+	OrganizationRoleFinance OrganizationRole = "finance" // deposits and transfers
+	OrganizationRoleViewer  OrganizationRole = "viewer"  // read only
+)
+
+// Valid reports whether r is a known role
+func (r OrganizationRole) Valid() bool {
+	switch r {
+	case OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleFinance, OrganizationRoleViewer:
+		return true
+	}
+	return false
+}
+
+// Permissions returns the wallet permissions a member with this role has
+func (r OrganizationRole) Permissions() []string {
+	switch r {
+	case OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleFinance:
+		return []string{"deposit", "transfer", "read"}
+	case OrganizationRoleViewer:
+		return []string{"read"}
+	}
+	return nil
+}
+
+// CanManage reports whether the role may manage members, invitations and API keys
+func (r OrganizationRole) CanManage() bool {
+	return r == OrganizationRoleOwner || r == OrganizationRoleAdmin
+}
+
+// Organization shares wallets between its members. The wallets (and the organization's API
+// keys) belong to the organization's account user, which can never sign in.
+type Organization struct {
+	ID              uuid.UUID        `db:"id" json:"id"`
+	Name            string           `db:"name" json:"name"`
+	AccountUserID   uuid.UUID        `db:"account_user_id" json:"account_user_id"`
+	CreatedByUserID *uuid.UUID       `db:"created_by_user_id" json:"created_by_user_id,omitempty"`
+	WalletNumber    string           `db:"wallet_number" json:"wallet_number"` // The default wallet
+	Role            OrganizationRole `db:"role" json:"role,omitempty"`         // The caller's role, when listed for a member
+	CreatedAt       time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time        `db:"updated_at" json:"updated_at"`
+}
+
+// OrganizationMember is a user's membership of an organization
+type OrganizationMember struct {
+	ID             uuid.UUID        `db:"id" json:"id"`
+	OrganizationID uuid.UUID        `db:"organization_id" json:"organization_id"`
+	UserID         uuid.UUID        `db:"user_id" json:"user_id"`
+	Role           OrganizationRole `db:"role" json:"role"`
+	Email          string           `db:"email" json:"email,omitempty"` // Joined from users when listed
+	Name           string           `db:"name" json:"name,omitempty"`
+	CreatedAt      time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time        `db:"updated_at" json:"updated_at"`
+}
+
+// Organization invitation statuses
+type InvitationStatus string
+
+const (
+	InvitationStatusPending  InvitationStatus = "pending"
+	InvitationStatusAccepted InvitationStatus = "accepted"
+	InvitationStatusDeclined InvitationStatus = "declined"
+	InvitationStatusRevoked  InvitationStatus = "revoked"
+	InvitationStatusExpired  InvitationStatus = "expired" // replaced by a new invitation after it lapsed
+)
+
+// OrganizationInvitation invites whoever signs in with an email address to join an organization
+type OrganizationInvitation struct {
+	ID               uuid.UUID        `db:"id" json:"id"`
+	OrganizationID   uuid.UUID        `db:"organization_id" json:"organization_id"`
+	OrganizationName string           `db:"organization_name" json:"organization_name,omitempty"` // Joined when listed for the invitee
+	Email            string           `db:"email" json:"email"`
+	Role             OrganizationRole `db:"role" json:"role"`
+	InvitedByUserID  *uuid.UUID       `db:"invited_by_user_id" json:"invited_by_user_id,omitempty"`
+	Status           InvitationStatus `db:"status" json:"status"`
+	ExpiresAt        time.Time        `db:"expires_at" json:"expires_at"`
+	RespondedAt      *time.Time       `db:"responded_at" json:"responded_at,omitempty"`
+	CreatedAt        time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt        time.Time        `db:"updated_at" json:"updated_at"`
+}
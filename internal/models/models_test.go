@@ -0,0 +1,78 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func auditEventFixture() *AuditEvent {
+	owner := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	actor := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	ip := "203.0.113.7"
+	agent := "curl/8.0"
+	targetType := "transfer"
+	targetID := "33333333-3333-3333-3333-333333333333"
+
+	return &AuditEvent{
+		ID:          42,
+		OwnerUserID: &owner,
+		ActorUserID: &actor,
+		IPAddress:   &ip,
+		UserAgent:   &agent,
+		Action:      "transfer.create",
+		TargetType:  &targetType,
+		TargetID:    &targetID,
+		After:       JSON(`{"amount":5000}`),
+		PrevHash:    "0000000000000000000000000000000000000000000000000000000000000000",
+		CreatedAt:   time.Date(2026, time.March, 1, 12, 30, 0, 123456000, time.UTC),
+	}
+}
+
+func TestAuditEventComputeHash(t *testing.T) {
+	// Stored chains are verified against this canonical form, so it must not drift
+	const want = "0d2d28fb42bbd3688107129c3c68b8726088284d12aa01bdf2336c1162fe9b90"
+
+	event := auditEventFixture()
+	if got := event.ComputeHash(); got != want {
+		t.Fatalf("ComputeHash = %s, want %s", got, want)
+	}
+
+	// The stored hash and row ID aren't part of it, and neither is the time zone
+	event.ID = 43
+	event.Hash = "anything"
+	event.CreatedAt = event.CreatedAt.In(time.FixedZone("WAT", 3600))
+	if got := event.ComputeHash(); got != want {
+		t.Fatalf("ComputeHash after changing ID, Hash and zone = %s, want %s", got, want)
+	}
+}
+
+func TestAuditEventComputeHashCoversFields(t *testing.T) {
+	base := auditEventFixture().ComputeHash()
+	other := uuid.MustParse("44444444-4444-4444-4444-444444444444")
+	text := "changed"
+
+	changes := map[string]func(e *AuditEvent){
+		"prev_hash":        func(e *AuditEvent) { e.PrevHash = base },
+		"owner_user_id":    func(e *AuditEvent) { e.OwnerUserID = &other },
+		"actor_user_id":    func(e *AuditEvent) { e.ActorUserID = nil },
+		"actor_api_key_id": func(e *AuditEvent) { e.ActorAPIKeyID = &other },
+		"ip_address":       func(e *AuditEvent) { e.IPAddress = &text },
+		"user_agent":       func(e *AuditEvent) { e.UserAgent = nil },
+		"action":           func(e *AuditEvent) { e.Action = "transfer.reverse" },
+		"target_type":      func(e *AuditEvent) { e.TargetType = &text },
+		"target_id":        func(e *AuditEvent) { e.TargetID = &text },
+		"before":           func(e *AuditEvent) { e.Before = JSON(`{}`) },
+		"after":            func(e *AuditEvent) { e.After = JSON(`{"amount":5001}`) },
+		"created_at":       func(e *AuditEvent) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) },
+	}
+
+	for field, change := range changes {
+		event := auditEventFixture()
+		change(event)
+		if event.ComputeHash() == base {
+			t.Fatalf("changing %s left the hash unchanged", field)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/flutterwave"
+)
+
+// Flutterwave adapts the Flutterwave API to Provider
+type Flutterwave struct {
+	client *flutterwave.Client
+}
+
+func NewFlutterwave(client *flutterwave.Client) *Flutterwave {
+	return &Flutterwave{client: client}
+}
+
+func (f *Flutterwave) Name() string {
+	return config.ProviderFlutterwave
+}
+
+func (f *Flutterwave) Initialize(ctx context.Context, req InitializeRequest) (*Checkout, error) {
+	resp, err := f.client.InitializePayment(ctx, req.Email, req.Amount, req.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{Reference: req.Reference, AuthorizationURL: resp.Data.Link}, nil
+}
+
+func (f *Flutterwave) Verify(ctx context.Context, reference string) (*Charge, error) {
+	resp, err := f.client.VerifyTransaction(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	return flutterwaveCharge(&resp.Data), nil
+}
+
+// VerifyWebhook checks the verif-hash header against the configured secret hash
+func (f *Flutterwave) VerifyWebhook(header http.Header, body []byte) bool {
+	return f.client.VerifyWebhookSignature(header.Get(flutterwave.SignatureHeader))
+}
+
+// SignsWebhooks is false: verif-hash is a static secret, the same for every webhook, so it
+// proves nothing about the body
+func (f *Flutterwave) SignsWebhooks() bool {
+	return false
+}
+
+func (f *Flutterwave) ParseWebhook(body []byte) (*Event, error) {
+	var payload flutterwave.WebhookEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse flutterwave webhook: %w", err)
+	}
+	if payload.Event == "" {
+		return nil, fmt.Errorf("flutterwave webhook has no event type")
+	}
+
+	event := &Event{
+		Type:      payload.Event,
+		Timestamp: parseTimestamp(payload.Data.CreatedAt),
+	}
+	if payload.Data.ID != 0 {
+		event.ID = strconv.FormatInt(payload.Data.ID, 10)
+	}
+
+	// Successful and failed payments are both reported as charge.completed
+	if payload.Event == "charge.completed" {
+		event.Charge = flutterwaveCharge(&payload.Data)
+	}
+
+	return event, nil
+}
+
+// Refund looks the payment up to find Flutterwave's id for it, which refunds are made against
+func (f *Flutterwave) Refund(ctx context.Context, reference string, amount int64) (*Refund, error) {
+	verified, err := f.client.VerifyTransaction(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Refund(ctx, verified.Data.ID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return &Refund{
+		ID:     strconv.FormatInt(resp.Data.ID, 10),
+		Amount: flutterwave.Kobo(resp.Data.AmountRefunded),
+		Status: resp.Data.Status,
+	}, nil
+}
+
+func (f *Flutterwave) Ping(ctx context.Context) error {
+	return f.client.Ping(ctx)
+}
+
+func flutterwaveCharge(tx *flutterwave.Transaction) *Charge {
+	charge := &Charge{
+		Reference:       tx.TxRef,
+		Amount:          flutterwave.Kobo(tx.Amount),
+		Currency:        tx.Currency,
+		GatewayResponse: tx.ProcessorResponse,
+		CustomerEmail:   tx.Customer.Email,
+		Channel:         tx.PaymentType,
+		PaidAt:          tx.CreatedAt,
+		Fees:            flutterwave.Kobo(tx.AppFee),
+	}
+	if tx.ID != 0 {
+		charge.ID = strconv.FormatInt(tx.ID, 10)
+	}
+
+	switch tx.Status {
+	case "successful":
+		charge.Status = ChargeSuccess
+	case "failed", "cancelled":
+		charge.Status = ChargeFailed
+	default:
+		charge.Status = ChargePending
+	}
+	return charge
+}
@@ -0,0 +1,146 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/paystack"
+)
+
+// Paystack adapts the Paystack API (or its simulator) to Provider
+type Paystack struct {
+	api paystack.API
+}
+
+func NewPaystack(api paystack.API) *Paystack {
+	return &Paystack{api: api}
+}
+
+func (p *Paystack) Name() string {
+	return config.ProviderPaystack
+}
+
+func (p *Paystack) Initialize(ctx context.Context, req InitializeRequest) (*Checkout, error) {
+	resp, err := p.api.InitializeTransaction(ctx, req.Email, req.Amount, req.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{Reference: req.Reference, AuthorizationURL: resp.Data.AuthorizationURL}, nil
+}
+
+func (p *Paystack) Verify(ctx context.Context, reference string) (*Charge, error) {
+	resp, err := p.api.VerifyTransaction(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	return paystackCharge(&resp.Data), nil
+}
+
+// VerifyWebhook checks the x-paystack-signature header, an HMAC-SHA512 of the body
+func (p *Paystack) VerifyWebhook(header http.Header, body []byte) bool {
+	signature := header.Get("x-paystack-signature")
+	return signature != "" && p.api.VerifyWebhookSignature(signature, body)
+}
+
+// SignsWebhooks is true: x-paystack-signature is an HMAC of the body
+func (p *Paystack) SignsWebhooks() bool {
+	return true
+}
+
+func (p *Paystack) ParseWebhook(body []byte) (*Event, error) {
+	var payload paystack.WebhookEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse paystack webhook: %w", err)
+	}
+	if payload.Event == "" {
+		return nil, fmt.Errorf("paystack webhook has no event type")
+	}
+
+	event := &Event{
+		Type: payload.Event,
+		// Transfer and refund events use camelCase
+		Timestamp: parseTimestamp(payload.Data.PaidAt, payload.Data.CreatedAt, payload.Data.CreatedAtCamel),
+	}
+	// Paystack redelivers an event with the same data.id
+	if payload.Data.ID != 0 {
+		event.ID = strconv.FormatInt(payload.Data.ID, 10)
+	}
+
+	switch payload.Event {
+	case "charge.success", "charge.failed":
+		event.Charge = paystackCharge(&payload.Data)
+	}
+
+	return event, nil
+}
+
+// ChargeAuthorization charges a saved card with charge_authorization
+func (p *Paystack) ChargeAuthorization(ctx context.Context, req ChargeAuthorizationRequest) (*Charge, error) {
+	resp, err := p.api.ChargeAuthorization(ctx, req.Email, req.Amount, req.Reference, req.AuthorizationCode)
+	if err != nil {
+		return nil, err
+	}
+	return paystackCharge(&resp.Data), nil
+}
+
+func (p *Paystack) Refund(ctx context.Context, reference string, amount int64) (*Refund, error) {
+	resp, err := p.api.Refund(ctx, reference, amount)
+	if err != nil {
+		return nil, err
+	}
+	return &Refund{ID: strconv.FormatInt(resp.Data.ID, 10), Amount: resp.Data.Amount, Status: resp.Data.Status}, nil
+}
+
+func (p *Paystack) Ping(ctx context.Context) error {
+	return p.api.Ping(ctx)
+}
+
+func paystackCharge(tx *paystack.Transaction) *Charge {
+	charge := &Charge{
+		Reference:       tx.Reference,
+		Amount:          tx.Amount,
+		Currency:        tx.Currency,
+		Status:          paystackStatus(tx.Status),
+		GatewayResponse: tx.GatewayResponse,
+		CustomerEmail:   tx.Customer.Email,
+		Channel:         tx.Channel,
+		PaidAt:          tx.PaidAt,
+		Fees:            tx.Fees,
+	}
+	if tx.ID != 0 {
+		charge.ID = strconv.FormatInt(tx.ID, 10)
+	}
+
+	// Only cards can be saved; bank and USSD authorizations aren't offered back to users
+	if auth := tx.Authorization; auth.AuthorizationCode != "" && auth.Channel == "card" {
+		charge.Authorization = &Authorization{
+			Code:      auth.AuthorizationCode,
+			Signature: auth.Signature,
+			Last4:     auth.Last4,
+			Brand:     auth.Brand,
+			CardType:  strings.TrimSpace(auth.CardType),
+			Bank:      auth.Bank,
+			ExpMonth:  auth.ExpMonth,
+			ExpYear:   auth.ExpYear,
+			Reusable:  auth.Reusable,
+		}
+	}
+	return charge
+}
+
+// paystackStatus normalizes a Paystack transaction status
+func paystackStatus(status string) string {
+	switch status {
+	case "success":
+		return ChargeSuccess
+	case "failed", "reversed":
+		return ChargeFailed
+	default: // ongoing, pending, or abandoned (the customer may still pay)
+		return ChargePending
+	}
+}
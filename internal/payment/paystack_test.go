@@ -0,0 +1,55 @@
+package payment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/franzego/stage08/internal/paystack"
+)
+
+// verifyStub answers VerifyTransaction with a fixed transaction
+type verifyStub struct {
+	paystack.API
+	tx paystack.Transaction
+}
+
+func (s verifyStub) VerifyTransaction(ctx context.Context, reference string) (*paystack.VerifyResponse, error) {
+	return &paystack.VerifyResponse{Status: true, Data: s.tx}, nil
+}
+
+func TestPaystackVerify(t *testing.T) {
+	tx := paystack.Transaction{
+		ID:              4099260516,
+		Reference:       "dep_123",
+		Amount:          50000,
+		Currency:        "NGN",
+		Status:          "success",
+		GatewayResponse: "Successful",
+		PaidAt:          "2026-01-15T10:07:30Z",
+		Channel:         "card",
+		Fees:            750,
+		Authorization: paystack.Authorization{
+			AuthorizationCode: "AUTH_abc",
+			Signature:         "SIG_abc",
+			Last4:             "4081",
+			Channel:           "card",
+			Reusable:          true,
+		},
+	}
+	tx.Customer.Email = "alice@example.com"
+
+	charge, err := NewPaystack(verifyStub{tx: tx}).Verify(context.Background(), "dep_123")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// Settling compares the currency and customer with the deposit, so they must come through
+	if charge.ID != "4099260516" || charge.Reference != "dep_123" || charge.Amount != 50000 || charge.Currency != "NGN" ||
+		charge.Status != ChargeSuccess || charge.GatewayResponse != "Successful" || charge.CustomerEmail != "alice@example.com" ||
+		charge.Channel != "card" || charge.PaidAt != "2026-01-15T10:07:30Z" || charge.Fees != 750 {
+		t.Fatalf("Verify = %+v", charge)
+	}
+	if charge.Authorization == nil || charge.Authorization.Code != "AUTH_abc" || charge.Authorization.Last4 != "4081" {
+		t.Fatalf("Verify authorization = %+v, want the card", charge.Authorization)
+	}
+}
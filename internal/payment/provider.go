@@ -0,0 +1,175 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/franzego/stage08/config"
+)
+
+// Currency is the currency deposits are made in, whichever provider takes the payment
+const Currency = "NGN"
+
+// Charge outcomes, normalized across providers
+const (
+	ChargeSuccess = "success"
+	ChargeFailed  = "failed"
+	ChargePending = "pending"
+)
+
+// Provider is a payment provider deposits can be made through. Amounts are in kobo and
+// outcomes are normalized, so deposits are settled the same way whoever took the payment.
+type Provider interface {
+	// Name identifies the provider in routes, the provider column and the webhook store
+	Name() string
+	// Initialize starts a hosted checkout for a deposit
+	Initialize(ctx context.Context, req InitializeRequest) (*Checkout, error)
+	// Verify asks the provider for the current outcome of a payment
+	Verify(ctx context.Context, reference string) (*Charge, error)
+	// VerifyWebhook checks that a webhook request really came from the provider
+	VerifyWebhook(header http.Header, body []byte) bool
+	// SignsWebhooks reports whether VerifyWebhook checks a signature over the body. If it
+	// doesn't, a webhook only says which payment to look up, and its charge is settled from
+	// Verify instead.
+	SignsWebhooks() bool
+	// ParseWebhook decodes a verified webhook body
+	ParseWebhook(body []byte) (*Event, error)
+	// Refund returns amount kobo of a successful payment to the customer
+	Refund(ctx context.Context, reference string, amount int64) (*Refund, error)
+	// Ping checks that the provider's API can be reached
+	Ping(ctx context.Context) error
+}
+
+// AuthorizationCharger is implemented by providers that can charge a saved card without a
+// checkout
+type AuthorizationCharger interface {
+	// ChargeAuthorization charges the card. The outcome may still be pending, in which case
+	// the provider's webhook settles it.
+	ChargeAuthorization(ctx context.Context, req ChargeAuthorizationRequest) (*Charge, error)
+}
+
+// ChargeAuthorizationRequest describes a deposit paid with a saved card
+type ChargeAuthorizationRequest struct {
+	Reference         string
+	Email             string // Must be the customer the card was saved for
+	Amount            int64  // in kobo
+	AuthorizationCode string
+}
+
+// InitializeRequest describes the deposit a checkout is for
+type InitializeRequest struct {
+	Reference string
+	Email     string
+	Amount    int64 // in kobo
+}
+
+// Checkout is a started payment the customer completes at AuthorizationURL
+type Checkout struct {
+	Reference        string
+	AuthorizationURL string
+}
+
+// Charge is the outcome of a payment as reported by a provider
+type Charge struct {
+	ID              string // The provider's id for the payment
+	Reference       string
+	Amount          int64 // in kobo
+	Currency        string
+	Status          string // ChargeSuccess, ChargeFailed or ChargePending
+	GatewayResponse string
+	CustomerEmail   string
+	Channel         string
+	PaidAt          string
+	Fees            int64          // The provider's charge, in kobo
+	Authorization   *Authorization // The card paid with, if the provider can charge it again
+}
+
+// Authorization is a card a provider can charge again without the customer
+type Authorization struct {
+	Code      string
+	Signature string // Identifies the card across authorizations
+	Last4     string
+	Brand     string
+	CardType  string
+	Bank      string
+	ExpMonth  string
+	ExpYear   string
+	Reusable  bool
+}
+
+// Event is a parsed webhook
+type Event struct {
+	ID        string    // The provider's id for the event; empty if it has none
+	Type      string    // The provider's event type, e.g. charge.success
+	Timestamp time.Time // When the event happened; zero if the payload doesn't say
+	Charge    *Charge   // Set for events that settle a payment
+}
+
+// Refund is a refund accepted by a provider
+type Refund struct {
+	ID     string
+	Amount int64  // in kobo
+	Status string // As reported by the provider
+}
+
+// Registry holds the configured providers and which one deposits use by default
+type Registry struct {
+	providers       map[string]Provider
+	defaultProvider string
+}
+
+// NewRegistry creates a registry. defaultProvider must be the name of one of providers.
+func NewRegistry(defaultProvider string, providers ...Provider) *Registry {
+	r := &Registry{providers: make(map[string]Provider), defaultProvider: defaultProvider}
+	for _, provider := range providers {
+		r.providers[provider.Name()] = provider
+	}
+	return r
+}
+
+// Get returns the named provider, or the default provider if name is empty. ok is false if
+// the provider isn't configured.
+func (r *Registry) Get(name string) (Provider, bool) {
+	if name == "" {
+		name = r.defaultProvider
+	}
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// All returns every configured provider, ordered by name
+func (r *Registry) All() []Provider {
+	providers := make([]Provider, 0, len(r.providers))
+	for _, provider := range r.providers {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name() < providers[j].Name() })
+	return providers
+}
+
+// DisplayName is the provider's name as shown to users, e.g. in deposit descriptions
+func DisplayName(name string) string {
+	switch name {
+	case config.ProviderPaystack:
+		return "Paystack"
+	case config.ProviderFlutterwave:
+		return "Flutterwave"
+	default:
+		return name
+	}
+}
+
+// parseTimestamp parses an RFC 3339 timestamp, returning the zero time if it can't
+func parseTimestamp(values ...string) time.Time {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, value); err == nil {
+			return at
+		}
+	}
+	return time.Time{}
+}
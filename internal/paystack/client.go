@@ -1,37 +1,65 @@
 package paystack
 
 import (
-	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"net/url"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/apiclient"
+	"github.com/franzego/stage08/internal/metrics"
 )
 
+// Currency is the currency every transaction is initialized in
+const Currency = "NGN"
+
+// API is the subset of Paystack the service uses. It is implemented by Client, which talks
+// to the real API, and by Simulator, which fakes checkout in-process for local development.
+type API interface {
+	InitializeTransaction(ctx context.Context, email string, amount int64, reference string) (*InitializeResponse, error)
+	VerifyTransaction(ctx context.Context, reference string) (*VerifyResponse, error)
+	ChargeAuthorization(ctx context.Context, email string, amount int64, reference, authorizationCode string) (*ChargeResponse, error)
+	Refund(ctx context.Context, reference string, amount int64) (*RefundResponse, error)
+	VerifyWebhookSignature(signature string, body []byte) bool
+	Ping(ctx context.Context) error
+}
+
 type Client struct {
 	SecretKey string
 	BaseURL   string
+	api       *apiclient.Client
 }
 
-func NewClient(secretKey string) *Client {
+// NewClient creates a Paystack client. The client is safe for concurrent use and should be
+// shared so connections to Paystack are pooled.
+func NewClient(cfg *config.PaystackConfig) *Client {
 	return &Client{
-		SecretKey: secretKey,
-		BaseURL:   "https://api.paystack.co",
+		SecretKey: cfg.SecretKey,
+		BaseURL:   cfg.BaseURL,
+		api: apiclient.New(apiclient.Config{
+			Name:         "paystack",
+			BaseURL:      cfg.BaseURL,
+			SecretKey:    cfg.SecretKey,
+			Timeout:      cfg.Timeout,
+			MaxRetries:   cfg.MaxRetries,
+			RetryBackoff: cfg.RetryBackoff,
+			Observe:      metrics.ObservePaystackRequest,
+		}),
 	}
 }
 
-// InitializeTransaction initializes a Paystack transaction
-func (c *Client) InitializeTransaction(email string, amount int64, reference string) (*InitializeResponse, error) {
-	url := c.BaseURL + "/transaction/initialize"
-
+// InitializeTransaction initializes a Paystack transaction.
+// It is not retried: a timed-out attempt may still have created the transaction.
+func (c *Client) InitializeTransaction(ctx context.Context, email string, amount int64, reference string) (*InitializeResponse, error) {
 	payload := map[string]interface{}{
 		"email":     email,
 		"amount":    amount, // Amount in kobo (smallest unit)
 		"reference": reference,
-		"currency":  "NGN",
+		"currency":  Currency,
 	}
 
 	body, err := json.Marshal(payload)
@@ -39,75 +67,105 @@ func (c *Client) InitializeTransaction(email string, amount int64, reference str
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	var result InitializeResponse
+	if err := c.do(ctx, "initialize_transaction", "POST", "/transaction/initialize", body, false, &result); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.SecretKey)
-	req.Header.Set("Content-Type", "application/json")
+	return &result, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+// VerifyTransaction verifies a transaction by reference. Transient failures are retried.
+func (c *Client) VerifyTransaction(ctx context.Context, reference string) (*VerifyResponse, error) {
+	var result VerifyResponse
+	if err := c.do(ctx, "verify_transaction", "GET", "/transaction/verify/"+url.PathEscape(reference), nil, true, &result); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
+	return &result, nil
+}
 
-	var result InitializeResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// ChargeAuthorization charges a card saved from an earlier payment, without a checkout.
+// It is not retried: a timed-out attempt may still have charged the card.
+func (c *Client) ChargeAuthorization(ctx context.Context, email string, amount int64, reference, authorizationCode string) (*ChargeResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"authorization_code": authorizationCode,
+		"email":              email,
+		"amount":             amount,
+		"reference":          reference,
+		"currency":           Currency,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	if !result.Status {
-		return nil, fmt.Errorf("paystack error: %s", result.Message)
+	var result ChargeResponse
+	if err := c.do(ctx, "charge_authorization", "POST", "/transaction/charge_authorization", body, false, &result); err != nil {
+		return nil, err
 	}
 
 	return &result, nil
 }
 
-// VerifyTransaction verifies a transaction by reference
-func (c *Client) VerifyTransaction(reference string) (*VerifyResponse, error) {
-	url := fmt.Sprintf("%s/transaction/verify/%s", c.BaseURL, reference)
-
-	req, err := http.NewRequest("GET", url, nil)
+// Refund refunds amount kobo of a successful transaction. It is not retried: a timed-out
+// attempt may still have created the refund.
+func (c *Client) Refund(ctx context.Context, reference string, amount int64) (*RefundResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"transaction": reference,
+		"amount":      amount,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.SecretKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	var result RefundResponse
+	if err := c.do(ctx, "refund", "POST", "/refund", body, false, &result); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
+	return &result, nil
+}
 
-	var result VerifyResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
+// do sends an authenticated request and decodes the JSON response into out.
+// Responses with "status": false are returned as errors.
+func (c *Client) do(ctx context.Context, operation, method, path string, body []byte, idempotent bool, out apiResponse) error {
+	return c.api.Do(ctx, operation, method, path, body, idempotent, func(respBody []byte) error {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		if !out.ok() {
+			return fmt.Errorf("paystack error: %s", out.message())
+		}
+		return nil
+	})
+}
 
-	return &result, nil
+// Ping checks that the Paystack API is reachable. Any response below 500 counts as reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.api.Ping(ctx)
 }
 
 // VerifyWebhookSignature verifies Paystack webhook signature
 func (c *Client) VerifyWebhookSignature(signature string, body []byte) bool {
-	mac := hmac.New(sha512.New, []byte(c.SecretKey))
+	return verifySignature(c.SecretKey, signature, body)
+}
+
+// Sign computes the x-paystack-signature header for a webhook body: the hex-encoded
+// HMAC-SHA512 of the body keyed with the secret key
+func Sign(secretKey string, body []byte) string {
+	mac := hmac.New(sha512.New, []byte(secretKey))
 	mac.Write(body)
-	expectedSignature := hex.EncodeToString(mac.Sum(nil))
-	return hmac.Equal([]byte(signature), []byte(expectedSignature))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifySignature(secretKey, signature string, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secretKey, body)))
+}
+
+// apiResponse is implemented by every Paystack response envelope
+type apiResponse interface {
+	ok() bool
+	message() string
 }
 
 // Response structures
@@ -122,27 +180,70 @@ type InitializeResponse struct {
 }
 
 type VerifyResponse struct {
+	Status  bool        `json:"status"`
+	Message string      `json:"message"`
+	Data    Transaction `json:"data"`
+}
+
+// Authorization is a card Paystack can charge again, reported with successful card payments
+type Authorization struct {
+	AuthorizationCode string `json:"authorization_code"`
+	Signature         string `json:"signature"` // The same for every authorization of one card
+	Last4             string `json:"last4"`
+	ExpMonth          string `json:"exp_month"`
+	ExpYear           string `json:"exp_year"`
+	Channel           string `json:"channel"`
+	CardType          string `json:"card_type"`
+	Bank              string `json:"bank"`
+	Brand             string `json:"brand"`
+	Reusable          bool   `json:"reusable"`
+}
+
+// Transaction is a transaction as reported in webhooks, verify and charge responses
+type Transaction struct {
+	ID              int64         `json:"id"`
+	Reference       string        `json:"reference"`
+	Amount          int64         `json:"amount"`
+	Currency        string        `json:"currency"`
+	Status          string        `json:"status"`
+	GatewayResponse string        `json:"gateway_response"`
+	PaidAt          string        `json:"paid_at"`
+	CreatedAt       string        `json:"created_at"`
+	CreatedAtCamel  string        `json:"createdAt"` // Transfer and refund events use camelCase
+	Channel         string        `json:"channel"`
+	Fees            int64         `json:"fees"` // Paystack's charge, in kobo
+	Authorization   Authorization `json:"authorization"`
+	Customer        struct {
+		Email string `json:"email"`
+	} `json:"customer"`
+}
+
+type ChargeResponse struct {
+	Status  bool        `json:"status"`
+	Message string      `json:"message"`
+	Data    Transaction `json:"data"`
+}
+
+type RefundResponse struct {
 	Status  bool   `json:"status"`
 	Message string `json:"message"`
 	Data    struct {
-		Reference string `json:"reference"`
-		Amount    int64  `json:"amount"`
-		Status    string `json:"status"`
-		PaidAt    string `json:"paid_at"`
-		Channel   string `json:"channel"`
+		ID     int64  `json:"id"`
+		Amount int64  `json:"amount"`
+		Status string `json:"status"` // pending, processing or processed
 	} `json:"data"`
 }
 
 type WebhookEvent struct {
-	Event string `json:"event"`
-	Data  struct {
-		Reference string `json:"reference"`
-		Amount    int64  `json:"amount"`
-		Status    string `json:"status"`
-		PaidAt    string `json:"paid_at"`
-		Channel   string `json:"channel"`
-		Customer  struct {
-			Email string `json:"email"`
-		} `json:"customer"`
-	} `json:"data"`
+	Event string      `json:"event"`
+	Data  Transaction `json:"data"`
 }
+
+func (r *InitializeResponse) ok() bool        { return r.Status }
+func (r *InitializeResponse) message() string { return r.Message }
+func (r *VerifyResponse) ok() bool            { return r.Status }
+func (r *VerifyResponse) message() string     { return r.Message }
+func (r *ChargeResponse) ok() bool            { return r.Status }
+func (r *ChargeResponse) message() string     { return r.Message }
+func (r *RefundResponse) ok() bool            { return r.Status }
+func (r *RefundResponse) message() string     { return r.Message }
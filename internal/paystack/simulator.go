@@ -0,0 +1,417 @@
+package paystack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SimulatorWebhookPath is where the simulator delivers webhooks, relative to its base URL
+const SimulatorWebhookPath = "/wallet/paystack/webhook"
+
+// Simulator stands in for Paystack during local development. Transactions live in memory,
+// the authorization URL points at a checkout page served by Handler, and approving or
+// declining a payment there sends a signed webhook back to the service, just like Paystack.
+type Simulator struct {
+	secretKey  string
+	baseURL    string
+	httpClient *http.Client
+	logger     *slog.Logger
+
+	mu             sync.Mutex
+	transactions   map[string]*simulatedTransaction
+	authorizations map[string]string // authorization code -> customer email
+	nextEventID    int64
+}
+
+type simulatedTransaction struct {
+	Reference         string
+	Email             string
+	Amount            int64
+	Currency          string
+	Status            string // abandoned until the developer approves (success) or declines (failed)
+	PaidAt            time.Time
+	AuthorizationCode string // Set once the card is approved
+}
+
+// NewSimulator creates a simulator whose checkout pages and webhooks use baseURL, the
+// address the service itself is reachable at (e.g. http://localhost:8080)
+func NewSimulator(secretKey, baseURL string, logger *slog.Logger) *Simulator {
+	return &Simulator{
+		secretKey:      secretKey,
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+		logger:         logger,
+		transactions:   make(map[string]*simulatedTransaction),
+		authorizations: make(map[string]string),
+	}
+}
+
+// InitializeTransaction records the transaction and returns a local checkout URL
+func (s *Simulator) InitializeTransaction(ctx context.Context, email string, amount int64, reference string) (*InitializeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.transactions[reference]; exists {
+		return nil, fmt.Errorf("paystack error: Duplicate Transaction Reference")
+	}
+
+	s.transactions[reference] = &simulatedTransaction{
+		Reference: reference,
+		Email:     email,
+		Amount:    amount,
+		Currency:  Currency,
+		Status:    "abandoned",
+	}
+
+	resp := &InitializeResponse{Status: true, Message: "Authorization URL created"}
+	resp.Data.AuthorizationURL = s.baseURL + "/simulator/checkout/" + url.PathEscape(reference)
+	resp.Data.AccessCode = "sim_" + reference
+	resp.Data.Reference = reference
+	return resp, nil
+}
+
+// VerifyTransaction reports the simulated transaction's current status
+func (s *Simulator) VerifyTransaction(ctx context.Context, reference string) (*VerifyResponse, error) {
+	tx, ok := s.transaction(reference)
+	if !ok {
+		return nil, fmt.Errorf("paystack error: Transaction reference not found")
+	}
+
+	resp := &VerifyResponse{Status: true, Message: "Verification successful"}
+	resp.Data = Transaction{
+		Reference:       tx.Reference,
+		Amount:          tx.Amount,
+		Currency:        tx.Currency,
+		Status:          tx.Status,
+		GatewayResponse: map[string]string{"success": "Approved", "failed": "Declined"}[tx.Status],
+		Channel:         "card",
+	}
+	resp.Data.Customer.Email = tx.Email
+	if !tx.PaidAt.IsZero() {
+		resp.Data.PaidAt = tx.PaidAt.Format(time.RFC3339)
+	}
+	if tx.Status == "success" {
+		resp.Data.Fees = simulatedFees(tx.Amount)
+	}
+	if tx.AuthorizationCode != "" {
+		resp.Data.Authorization = simulatedAuthorization(tx)
+	}
+	return resp, nil
+}
+
+// ChargeAuthorization charges a card approved at an earlier simulated checkout. The charge
+// always succeeds, and a charge.success webhook follows, as it would from Paystack.
+func (s *Simulator) ChargeAuthorization(ctx context.Context, email string, amount int64, reference, authorizationCode string) (*ChargeResponse, error) {
+	s.mu.Lock()
+	if owner, ok := s.authorizations[authorizationCode]; !ok || !strings.EqualFold(owner, email) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("paystack error: Invalid authorization code")
+	}
+	if _, exists := s.transactions[reference]; exists {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("paystack error: Duplicate Transaction Reference")
+	}
+
+	tx := &simulatedTransaction{
+		Reference:         reference,
+		Email:             email,
+		Amount:            amount,
+		Currency:          Currency,
+		Status:            "success",
+		PaidAt:            time.Now().UTC(),
+		AuthorizationCode: authorizationCode,
+	}
+	s.transactions[reference] = tx
+	s.nextEventID++
+	snapshot, id := *tx, s.nextEventID
+	s.mu.Unlock()
+
+	go s.deliver(ctx, snapshot)
+
+	resp := &ChargeResponse{Status: true, Message: "Charge attempted"}
+	resp.Data = Transaction{
+		ID:              id,
+		Reference:       snapshot.Reference,
+		Amount:          snapshot.Amount,
+		Currency:        snapshot.Currency,
+		Status:          snapshot.Status,
+		GatewayResponse: "Approved",
+		PaidAt:          snapshot.PaidAt.Format(time.RFC3339),
+		Channel:         "card",
+		Fees:            simulatedFees(snapshot.Amount),
+		Authorization:   simulatedAuthorization(snapshot),
+	}
+	resp.Data.Customer.Email = snapshot.Email
+	return resp, nil
+}
+
+// Refund refunds a simulated successful transaction at once; no webhook is sent
+func (s *Simulator) Refund(ctx context.Context, reference string, amount int64) (*RefundResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, ok := s.transactions[reference]
+	if !ok {
+		return nil, fmt.Errorf("paystack error: Transaction reference not found")
+	}
+	if tx.Status != "success" {
+		return nil, fmt.Errorf("paystack error: Transaction has not been paid")
+	}
+	if amount > tx.Amount {
+		return nil, fmt.Errorf("paystack error: Refund amount cannot be more than the transaction amount")
+	}
+
+	s.nextEventID++
+	tx.Status = "reversed"
+
+	resp := &RefundResponse{Status: true, Message: "Refund has been queued for processing"}
+	resp.Data.ID = s.nextEventID
+	resp.Data.Amount = amount
+	resp.Data.Status = "processed"
+	return resp, nil
+}
+
+// VerifyWebhookSignature checks the signature against the simulator's secret key
+func (s *Simulator) VerifyWebhookSignature(signature string, body []byte) bool {
+	return verifySignature(s.secretKey, signature, body)
+}
+
+// Ping always succeeds; the simulator runs in-process
+func (s *Simulator) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Handler serves the checkout pages:
+//
+//	GET  /simulator/checkout/{reference}  shows the payment with Approve and Decline buttons
+//	POST /simulator/checkout/{reference}  action=approve|decline|resend settles it and sends the webhook
+func (s *Simulator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /simulator/checkout/{reference}", s.checkout)
+	mux.HandleFunc("POST /simulator/checkout/{reference}", s.decide)
+	return mux
+}
+
+func (s *Simulator) checkout(w http.ResponseWriter, r *http.Request) {
+	tx, ok := s.transaction(r.PathValue("reference"))
+	if !ok {
+		http.Error(w, "Unknown transaction reference", http.StatusNotFound)
+		return
+	}
+	s.render(w, http.StatusOK, checkoutPage{Transaction: tx})
+}
+
+func (s *Simulator) decide(w http.ResponseWriter, r *http.Request) {
+	reference := r.PathValue("reference")
+	action := r.FormValue("action")
+
+	s.mu.Lock()
+	tx, ok := s.transactions[reference]
+	if !ok {
+		s.mu.Unlock()
+		http.Error(w, "Unknown transaction reference", http.StatusNotFound)
+		return
+	}
+
+	decided := tx.Status != "abandoned"
+	switch {
+	case action == "resend" && decided:
+	case (action == "approve" || action == "decline") && !decided:
+		tx.Status = "success"
+		if action == "decline" {
+			tx.Status = "failed"
+		}
+		tx.PaidAt = time.Now().UTC()
+		if tx.Status == "success" {
+			// The approved card can be charged again with charge_authorization
+			tx.AuthorizationCode = "AUTH_sim_" + tx.Reference
+			s.authorizations[tx.AuthorizationCode] = tx.Email
+		}
+	default:
+		snapshot := *tx
+		s.mu.Unlock()
+		message := "This payment has already been " + map[string]string{"success": "approved", "failed": "declined", "reversed": "refunded"}[snapshot.Status] + "."
+		if !decided {
+			message = "Approve or decline the payment first."
+		}
+		s.render(w, http.StatusConflict, checkoutPage{Transaction: snapshot, Error: message})
+		return
+	}
+	snapshot := *tx
+	s.mu.Unlock()
+
+	page := checkoutPage{Transaction: snapshot}
+	page.WebhookStatus, page.WebhookResponse, page.Error = s.deliver(r.Context(), snapshot)
+	s.render(w, http.StatusOK, page)
+}
+
+// deliver sends a signed charge.success or charge.failed event for the transaction to the service
+func (s *Simulator) deliver(ctx context.Context, tx simulatedTransaction) (int, string, string) {
+	s.mu.Lock()
+	s.nextEventID++
+	eventID := s.nextEventID
+	s.mu.Unlock()
+
+	event := "charge.success"
+	if tx.Status != "success" {
+		event = "charge.failed"
+	}
+
+	data := map[string]interface{}{
+		"id":               eventID,
+		"reference":        tx.Reference,
+		"amount":           tx.Amount,
+		"currency":         tx.Currency,
+		"status":           tx.Status,
+		"gateway_response": map[string]string{"success": "Approved", "failed": "Declined"}[tx.Status],
+		"paid_at":          tx.PaidAt.Format(time.RFC3339),
+		"channel":          "card",
+		"fees":             simulatedFees(tx.Amount),
+		"customer":         map[string]interface{}{"email": tx.Email},
+	}
+	if tx.AuthorizationCode != "" {
+		data["authorization"] = simulatedAuthorization(tx)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"event": event, "data": data})
+	if err != nil {
+		return 0, "", fmt.Sprintf("Failed to build webhook: %v", err)
+	}
+
+	// The webhook must outlive the checkout request, like a real delivery would
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.httpClient.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+SimulatorWebhookPath, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", fmt.Sprintf("Failed to build webhook: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-paystack-signature", Sign(s.secretKey, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		s.logger.WarnContext(ctx, "Simulator webhook delivery failed", "reference", tx.Reference, "event", event, "error", err)
+		return 0, "", fmt.Sprintf("Webhook delivery failed: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	s.logger.InfoContext(ctx, "Simulator webhook delivered", "reference", tx.Reference, "event", event, "status", resp.StatusCode)
+	return resp.StatusCode, string(respBody), ""
+}
+
+// simulatedAuthorization is the test card every simulated checkout is paid with. It has the
+// same signature for each customer, so repeat deposits save one card.
+func simulatedAuthorization(tx simulatedTransaction) Authorization {
+	return Authorization{
+		AuthorizationCode: tx.AuthorizationCode,
+		Signature:         "SIG_sim_" + strings.ToLower(tx.Email),
+		Last4:             "4081",
+		ExpMonth:          "12",
+		ExpYear:           strconv.Itoa(time.Now().Year() + 3),
+		Channel:           "card",
+		CardType:          "visa",
+		Bank:              "Simulator Bank",
+		Brand:             "visa",
+		Reusable:          true,
+	}
+}
+
+// simulatedFees approximates Paystack's local card fee: 1.5% plus 100 naira above 2,500
+// naira, capped at 2,000 naira
+func simulatedFees(amount int64) int64 {
+	fees := amount * 15 / 1000
+	if amount >= 250_000 {
+		fees += 10_000
+	}
+	return min(fees, 200_000)
+}
+
+func (s *Simulator) transaction(reference string) (simulatedTransaction, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, ok := s.transactions[reference]
+	if !ok {
+		return simulatedTransaction{}, false
+	}
+	return *tx, true
+}
+
+type checkoutPage struct {
+	Transaction     simulatedTransaction
+	WebhookStatus   int
+	WebhookResponse string
+	Error           string
+}
+
+// Naira formats the kobo amount for display
+func (p checkoutPage) Naira() string {
+	return fmt.Sprintf("%d.%02d", p.Transaction.Amount/100, p.Transaction.Amount%100)
+}
+
+func (s *Simulator) render(w http.ResponseWriter, status int, page checkoutPage) {
+	var buf bytes.Buffer
+	if err := checkoutTemplate.Execute(&buf, page); err != nil {
+		s.logger.Error("Failed to render simulator checkout", "error", err)
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+var checkoutTemplate = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Paystack Simulator</title>
+    <style>
+        body { font-family: sans-serif; max-width: 32rem; margin: 3rem auto; color: #222; }
+        .amount { font-size: 2rem; margin: 0.5rem 0; }
+        dt { color: #666; font-size: 0.85rem; }
+        dd { margin: 0 0 0.75rem 0; }
+        button { font-size: 1rem; padding: 0.5rem 1.25rem; margin-right: 0.5rem; cursor: pointer; }
+        .approve { background: #0ba34e; color: #fff; border: 0; }
+        .decline { background: #d9342b; color: #fff; border: 0; }
+        .error { color: #d9342b; }
+        pre { background: #f4f4f4; padding: 0.75rem; white-space: pre-wrap; }
+    </style>
+</head>
+<body>
+    <h1>Paystack Simulator</h1>
+    <p>No real money moves here. This page stands in for Paystack checkout.</p>
+    <div class="amount">{{.Transaction.Currency}} {{.Naira}}</div>
+    <dl>
+        <dt>Customer</dt><dd>{{.Transaction.Email}}</dd>
+        <dt>Reference</dt><dd>{{.Transaction.Reference}}</dd>
+        <dt>Status</dt><dd>{{.Transaction.Status}}</dd>
+    </dl>
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    <form method="POST">
+    {{if eq .Transaction.Status "abandoned"}}
+        <button class="approve" name="action" value="approve">Approve</button>
+        <button class="decline" name="action" value="decline">Decline</button>
+    {{else}}
+        <button name="action" value="resend">Resend webhook</button>
+    {{end}}
+    </form>
+    {{if .WebhookStatus}}
+    <h2>Webhook</h2>
+    <p>The service answered {{.WebhookStatus}}:</p>
+    <pre>{{.WebhookResponse}}</pre>
+    {{end}}
+</body>
+</html>`))
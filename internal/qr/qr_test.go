@@ -0,0 +1,185 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// 1-M "HELLO WORLD" from the ISO/IEC 18004 worked example
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Fatalf("error correction = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeData(t *testing.T) {
+	// Byte mode, a count of 5, "hello", the terminator, then alternating 0xEC/0x11 padding
+	want := []byte{
+		0x40, 0x56, 0x86, 0x56, 0xC6, 0xC6, 0xF0,
+		0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC,
+	}
+
+	if got := encodeData(1, []byte("hello")); !bytes.Equal(got, want) {
+		t.Fatalf("data codewords = % X, want % X", got, want)
+	}
+}
+
+func TestFormatInformation(t *testing.T) {
+	// Level M format strings for masks 0 to 7, most significant bit first
+	want := []string{
+		"101010000010010",
+		"101000100100101",
+		"101111001111100",
+		"101101101001011",
+		"100010111111001",
+		"100000011001110",
+		"100111110010111",
+		"100101010100000",
+	}
+
+	for mask, bits := range want {
+		c := newCode(1)
+		c.drawFormat(mask)
+
+		var first, second strings.Builder
+		for i := 14; i >= 0; i-- {
+			first.WriteByte(bitChar(c.Dark(formatTopLeft(i))))
+			if i < 8 {
+				second.WriteByte(bitChar(c.Dark(c.Size-1-i, 8)))
+			} else {
+				second.WriteByte(bitChar(c.Dark(8, c.Size-15+i)))
+			}
+		}
+		if first.String() != bits || second.String() != bits {
+			t.Fatalf("mask %d format = %s and %s, want %s", mask, first.String(), second.String(), bits)
+		}
+	}
+}
+
+func TestVersionInformation(t *testing.T) {
+	// Version 7's 18-bit version information from the standard's table
+	const want = 0x07C94
+
+	c := newCode(7)
+	for i := 0; i < 18; i++ {
+		dark := (want>>i)&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		if c.Dark(a, b) != dark || c.Dark(b, a) != dark {
+			t.Fatalf("version information bit %d is not %v in both copies", i, dark)
+		}
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	cases := []struct {
+		length int
+		size   int
+	}{
+		{1, 21},
+		{14, 21}, // The most 1-M holds
+		{15, 25},
+		{213, 57}, // The most 10-M holds
+	}
+
+	for _, tc := range cases {
+		c, err := Encode(strings.Repeat("a", tc.length))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", tc.length, err)
+		}
+		if c.Size != tc.size {
+			t.Fatalf("Encode(%d bytes) size = %d, want %d", tc.length, c.Size, tc.size)
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("a", 214)); err == nil {
+		t.Fatal("Encode(214 bytes) succeeded, want an error")
+	}
+}
+
+func TestEncodeFunctionPatterns(t *testing.T) {
+	c, err := Encode("https://example.com/pay/abc123")
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// Finder patterns: dark ring, light ring, dark 3x3 core
+	for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+		for dy := 0; dy < 7; dy++ {
+			for dx := 0; dx < 7; dx++ {
+				dist := max(abs(dx-3), abs(dy-3))
+				if want := dist != 2; c.Dark(corner[0]+dx, corner[1]+dy) != want {
+					t.Fatalf("finder at %v module (%d,%d) dark = %v, want %v", corner, dx, dy, !want, want)
+				}
+			}
+		}
+	}
+
+	// Timing patterns alternate between the finders
+	for i := 8; i < c.Size-8; i++ {
+		if c.Dark(i, 6) != (i%2 == 0) || c.Dark(6, i) != (i%2 == 0) {
+			t.Fatalf("timing module %d is wrong", i)
+		}
+	}
+
+	if !c.Dark(8, c.Size-8) {
+		t.Fatal("dark module is light")
+	}
+}
+
+func TestPNG(t *testing.T) {
+	c, err := Encode("hello")
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	const scale = 3
+	data, err := c.PNG(scale)
+	if err != nil {
+		t.Fatalf("PNG: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode PNG: %v", err)
+	}
+
+	side := (c.Size + 8) * scale
+	if bounds := img.Bounds(); bounds.Dx() != side || bounds.Dy() != side {
+		t.Fatalf("PNG is %v, want %dx%d", bounds, side, side)
+	}
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			mx, my := x/scale-4, y/scale-4
+			want := mx >= 0 && mx < c.Size && my >= 0 && my < c.Size && c.Dark(mx, my)
+			r, _, _, _ := img.At(x, y).RGBA()
+			if dark := r == 0; dark != want {
+				t.Fatalf("pixel (%d,%d) dark = %v, want %v", x, y, dark, want)
+			}
+		}
+	}
+}
+
+// formatTopLeft returns the column and row of format bit i around the top-left finder
+func formatTopLeft(i int) (x, y int) {
+	switch {
+	case i <= 5:
+		return 8, i
+	case i <= 7:
+		return 8, i + 1
+	case i == 8:
+		return 7, 8
+	default:
+		return 14 - i, 8
+	}
+}
+
+func bitChar(dark bool) byte {
+	if dark {
+		return '1'
+	}
+	return '0'
+}
@@ -1,29 +1,43 @@
 package repository
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/franzego/stage08/internal/models"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
-type APIKeyRepository struct {
-	db *sqlx.DB
+// APIKeyRepository stores API keys
+type APIKeyRepository interface {
+	Create(ctx context.Context, userID uuid.UUID, organizationID *uuid.UUID, name string, permissions []string, expiresAt time.Time) (*models.APIKey, string, error)
+	FindByKey(ctx context.Context, rawKey string) (*models.APIKey, error)
+	CountActiveByUser(ctx context.Context, userID uuid.UUID) (int, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*models.APIKey, error)
+	UpdateLastUsed(ctx context.Context, id uuid.UUID) error
+	Revoke(ctx context.Context, id uuid.UUID) error
 }
 
-func NewAPIKeyRepository(db *sqlx.DB) *APIKeyRepository {
-	return &APIKeyRepository{db: db}
+type aPIKeyRepository struct {
+	db     DBTX
+	logger *slog.Logger
 }
 
-// Create generates and stores a new API key
-func (r *APIKeyRepository) Create(userID uuid.UUID, name string, permissions []string, expiresAt time.Time) (*models.APIKey, string, error) {
+func NewAPIKeyRepository(db DBTX, logger *slog.Logger) APIKeyRepository {
+	return &aPIKeyRepository{db: db, logger: logger}
+}
+
+// Create generates and stores a new API key. organizationID is set for keys issued by an
+// organization, whose userID is the organization's account user.
+func (r *aPIKeyRepository) Create(ctx context.Context, userID uuid.UUID, organizationID *uuid.UUID, name string, permissions []string, expiresAt time.Time) (*models.APIKey, string, error) {
 	// Generate raw API key
 	rawKey, err := generateAPIKey()
 	if err != nil {
@@ -36,23 +50,25 @@ func (r *APIKeyRepository) Create(userID uuid.UUID, name string, permissions []s
 
 	// Create API key record
 	apiKey := &models.APIKey{
-		UserID:      userID,
-		Name:        name,
-		KeyHash:     keyHash,
-		KeyPrefix:   keyPrefix,
-		Permissions: permissions,
-		IsActive:    true,
-		ExpiresAt:   expiresAt,
+		UserID:         userID,
+		OrganizationID: organizationID,
+		Name:           name,
+		KeyHash:        keyHash,
+		KeyPrefix:      keyPrefix,
+		Permissions:    permissions,
+		IsActive:       true,
+		ExpiresAt:      expiresAt,
 	}
 
 	query := `
-		INSERT INTO api_keys (user_id, name, key_hash, key_prefix, permissions, is_active, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO api_keys (user_id, organization_id, name, key_hash, key_prefix, permissions, is_active, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id, created_at, updated_at
 	`
 
-	err = r.db.QueryRowx(query,
+	err = r.db.QueryRowxContext(ctx, query,
 		apiKey.UserID,
+		apiKey.OrganizationID,
 		apiKey.Name,
 		apiKey.KeyHash,
 		apiKey.KeyPrefix,
@@ -69,13 +85,13 @@ func (r *APIKeyRepository) Create(userID uuid.UUID, name string, permissions []s
 }
 
 // FindByKey finds an API key by its raw key value
-func (r *APIKeyRepository) FindByKey(rawKey string) (*models.APIKey, error) {
+func (r *aPIKeyRepository) FindByKey(ctx context.Context, rawKey string) (*models.APIKey, error) {
 	keyHash := hashAPIKey(rawKey)
 
 	var apiKey models.APIKey
 	query := `SELECT * FROM api_keys WHERE key_hash = $1`
 
-	err := r.db.Get(&apiKey, query, keyHash)
+	err := r.db.GetContext(ctx, &apiKey, query, keyHash)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -87,11 +103,11 @@ func (r *APIKeyRepository) FindByKey(rawKey string) (*models.APIKey, error) {
 }
 
 // CountActiveByUser counts active API keys for a user
-func (r *APIKeyRepository) CountActiveByUser(userID uuid.UUID) (int, error) {
+func (r *aPIKeyRepository) CountActiveByUser(ctx context.Context, userID uuid.UUID) (int, error) {
 	var count int
 	query := `SELECT COUNT(*) FROM api_keys WHERE user_id = $1 AND is_active = true`
 
-	err := r.db.Get(&count, query, userID)
+	err := r.db.GetContext(ctx, &count, query, userID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count API keys: %w", err)
 	}
@@ -100,11 +116,11 @@ func (r *APIKeyRepository) CountActiveByUser(userID uuid.UUID) (int, error) {
 }
 
 // ListByUser lists all API keys for a user
-func (r *APIKeyRepository) ListByUser(userID uuid.UUID) ([]models.APIKey, error) {
+func (r *aPIKeyRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
 	var keys []models.APIKey
 	query := `SELECT * FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`
 
-	err := r.db.Select(&keys, query, userID)
+	err := r.db.SelectContext(ctx, &keys, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list API keys: %w", err)
 	}
@@ -113,11 +129,11 @@ func (r *APIKeyRepository) ListByUser(userID uuid.UUID) ([]models.APIKey, error)
 }
 
 // FindByID finds an API key by ID
-func (r *APIKeyRepository) FindByID(id uuid.UUID) (*models.APIKey, error) {
+func (r *aPIKeyRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
 	var apiKey models.APIKey
 	query := `SELECT * FROM api_keys WHERE id = $1`
 
-	err := r.db.Get(&apiKey, query, id)
+	err := r.db.GetContext(ctx, &apiKey, query, id)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -129,17 +145,20 @@ func (r *APIKeyRepository) FindByID(id uuid.UUID) (*models.APIKey, error) {
 }
 
 // UpdateLastUsed updates the last_used_at timestamp
-func (r *APIKeyRepository) UpdateLastUsed(id uuid.UUID) error {
+func (r *aPIKeyRepository) UpdateLastUsed(ctx context.Context, id uuid.UUID) error {
 	query := `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`
-	_, err := r.db.Exec(query, id)
+	_, err := r.db.ExecContext(ctx, query, id)
 	return err
 }
 
 // Revoke deactivates an API key
-func (r *APIKeyRepository) Revoke(id uuid.UUID) error {
+func (r *aPIKeyRepository) Revoke(ctx context.Context, id uuid.UUID) error {
 	query := `UPDATE api_keys SET is_active = false, updated_at = NOW() WHERE id = $1`
-	_, err := r.db.Exec(query, id)
-	return err
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	r.logger.Debug("API key revoked", "api_key_id", id)
+	return nil
 }
 
 // generateAPIKey generates a secure random API key
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// auditChainLockID is the advisory lock key that serializes appends to the audit chain
+const auditChainLockID = 727_001
+
+// GenesisHash is the prev_hash of the first audit event
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// AuditRepository stores entries in the append-only audit log
+type AuditRepository interface {
+	Append(ctx context.Context, event *models.AuditEvent) error
+	ListByOwner(ctx context.Context, ownerID uuid.UUID, limit, offset int) ([]models.AuditEvent, error)
+	CountByOwner(ctx context.Context, ownerID uuid.UUID) (int, error)
+	ListAfter(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error)
+}
+
+type auditRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewAuditRepository(db DBTX, logger *slog.Logger) AuditRepository {
+	return &auditRepository{db: db, logger: logger}
+}
+
+// Append links the event to the end of the chain and stores it
+func (r *auditRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	err := withTx(ctx, r.db, func(tx DBTX) error {
+		// Only one writer may read the chain head and append at a time
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+			return fmt.Errorf("failed to lock audit chain: %w", err)
+		}
+
+		var prevHash string
+		err := sqlx.GetContext(ctx, tx, &prevHash, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`)
+		if err == sql.ErrNoRows {
+			prevHash = GenesisHash
+		} else if err != nil {
+			return fmt.Errorf("failed to read audit chain head: %w", err)
+		}
+
+		event.PrevHash = prevHash
+		event.Hash = event.ComputeHash()
+
+		query := `
+			INSERT INTO audit_events (
+				owner_user_id, actor_user_id, actor_api_key_id, ip_address, user_agent,
+				action, target_type, target_id, before, after, prev_hash, hash, created_at
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING id
+		`
+
+		err = tx.QueryRowxContext(ctx, query,
+			event.OwnerUserID,
+			event.ActorUserID,
+			event.ActorAPIKeyID,
+			event.IPAddress,
+			event.UserAgent,
+			event.Action,
+			event.TargetType,
+			event.TargetID,
+			event.Before,
+			event.After,
+			event.PrevHash,
+			event.Hash,
+			event.CreatedAt,
+		).Scan(&event.ID)
+		if err != nil {
+			return fmt.Errorf("failed to append audit event: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.logger.Debug("Audit event appended", "audit_event_id", event.ID, "action", event.Action)
+	return nil
+}
+
+// ListByOwner lists audit events for an account, newest first
+func (r *auditRepository) ListByOwner(ctx context.Context, ownerID uuid.UUID, limit, offset int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	query := `
+		SELECT * FROM audit_events
+		WHERE owner_user_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	err := r.db.SelectContext(ctx, &events, query, ownerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+// CountByOwner counts audit events for an account
+func (r *auditRepository) CountByOwner(ctx context.Context, ownerID uuid.UUID) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM audit_events WHERE owner_user_id = $1`
+
+	if err := r.db.GetContext(ctx, &count, query, ownerID); err != nil {
+		return 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	return count, nil
+}
+
+// ListAfter lists events in chain order starting after the given id
+func (r *auditRepository) ListAfter(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	query := `SELECT * FROM audit_events WHERE id > $1 ORDER BY id ASC LIMIT $2`
+
+	err := r.db.SelectContext(ctx, &events, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	return events, nil
+}
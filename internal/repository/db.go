@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DBTX is the query interface shared by *sqlx.DB and *sqlx.Tx, so a repository can run
+// either on the pool or inside a caller's transaction
+type DBTX interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// withTx runs fn in a transaction. If db is already a transaction, fn joins it and the
+// caller stays responsible for committing.
+func withTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	if tx, ok := db.(*sqlx.Tx); ok {
+		return fn(tx)
+	}
+
+	pool, ok := db.(*sqlx.DB)
+	if !ok {
+		return fmt.Errorf("unsupported database handle %T", db)
+	}
+
+	tx, err := pool.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// DisputeRepository stores disputed transfers and how they were resolved
+type DisputeRepository interface {
+	Create(ctx context.Context, dispute *models.Dispute) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.Dispute, error)
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.Dispute, error)
+	FindActiveByReference(ctx context.Context, reference string) (*models.Dispute, error)
+	ListBySender(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Dispute, error)
+	ListByRecipient(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Dispute, error)
+	List(ctx context.Context, status string, limit, offset int) ([]models.Dispute, error)
+	Update(ctx context.Context, dispute *models.Dispute) error
+}
+
+type disputeRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewDisputeRepository(db DBTX, logger *slog.Logger) DisputeRepository {
+	return &disputeRepository{db: db, logger: logger}
+}
+
+// Create stores a new open dispute
+func (r *disputeRepository) Create(ctx context.Context, dispute *models.Dispute) error {
+	query := `
+		INSERT INTO disputes
+			(transfer_reference, debit_transaction_id, credit_transaction_id, sender_user_id, sender_wallet_id,
+			 recipient_user_id, recipient_wallet_id, amount, reason, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		dispute.TransferReference,
+		dispute.DebitTransactionID,
+		dispute.CreditTransactionID,
+		dispute.SenderUserID,
+		dispute.SenderWalletID,
+		dispute.RecipientUserID,
+		dispute.RecipientWalletID,
+		dispute.Amount,
+		dispute.Reason,
+		dispute.Status,
+	).Scan(&dispute.ID, &dispute.CreatedAt, &dispute.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create dispute: %w", err)
+	}
+
+	r.logger.Debug("Dispute created", "dispute_id", dispute.ID, "transfer_reference", dispute.TransferReference)
+	return nil
+}
+
+// FindByID finds a dispute
+func (r *disputeRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.Dispute, error) {
+	return r.find(ctx, `SELECT * FROM disputes WHERE id = $1`, id)
+}
+
+// FindByIDForUpdate finds a dispute and row-locks it until the surrounding transaction
+// ends, so it is resolved at most once
+func (r *disputeRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.Dispute, error) {
+	return r.find(ctx, `SELECT * FROM disputes WHERE id = $1 FOR UPDATE`, id)
+}
+
+// FindActiveByReference finds the dispute of a transfer that hasn't been cancelled
+func (r *disputeRepository) FindActiveByReference(ctx context.Context, reference string) (*models.Dispute, error) {
+	return r.find(ctx, `SELECT * FROM disputes WHERE transfer_reference = $1 AND status <> 'cancelled'`, reference)
+}
+
+func (r *disputeRepository) find(ctx context.Context, query string, arg interface{}) (*models.Dispute, error) {
+	var dispute models.Dispute
+
+	err := r.db.GetContext(ctx, &dispute, query, arg)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find dispute: %w", err)
+	}
+
+	return &dispute, nil
+}
+
+// ListBySender lists the disputes a user opened, newest first, optionally only those with
+// the given status
+func (r *disputeRepository) ListBySender(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Dispute, error) {
+	return r.list(ctx, `WHERE sender_user_id = $1 AND ($2 = '' OR status = $2)`, userID, status, limit, offset)
+}
+
+// ListByRecipient lists the disputes against transfers a user received, newest first,
+// optionally only those with the given status
+func (r *disputeRepository) ListByRecipient(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Dispute, error) {
+	return r.list(ctx, `WHERE recipient_user_id = $1 AND ($2 = '' OR status = $2)`, userID, status, limit, offset)
+}
+
+// List lists every dispute, newest first, optionally only those with the given status
+func (r *disputeRepository) List(ctx context.Context, status string, limit, offset int) ([]models.Dispute, error) {
+	disputes := []models.Dispute{}
+	query := `
+		SELECT * FROM disputes
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	if err := r.db.SelectContext(ctx, &disputes, query, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list disputes: %w", err)
+	}
+
+	return disputes, nil
+}
+
+func (r *disputeRepository) list(ctx context.Context, where string, userID uuid.UUID, status string, limit, offset int) ([]models.Dispute, error) {
+	disputes := []models.Dispute{}
+	query := `SELECT * FROM disputes ` + where + ` ORDER BY created_at DESC LIMIT $3 OFFSET $4`
+
+	if err := r.db.SelectContext(ctx, &disputes, query, userID, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list disputes: %w", err)
+	}
+
+	return disputes, nil
+}
+
+// Update saves a dispute's outcome
+func (r *disputeRepository) Update(ctx context.Context, dispute *models.Dispute) error {
+	query := `
+		UPDATE disputes
+		SET status = $2,
+			resolution = $3,
+			resolution_note = $4,
+			resolved_by_user_id = $5,
+			reversal_reference = $6,
+			resolved_at = $7,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		dispute.ID,
+		dispute.Status,
+		dispute.Resolution,
+		dispute.ResolutionNote,
+		dispute.ResolvedByUserID,
+		dispute.ReversalReference,
+		dispute.ResolvedAt,
+	).Scan(&dispute.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update dispute: %w", err)
+	}
+
+	return nil
+}
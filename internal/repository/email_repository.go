@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// EmailRepository queues outgoing emails and hands them out for sending. Like webhook events,
+// an attempt claims an email by pushing next_attempt_at past a lease, so several instances can
+// send concurrently and an email whose worker died becomes due again.
+type EmailRepository interface {
+	Create(ctx context.Context, email *models.Email) error
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.Email, error)
+	Complete(ctx context.Context, id uuid.UUID, status models.EmailStatus, lastError *string) error
+	Retry(ctx context.Context, id uuid.UUID, lastError string, at time.Time) error
+}
+
+type emailRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewEmailRepository(db DBTX, logger *slog.Logger) EmailRepository {
+	return &emailRepository{db: db, logger: logger}
+}
+
+// Create queues an email to be sent
+func (r *emailRepository) Create(ctx context.Context, email *models.Email) error {
+	query := `
+		INSERT INTO emails (recipient, subject, body)
+		VALUES ($1, $2, $3)
+		RETURNING id, status, attempts, next_attempt_at, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query, email.Recipient, email.Subject, email.Body).
+		Scan(&email.ID, &email.Status, &email.Attempts, &email.NextAttemptAt, &email.CreatedAt, &email.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to queue email: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimDue claims up to limit pending emails that are due, oldest first, for lease
+func (r *emailRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.Email, error) {
+	var emails []models.Email
+	query := `
+		UPDATE emails
+		SET attempts = attempts + 1, next_attempt_at = NOW() + $2::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM emails
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &emails, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim emails: %w", err)
+	}
+
+	return emails, nil
+}
+
+// Complete records the final outcome of an attempt
+func (r *emailRepository) Complete(ctx context.Context, id uuid.UUID, status models.EmailStatus, lastError *string) error {
+	query := `
+		UPDATE emails
+		SET status = $1::text, last_error = $2,
+			sent_at = CASE WHEN $1::text = 'sent' THEN NOW() ELSE sent_at END, updated_at = NOW()
+		WHERE id = $3
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, status, lastError, id); err != nil {
+		return fmt.Errorf("failed to complete email: %w", err)
+	}
+
+	return nil
+}
+
+// Retry leaves the email pending and schedules its next attempt
+func (r *emailRepository) Retry(ctx context.Context, id uuid.UUID, lastError string, at time.Time) error {
+	query := `
+		UPDATE emails
+		SET last_error = $1, next_attempt_at = $2, updated_at = NOW()
+		WHERE id = $3
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, lastError, at, id); err != nil {
+		return fmt.Errorf("failed to reschedule email: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// EscrowRepository stores escrow agreements and their history
+type EscrowRepository interface {
+	Create(ctx context.Context, escrow *models.Escrow) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.Escrow, error)
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.Escrow, error)
+	ListByBuyer(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Escrow, error)
+	ListBySeller(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Escrow, error)
+	ListByArbiter(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Escrow, error)
+	Update(ctx context.Context, escrow *models.Escrow) error
+	CreateEvent(ctx context.Context, event *models.EscrowEvent) error
+	ListEvents(ctx context.Context, escrowID uuid.UUID) ([]models.EscrowEvent, error)
+}
+
+type escrowRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewEscrowRepository(db DBTX, logger *slog.Logger) EscrowRepository {
+	return &escrowRepository{db: db, logger: logger}
+}
+
+// Create stores a new funded escrow
+func (r *escrowRepository) Create(ctx context.Context, escrow *models.Escrow) error {
+	query := `
+		INSERT INTO escrows
+			(buyer_user_id, buyer_wallet_id, buyer_wallet_number, seller_user_id, seller_wallet_id,
+			 seller_wallet_number, arbiter_user_id, amount, description, conditions, deadline, status,
+			 funding_reference)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		escrow.BuyerUserID,
+		escrow.BuyerWalletID,
+		escrow.BuyerWalletNumber,
+		escrow.SellerUserID,
+		escrow.SellerWalletID,
+		escrow.SellerWalletNumber,
+		escrow.ArbiterUserID,
+		escrow.Amount,
+		escrow.Description,
+		escrow.Conditions,
+		escrow.Deadline,
+		escrow.Status,
+		escrow.FundingReference,
+	).Scan(&escrow.ID, &escrow.CreatedAt, &escrow.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create escrow: %w", err)
+	}
+
+	r.logger.Debug("Escrow created", "escrow_id", escrow.ID, "buyer_user_id", escrow.BuyerUserID)
+	return nil
+}
+
+// FindByID finds an escrow
+func (r *escrowRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.Escrow, error) {
+	return r.find(ctx, `SELECT * FROM escrows WHERE id = $1`, id)
+}
+
+// FindByIDForUpdate finds an escrow and row-locks it until the surrounding transaction
+// ends, so it is settled at most once
+func (r *escrowRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.Escrow, error) {
+	return r.find(ctx, `SELECT * FROM escrows WHERE id = $1 FOR UPDATE`, id)
+}
+
+func (r *escrowRepository) find(ctx context.Context, query string, id uuid.UUID) (*models.Escrow, error) {
+	var escrow models.Escrow
+
+	err := r.db.GetContext(ctx, &escrow, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find escrow: %w", err)
+	}
+
+	return &escrow, nil
+}
+
+// ListByBuyer lists the escrows a user funded, newest first, optionally only those with
+// the given status
+func (r *escrowRepository) ListByBuyer(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Escrow, error) {
+	return r.list(ctx, `WHERE buyer_user_id = $1 AND ($2 = '' OR status = $2)`, userID, status, limit, offset)
+}
+
+// ListBySeller lists the escrows a user is paid from, newest first, optionally only those
+// with the given status
+func (r *escrowRepository) ListBySeller(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Escrow, error) {
+	return r.list(ctx, `WHERE seller_user_id = $1 AND ($2 = '' OR status = $2)`, userID, status, limit, offset)
+}
+
+// ListByArbiter lists the escrows a user arbitrates, newest first, optionally only those
+// with the given status
+func (r *escrowRepository) ListByArbiter(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.Escrow, error) {
+	return r.list(ctx, `WHERE arbiter_user_id = $1 AND ($2 = '' OR status = $2)`, userID, status, limit, offset)
+}
+
+func (r *escrowRepository) list(ctx context.Context, where string, userID uuid.UUID, status string, limit, offset int) ([]models.Escrow, error) {
+	escrows := []models.Escrow{}
+	query := `SELECT * FROM escrows ` + where + ` ORDER BY created_at DESC LIMIT $3 OFFSET $4`
+
+	if err := r.db.SelectContext(ctx, &escrows, query, userID, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list escrows: %w", err)
+	}
+
+	return escrows, nil
+}
+
+// Update saves how much of an escrow has been paid out and its status
+func (r *escrowRepository) Update(ctx context.Context, escrow *models.Escrow) error {
+	query := `
+		UPDATE escrows
+		SET status = $2,
+			released_amount = $3,
+			refunded_amount = $4,
+			settled_at = $5,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		escrow.ID,
+		escrow.Status,
+		escrow.ReleasedAmount,
+		escrow.RefundedAmount,
+		escrow.SettledAt,
+	).Scan(&escrow.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update escrow: %w", err)
+	}
+
+	return nil
+}
+
+// CreateEvent appends an entry to an escrow's history
+func (r *escrowRepository) CreateEvent(ctx context.Context, event *models.EscrowEvent) error {
+	query := `
+		INSERT INTO escrow_events (escrow_id, action, actor_user_id, seller_amount, buyer_amount, reference, note)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		event.EscrowID,
+		event.Action,
+		event.ActorUserID,
+		event.SellerAmount,
+		event.BuyerAmount,
+		event.Reference,
+		event.Note,
+	).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create escrow event: %w", err)
+	}
+
+	return nil
+}
+
+// ListEvents returns an escrow's history, oldest first
+func (r *escrowRepository) ListEvents(ctx context.Context, escrowID uuid.UUID) ([]models.EscrowEvent, error) {
+	events := []models.EscrowEvent{}
+	query := `SELECT * FROM escrow_events WHERE escrow_id = $1 ORDER BY created_at, id`
+
+	if err := r.db.SelectContext(ctx, &events, query, escrowID); err != nil {
+		return nil, fmt.Errorf("failed to list escrow events: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,319 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// OrganizationRepository stores organizations, their members and invitations
+type OrganizationRepository interface {
+	Create(ctx context.Context, org *models.Organization) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.Organization, error)
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.Organization, error)
+	ListByMember(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Organization, error)
+	AddMember(ctx context.Context, member *models.OrganizationMember) error
+	FindMember(ctx context.Context, orgID, userID uuid.UUID) (*models.OrganizationMember, error)
+	ListMembers(ctx context.Context, orgID uuid.UUID) ([]models.OrganizationMember, error)
+	CountOwners(ctx context.Context, orgID uuid.UUID) (int, error)
+	UpdateMemberRole(ctx context.Context, member *models.OrganizationMember) error
+	RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error
+	CreateInvitation(ctx context.Context, invitation *models.OrganizationInvitation) error
+	FindInvitationByID(ctx context.Context, id uuid.UUID) (*models.OrganizationInvitation, error)
+	FindInvitationByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.OrganizationInvitation, error)
+	FindPendingInvitation(ctx context.Context, orgID uuid.UUID, email string) (*models.OrganizationInvitation, error)
+	ListInvitations(ctx context.Context, orgID uuid.UUID, status string, limit, offset int) ([]models.OrganizationInvitation, error)
+	ListPendingInvitationsByEmail(ctx context.Context, email string) ([]models.OrganizationInvitation, error)
+	UpdateInvitation(ctx context.Context, invitation *models.OrganizationInvitation) error
+}
+
+type organizationRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewOrganizationRepository(db DBTX, logger *slog.Logger) OrganizationRepository {
+	return &organizationRepository{db: db, logger: logger}
+}
+
+// organizationColumns selects an organization with its default wallet number
+const organizationColumns = `
+	SELECT o.*, w.wallet_number
+	FROM organizations o
+	JOIN wallets w ON w.user_id = o.account_user_id AND w.is_default
+`
+
+// invitationColumns selects an invitation with the name of its organization
+const invitationColumns = `
+	SELECT i.*, o.name AS organization_name
+	FROM organization_invitations i
+	JOIN organizations o ON o.id = i.organization_id
+`
+
+// Create stores a new organization. Its account user and wallet must already exist.
+func (r *organizationRepository) Create(ctx context.Context, org *models.Organization) error {
+	query := `
+		INSERT INTO organizations (name, account_user_id, created_by_user_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query, org.Name, org.AccountUserID, org.CreatedByUserID).Scan(
+		&org.ID, &org.CreatedAt, &org.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	r.logger.Debug("Organization created", "organization_id", org.ID, "account_user_id", org.AccountUserID)
+	return nil
+}
+
+// FindByID finds an organization
+func (r *organizationRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	return r.find(ctx, organizationColumns+` WHERE o.id = $1`, id)
+}
+
+// FindByIDForUpdate finds an organization and row-locks it until the surrounding transaction
+// ends, so membership changes to it happen one at a time
+func (r *organizationRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	return r.find(ctx, organizationColumns+` WHERE o.id = $1 FOR UPDATE OF o`, id)
+}
+
+func (r *organizationRepository) find(ctx context.Context, query string, id uuid.UUID) (*models.Organization, error) {
+	var org models.Organization
+
+	err := r.db.GetContext(ctx, &org, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+// ListByMember lists the organizations a user belongs to, with their role, newest first
+func (r *organizationRepository) ListByMember(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Organization, error) {
+	orgs := []models.Organization{}
+	query := `
+		SELECT o.*, w.wallet_number, m.role
+		FROM organizations o
+		JOIN wallets w ON w.user_id = o.account_user_id AND w.is_default
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	if err := r.db.SelectContext(ctx, &orgs, query, userID, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+
+	return orgs, nil
+}
+
+// AddMember adds a user to an organization
+func (r *organizationRepository) AddMember(ctx context.Context, member *models.OrganizationMember) error {
+	query := `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query, member.OrganizationID, member.UserID, member.Role).Scan(
+		&member.ID, &member.CreatedAt, &member.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add organization member: %w", err)
+	}
+
+	return nil
+}
+
+// FindMember finds a user's membership of an organization
+func (r *organizationRepository) FindMember(ctx context.Context, orgID, userID uuid.UUID) (*models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	query := `
+		SELECT m.*, u.email, u.name
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1 AND m.user_id = $2
+	`
+
+	err := r.db.GetContext(ctx, &member, query, orgID, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find organization member: %w", err)
+	}
+
+	return &member, nil
+}
+
+// ListMembers lists an organization's members, oldest first
+func (r *organizationRepository) ListMembers(ctx context.Context, orgID uuid.UUID) ([]models.OrganizationMember, error) {
+	members := []models.OrganizationMember{}
+	query := `
+		SELECT m.*, u.email, u.name
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1
+		ORDER BY m.created_at, m.id
+	`
+
+	if err := r.db.SelectContext(ctx, &members, query, orgID); err != nil {
+		return nil, fmt.Errorf("failed to list organization members: %w", err)
+	}
+
+	return members, nil
+}
+
+// CountOwners counts an organization's owners
+func (r *organizationRepository) CountOwners(ctx context.Context, orgID uuid.UUID) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND role = 'owner'`
+
+	if err := r.db.GetContext(ctx, &count, query, orgID); err != nil {
+		return 0, fmt.Errorf("failed to count organization owners: %w", err)
+	}
+
+	return count, nil
+}
+
+// UpdateMemberRole saves a member's role
+func (r *organizationRepository) UpdateMemberRole(ctx context.Context, member *models.OrganizationMember) error {
+	query := `
+		UPDATE organization_members
+		SET role = $3, updated_at = NOW()
+		WHERE organization_id = $1 AND user_id = $2
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query, member.OrganizationID, member.UserID, member.Role).Scan(&member.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update organization member: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveMember removes a user from an organization
+func (r *organizationRepository) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	query := `DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, orgID, userID); err != nil {
+		return fmt.Errorf("failed to remove organization member: %w", err)
+	}
+
+	r.logger.Debug("Organization member removed", "organization_id", orgID, "user_id", userID)
+	return nil
+}
+
+// CreateInvitation stores a new pending invitation
+func (r *organizationRepository) CreateInvitation(ctx context.Context, invitation *models.OrganizationInvitation) error {
+	query := `
+		INSERT INTO organization_invitations (organization_id, email, role, invited_by_user_id, status, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		invitation.OrganizationID,
+		invitation.Email,
+		invitation.Role,
+		invitation.InvitedByUserID,
+		invitation.Status,
+		invitation.ExpiresAt,
+	).Scan(&invitation.ID, &invitation.CreatedAt, &invitation.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create organization invitation: %w", err)
+	}
+
+	return nil
+}
+
+// FindInvitationByID finds an invitation
+func (r *organizationRepository) FindInvitationByID(ctx context.Context, id uuid.UUID) (*models.OrganizationInvitation, error) {
+	return r.findInvitation(ctx, invitationColumns+` WHERE i.id = $1`, id)
+}
+
+// FindInvitationByIDForUpdate finds an invitation and row-locks it until the surrounding
+// transaction ends, so it is answered at most once
+func (r *organizationRepository) FindInvitationByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.OrganizationInvitation, error) {
+	return r.findInvitation(ctx, invitationColumns+` WHERE i.id = $1 FOR UPDATE OF i`, id)
+}
+
+// FindPendingInvitation finds the pending invitation of an email address to an organization
+func (r *organizationRepository) FindPendingInvitation(ctx context.Context, orgID uuid.UUID, email string) (*models.OrganizationInvitation, error) {
+	return r.findInvitation(ctx, invitationColumns+` WHERE i.organization_id = $1 AND i.email = $2 AND i.status = 'pending'`, orgID, email)
+}
+
+func (r *organizationRepository) findInvitation(ctx context.Context, query string, args ...interface{}) (*models.OrganizationInvitation, error) {
+	var invitation models.OrganizationInvitation
+
+	err := r.db.GetContext(ctx, &invitation, query, args...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find organization invitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// ListInvitations lists an organization's invitations, newest first, optionally only those
+// with the given status
+func (r *organizationRepository) ListInvitations(ctx context.Context, orgID uuid.UUID, status string, limit, offset int) ([]models.OrganizationInvitation, error) {
+	invitations := []models.OrganizationInvitation{}
+	query := invitationColumns + `
+		WHERE i.organization_id = $1 AND ($2 = '' OR i.status = $2)
+		ORDER BY i.created_at DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	if err := r.db.SelectContext(ctx, &invitations, query, orgID, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list organization invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// ListPendingInvitationsByEmail lists the unexpired invitations waiting for an email address, newest first
+func (r *organizationRepository) ListPendingInvitationsByEmail(ctx context.Context, email string) ([]models.OrganizationInvitation, error) {
+	invitations := []models.OrganizationInvitation{}
+	query := invitationColumns + `
+		WHERE i.email = $1 AND i.status = 'pending' AND i.expires_at > NOW()
+		ORDER BY i.created_at DESC
+	`
+
+	if err := r.db.SelectContext(ctx, &invitations, query, email); err != nil {
+		return nil, fmt.Errorf("failed to list organization invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// UpdateInvitation saves an invitation's status
+func (r *organizationRepository) UpdateInvitation(ctx context.Context, invitation *models.OrganizationInvitation) error {
+	query := `
+		UPDATE organization_invitations
+		SET status = $2, responded_at = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query, invitation.ID, invitation.Status, invitation.RespondedAt).Scan(&invitation.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update organization invitation: %w", err)
+	}
+
+	return nil
+}
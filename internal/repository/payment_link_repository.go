@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// PaymentLinkRepository stores public payment links and what was paid through them
+type PaymentLinkRepository interface {
+	Create(ctx context.Context, link *models.PaymentLink) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.PaymentLink, error)
+	FindByCode(ctx context.Context, code string) (*models.PaymentLink, error)
+	ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.PaymentLink, error)
+	Deactivate(ctx context.Context, id uuid.UUID) error
+	RecordPayment(ctx context.Context, id uuid.UUID, amount int64) error
+}
+
+type paymentLinkRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewPaymentLinkRepository(db DBTX, logger *slog.Logger) PaymentLinkRepository {
+	return &paymentLinkRepository{db: db, logger: logger}
+}
+
+// Create stores a new active payment link
+func (r *paymentLinkRepository) Create(ctx context.Context, link *models.PaymentLink) error {
+	query := `
+		INSERT INTO payment_links (user_id, code, amount, description, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, is_active, payment_count, total_received, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		link.UserID,
+		link.Code,
+		link.Amount,
+		link.Description,
+		link.ExpiresAt,
+	).Scan(&link.ID, &link.IsActive, &link.PaymentCount, &link.TotalReceived, &link.CreatedAt, &link.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create payment link: %w", err)
+	}
+
+	r.logger.Debug("Payment link created", "payment_link_id", link.ID, "user_id", link.UserID)
+	return nil
+}
+
+// FindByID finds a payment link
+func (r *paymentLinkRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.PaymentLink, error) {
+	return r.find(ctx, `SELECT * FROM payment_links WHERE id = $1`, id)
+}
+
+// FindByCode finds a payment link by the code in its URL
+func (r *paymentLinkRepository) FindByCode(ctx context.Context, code string) (*models.PaymentLink, error) {
+	return r.find(ctx, `SELECT * FROM payment_links WHERE code = $1`, code)
+}
+
+func (r *paymentLinkRepository) find(ctx context.Context, query string, arg interface{}) (*models.PaymentLink, error) {
+	var link models.PaymentLink
+
+	err := r.db.GetContext(ctx, &link, query, arg)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find payment link: %w", err)
+	}
+
+	return &link, nil
+}
+
+// ListByUser lists a user's payment links, newest first
+func (r *paymentLinkRepository) ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.PaymentLink, error) {
+	links := []models.PaymentLink{}
+	query := `
+		SELECT * FROM payment_links
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	if err := r.db.SelectContext(ctx, &links, query, userID, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list payment links: %w", err)
+	}
+
+	return links, nil
+}
+
+// Deactivate stops a payment link from accepting new payments
+func (r *paymentLinkRepository) Deactivate(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE payment_links SET is_active = false, updated_at = NOW() WHERE id = $1`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to deactivate payment link: %w", err)
+	}
+
+	return nil
+}
+
+// RecordPayment adds a settled payment to the link's totals
+func (r *paymentLinkRepository) RecordPayment(ctx context.Context, id uuid.UUID, amount int64) error {
+	query := `
+		UPDATE payment_links
+		SET payment_count = payment_count + 1, total_received = total_received + $2, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, id, amount); err != nil {
+		return fmt.Errorf("failed to record payment link payment: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// PaymentMethodRepository stores users' saved cards
+type PaymentMethodRepository interface {
+	Upsert(ctx context.Context, method *models.PaymentMethod) (bool, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*models.PaymentMethod, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethod, error)
+	MarkUsed(ctx context.Context, id uuid.UUID) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type paymentMethodRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewPaymentMethodRepository(db DBTX, logger *slog.Logger) PaymentMethodRepository {
+	return &paymentMethodRepository{db: db, logger: logger}
+}
+
+// Upsert saves a card, or refreshes the user's existing card with the same signature (a new
+// authorization code, expiry or reusable flag). It returns true if the card is new.
+func (r *paymentMethodRepository) Upsert(ctx context.Context, method *models.PaymentMethod) (bool, error) {
+	query := `
+		INSERT INTO payment_methods
+			(user_id, provider, authorization_code, signature, email, last4, brand, card_type, bank, exp_month, exp_year, reusable)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (user_id, provider, signature) DO UPDATE
+		SET authorization_code = EXCLUDED.authorization_code,
+			email = EXCLUDED.email,
+			exp_month = EXCLUDED.exp_month,
+			exp_year = EXCLUDED.exp_year,
+			reusable = EXCLUDED.reusable,
+			updated_at = NOW()
+		RETURNING id, created_at, updated_at, (xmax = 0) AS inserted
+	`
+
+	var inserted bool
+	err := r.db.QueryRowxContext(ctx, query,
+		method.UserID,
+		method.Provider,
+		method.AuthorizationCode,
+		method.Signature,
+		method.Email,
+		method.Last4,
+		method.Brand,
+		method.CardType,
+		method.Bank,
+		method.ExpMonth,
+		method.ExpYear,
+		method.Reusable,
+	).Scan(&method.ID, &method.CreatedAt, &method.UpdatedAt, &inserted)
+	if err != nil {
+		return false, fmt.Errorf("failed to save payment method: %w", err)
+	}
+
+	return inserted, nil
+}
+
+// FindByID finds a saved card
+func (r *paymentMethodRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.PaymentMethod, error) {
+	var method models.PaymentMethod
+	query := `SELECT * FROM payment_methods WHERE id = $1`
+
+	err := r.db.GetContext(ctx, &method, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find payment method: %w", err)
+	}
+
+	return &method, nil
+}
+
+// ListByUser lists a user's saved cards, most recently saved first
+func (r *paymentMethodRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethod, error) {
+	methods := []models.PaymentMethod{}
+	query := `SELECT * FROM payment_methods WHERE user_id = $1 ORDER BY created_at DESC`
+
+	if err := r.db.SelectContext(ctx, &methods, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to list payment methods: %w", err)
+	}
+
+	return methods, nil
+}
+
+// MarkUsed records that the card was just charged
+func (r *paymentMethodRepository) MarkUsed(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE payment_methods SET last_used_at = NOW(), updated_at = NOW() WHERE id = $1`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to update payment method: %w", err)
+	}
+
+	return nil
+}
+
+// Delete forgets a saved card
+func (r *paymentMethodRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM payment_methods WHERE id = $1`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to delete payment method: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// paymentRequestColumns reads a pending request past its expiry as expired, so callers
+// never see a stale pending status
+const paymentRequestColumns = `
+	id, requester_user_id, requester_wallet_number, payer_user_id, payer_wallet_number,
+	payer_email, amount, memo, expires_at, transfer_reference, responded_at, created_at, updated_at,
+	CASE WHEN status = 'pending' AND expires_at <= NOW() THEN 'expired' ELSE status END AS status
+`
+
+// PaymentRequestRepository stores requests for money and their outcome
+type PaymentRequestRepository interface {
+	Create(ctx context.Context, request *models.PaymentRequest) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.PaymentRequest, error)
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.PaymentRequest, error)
+	ListByRequester(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.PaymentRequest, error)
+	ListByPayer(ctx context.Context, userID uuid.UUID, email, status string, limit, offset int) ([]models.PaymentRequest, error)
+	Update(ctx context.Context, request *models.PaymentRequest) error
+}
+
+type paymentRequestRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewPaymentRequestRepository(db DBTX, logger *slog.Logger) PaymentRequestRepository {
+	return &paymentRequestRepository{db: db, logger: logger}
+}
+
+// Create stores a new pending payment request
+func (r *paymentRequestRepository) Create(ctx context.Context, request *models.PaymentRequest) error {
+	query := `
+		INSERT INTO payment_requests
+			(requester_user_id, requester_wallet_number, payer_user_id, payer_wallet_number,
+			 payer_email, amount, memo, status, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		request.RequesterUserID,
+		request.RequesterWalletNumber,
+		request.PayerUserID,
+		request.PayerWalletNumber,
+		request.PayerEmail,
+		request.Amount,
+		request.Memo,
+		request.Status,
+		request.ExpiresAt,
+	).Scan(&request.ID, &request.CreatedAt, &request.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create payment request: %w", err)
+	}
+
+	r.logger.Debug("Payment request created", "payment_request_id", request.ID, "requester_user_id", request.RequesterUserID)
+	return nil
+}
+
+// FindByID finds a payment request
+func (r *paymentRequestRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.PaymentRequest, error) {
+	return r.find(ctx, `SELECT `+paymentRequestColumns+` FROM payment_requests WHERE id = $1`, id)
+}
+
+// FindByIDForUpdate finds a payment request and row-locks it until the surrounding
+// transaction ends, so it is answered at most once
+func (r *paymentRequestRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.PaymentRequest, error) {
+	return r.find(ctx, `SELECT `+paymentRequestColumns+` FROM payment_requests WHERE id = $1 FOR UPDATE`, id)
+}
+
+func (r *paymentRequestRepository) find(ctx context.Context, query string, id uuid.UUID) (*models.PaymentRequest, error) {
+	var request models.PaymentRequest
+
+	err := r.db.GetContext(ctx, &request, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find payment request: %w", err)
+	}
+
+	return &request, nil
+}
+
+// ListByRequester lists the requests a user sent, newest first, optionally only those
+// with the given status
+func (r *paymentRequestRepository) ListByRequester(ctx context.Context, userID uuid.UUID, status string, limit, offset int) ([]models.PaymentRequest, error) {
+	requests := []models.PaymentRequest{}
+	query := `
+		SELECT * FROM (SELECT ` + paymentRequestColumns + ` FROM payment_requests WHERE requester_user_id = $1) r
+		WHERE $2 = '' OR status = $2
+		ORDER BY created_at DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	if err := r.db.SelectContext(ctx, &requests, query, userID, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list payment requests: %w", err)
+	}
+
+	return requests, nil
+}
+
+// ListByPayer lists the requests addressed to a user, newest first, including those sent
+// to their email before they signed up, optionally only those with the given status
+func (r *paymentRequestRepository) ListByPayer(ctx context.Context, userID uuid.UUID, email, status string, limit, offset int) ([]models.PaymentRequest, error) {
+	requests := []models.PaymentRequest{}
+	query := `
+		SELECT * FROM (
+			SELECT ` + paymentRequestColumns + ` FROM payment_requests
+			WHERE payer_user_id = $1 OR (payer_user_id IS NULL AND payer_email = $2)
+		) r
+		WHERE $3 = '' OR status = $3
+		ORDER BY created_at DESC
+		LIMIT $4 OFFSET $5
+	`
+
+	if err := r.db.SelectContext(ctx, &requests, query, userID, email, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list payment requests: %w", err)
+	}
+
+	return requests, nil
+}
+
+// Update saves a payment request's answer
+func (r *paymentRequestRepository) Update(ctx context.Context, request *models.PaymentRequest) error {
+	query := `
+		UPDATE payment_requests
+		SET status = $2,
+			payer_user_id = $3,
+			transfer_reference = $4,
+			responded_at = $5,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		request.ID,
+		request.Status,
+		request.PayerUserID,
+		request.TransferReference,
+		request.RespondedAt,
+	).Scan(&request.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update payment request: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// ScheduledTransferRepository stores standing orders and the outcome of each run
+type ScheduledTransferRepository interface {
+	Create(ctx context.Context, transfer *models.ScheduledTransfer) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.ScheduledTransfer, error)
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.ScheduledTransfer, error)
+	ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.ScheduledTransfer, error)
+	ListDue(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error)
+	Update(ctx context.Context, transfer *models.ScheduledTransfer) error
+	CreateRun(ctx context.Context, run *models.ScheduledTransferRun) error
+	ListRuns(ctx context.Context, scheduledTransferID uuid.UUID, limit int) ([]models.ScheduledTransferRun, error)
+}
+
+type scheduledTransferRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewScheduledTransferRepository(db DBTX, logger *slog.Logger) ScheduledTransferRepository {
+	return &scheduledTransferRepository{db: db, logger: logger}
+}
+
+// Create stores a new scheduled transfer
+func (r *scheduledTransferRepository) Create(ctx context.Context, transfer *models.ScheduledTransfer) error {
+	query := `
+		INSERT INTO scheduled_transfers
+			(user_id, wallet_id, from_wallet_number, recipient_wallet_number, amount, description,
+			 cron_expr, interval_spec, start_at, end_at, max_occurrences, status, occurrence_at, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id, occurrences, failure_count, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		transfer.UserID,
+		transfer.WalletID,
+		transfer.FromWalletNumber,
+		transfer.RecipientWalletNumber,
+		transfer.Amount,
+		transfer.Description,
+		transfer.Cron,
+		transfer.Interval,
+		transfer.StartAt,
+		transfer.EndAt,
+		transfer.MaxOccurrences,
+		transfer.Status,
+		transfer.OccurrenceAt,
+		transfer.NextRunAt,
+	).Scan(&transfer.ID, &transfer.Occurrences, &transfer.FailureCount, &transfer.CreatedAt, &transfer.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create scheduled transfer: %w", err)
+	}
+
+	r.logger.Debug("Scheduled transfer created", "scheduled_transfer_id", transfer.ID, "user_id", transfer.UserID)
+	return nil
+}
+
+// FindByID finds a scheduled transfer
+func (r *scheduledTransferRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.ScheduledTransfer, error) {
+	return r.find(ctx, `SELECT * FROM scheduled_transfers WHERE id = $1`, id)
+}
+
+// FindByIDForUpdate finds a scheduled transfer and row-locks it until the surrounding
+// transaction ends, so a run and a pause or cancel can't interleave
+func (r *scheduledTransferRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.ScheduledTransfer, error) {
+	return r.find(ctx, `SELECT * FROM scheduled_transfers WHERE id = $1 FOR UPDATE`, id)
+}
+
+func (r *scheduledTransferRepository) find(ctx context.Context, query string, id uuid.UUID) (*models.ScheduledTransfer, error) {
+	var transfer models.ScheduledTransfer
+
+	err := r.db.GetContext(ctx, &transfer, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find scheduled transfer: %w", err)
+	}
+
+	return &transfer, nil
+}
+
+// ListByUser lists a user's scheduled transfers, newest first
+func (r *scheduledTransferRepository) ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.ScheduledTransfer, error) {
+	transfers := []models.ScheduledTransfer{}
+	query := `
+		SELECT * FROM scheduled_transfers
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	if err := r.db.SelectContext(ctx, &transfers, query, userID, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list scheduled transfers: %w", err)
+	}
+
+	return transfers, nil
+}
+
+// ListDue returns the IDs of active scheduled transfers due at now, longest overdue first
+func (r *scheduledTransferRepository) ListDue(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	query := `
+		SELECT id FROM scheduled_transfers
+		WHERE status = 'active' AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT $2
+	`
+
+	if err := r.db.SelectContext(ctx, &ids, query, now, limit); err != nil {
+		return nil, fmt.Errorf("failed to list due scheduled transfers: %w", err)
+	}
+
+	return ids, nil
+}
+
+// Update saves a scheduled transfer's progress and status
+func (r *scheduledTransferRepository) Update(ctx context.Context, transfer *models.ScheduledTransfer) error {
+	query := `
+		UPDATE scheduled_transfers
+		SET occurrences = $2,
+			status = $3,
+			pause_reason = $4,
+			occurrence_at = $5,
+			next_run_at = $6,
+			failure_count = $7,
+			last_run_at = $8,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		transfer.ID,
+		transfer.Occurrences,
+		transfer.Status,
+		transfer.PauseReason,
+		transfer.OccurrenceAt,
+		transfer.NextRunAt,
+		transfer.FailureCount,
+		transfer.LastRunAt,
+	).Scan(&transfer.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update scheduled transfer: %w", err)
+	}
+
+	return nil
+}
+
+// CreateRun records the outcome of an attempt
+func (r *scheduledTransferRepository) CreateRun(ctx context.Context, run *models.ScheduledTransferRun) error {
+	query := `
+		INSERT INTO scheduled_transfer_runs
+			(scheduled_transfer_id, occurrence_at, status, reference, error_code, error_message)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		run.ScheduledTransferID,
+		run.OccurrenceAt,
+		run.Status,
+		run.Reference,
+		run.ErrorCode,
+		run.ErrorMessage,
+	).Scan(&run.ID, &run.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record scheduled transfer run: %w", err)
+	}
+
+	return nil
+}
+
+// ListRuns lists a scheduled transfer's most recent runs, newest first
+func (r *scheduledTransferRepository) ListRuns(ctx context.Context, scheduledTransferID uuid.UUID, limit int) ([]models.ScheduledTransferRun, error) {
+	runs := []models.ScheduledTransferRun{}
+	query := `
+		SELECT * FROM scheduled_transfer_runs
+		WHERE scheduled_transfer_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	if err := r.db.SelectContext(ctx, &runs, query, scheduledTransferID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list scheduled transfer runs: %w", err)
+	}
+
+	return runs, nil
+}
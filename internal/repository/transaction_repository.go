@@ -1,40 +1,53 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/franzego/stage08/internal/models"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 )
 
-type TransactionRepository struct {
-	db *sqlx.DB
+// TransactionRepository stores wallet transactions
+type TransactionRepository interface {
+	Create(ctx context.Context, tx *models.Transaction) error
+	FindByReference(ctx context.Context, reference string) (*models.Transaction, error)
+	FindByReferenceForUpdate(ctx context.Context, reference string) (*models.Transaction, error)
+	UpdateStatus(ctx context.Context, id uuid.UUID, status models.TransactionStatus) error
+	TransitionStatus(ctx context.Context, id uuid.UUID, from, to models.TransactionStatus) (bool, error)
+	UpdateStatusAndMetadata(ctx context.Context, id uuid.UUID, status models.TransactionStatus, metadata []byte) error
+	ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Transaction, error)
+	ListByWallet(ctx context.Context, walletID uuid.UUID, limit, offset int) ([]models.Transaction, error)
 }
 
-func NewTransactionRepository(db *sqlx.DB) *TransactionRepository {
-	return &TransactionRepository{db: db}
+type transactionRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewTransactionRepository(db DBTX, logger *slog.Logger) TransactionRepository {
+	return &transactionRepository{db: db, logger: logger}
 }
 
 // Create creates a new transaction
-func (r *TransactionRepository) Create(tx *models.Transaction) error {
+func (r *transactionRepository) Create(ctx context.Context, tx *models.Transaction) error {
 	query := `
-		INSERT INTO transactions (user_id, wallet_id, type, amount, status, reference, description, metadata)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO transactions (user_id, wallet_id, type, amount, status, reference, description, provider, metadata, reversal_of)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id, created_at, updated_at
 	`
 
-	// Handle nil metadata - pass NULL to database
+	// Handle nil metadata - pass NULL to database. JSON is sent as text,
+	// since lib/pq would encode []byte as bytea.
 	var metadata interface{}
-	if tx.Metadata != nil && len(tx.Metadata) > 0 {
-		metadata = tx.Metadata
-	} else {
-		metadata = nil
+	if len(tx.Metadata) > 0 {
+		metadata = string(tx.Metadata)
 	}
 
-	err := r.db.QueryRowx(query,
+	err := r.db.QueryRowxContext(ctx, query,
 		tx.UserID,
 		tx.WalletID,
 		tx.Type,
@@ -42,7 +55,9 @@ func (r *TransactionRepository) Create(tx *models.Transaction) error {
 		tx.Status,
 		tx.Reference,
 		tx.Description,
+		tx.Provider,
 		metadata,
+		tx.ReversalOf,
 	).Scan(&tx.ID, &tx.CreatedAt, &tx.UpdatedAt)
 
 	if err != nil {
@@ -53,11 +68,11 @@ func (r *TransactionRepository) Create(tx *models.Transaction) error {
 }
 
 // FindByReference finds a transaction by reference
-func (r *TransactionRepository) FindByReference(reference string) (*models.Transaction, error) {
+func (r *transactionRepository) FindByReference(ctx context.Context, reference string) (*models.Transaction, error) {
 	var tx models.Transaction
 	query := `SELECT * FROM transactions WHERE reference = $1`
 
-	err := r.db.Get(&tx, query, reference)
+	err := r.db.GetContext(ctx, &tx, query, reference)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -68,18 +83,75 @@ func (r *TransactionRepository) FindByReference(reference string) (*models.Trans
 	return &tx, nil
 }
 
+// FindByReferenceForUpdate finds a transaction by reference and locks its row until the
+// surrounding database transaction ends. It must run inside TxManager.WithinTx.
+func (r *transactionRepository) FindByReferenceForUpdate(ctx context.Context, reference string) (*models.Transaction, error) {
+	var tx models.Transaction
+	query := `SELECT * FROM transactions WHERE reference = $1 FOR UPDATE`
+
+	err := r.db.GetContext(ctx, &tx, query, reference)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock transaction: %w", err)
+	}
+
+	return &tx, nil
+}
+
 // UpdateStatus updates transaction status
-func (r *TransactionRepository) UpdateStatus(id uuid.UUID, status models.TransactionStatus) error {
+func (r *transactionRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status models.TransactionStatus) error {
 	query := `UPDATE transactions SET status = $1, updated_at = NOW() WHERE id = $2`
-	_, err := r.db.Exec(query, status, id)
+	_, err := r.db.ExecContext(ctx, query, status, id)
 	if err != nil {
 		return fmt.Errorf("failed to update transaction status: %w", err)
 	}
+	r.logger.Debug("Transaction status updated", "transaction_id", id, "status", status)
+	return nil
+}
+
+// TransitionStatus moves a transaction from one status to another and reports whether it did.
+// Nothing changes if the transaction is no longer in the from status, so of several concurrent
+// callers only one applies the transition.
+func (r *transactionRepository) TransitionStatus(ctx context.Context, id uuid.UUID, from, to models.TransactionStatus) (bool, error) {
+	query := `UPDATE transactions SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3`
+	result, err := r.db.ExecContext(ctx, query, to, id, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to update transaction status: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update transaction status: %w", err)
+	}
+
+	if rows > 0 {
+		r.logger.Debug("Transaction status updated", "transaction_id", id, "from", from, "to", to)
+	}
+	return rows > 0, nil
+}
+
+// UpdateStatusAndMetadata sets the status and merges metadata (a JSON object) into the
+// transaction's existing metadata
+func (r *transactionRepository) UpdateStatusAndMetadata(ctx context.Context, id uuid.UUID, status models.TransactionStatus, metadata []byte) error {
+	query := `
+		UPDATE transactions
+		SET status = $1, metadata = COALESCE(metadata, '{}'::jsonb) || $2::jsonb, updated_at = NOW()
+		WHERE id = $3
+	`
+
+	// JSON is sent as text, since lib/pq would encode []byte as bytea
+	if _, err := r.db.ExecContext(ctx, query, status, string(metadata), id); err != nil {
+		return fmt.Errorf("failed to update transaction: %w", err)
+	}
+
+	r.logger.Debug("Transaction status updated", "transaction_id", id, "status", status)
 	return nil
 }
 
 // ListByUser lists all transactions for a user
-func (r *TransactionRepository) ListByUser(userID uuid.UUID, limit, offset int) ([]models.Transaction, error) {
+func (r *transactionRepository) ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Transaction, error) {
 	var transactions []models.Transaction
 	query := `
 		SELECT * FROM transactions 
@@ -88,7 +160,25 @@ func (r *TransactionRepository) ListByUser(userID uuid.UUID, limit, offset int)
 		LIMIT $2 OFFSET $3
 	`
 
-	err := r.db.Select(&transactions, query, userID, limit, offset)
+	err := r.db.SelectContext(ctx, &transactions, query, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// ListByWallet lists the transactions of one wallet, newest first
+func (r *transactionRepository) ListByWallet(ctx context.Context, walletID uuid.UUID, limit, offset int) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	query := `
+		SELECT * FROM transactions
+		WHERE wallet_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	err := r.db.SelectContext(ctx, &transactions, query, walletID, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list transactions: %w", err)
 	}
@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// TransferBatchRepository stores bulk transfer uploads and the outcome of each line
+type TransferBatchRepository interface {
+	Create(ctx context.Context, batch *models.TransferBatch) error
+	CreateItem(ctx context.Context, item *models.TransferBatchItem) error
+	FindByID(ctx context.Context, id uuid.UUID) (*models.TransferBatch, error)
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.TransferBatch, error)
+	ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.TransferBatch, error)
+	ListItems(ctx context.Context, batchID uuid.UUID, status models.TransferBatchItemStatus, limit, offset int) ([]models.TransferBatchItem, error)
+	FindItemForUpdate(ctx context.Context, id uuid.UUID) (*models.TransferBatchItem, error)
+	UpdateItem(ctx context.Context, item *models.TransferBatchItem) error
+	AddProgress(ctx context.Context, batchID uuid.UUID, succeeded, failed int, succeededAmount, refundedAmount int64) error
+	Claim(ctx context.Context, lease time.Duration) (*models.TransferBatch, error)
+	Renew(ctx context.Context, id uuid.UUID, lease time.Duration) error
+	Complete(ctx context.Context, id uuid.UUID, status models.TransferBatchStatus) (bool, error)
+}
+
+type transferBatchRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewTransferBatchRepository(db DBTX, logger *slog.Logger) TransferBatchRepository {
+	return &transferBatchRepository{db: db, logger: logger}
+}
+
+// Create stores a new batch
+func (r *transferBatchRepository) Create(ctx context.Context, batch *models.TransferBatch) error {
+	query := `
+		INSERT INTO transfer_batches
+			(user_id, wallet_id, mode, status, total_items, total_amount, failed_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		batch.UserID,
+		batch.WalletID,
+		batch.Mode,
+		batch.Status,
+		batch.TotalItems,
+		batch.TotalAmount,
+		batch.FailedCount,
+	).Scan(&batch.ID, &batch.CreatedAt, &batch.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create transfer batch: %w", err)
+	}
+
+	r.logger.Debug("Transfer batch created", "batch_id", batch.ID, "user_id", batch.UserID)
+	return nil
+}
+
+// CreateItem stores one line of a batch
+func (r *transferBatchRepository) CreateItem(ctx context.Context, item *models.TransferBatchItem) error {
+	query := `
+		INSERT INTO transfer_batch_items
+			(batch_id, line, recipient_wallet_number, amount, description, status,
+			 debit_transaction_id, reference, error_code, error_message, processed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		item.BatchID,
+		item.Line,
+		item.RecipientWalletNumber,
+		item.Amount,
+		item.Description,
+		item.Status,
+		item.DebitTransactionID,
+		item.Reference,
+		item.ErrorCode,
+		item.ErrorMessage,
+		item.ProcessedAt,
+	).Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create transfer batch item: %w", err)
+	}
+
+	return nil
+}
+
+// FindByID finds a batch
+func (r *transferBatchRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.TransferBatch, error) {
+	return r.find(ctx, `SELECT * FROM transfer_batches WHERE id = $1`, id)
+}
+
+// FindByIDForUpdate finds a batch and row-locks it until the surrounding transaction ends
+func (r *transferBatchRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.TransferBatch, error) {
+	return r.find(ctx, `SELECT * FROM transfer_batches WHERE id = $1 FOR UPDATE`, id)
+}
+
+func (r *transferBatchRepository) find(ctx context.Context, query string, id uuid.UUID) (*models.TransferBatch, error) {
+	var batch models.TransferBatch
+
+	err := r.db.GetContext(ctx, &batch, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find transfer batch: %w", err)
+	}
+
+	return &batch, nil
+}
+
+// ListByUser lists a user's batches, newest first
+func (r *transferBatchRepository) ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.TransferBatch, error) {
+	batches := []models.TransferBatch{}
+	query := `
+		SELECT * FROM transfer_batches
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	if err := r.db.SelectContext(ctx, &batches, query, userID, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list transfer batches: %w", err)
+	}
+
+	return batches, nil
+}
+
+// ListItems lists a batch's items in upload order. An empty status lists every item.
+func (r *transferBatchRepository) ListItems(ctx context.Context, batchID uuid.UUID, status models.TransferBatchItemStatus, limit, offset int) ([]models.TransferBatchItem, error) {
+	items := []models.TransferBatchItem{}
+	query := `
+		SELECT * FROM transfer_batch_items
+		WHERE batch_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY line
+		LIMIT $3 OFFSET $4
+	`
+
+	if err := r.db.SelectContext(ctx, &items, query, batchID, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list transfer batch items: %w", err)
+	}
+
+	return items, nil
+}
+
+// FindItemForUpdate finds an item and row-locks it until the surrounding transaction ends,
+// so two workers can't pay it twice
+func (r *transferBatchRepository) FindItemForUpdate(ctx context.Context, id uuid.UUID) (*models.TransferBatchItem, error) {
+	var item models.TransferBatchItem
+	query := `SELECT * FROM transfer_batch_items WHERE id = $1 FOR UPDATE`
+
+	err := r.db.GetContext(ctx, &item, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find transfer batch item: %w", err)
+	}
+
+	return &item, nil
+}
+
+// UpdateItem saves an item's outcome
+func (r *transferBatchRepository) UpdateItem(ctx context.Context, item *models.TransferBatchItem) error {
+	query := `
+		UPDATE transfer_batch_items
+		SET status = $2,
+			reference = $3,
+			error_code = $4,
+			error_message = $5,
+			processed_at = $6,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		item.ID,
+		item.Status,
+		item.Reference,
+		item.ErrorCode,
+		item.ErrorMessage,
+		item.ProcessedAt,
+	).Scan(&item.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update transfer batch item: %w", err)
+	}
+
+	return nil
+}
+
+// AddProgress adds item outcomes to the batch's counters
+func (r *transferBatchRepository) AddProgress(ctx context.Context, batchID uuid.UUID, succeeded, failed int, succeededAmount, refundedAmount int64) error {
+	query := `
+		UPDATE transfer_batches
+		SET succeeded_count = succeeded_count + $2,
+			failed_count = failed_count + $3,
+			succeeded_amount = succeeded_amount + $4,
+			refunded_amount = refunded_amount + $5,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, batchID, succeeded, failed, succeededAmount, refundedAmount); err != nil {
+		return fmt.Errorf("failed to update transfer batch progress: %w", err)
+	}
+
+	return nil
+}
+
+// Claim claims the oldest unfinished batch nobody holds a lease on, marking it processing
+// for lease. It returns nil if there is none.
+func (r *transferBatchRepository) Claim(ctx context.Context, lease time.Duration) (*models.TransferBatch, error) {
+	var batch models.TransferBatch
+	query := `
+		UPDATE transfer_batches
+		SET status = 'processing', claimed_until = NOW() + $1::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM transfer_batches
+			WHERE status IN ('pending', 'processing') AND (claimed_until IS NULL OR claimed_until <= NOW())
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+
+	err := r.db.GetContext(ctx, &batch, query, lease.Milliseconds())
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim transfer batch: %w", err)
+	}
+
+	return &batch, nil
+}
+
+// Renew extends the lease on a claimed batch
+func (r *transferBatchRepository) Renew(ctx context.Context, id uuid.UUID, lease time.Duration) error {
+	query := `
+		UPDATE transfer_batches
+		SET claimed_until = NOW() + $2::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id = $1
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, id, lease.Milliseconds()); err != nil {
+		return fmt.Errorf("failed to renew transfer batch lease: %w", err)
+	}
+
+	return nil
+}
+
+// Complete records a batch's final status and releases its lease, and reports whether it
+// did. Nothing changes unless the batch is processing, so a batch is completed only once.
+func (r *transferBatchRepository) Complete(ctx context.Context, id uuid.UUID, status models.TransferBatchStatus) (bool, error) {
+	query := `
+		UPDATE transfer_batches
+		SET status = $2, claimed_until = NULL, completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'processing'
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id, status)
+	if err != nil {
+		return false, fmt.Errorf("failed to complete transfer batch: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to complete transfer batch: %w", err)
+	}
+
+	return rows > 0, nil
+}
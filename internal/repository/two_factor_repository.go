@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// TwoFactorRepository stores two-factor enrollments and recovery codes
+type TwoFactorRepository interface {
+	FindByUserID(ctx context.Context, userID uuid.UUID) (*models.UserTwoFactor, error)
+	UpsertPending(ctx context.Context, userID uuid.UUID, secret string) error
+	Enable(ctx context.Context, userID uuid.UUID, step int64, recoveryCodes []string) error
+	Disable(ctx context.Context, userID uuid.UUID) error
+	MarkStepUsed(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codes []string) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, code string) (bool, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error)
+	ReserveAttempt(ctx context.Context, userID uuid.UUID, maxAttempts int, lockout time.Duration) (bool, error)
+	ResetAttempts(ctx context.Context, userID uuid.UUID) error
+	UseChallenge(ctx context.Context, id, userID uuid.UUID, expiresAt time.Time) (bool, error)
+}
+
+type twoFactorRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewTwoFactorRepository(db DBTX, logger *slog.Logger) TwoFactorRepository {
+	return &twoFactorRepository{db: db, logger: logger}
+}
+
+// FindByUserID finds a user's 2FA enrollment
+func (r *twoFactorRepository) FindByUserID(ctx context.Context, userID uuid.UUID) (*models.UserTwoFactor, error) {
+	var tf models.UserTwoFactor
+	query := `SELECT * FROM user_two_factor WHERE user_id = $1`
+
+	err := r.db.GetContext(ctx, &tf, query, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find two-factor enrollment: %w", err)
+	}
+
+	return &tf, nil
+}
+
+// UpsertPending stores a new unconfirmed secret, replacing any previous unconfirmed one
+func (r *twoFactorRepository) UpsertPending(ctx context.Context, userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO user_two_factor (user_id, secret, enabled)
+		VALUES ($1, $2, false)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, updated_at = NOW()
+		WHERE user_two_factor.enabled = false
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to store two-factor secret: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	return nil
+}
+
+// Enable confirms the enrollment and replaces the user's recovery codes
+func (r *twoFactorRepository) Enable(ctx context.Context, userID uuid.UUID, step int64, recoveryCodes []string) error {
+	return withTx(ctx, r.db, func(tx DBTX) error {
+		query := `
+			UPDATE user_two_factor
+			SET enabled = true, last_used_step = $2, confirmed_at = NOW(), updated_at = NOW()
+			WHERE user_id = $1 AND enabled = false
+		`
+		result, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return fmt.Errorf("failed to enable two-factor: %w", err)
+		}
+
+		rows, _ := result.RowsAffected()
+		if rows == 0 {
+			return fmt.Errorf("no pending two-factor enrollment")
+		}
+
+		if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// Disable removes the enrollment and all recovery codes
+func (r *twoFactorRepository) Disable(ctx context.Context, userID uuid.UUID) error {
+	return withTx(ctx, r.db, func(tx DBTX) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM two_factor_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_two_factor WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to disable two-factor: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// MarkStepUsed records an accepted TOTP time step.
+// It returns false if the step (or a later one) was already used, which means the code is being replayed.
+func (r *twoFactorRepository) MarkStepUsed(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	query := `
+		UPDATE user_two_factor
+		SET last_used_step = $2, updated_at = NOW()
+		WHERE user_id = $1 AND last_used_step < $2
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// ReplaceRecoveryCodes invalidates all existing recovery codes and stores new ones
+func (r *twoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codes []string) error {
+	return withTx(ctx, r.db, func(tx DBTX) error {
+		if err := replaceRecoveryCodes(ctx, tx, userID, codes); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// UseRecoveryCode consumes a recovery code. It returns false if the code is unknown or already used.
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, code string) (bool, error) {
+	query := `
+		UPDATE two_factor_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// CountUnusedRecoveryCodes counts the recovery codes a user has left
+func (r *twoFactorRepository) CountUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM two_factor_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	if err := r.db.GetContext(ctx, &count, query, userID); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReserveAttempt counts a second-factor attempt before the code is checked, so concurrent
+// guesses can't get past the limit. The attempt that reaches maxAttempts locks verification for
+// lockout. It returns false while verification is locked.
+func (r *twoFactorRepository) ReserveAttempt(ctx context.Context, userID uuid.UUID, maxAttempts int, lockout time.Duration) (bool, error) {
+	// A lock that has run out starts the count again
+	query := `
+		UPDATE user_two_factor
+		SET failed_attempts = CASE WHEN locked_until IS NULL THEN failed_attempts + 1 ELSE 1 END,
+		    locked_until = CASE
+		        WHEN (CASE WHEN locked_until IS NULL THEN failed_attempts + 1 ELSE 1 END) >= $2
+		        THEN NOW() + make_interval(secs => $3)
+		    END,
+		    updated_at = NOW()
+		WHERE user_id = $1 AND (locked_until IS NULL OR locked_until <= NOW())
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, maxAttempts, lockout.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("failed to record two-factor attempt: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// ResetAttempts clears the failed attempt count and any lock after a successful check
+func (r *twoFactorRepository) ResetAttempts(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE user_two_factor
+		SET failed_attempts = 0, locked_until = NULL, updated_at = NOW()
+		WHERE user_id = $1 AND (failed_attempts > 0 OR locked_until IS NOT NULL)
+	`
+	if _, err := r.db.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to reset two-factor attempts: %w", err)
+	}
+
+	return nil
+}
+
+// UseChallenge records that a login challenge token was used.
+// It returns false if the token was used before.
+func (r *twoFactorRepository) UseChallenge(ctx context.Context, id, userID uuid.UUID, expiresAt time.Time) (bool, error) {
+	// Expired tokens are refused anyway, so their rows are no longer needed
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM used_two_factor_challenges WHERE expires_at < NOW()`); err != nil {
+		return false, fmt.Errorf("failed to prune used challenges: %w", err)
+	}
+
+	query := `
+		INSERT INTO used_two_factor_challenges (id, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING
+	`
+	result, err := r.db.ExecContext(ctx, query, id, userID, expiresAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to record used challenge: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx DBTX, userID uuid.UUID, codes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM two_factor_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	query := `INSERT INTO two_factor_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, query, userID, hashRecoveryCode(code)); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// hashRecoveryCode creates a SHA256 hash of a recovery code
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
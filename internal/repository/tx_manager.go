@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// UnitOfWork hands out repositories bound to a single database transaction.
+// Everything done through it commits or rolls back together.
+type UnitOfWork struct {
+	Users              UserRepository
+	APIKeys            APIKeyRepository
+	Wallets            WalletRepository
+	Transactions       TransactionRepository
+	TwoFactor          TwoFactorRepository
+	Audit              AuditRepository
+	PaymentMethods     PaymentMethodRepository
+	ScheduledTransfers ScheduledTransferRepository
+	PaymentRequests    PaymentRequestRepository
+	PaymentLinks       PaymentLinkRepository
+	TransferBatches    TransferBatchRepository
+	Disputes           DisputeRepository
+	Escrows            EscrowRepository
+	Organizations      OrganizationRepository
+	WebhookEvents      WebhookEventRepository
+	Emails             EmailRepository
+}
+
+// TxManager runs work inside a database transaction
+type TxManager interface {
+	// WithinTx commits if fn returns nil and rolls back otherwise (including on panic)
+	WithinTx(ctx context.Context, fn func(uow *UnitOfWork) error) error
+}
+
+type txManager struct {
+	db     *sqlx.DB
+	logger *slog.Logger
+}
+
+func NewTxManager(db *sqlx.DB, logger *slog.Logger) TxManager {
+	return &txManager{db: db, logger: logger}
+}
+
+func (m *txManager) WithinTx(ctx context.Context, fn func(uow *UnitOfWork) error) error {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	uow := &UnitOfWork{
+		Users:              NewUserRepository(tx, m.logger),
+		APIKeys:            NewAPIKeyRepository(tx, m.logger),
+		Wallets:            NewWalletRepository(tx, m.logger),
+		Transactions:       NewTransactionRepository(tx, m.logger),
+		TwoFactor:          NewTwoFactorRepository(tx, m.logger),
+		Audit:              NewAuditRepository(tx, m.logger),
+		PaymentMethods:     NewPaymentMethodRepository(tx, m.logger),
+		ScheduledTransfers: NewScheduledTransferRepository(tx, m.logger),
+		PaymentRequests:    NewPaymentRequestRepository(tx, m.logger),
+		PaymentLinks:       NewPaymentLinkRepository(tx, m.logger),
+		TransferBatches:    NewTransferBatchRepository(tx, m.logger),
+		Disputes:           NewDisputeRepository(tx, m.logger),
+		Escrows:            NewEscrowRepository(tx, m.logger),
+		Organizations:      NewOrganizationRepository(tx, m.logger),
+		WebhookEvents:      NewWebhookEventRepository(tx, m.logger),
+		Emails:             NewEmailRepository(tx, m.logger),
+	}
+
+	if err := fn(uow); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
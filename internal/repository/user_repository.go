@@ -1,28 +1,38 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 
 	"github.com/franzego/stage08/internal/models"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 )
 
-type UserRepository struct {
-	db *sqlx.DB
+// UserRepository stores users
+type UserRepository interface {
+	FindByGoogleID(ctx context.Context, googleID string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	Create(ctx context.Context, googleID, email, name string, picture *string) (*models.User, error)
 }
 
-func NewUserRepository(db *sqlx.DB) *UserRepository {
-	return &UserRepository{db: db}
+type userRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewUserRepository(db DBTX, logger *slog.Logger) UserRepository {
+	return &userRepository{db: db, logger: logger}
 }
 
 // FindByGoogleID finds a user by their Google ID
-func (r *UserRepository) FindByGoogleID(googleID string) (*models.User, error) {
+func (r *userRepository) FindByGoogleID(ctx context.Context, googleID string) (*models.User, error) {
 	var user models.User
 	query := `SELECT * FROM users WHERE google_id = $1`
 
-	err := r.db.Get(&user, query, googleID)
+	err := r.db.GetContext(ctx, &user, query, googleID)
 	if err == sql.ErrNoRows {
 		return nil, nil // User not found
 	}
@@ -34,11 +44,11 @@ func (r *UserRepository) FindByGoogleID(googleID string) (*models.User, error) {
 }
 
 // FindByEmail finds a user by email
-func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
+func (r *userRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
 	query := `SELECT * FROM users WHERE email = $1`
 
-	err := r.db.Get(&user, query, email)
+	err := r.db.GetContext(ctx, &user, query, email)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -50,11 +60,11 @@ func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
 }
 
 // FindByID finds a user by ID
-func (r *UserRepository) FindByID(id uuid.UUID) (*models.User, error) {
+func (r *userRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	var user models.User
 	query := `SELECT * FROM users WHERE id = $1`
 
-	err := r.db.Get(&user, query, id)
+	err := r.db.GetContext(ctx, &user, query, id)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -66,14 +76,7 @@ func (r *UserRepository) FindByID(id uuid.UUID) (*models.User, error) {
 }
 
 // Create creates a new user and their wallet
-func (r *UserRepository) Create(googleID, email, name string, picture *string) (*models.User, error) {
-	tx, err := r.db.Beginx()
-	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
-	}
-	defer tx.Rollback()
-
-	// Create user
+func (r *userRepository) Create(ctx context.Context, googleID, email, name string, picture *string) (*models.User, error) {
 	user := &models.User{
 		GoogleID: googleID,
 		Email:    email,
@@ -81,32 +84,37 @@ func (r *UserRepository) Create(googleID, email, name string, picture *string) (
 		Picture:  picture,
 	}
 
-	query := `
-		INSERT INTO users (google_id, email, name, picture)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, updated_at
-	`
-
-	err = tx.QueryRowx(query, googleID, email, name, picture).Scan(
-		&user.ID, &user.CreatedAt, &user.UpdatedAt,
-	)
+	err := withTx(ctx, r.db, func(tx DBTX) error {
+		// Create user
+		query := `
+			INSERT INTO users (google_id, email, name, picture)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, updated_at
+		`
+
+		err := tx.QueryRowxContext(ctx, query, googleID, email, name, picture).Scan(
+			&user.ID, &user.CreatedAt, &user.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		// Create the user's default wallet
+		walletQuery := `
+			INSERT INTO wallets (user_id, wallet_number, name, is_default)
+			VALUES ($1, generate_wallet_number(), 'Main', true)
+		`
+
+		if _, err := tx.ExecContext(ctx, walletQuery, user.ID); err != nil {
+			return fmt.Errorf("failed to create wallet: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
-	}
-
-	// Create wallet for the user
-	walletQuery := `
-		INSERT INTO wallets (user_id, wallet_number)
-		VALUES ($1, generate_wallet_number())
-	`
-
-	if _, err := tx.Exec(walletQuery, user.ID); err != nil {
-		return nil, fmt.Errorf("failed to create wallet: %w", err)
-	}
-
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, err
 	}
 
+	r.logger.Debug("User and wallet created", "user_id", user.ID)
 	return user, nil
 }
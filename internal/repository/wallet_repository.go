@@ -1,28 +1,50 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
 
 	"github.com/franzego/stage08/internal/models"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
-type WalletRepository struct {
-	db *sqlx.DB
+// ErrInsufficientBalance is returned by Debit when the wallet can't cover the amount
+var ErrInsufficientBalance = errors.New("insufficient balance or wallet not found")
+
+// WalletRepository stores wallets and their balances
+type WalletRepository interface {
+	FindByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	FindByUserID(ctx context.Context, userID uuid.UUID) (*models.Wallet, error)
+	ListByUserID(ctx context.Context, userID uuid.UUID) ([]models.Wallet, error)
+	FindByWalletNumber(ctx context.Context, walletNumber string) (*models.Wallet, error)
+	FindByWalletNumbers(ctx context.Context, walletNumbers []string) ([]models.Wallet, error)
+	Create(ctx context.Context, wallet *models.Wallet) error
+	SetDefault(ctx context.Context, userID, walletID uuid.UUID) error
+	UpdateBalance(ctx context.Context, walletID uuid.UUID, newBalance int64) error
+	Credit(ctx context.Context, walletID uuid.UUID, amount int64) error
+	Debit(ctx context.Context, walletID uuid.UUID, amount int64) error
+	LockForUpdate(ctx context.Context, walletIDs ...uuid.UUID) error
 }
 
-func NewWalletRepository(db *sqlx.DB) *WalletRepository {
-	return &WalletRepository{db: db}
+type walletRepository struct {
+	db     DBTX
+	logger *slog.Logger
 }
 
-// FindByUserID finds a wallet by user ID
-func (r *WalletRepository) FindByUserID(userID uuid.UUID) (*models.Wallet, error) {
+func NewWalletRepository(db DBTX, logger *slog.Logger) WalletRepository {
+	return &walletRepository{db: db, logger: logger}
+}
+
+// FindByID finds a wallet by ID
+func (r *walletRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
 	var wallet models.Wallet
-	query := `SELECT * FROM wallets WHERE user_id = $1`
+	query := `SELECT * FROM wallets WHERE id = $1`
 
-	err := r.db.Get(&wallet, query, userID)
+	err := r.db.GetContext(ctx, &wallet, query, id)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -33,12 +55,12 @@ func (r *WalletRepository) FindByUserID(userID uuid.UUID) (*models.Wallet, error
 	return &wallet, nil
 }
 
-// FindByWalletNumber finds a wallet by wallet number
-func (r *WalletRepository) FindByWalletNumber(walletNumber string) (*models.Wallet, error) {
+// FindByUserID finds a user's default wallet
+func (r *walletRepository) FindByUserID(ctx context.Context, userID uuid.UUID) (*models.Wallet, error) {
 	var wallet models.Wallet
-	query := `SELECT * FROM wallets WHERE wallet_number = $1`
+	query := `SELECT * FROM wallets WHERE user_id = $1 AND is_default`
 
-	err := r.db.Get(&wallet, query, walletNumber)
+	err := r.db.GetContext(ctx, &wallet, query, userID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -49,10 +71,93 @@ func (r *WalletRepository) FindByWalletNumber(walletNumber string) (*models.Wall
 	return &wallet, nil
 }
 
+// ListByUserID lists a user's wallets, the default one first and the rest oldest first
+func (r *walletRepository) ListByUserID(ctx context.Context, userID uuid.UUID) ([]models.Wallet, error) {
+	wallets := []models.Wallet{}
+	query := `SELECT * FROM wallets WHERE user_id = $1 ORDER BY is_default DESC, created_at, id`
+
+	if err := r.db.SelectContext(ctx, &wallets, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to list wallets: %w", err)
+	}
+
+	return wallets, nil
+}
+
+// FindByWalletNumber finds a wallet by wallet number. System wallets are never found, so
+// money can only reach them through the features that own them.
+func (r *walletRepository) FindByWalletNumber(ctx context.Context, walletNumber string) (*models.Wallet, error) {
+	var wallet models.Wallet
+	query := `SELECT * FROM wallets WHERE wallet_number = $1 AND NOT is_system`
+
+	err := r.db.GetContext(ctx, &wallet, query, walletNumber)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find wallet: %w", err)
+	}
+
+	return &wallet, nil
+}
+
+// FindByWalletNumbers finds the wallets with any of the given wallet numbers; numbers
+// that don't exist, or belong to system wallets, are simply missing from the result
+func (r *walletRepository) FindByWalletNumbers(ctx context.Context, walletNumbers []string) ([]models.Wallet, error) {
+	wallets := []models.Wallet{}
+	query := `SELECT * FROM wallets WHERE wallet_number = ANY($1) AND NOT is_system`
+
+	if err := r.db.SelectContext(ctx, &wallets, query, pq.Array(walletNumbers)); err != nil {
+		return nil, fmt.Errorf("failed to find wallets: %w", err)
+	}
+
+	return wallets, nil
+}
+
+// Create opens a new empty wallet for wallet.UserID with a fresh wallet number
+func (r *walletRepository) Create(ctx context.Context, wallet *models.Wallet) error {
+	query := `
+		INSERT INTO wallets (user_id, wallet_number, name, is_default)
+		VALUES ($1, generate_wallet_number(), $2, $3)
+		RETURNING id, wallet_number, balance, created_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query, wallet.UserID, wallet.Name, wallet.IsDefault).Scan(
+		&wallet.ID, &wallet.WalletNumber, &wallet.Balance, &wallet.CreatedAt, &wallet.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create wallet: %w", err)
+	}
+
+	r.logger.Debug("Wallet created", "wallet_id", wallet.ID, "user_id", wallet.UserID)
+	return nil
+}
+
+// SetDefault makes walletID the user's default wallet. Run it in a transaction: the old
+// default is cleared first, so a user briefly has none.
+func (r *walletRepository) SetDefault(ctx context.Context, userID, walletID uuid.UUID) error {
+	clear := `UPDATE wallets SET is_default = false, updated_at = NOW() WHERE user_id = $1 AND is_default AND id <> $2`
+	if _, err := r.db.ExecContext(ctx, clear, userID, walletID); err != nil {
+		return fmt.Errorf("failed to clear default wallet: %w", err)
+	}
+
+	set := `UPDATE wallets SET is_default = true, updated_at = NOW() WHERE user_id = $1 AND id = $2`
+	result, err := r.db.ExecContext(ctx, set, userID, walletID)
+	if err != nil {
+		return fmt.Errorf("failed to set default wallet: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("wallet not found")
+	}
+
+	return nil
+}
+
 // UpdateBalance updates wallet balance (use with caution - prefer transactions)
-func (r *WalletRepository) UpdateBalance(walletID uuid.UUID, newBalance int64) error {
+func (r *walletRepository) UpdateBalance(ctx context.Context, walletID uuid.UUID, newBalance int64) error {
 	query := `UPDATE wallets SET balance = $1, updated_at = NOW() WHERE id = $2`
-	_, err := r.db.Exec(query, newBalance, walletID)
+	_, err := r.db.ExecContext(ctx, query, newBalance, walletID)
 	if err != nil {
 		return fmt.Errorf("failed to update balance: %w", err)
 	}
@@ -60,13 +165,13 @@ func (r *WalletRepository) UpdateBalance(walletID uuid.UUID, newBalance int64) e
 }
 
 // Credit adds money to a wallet (atomic operation)
-func (r *WalletRepository) Credit(walletID uuid.UUID, amount int64) error {
+func (r *walletRepository) Credit(ctx context.Context, walletID uuid.UUID, amount int64) error {
 	query := `
 		UPDATE wallets 
 		SET balance = balance + $1, updated_at = NOW() 
 		WHERE id = $2
 	`
-	result, err := r.db.Exec(query, amount, walletID)
+	result, err := r.db.ExecContext(ctx, query, amount, walletID)
 	if err != nil {
 		return fmt.Errorf("failed to credit wallet: %w", err)
 	}
@@ -76,24 +181,43 @@ func (r *WalletRepository) Credit(walletID uuid.UUID, amount int64) error {
 		return fmt.Errorf("wallet not found")
 	}
 
+	r.logger.Debug("Wallet credited", "wallet_id", walletID, "amount", amount)
 	return nil
 }
 
 // Debit removes money from a wallet (atomic operation with balance check)
-func (r *WalletRepository) Debit(walletID uuid.UUID, amount int64) error {
+func (r *walletRepository) Debit(ctx context.Context, walletID uuid.UUID, amount int64) error {
 	query := `
 		UPDATE wallets 
 		SET balance = balance - $1, updated_at = NOW() 
 		WHERE id = $2 AND balance >= $1
 	`
-	result, err := r.db.Exec(query, amount, walletID)
+	result, err := r.db.ExecContext(ctx, query, amount, walletID)
 	if err != nil {
 		return fmt.Errorf("failed to debit wallet: %w", err)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("insufficient balance or wallet not found")
+		return ErrInsufficientBalance
+	}
+
+	r.logger.Debug("Wallet debited", "wallet_id", walletID, "amount", amount)
+	return nil
+}
+
+// LockForUpdate row-locks the wallets until the surrounding transaction ends.
+// Rows are locked in ID order so concurrent transfers between the same wallets can't deadlock.
+func (r *walletRepository) LockForUpdate(ctx context.Context, walletIDs ...uuid.UUID) error {
+	ids := make([]string, len(walletIDs))
+	for i, id := range walletIDs {
+		ids[i] = id.String()
+	}
+
+	query := `SELECT id FROM wallets WHERE id = ANY($1::uuid[]) ORDER BY id FOR UPDATE`
+	var locked []uuid.UUID
+	if err := r.db.SelectContext(ctx, &locked, query, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to lock wallets: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/models"
+	"github.com/google/uuid"
+)
+
+// WebhookEventRepository stores received webhooks and hands them out for processing.
+// An attempt claims an event by pushing next_attempt_at past a lease, so several instances
+// can process the store concurrently and an event whose worker died becomes due again.
+type WebhookEventRepository interface {
+	Insert(ctx context.Context, event *models.WebhookEvent) (bool, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*models.WebhookEvent, error)
+	FindByEventID(ctx context.Context, provider, eventType, eventID string) (*models.WebhookEvent, error)
+	List(ctx context.Context, status models.WebhookEventStatus, limit, offset int) ([]models.WebhookEvent, error)
+	Count(ctx context.Context, status models.WebhookEventStatus) (int, error)
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookEvent, error)
+	Claim(ctx context.Context, id uuid.UUID, lease time.Duration) (*models.WebhookEvent, error)
+	Complete(ctx context.Context, id uuid.UUID, status models.WebhookEventStatus, lastError *string) error
+	Retry(ctx context.Context, id uuid.UUID, lastError string, at time.Time) error
+}
+
+type webhookEventRepository struct {
+	db     DBTX
+	logger *slog.Logger
+}
+
+func NewWebhookEventRepository(db DBTX, logger *slog.Logger) WebhookEventRepository {
+	return &webhookEventRepository{db: db, logger: logger}
+}
+
+// Insert stores a new pending event. It returns false without error if an event with the
+// same provider, type and id was already stored.
+func (r *webhookEventRepository) Insert(ctx context.Context, event *models.WebhookEvent) (bool, error) {
+	query := `
+		INSERT INTO webhook_events (provider, event_id, event_type, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, event_type, event_id) DO NOTHING
+		RETURNING id, status, attempts, next_attempt_at, received_at, updated_at
+	`
+
+	err := r.db.QueryRowxContext(ctx, query,
+		event.Provider,
+		event.EventID,
+		event.EventType,
+		string(event.Payload), // Sent as text so lib/pq doesn't encode it as bytea
+	).Scan(&event.ID, &event.Status, &event.Attempts, &event.NextAttemptAt, &event.ReceivedAt, &event.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to store webhook event: %w", err)
+	}
+
+	return true, nil
+}
+
+// FindByID finds a stored event
+func (r *webhookEventRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.WebhookEvent, error) {
+	var event models.WebhookEvent
+	query := `SELECT * FROM webhook_events WHERE id = $1`
+
+	err := r.db.GetContext(ctx, &event, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhook event: %w", err)
+	}
+
+	return &event, nil
+}
+
+// FindByEventID finds a stored event by the provider's id for it
+func (r *webhookEventRepository) FindByEventID(ctx context.Context, provider, eventType, eventID string) (*models.WebhookEvent, error) {
+	var event models.WebhookEvent
+	query := `SELECT * FROM webhook_events WHERE provider = $1 AND event_type = $2 AND event_id = $3`
+
+	err := r.db.GetContext(ctx, &event, query, provider, eventType, eventID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhook event: %w", err)
+	}
+
+	return &event, nil
+}
+
+// List lists events newest first, optionally only those with the given status
+func (r *webhookEventRepository) List(ctx context.Context, status models.WebhookEventStatus, limit, offset int) ([]models.WebhookEvent, error) {
+	var events []models.WebhookEvent
+	query := `
+		SELECT * FROM webhook_events
+		WHERE $1::text = '' OR status = $1::text
+		ORDER BY received_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	err := r.db.SelectContext(ctx, &events, query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook events: %w", err)
+	}
+
+	return events, nil
+}
+
+// Count counts events, optionally only those with the given status
+func (r *webhookEventRepository) Count(ctx context.Context, status models.WebhookEventStatus) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM webhook_events WHERE $1::text = '' OR status = $1::text`
+
+	if err := r.db.GetContext(ctx, &count, query, status); err != nil {
+		return 0, fmt.Errorf("failed to count webhook events: %w", err)
+	}
+
+	return count, nil
+}
+
+// ClaimDue claims up to limit pending events that are due, oldest first, for lease
+func (r *webhookEventRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookEvent, error) {
+	var events []models.WebhookEvent
+	query := `
+		UPDATE webhook_events
+		SET attempts = attempts + 1, next_attempt_at = NOW() + $2::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM webhook_events
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY received_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`
+
+	err := r.db.SelectContext(ctx, &events, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook events: %w", err)
+	}
+
+	return events, nil
+}
+
+// Claim claims a single event in any status for lease, making it pending again.
+// It returns nil if the event doesn't exist or another attempt currently holds it.
+func (r *webhookEventRepository) Claim(ctx context.Context, id uuid.UUID, lease time.Duration) (*models.WebhookEvent, error) {
+	var event models.WebhookEvent
+	query := `
+		UPDATE webhook_events
+		SET status = 'pending', attempts = attempts + 1,
+			next_attempt_at = NOW() + $2::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id = $1 AND (status <> 'pending' OR next_attempt_at <= NOW())
+		RETURNING *
+	`
+
+	err := r.db.GetContext(ctx, &event, query, id, lease.Milliseconds())
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook event: %w", err)
+	}
+
+	return &event, nil
+}
+
+// Complete records the final outcome of an attempt
+func (r *webhookEventRepository) Complete(ctx context.Context, id uuid.UUID, status models.WebhookEventStatus, lastError *string) error {
+	query := `
+		UPDATE webhook_events
+		SET status = $1, last_error = $2, processed_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, status, lastError, id); err != nil {
+		return fmt.Errorf("failed to complete webhook event: %w", err)
+	}
+
+	return nil
+}
+
+// Retry leaves the event pending and schedules its next attempt
+func (r *webhookEventRepository) Retry(ctx context.Context, id uuid.UUID, lastError string, at time.Time) error {
+	query := `
+		UPDATE webhook_events
+		SET last_error = $1, next_attempt_at = $2, updated_at = NOW()
+		WHERE id = $3
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, lastError, at, id); err != nil {
+		return fmt.Errorf("failed to reschedule webhook event: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+// Package schedule computes the run times of recurring jobs from cron expressions or
+// calendar intervals. All times are in UTC.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule yields the times a recurring job runs at
+type Schedule interface {
+	// Next returns the first run time strictly after t, or the zero time if there is none
+	Next(t time.Time) time.Time
+}
+
+// Parse builds the schedule described by exactly one of a cron expression or an interval.
+// Intervals count from anchor.
+func Parse(cron, interval string, anchor time.Time) (Schedule, error) {
+	switch {
+	case cron != "" && interval != "":
+		return nil, fmt.Errorf("set either cron or interval, not both")
+	case cron != "":
+		return ParseCron(cron)
+	case interval != "":
+		return ParseInterval(interval, anchor)
+	default:
+		return nil, fmt.Errorf("cron or interval is required")
+	}
+}
+
+// Interval runs every Value Units starting at Anchor. Months and years are calendar
+// months and years; a run that would fall on a day the month doesn't have (e.g. the 31st)
+// runs on the month's last day instead.
+type Interval struct {
+	Value  int
+	Unit   byte // H, D, W, M or Y
+	Anchor time.Time
+}
+
+// ParseInterval parses an interval such as 12H, 1D, 2W, 1M or 1Y
+func ParseInterval(spec string, anchor time.Time) (*Interval, error) {
+	if len(spec) < 2 {
+		return nil, fmt.Errorf("invalid interval format: %s", spec)
+	}
+
+	value, err := strconv.Atoi(spec[:len(spec)-1])
+	if err != nil || value < 1 {
+		return nil, fmt.Errorf("invalid interval value: %s", spec)
+	}
+
+	unit := strings.ToUpper(spec[len(spec)-1:])[0]
+	switch unit {
+	case 'H', 'D', 'W', 'M', 'Y':
+	default:
+		return nil, fmt.Errorf("invalid interval unit: %c (use H, D, W, M or Y)", unit)
+	}
+
+	return &Interval{Value: value, Unit: unit, Anchor: anchor.UTC()}, nil
+}
+
+// Next returns the first run strictly after t; the anchor itself is the first run
+func (i *Interval) Next(t time.Time) time.Time {
+	t = t.UTC()
+	if t.Before(i.Anchor) {
+		return i.Anchor
+	}
+
+	if step := i.fixed(); step > 0 {
+		n := int64(t.Sub(i.Anchor)/step) + 1
+		return i.Anchor.Add(time.Duration(n) * step)
+	}
+
+	months := i.Value
+	if i.Unit == 'Y' {
+		months *= 12
+	}
+	// Start from an estimate a step short of t so the loop runs once or twice
+	elapsed := (t.Year()-i.Anchor.Year())*12 + int(t.Month()-i.Anchor.Month())
+	n := max(elapsed/months-1, 0)
+	for {
+		next := addMonths(i.Anchor, n*months)
+		if next.After(t) {
+			return next
+		}
+		n++
+	}
+}
+
+// fixed returns the interval's length, or 0 for calendar months and years
+func (i *Interval) fixed() time.Duration {
+	switch i.Unit {
+	case 'H':
+		return time.Duration(i.Value) * time.Hour
+	case 'D':
+		return time.Duration(i.Value) * 24 * time.Hour
+	case 'W':
+		return time.Duration(i.Value) * 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// addMonths adds months to t, clamping the day to the end of the target month
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), lastDay)-1)
+}
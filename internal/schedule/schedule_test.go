@@ -0,0 +1,102 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// from is a Thursday
+var from = time.Date(2026, time.January, 15, 10, 7, 30, 0, time.UTC)
+
+func TestCronNext(t *testing.T) {
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, time.January, 15, 10, 15, 0, 0, time.UTC)},
+		{"7 10 * * *", time.Date(2026, time.January, 16, 10, 7, 0, 0, time.UTC)}, // Strictly after
+		{"0 9 * * 1-5", time.Date(2026, time.January, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{"30 8 1 * 0", time.Date(2026, time.January, 18, 8, 30, 0, 0, time.UTC)}, // Day of month or week
+		{"0,30 12 1,15 3 *", time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2026, time.January, 15, 10, 25, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}}, // Never
+	}
+
+	for _, tc := range cases {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+		}
+		if got := c.Next(from); !got.Equal(tc.want) {
+			t.Fatalf("ParseCron(%q).Next = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@fortnightly",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Fatalf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestIntervalNext(t *testing.T) {
+	anchor := time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		at   time.Time
+		want time.Time
+	}{
+		{"12H", anchor.Add(-time.Hour), anchor},
+		{"12H", anchor, anchor.Add(12 * time.Hour)},
+		{"12H", anchor.Add(13 * time.Hour), anchor.Add(24 * time.Hour)},
+		{"2W", anchor.Add(time.Hour), anchor.AddDate(0, 0, 14)},
+		{"1M", anchor, time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC)}, // Clamped to the month's end
+		{"1M", time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC), time.Date(2026, time.March, 31, 9, 0, 0, 0, time.UTC)},
+		{"1y", time.Date(2027, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, time.January, 31, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range cases {
+		s, err := Parse("", tc.spec, anchor)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.spec, err)
+		}
+		if got := s.Next(tc.at); !got.Equal(tc.want) {
+			t.Fatalf("%s.Next(%v) = %v, want %v", tc.spec, tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct{ cron, interval string }{
+		{"", ""},
+		{"@daily", "1D"},
+		{"", "D"},
+		{"", "0D"},
+		{"", "5X"},
+	}
+
+	for _, tc := range cases {
+		if _, err := Parse(tc.cron, tc.interval, from); err == nil {
+			t.Fatalf("Parse(%q, %q) succeeded, want an error", tc.cron, tc.interval)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/franzego/stage08/config"
+)
+
+// New creates an HTTP server with the configured timeouts
+func New(cfg *config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    1 << 20,
+	}
+}
+
+// Run serves until ctx is cancelled, then stops accepting connections and waits up to
+// cfg.ShutdownTimeout for in-flight requests to finish. TLS is used when a certificate is configured.
+func Run(ctx context.Context, srv *http.Server, cfg *config.ServerConfig, logger *slog.Logger) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		var err error
+		if cfg.TLSEnabled() {
+			logger.Info("Server starting", "addr", srv.Addr, "tls", true)
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			logger.Info("Server starting", "addr", srv.Addr, "tls", false)
+			err = srv.ListenAndServe()
+		}
+		serveErr <- err
+	}()
+
+	select {
+	case err := <-serveErr:
+		// The server never started (e.g. port in use or bad certificate)
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutdown signal received, draining in-flight requests", "timeout", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Deadline hit: drop whatever is still running
+		srv.Close()
+		return fmt.Errorf("failed to drain requests: %w", err)
+	}
+
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %w", err)
+	}
+
+	logger.Info("Server stopped")
+	return nil
+}
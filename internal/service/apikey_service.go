@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/utils"
+	"github.com/google/uuid"
+)
+
+// MaxActiveAPIKeys is how many unrevoked, unexpired keys a user may hold at once
+const MaxActiveAPIKeys = 5
+
+type APIKeyService struct {
+	txManager  repository.TxManager
+	apiKeyRepo repository.APIKeyRepository
+	auditor    *audit.Recorder
+	logger     *slog.Logger
+}
+
+func NewAPIKeyService(txManager repository.TxManager, apiKeyRepo repository.APIKeyRepository, auditor *audit.Recorder, logger *slog.Logger) *APIKeyService {
+	return &APIKeyService{txManager: txManager, apiKeyRepo: apiKeyRepo, auditor: auditor, logger: logger}
+}
+
+// Create issues a new API key. The raw key is only ever returned here.
+func (s *APIKeyService) Create(ctx context.Context, userID uuid.UUID, name string, permissions []string, expiry string) (*models.APIKey, string, error) {
+	if err := utils.ValidatePermissions(permissions); err != nil {
+		return nil, "", ErrInvalidPermissions.WithDetail(err.Error())
+	}
+
+	return s.issue(ctx, userID, nil, name, permissions, expiry, nil)
+}
+
+// CreateForOrganization issues a new API key that belongs to an organization. The key acts on
+// the organization's wallets with its own permissions, whoever created it.
+func (s *APIKeyService) CreateForOrganization(ctx context.Context, org *models.Organization, name string, permissions []string, expiry string) (*models.APIKey, string, error) {
+	if err := utils.ValidatePermissions(permissions); err != nil {
+		return nil, "", ErrInvalidPermissions.WithDetail(err.Error())
+	}
+
+	return s.issue(ctx, org.AccountUserID, &org.ID, name, permissions, expiry, nil)
+}
+
+// Rollover issues a new key with the name and permissions of one of the user's expired keys.
+// It returns the new key and its raw value.
+func (s *APIKeyService) Rollover(ctx context.Context, userID, expiredKeyID uuid.UUID, expiry string) (*models.APIKey, string, error) {
+	expiredKey, err := s.owned(ctx, userID, expiredKeyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !expiredKey.IsExpired() {
+		return nil, "", ErrAPIKeyNotExpired
+	}
+
+	return s.issue(ctx, userID, expiredKey.OrganizationID, expiredKey.Name, expiredKey.Permissions, expiry, expiredKey)
+}
+
+// List returns all of the user's keys, including revoked and expired ones
+func (s *APIKeyService) List(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
+	return s.apiKeyRepo.ListByUser(ctx, userID)
+}
+
+// Revoke deactivates one of the user's keys
+func (s *APIKeyService) Revoke(ctx context.Context, userID, keyID uuid.UUID) error {
+	key, err := s.owned(ctx, userID, keyID)
+	if err != nil {
+		return err
+	}
+
+	return s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := uow.APIKeys.Revoke(ctx, keyID); err != nil {
+			return err
+		}
+
+		revoked := *key
+		revoked.IsActive = false
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionAPIKeyRevoke,
+			TargetType:  audit.TargetAPIKey,
+			TargetID:    key.ID.String(),
+			Before:      audit.APIKeyState(key),
+			After:       audit.APIKeyState(&revoked),
+		})
+	})
+}
+
+// issue parses the expiry, enforces the active key limit and creates and audits the key.
+// replaces is the expired key a rollover replaces, if any.
+func (s *APIKeyService) issue(ctx context.Context, userID uuid.UUID, organizationID *uuid.UUID, name string, permissions []string, expiry string, replaces *models.APIKey) (*models.APIKey, string, error) {
+	expiresAt, err := utils.ParseExpiry(expiry)
+	if err != nil {
+		return nil, "", ErrInvalidExpiry.WithDetail(err.Error())
+	}
+
+	var apiKey *models.APIKey
+	var rawKey string
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		count, err := uow.APIKeys.CountActiveByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if count >= MaxActiveAPIKeys {
+			if organizationID != nil {
+				return ErrAPIKeyLimitReached.WithDetail("Maximum 5 active API keys allowed per organization")
+			}
+			return ErrAPIKeyLimitReached
+		}
+
+		apiKey, rawKey, err = uow.APIKeys.Create(ctx, userID, organizationID, name, permissions, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		event := audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionAPIKeyCreate,
+			TargetType:  audit.TargetAPIKey,
+			TargetID:    apiKey.ID.String(),
+			After:       audit.APIKeyState(apiKey),
+		}
+		if replaces != nil {
+			event.Action = audit.ActionAPIKeyRollover
+			event.Before = audit.APIKeyState(replaces)
+		}
+		return s.auditor.Record(ctx, uow, event)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, rawKey, nil
+}
+
+// owned finds a key and checks that it belongs to the user
+func (s *APIKeyService) owned(ctx context.Context, userID, keyID uuid.UUID) (*models.APIKey, error) {
+	key, err := s.apiKeyRepo.FindByID(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	if key.UserID != userID {
+		return nil, ErrAPIKeyNotOwned
+	}
+	return key, nil
+}
@@ -0,0 +1,805 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/google/uuid"
+)
+
+const (
+	batchChunkSize     = 50              // Pending items listed per round of a best-effort batch
+	batchLease         = 2 * time.Minute // How long a worker owns a batch before another may take it over
+	batchMaxLineErrors = 10              // Line errors spelled out in an invalid_batch message
+)
+
+// errBatchAborted rolls back an all-or-nothing batch after one of its items failed
+var errBatchAborted = errors.New("transfer batch aborted")
+
+// BatchTransferService pays bulk transfer uploads. The total of the valid lines is debited
+// from the sender's wallet on upload, as a pending transfer_out per line, so the batch can't
+// run short; a background worker then pays each line and refunds those that fail.
+// All-or-nothing batches are paid in one database transaction, best-effort batches one item
+// per transaction.
+type BatchTransferService struct {
+	txManager  repository.TxManager
+	batchRepo  repository.TransferBatchRepository
+	walletRepo repository.WalletRepository
+	auditor    *audit.Recorder
+	maxItems   int
+	wake       chan struct{}
+	logger     *slog.Logger
+}
+
+func NewBatchTransferService(txManager repository.TxManager, batchRepo repository.TransferBatchRepository, walletRepo repository.WalletRepository, auditor *audit.Recorder, maxItems int, logger *slog.Logger) *BatchTransferService {
+	return &BatchTransferService{
+		txManager:  txManager,
+		batchRepo:  batchRepo,
+		walletRepo: walletRepo,
+		auditor:    auditor,
+		maxItems:   maxItems,
+		wake:       make(chan struct{}, 1),
+		logger:     logger,
+	}
+}
+
+// MaxItems is the most lines a batch may have
+func (s *BatchTransferService) MaxItems() int {
+	return s.maxItems
+}
+
+// BatchTransferInput is one line of a batch upload
+type BatchTransferInput struct {
+	WalletNumber string
+	Amount       int64 // In kobo
+	Description  string
+	invalid      *Error // Why ParseBatchCSV couldn't read the line, if it couldn't
+}
+
+// ParseBatchCSV reads a CSV upload. The header row names a wallet_number and an amount (in
+// kobo) column, and optionally a description column, in any order. A line whose amount
+// isn't a whole number is kept, and fails validation like any other invalid line. Reading
+// stops one line past maxItems, since a longer upload is refused anyway.
+func ParseBatchCSV(r io.Reader, maxItems int) ([]BatchTransferInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ErrInvalidBatch.WithDetail("CSV upload is empty")
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// Spreadsheet exports often start with a byte order mark
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	walletColumn, hasWallet := columns["wallet_number"]
+	amountColumn, hasAmount := columns["amount"]
+	descriptionColumn, hasDescription := columns["description"]
+	if !hasWallet || !hasAmount {
+		return nil, ErrInvalidBatch.WithDetail("CSV header must name wallet_number and amount columns")
+	}
+
+	var inputs []BatchTransferInput
+	for {
+		// One line over the limit is enough for Create to refuse the batch
+		if len(inputs) > maxItems {
+			break
+		}
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvError(err)
+		}
+
+		input := BatchTransferInput{WalletNumber: strings.TrimSpace(record[walletColumn])}
+		if hasDescription {
+			input.Description = strings.TrimSpace(record[descriptionColumn])
+		}
+		if amount, err := strconv.ParseInt(strings.TrimSpace(record[amountColumn]), 10, 64); err == nil {
+			input.Amount = amount
+		} else {
+			input.invalid = ErrInvalidAmount.WithDetail("Amount must be a whole number of kobo")
+		}
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
+
+// csvError reports malformed CSV as an invalid batch, and passes on failures to read the upload
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return ErrInvalidBatch.WithDetail("Invalid CSV: " + err.Error())
+	}
+	return fmt.Errorf("failed to read CSV upload: %w", err)
+}
+
+// Create validates every line of an upload from the user, reserves the total of the valid
+// lines and queues the batch for the worker. An all-or-nothing batch is refused if any line
+// is invalid; a best-effort batch records invalid lines as failed and pays the rest.
+func (s *BatchTransferService) Create(ctx context.Context, userID uuid.UUID, mode models.TransferBatchMode, inputs []BatchTransferInput) (*models.TransferBatch, error) {
+	switch mode {
+	case "":
+		mode = models.TransferBatchModeAllOrNothing
+	case models.TransferBatchModeAllOrNothing, models.TransferBatchModeBestEffort:
+	default:
+		return nil, ErrInvalidBatch.WithDetail("mode must be all_or_nothing or best_effort")
+	}
+	if len(inputs) == 0 {
+		return nil, ErrInvalidBatch.WithDetail("Batch has no transfers")
+	}
+	if len(inputs) > s.maxItems {
+		return nil, ErrInvalidBatch.WithDetail(fmt.Sprintf("Batch can have at most %d transfers", s.maxItems))
+	}
+
+	sender, err := s.walletRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if sender == nil {
+		return nil, ErrWalletNotFound
+	}
+
+	recipients, err := s.recipients(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]models.TransferBatchItem, len(inputs))
+	var total int64
+	var lineErrors []string
+	for i, input := range inputs {
+		item := &items[i]
+		*item = models.TransferBatchItem{
+			Line:                  i + 1,
+			RecipientWalletNumber: input.WalletNumber,
+			Amount:                input.Amount,
+			Description:           optionalString(input.Description),
+			Status:                models.TransferBatchItemStatusPending,
+		}
+
+		if lineErr := validateBatchLine(input, sender, recipients); lineErr != nil {
+			failBatchItem(item, lineErr)
+			lineErrors = append(lineErrors, fmt.Sprintf("line %d: %s", item.Line, lineErr.Message))
+			continue
+		}
+		if input.Amount > math.MaxInt64-total {
+			return nil, ErrInvalidBatch.WithDetail("Batch total is too large")
+		}
+		total += input.Amount
+	}
+
+	if len(lineErrors) > 0 && (mode == models.TransferBatchModeAllOrNothing || len(lineErrors) == len(inputs)) {
+		return nil, ErrInvalidBatch.WithDetail(summarizeLineErrors(lineErrors))
+	}
+
+	batch := &models.TransferBatch{
+		UserID:      userID,
+		WalletID:    sender.ID,
+		Mode:        mode,
+		Status:      models.TransferBatchStatusPending,
+		TotalItems:  len(items),
+		TotalAmount: total,
+		FailedCount: len(lineErrors),
+	}
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := uow.Wallets.LockForUpdate(ctx, sender.ID); err != nil {
+			return err
+		}
+		if err := uow.Wallets.Debit(ctx, sender.ID, total); err != nil {
+			if errors.Is(err, repository.ErrInsufficientBalance) {
+				return ErrInsufficientFunds.WithDetail(fmt.Sprintf("Insufficient balance for the batch total of %d kobo", total))
+			}
+			return err
+		}
+
+		if err := uow.TransferBatches.Create(ctx, batch); err != nil {
+			return err
+		}
+
+		for i := range items {
+			item := &items[i]
+			item.BatchID = batch.ID
+			if item.Status == models.TransferBatchItemStatusPending {
+				if err := s.reserve(ctx, uow, batch, sender, recipients[item.RecipientWalletNumber], item); err != nil {
+					return err
+				}
+			}
+			if err := uow.TransferBatches.CreateItem(ctx, item); err != nil {
+				return err
+			}
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionTransferBatchCreate,
+			TargetType:  audit.TargetTransferBatch,
+			TargetID:    batch.ID.String(),
+			After: map[string]interface{}{
+				"mode":         batch.Mode,
+				"total_items":  batch.TotalItems,
+				"total_amount": batch.TotalAmount,
+				"rejected":     batch.FailedCount,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Let the worker pick the batch up now instead of on its next poll
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	s.logger.InfoContext(ctx, "Transfer batch created", "batch_id", batch.ID, "mode", batch.Mode, "items", batch.TotalItems, "rejected", batch.FailedCount, "total_amount", batch.TotalAmount)
+	return batch, nil
+}
+
+// recipients looks up the wallets the upload pays, by wallet number
+func (s *BatchTransferService) recipients(ctx context.Context, inputs []BatchTransferInput) (map[string]*models.Wallet, error) {
+	var numbers []string
+	seen := make(map[string]bool, len(inputs))
+	for _, input := range inputs {
+		if input.WalletNumber != "" && !seen[input.WalletNumber] {
+			seen[input.WalletNumber] = true
+			numbers = append(numbers, input.WalletNumber)
+		}
+	}
+
+	wallets, err := s.walletRepo.FindByWalletNumbers(ctx, numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make(map[string]*models.Wallet, len(wallets))
+	for i := range wallets {
+		recipients[wallets[i].WalletNumber] = &wallets[i]
+	}
+	return recipients, nil
+}
+
+// reserve writes the pending transfer_out that holds an item's amount until it is paid or
+// refunded
+func (s *BatchTransferService) reserve(ctx context.Context, uow *repository.UnitOfWork, batch *models.TransferBatch, sender, recipient *models.Wallet, item *models.TransferBatchItem) error {
+	reference := fmt.Sprintf("TRF_%s_%s", sender.UserID.String()[:8], uuid.New().String()[:8])
+
+	debit, err := transferEntry(sender, recipient, models.TransactionTypeTransferOut, item.Amount, reference+"_OUT", "Transfer to "+recipient.WalletNumber, batchEntryMetadata(batch, item))
+	if err != nil {
+		return err
+	}
+	debit.Status = models.TransactionStatusPending
+	if err := uow.Transactions.Create(ctx, debit); err != nil {
+		return err
+	}
+
+	item.DebitTransactionID = &debit.ID
+	item.Reference = &reference
+	return nil
+}
+
+// List returns the user's batches, newest first
+func (s *BatchTransferService) List(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.TransferBatch, error) {
+	return s.batchRepo.ListByUser(ctx, userID, limit, offset)
+}
+
+// Get returns one of the user's batches
+func (s *BatchTransferService) Get(ctx context.Context, userID, id uuid.UUID) (*models.TransferBatch, error) {
+	batch, err := s.batchRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if batch == nil || batch.UserID != userID {
+		return nil, ErrBatchNotFound
+	}
+	return batch, nil
+}
+
+// ListItems returns the items of one of the user's batches in upload order, optionally only
+// those with the given status
+func (s *BatchTransferService) ListItems(ctx context.Context, userID, id uuid.UUID, status string, limit, offset int) ([]models.TransferBatchItem, error) {
+	switch models.TransferBatchItemStatus(status) {
+	case "", models.TransferBatchItemStatusPending, models.TransferBatchItemStatusSuccess, models.TransferBatchItemStatusFailed:
+	default:
+		return nil, ErrInvalidBatch.WithDetail("status must be one of pending, success or failed")
+	}
+
+	if _, err := s.Get(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	return s.batchRepo.ListItems(ctx, id, models.TransferBatchItemStatus(status), limit, offset)
+}
+
+// Result returns one of the user's batches with every item, for the downloadable result
+func (s *BatchTransferService) Result(ctx context.Context, userID, id uuid.UUID) (*models.TransferBatch, []models.TransferBatchItem, error) {
+	batch, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items, err := s.batchRepo.ListItems(ctx, id, "", batch.TotalItems, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return batch, items, nil
+}
+
+// Run pays unfinished batches until ctx is cancelled. It polls every interval, and right
+// away when Create stores a new batch. beat is called whenever the worker makes progress.
+func (s *BatchTransferService) Run(ctx context.Context, interval time.Duration, beat func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		beat()
+		s.ProcessDue(ctx, beat)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// ProcessDue claims and pays unfinished batches until none are left or ctx is cancelled. It
+// stops at the first unexpected error; the batch's lease then expires and it is tried again.
+func (s *BatchTransferService) ProcessDue(ctx context.Context, beat func()) {
+	for ctx.Err() == nil {
+		batch, err := s.batchRepo.Claim(ctx, batchLease)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.ErrorContext(ctx, "Failed to claim transfer batch", "error", err)
+			}
+			return
+		}
+		if batch == nil {
+			return
+		}
+
+		if batch.Mode == models.TransferBatchModeAllOrNothing {
+			err = s.processAtomically(ctx, batch)
+		} else {
+			err = s.processEach(ctx, batch, beat)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.ErrorContext(ctx, "Failed to process transfer batch", "batch_id", batch.ID, "error", err)
+			}
+			return
+		}
+		beat()
+	}
+}
+
+// batchOutcome is an item whose outcome was committed, for metrics and logs
+type batchOutcome struct {
+	item      models.TransferBatchItem
+	recipient *models.Wallet // nil if the item failed
+}
+
+// processEach pays a best-effort batch's pending items one transaction at a time, refunding
+// those that can't be paid, then completes it
+func (s *BatchTransferService) processEach(ctx context.Context, batch *models.TransferBatch, beat func()) error {
+	for {
+		items, err := s.batchRepo.ListItems(ctx, batch.ID, models.TransferBatchItemStatusPending, batchChunkSize, 0)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			break
+		}
+
+		for _, item := range items {
+			if err := s.processItem(ctx, batch, item.ID); err != nil {
+				return err
+			}
+			beat()
+		}
+
+		if err := s.batchRepo.Renew(ctx, batch.ID, batchLease); err != nil {
+			return err
+		}
+	}
+
+	var completed *models.TransferBatch
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		completed, err = s.complete(ctx, uow, batch.ID, models.TransferBatchStatusCompleted)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if completed != nil {
+		s.recordCompletion(ctx, completed)
+	}
+	return nil
+}
+
+// processItem pays or refunds one pending item of a best-effort batch while it is locked
+func (s *BatchTransferService) processItem(ctx context.Context, batch *models.TransferBatch, id uuid.UUID) error {
+	var outcome *batchOutcome
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		item, err := uow.TransferBatches.FindItemForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		// Settled by another worker since it was listed
+		if item == nil || item.Status != models.TransferBatchItemStatusPending {
+			return nil
+		}
+
+		sender, err := s.sender(ctx, uow, batch)
+		if err != nil {
+			return err
+		}
+
+		recipient, err := s.pay(ctx, uow, batch, sender, item)
+		var domainErr *Error
+		switch {
+		case err == nil:
+		case errors.As(err, &domainErr) && domainErr.Kind != KindInternal:
+			if err := s.refund(ctx, uow, batch, sender, item, domainErr); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		outcome = &batchOutcome{item: *item, recipient: recipient}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if outcome != nil {
+		s.recordOutcome(ctx, batch, outcome)
+	}
+	return nil
+}
+
+// processAtomically pays every pending item of an all-or-nothing batch in one transaction.
+// If any item can't be paid nothing is, and every item is refunded instead.
+func (s *BatchTransferService) processAtomically(ctx context.Context, batch *models.TransferBatch) error {
+	var (
+		outcomes   []batchOutcome
+		completed  *models.TransferBatch
+		failedLine int
+		failure    *Error
+	)
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		items, sender, err := s.lockPending(ctx, uow, batch)
+		if err != nil || items == nil {
+			return err
+		}
+
+		for i := range items {
+			item := &items[i]
+			recipient, err := s.pay(ctx, uow, batch, sender, item)
+			if err != nil {
+				if errors.As(err, &failure) && failure.Kind != KindInternal {
+					failedLine = item.Line
+					return errBatchAborted
+				}
+				return err
+			}
+			outcomes = append(outcomes, batchOutcome{item: *item, recipient: recipient})
+		}
+
+		completed, err = s.complete(ctx, uow, batch.ID, models.TransferBatchStatusCompleted)
+		return err
+	})
+	if errors.Is(err, errBatchAborted) {
+		return s.abort(ctx, batch, failedLine, failure)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range outcomes {
+		s.recordOutcome(ctx, batch, &outcomes[i])
+	}
+	if completed != nil {
+		s.recordCompletion(ctx, completed)
+	}
+	return nil
+}
+
+// abort refunds every pending item of an all-or-nothing batch after the item on failedLine
+// couldn't be paid, and marks the batch failed
+func (s *BatchTransferService) abort(ctx context.Context, batch *models.TransferBatch, failedLine int, reason *Error) error {
+	var (
+		outcomes  []batchOutcome
+		completed *models.TransferBatch
+	)
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		items, sender, err := s.lockPending(ctx, uow, batch)
+		if err != nil || items == nil {
+			return err
+		}
+
+		aborted := ErrBatchItemAborted.WithDetail(fmt.Sprintf("Not sent because line %d failed: %s", failedLine, reason.Message))
+		for i := range items {
+			item := &items[i]
+			cause := aborted
+			if item.Line == failedLine {
+				cause = reason
+			}
+			if err := s.refund(ctx, uow, batch, sender, item, cause); err != nil {
+				return err
+			}
+			outcomes = append(outcomes, batchOutcome{item: *item})
+		}
+
+		completed, err = s.complete(ctx, uow, batch.ID, models.TransferBatchStatusFailed)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range outcomes {
+		s.recordOutcome(ctx, batch, &outcomes[i])
+	}
+	if completed != nil {
+		s.recordCompletion(ctx, completed)
+	}
+	return nil
+}
+
+// lockPending locks an all-or-nothing batch and returns its pending items and the wallet
+// paying them. It returns no items if another worker has already finished the batch.
+func (s *BatchTransferService) lockPending(ctx context.Context, uow *repository.UnitOfWork, batch *models.TransferBatch) ([]models.TransferBatchItem, *models.Wallet, error) {
+	locked, err := uow.TransferBatches.FindByIDForUpdate(ctx, batch.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if locked == nil || locked.Status != models.TransferBatchStatusProcessing {
+		return nil, nil, nil
+	}
+
+	sender, err := s.sender(ctx, uow, batch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items, err := uow.TransferBatches.ListItems(ctx, batch.ID, models.TransferBatchItemStatusPending, locked.TotalItems, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, sender, nil
+}
+
+// sender loads the wallet a batch is paid from
+func (s *BatchTransferService) sender(ctx context.Context, uow *repository.UnitOfWork, batch *models.TransferBatch) (*models.Wallet, error) {
+	sender, err := uow.Wallets.FindByID(ctx, batch.WalletID)
+	if err != nil {
+		return nil, err
+	}
+	if sender == nil {
+		return nil, fmt.Errorf("wallet %s of transfer batch %s not found", batch.WalletID, batch.ID)
+	}
+	return sender, nil
+}
+
+// pay credits an item's recipient and settles its reservation. Every domain error is
+// returned before anything is written.
+func (s *BatchTransferService) pay(ctx context.Context, uow *repository.UnitOfWork, batch *models.TransferBatch, sender *models.Wallet, item *models.TransferBatchItem) (*models.Wallet, error) {
+	recipient, err := uow.Wallets.FindByWalletNumber(ctx, item.RecipientWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		return nil, ErrRecipientNotFound
+	}
+
+	if err := uow.Wallets.Credit(ctx, recipient.ID, item.Amount); err != nil {
+		return nil, err
+	}
+	if err := settleReservation(ctx, uow, item, models.TransactionStatusSuccess); err != nil {
+		return nil, err
+	}
+
+	credit, err := transferEntry(recipient, sender, models.TransactionTypeTransferIn, item.Amount, *item.Reference+"_IN", "Transfer from "+sender.WalletNumber, batchEntryMetadata(batch, item))
+	if err != nil {
+		return nil, err
+	}
+	if err := uow.Transactions.Create(ctx, credit); err != nil {
+		return nil, err
+	}
+
+	err = s.auditor.RecordSystem(ctx, uow, audit.Event{
+		OwnerUserID: batch.UserID,
+		Action:      audit.ActionTransferCreate,
+		TargetType:  audit.TargetWallet,
+		TargetID:    recipient.ID.String(),
+		After: map[string]interface{}{
+			"reference":               *item.Reference,
+			"amount":                  item.Amount,
+			"sender_wallet_number":    sender.WalletNumber,
+			"recipient_wallet_number": recipient.WalletNumber,
+			"batch_id":                batch.ID,
+			"batch_line":              item.Line,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	item.Status = models.TransferBatchItemStatusSuccess
+	item.ProcessedAt = &now
+	if err := uow.TransferBatches.UpdateItem(ctx, item); err != nil {
+		return nil, err
+	}
+	if err := uow.TransferBatches.AddProgress(ctx, batch.ID, 1, 0, item.Amount, 0); err != nil {
+		return nil, err
+	}
+
+	return recipient, nil
+}
+
+// refund returns an item's reserved amount to the sender and marks it failed with reason
+func (s *BatchTransferService) refund(ctx context.Context, uow *repository.UnitOfWork, batch *models.TransferBatch, sender *models.Wallet, item *models.TransferBatchItem, reason *Error) error {
+	if err := uow.Wallets.Credit(ctx, sender.ID, item.Amount); err != nil {
+		return err
+	}
+	if err := settleReservation(ctx, uow, item, models.TransactionStatusFailed); err != nil {
+		return err
+	}
+
+	failBatchItem(item, reason)
+	if err := uow.TransferBatches.UpdateItem(ctx, item); err != nil {
+		return err
+	}
+	return uow.TransferBatches.AddProgress(ctx, batch.ID, 0, 1, 0, item.Amount)
+}
+
+// recordOutcome reports a committed item in metrics and logs
+func (s *BatchTransferService) recordOutcome(ctx context.Context, batch *models.TransferBatch, outcome *batchOutcome) {
+	item := outcome.item
+	if outcome.recipient == nil {
+		metrics.RecordTransfer("failed", item.Amount)
+		metrics.RecordTransferBatchItem(string(models.TransferBatchItemStatusFailed))
+		s.logger.WarnContext(ctx, "Transfer batch item failed", "batch_id", batch.ID, "line", item.Line, "error_code", *item.ErrorCode)
+		return
+	}
+
+	metrics.RecordTransfer("success", item.Amount)
+	metrics.RecordTransferBatchItem(string(models.TransferBatchItemStatusSuccess))
+}
+
+// complete marks a batch finished with status and audits its final counts. It returns the
+// finished batch, or nil if another worker had already finished it.
+func (s *BatchTransferService) complete(ctx context.Context, uow *repository.UnitOfWork, id uuid.UUID, status models.TransferBatchStatus) (*models.TransferBatch, error) {
+	completed, err := uow.TransferBatches.Complete(ctx, id, status)
+	if err != nil || !completed {
+		return nil, err
+	}
+
+	batch, err := uow.TransferBatches.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if batch == nil {
+		return nil, ErrBatchNotFound
+	}
+
+	err = s.auditor.RecordSystem(ctx, uow, audit.Event{
+		OwnerUserID: batch.UserID,
+		Action:      audit.ActionTransferBatchComplete,
+		TargetType:  audit.TargetTransferBatch,
+		TargetID:    batch.ID.String(),
+		Before:      map[string]interface{}{"status": models.TransferBatchStatusProcessing},
+		After: map[string]interface{}{
+			"status":           batch.Status,
+			"succeeded_count":  batch.SucceededCount,
+			"failed_count":     batch.FailedCount,
+			"succeeded_amount": batch.SucceededAmount,
+			"refunded_amount":  batch.RefundedAmount,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// recordCompletion logs a batch that has just finished, with its final counts
+func (s *BatchTransferService) recordCompletion(ctx context.Context, batch *models.TransferBatch) {
+	s.logger.InfoContext(ctx, "Transfer batch finished", "batch_id", batch.ID, "status", batch.Status, "succeeded", batch.SucceededCount, "failed", batch.FailedCount)
+}
+
+// settleReservation moves an item's pending transfer_out to status
+func settleReservation(ctx context.Context, uow *repository.UnitOfWork, item *models.TransferBatchItem, status models.TransactionStatus) error {
+	settled, err := uow.Transactions.TransitionStatus(ctx, *item.DebitTransactionID, models.TransactionStatusPending, status)
+	if err != nil {
+		return err
+	}
+	if !settled {
+		return fmt.Errorf("reservation for transfer batch item %s is no longer pending", item.ID)
+	}
+	return nil
+}
+
+// validateBatchLine checks one line of an upload before anything is reserved
+func validateBatchLine(input BatchTransferInput, sender *models.Wallet, recipients map[string]*models.Wallet) *Error {
+	if input.invalid != nil {
+		return input.invalid
+	}
+	if input.Amount < MinAmount {
+		return ErrInvalidAmount
+	}
+	if input.WalletNumber == "" {
+		return ErrRecipientNotFound.WithDetail("wallet_number is required")
+	}
+
+	recipient := recipients[input.WalletNumber]
+	if recipient == nil {
+		return ErrRecipientNotFound
+	}
+	if recipient.ID == sender.ID {
+		return ErrSelfTransfer
+	}
+	return nil
+}
+
+// failBatchItem marks an item failed with a domain error
+func failBatchItem(item *models.TransferBatchItem, err *Error) {
+	now := time.Now()
+	item.Status = models.TransferBatchItemStatusFailed
+	item.ErrorCode = &err.Code
+	item.ErrorMessage = &err.Message
+	item.ProcessedAt = &now
+}
+
+// batchEntryMetadata ties a ledger entry to the batch line it pays
+func batchEntryMetadata(batch *models.TransferBatch, item *models.TransferBatchItem) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"batch_id":   batch.ID.String(),
+		"batch_line": item.Line,
+	}
+	if item.Description != nil {
+		metadata["description"] = *item.Description
+	}
+	return metadata
+}
+
+// summarizeLineErrors lists the first few invalid lines of an upload
+func summarizeLineErrors(lineErrors []string) string {
+	shown := lineErrors
+	if len(shown) > batchMaxLineErrors {
+		shown = shown[:batchMaxLineErrors]
+	}
+
+	summary := fmt.Sprintf("%d invalid line(s): %s", len(lineErrors), strings.Join(shown, "; "))
+	if more := len(lineErrors) - len(shown); more > 0 {
+		summary += fmt.Sprintf("; and %d more", more)
+	}
+	return summary
+}
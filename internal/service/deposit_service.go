@@ -0,0 +1,556 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/payment"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type DepositService struct {
+	txManager         repository.TxManager
+	txRepo            repository.TransactionRepository
+	userRepo          repository.UserRepository
+	paymentMethodRepo repository.PaymentMethodRepository
+	providers         *payment.Registry
+	auditor           *audit.Recorder
+	logger            *slog.Logger
+}
+
+func NewDepositService(txManager repository.TxManager, txRepo repository.TransactionRepository, userRepo repository.UserRepository, paymentMethodRepo repository.PaymentMethodRepository, providers *payment.Registry, auditor *audit.Recorder, logger *slog.Logger) *DepositService {
+	return &DepositService{
+		txManager:         txManager,
+		txRepo:            txRepo,
+		userRepo:          userRepo,
+		paymentMethodRepo: paymentMethodRepo,
+		providers:         providers,
+		auditor:           auditor,
+		logger:            logger,
+	}
+}
+
+// InitializedDeposit is a pending deposit and the checkout URL that completes it
+type InitializedDeposit struct {
+	Transaction      *models.Transaction
+	AuthorizationURL string
+}
+
+// Initialize records a pending deposit into the user's wallet with walletNumber (their
+// default wallet if empty) and starts a checkout for it with the named provider, or the
+// default provider if providerName is empty. The checkout is opened with the email of payerID,
+// who is the user themselves or a member depositing for an organization; the payer is kept with
+// the deposit, since that is who the settling charge comes from and whose card it is.
+func (s *DepositService) Initialize(ctx context.Context, userID, payerID uuid.UUID, walletNumber string, amount int64, providerName string) (*InitializedDeposit, error) {
+	if amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+
+	provider, ok := s.providers.Get(providerName)
+	if !ok {
+		return nil, ErrUnsupportedProvider
+	}
+
+	payer, err := s.userRepo.FindByID(ctx, payerID)
+	if err != nil {
+		return nil, err
+	}
+	if payer == nil {
+		return nil, ErrWalletNotFound
+	}
+
+	metadata, err := repository.CreateMetadata(map[string]interface{}{"payer_id": payer.ID, "payer_email": payer.Email})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build deposit metadata: %w", err)
+	}
+	return s.checkout(ctx, provider, userID, walletNumber, payer.Email, amount, "Wallet deposit via "+payment.DisplayName(provider.Name()), metadata, audit.ActionDepositInitialize)
+}
+
+// InitializeLinkPayment records a pending deposit into a payment link owner's wallet, paid
+// by someone else, and starts a Paystack checkout for it, keeping the payer's email with the
+// deposit as Initialize does.
+func (s *DepositService) InitializeLinkPayment(ctx context.Context, link *models.PaymentLink, email string, amount int64) (*InitializedDeposit, error) {
+	if amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+
+	provider, ok := s.providers.Get(config.ProviderPaystack)
+	if !ok {
+		return nil, ErrUnsupportedProvider
+	}
+
+	metadata, err := repository.CreateMetadata(map[string]interface{}{"payment_link_id": link.ID, "payer_email": email})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build deposit metadata: %w", err)
+	}
+	return s.checkout(ctx, provider, link.UserID, "", email, amount, "Payment via link "+link.Code, metadata, "")
+}
+
+// checkout records a pending deposit into the user's wallet and starts the provider's
+// checkout for it, paid by email. The deposit is audited as action, unless that is empty.
+func (s *DepositService) checkout(ctx context.Context, provider payment.Provider, userID uuid.UUID, walletNumber, email string, amount int64, description string, metadata []byte, action string) (*InitializedDeposit, error) {
+	name := provider.Name()
+
+	tx, err := s.createPending(ctx, userID, walletNumber, amount, name, description, metadata, action, nil)
+	if err != nil {
+		return nil, err
+	}
+	reference := *tx.Reference
+
+	checkout, err := provider.Initialize(ctx, payment.InitializeRequest{Reference: reference, Email: email, Amount: amount})
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Payment initialization failed", "provider", name, "reference", reference, "error", err)
+		// Mark the deposit failed, even if the request itself was cancelled
+		if err := s.txRepo.UpdateStatus(context.WithoutCancel(ctx), tx.ID, models.TransactionStatusFailed); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to mark deposit failed", "reference", reference, "error", err)
+		}
+		metrics.RecordDeposit(string(models.TransactionStatusFailed), amount)
+		return nil, ErrPaymentProvider
+	}
+
+	metrics.RecordDeposit("initialized", amount)
+
+	return &InitializedDeposit{
+		Transaction:      tx,
+		AuthorizationURL: checkout.AuthorizationURL,
+	}, nil
+}
+
+// ChargeSavedCard deposits into the user's wallet by charging one of their saved cards, with
+// no checkout. Only cards the user paid with themselves can be charged, so an organization's
+// wallet can never be funded from a member's card. The deposit is settled from the provider's
+// answer; if that is still pending, or the provider couldn't be reached, the provider's
+// webhook settles it later.
+func (s *DepositService) ChargeSavedCard(ctx context.Context, userID, paymentMethodID uuid.UUID, walletNumber string, amount int64) (*models.Transaction, *models.PaymentMethod, error) {
+	if amount < MinAmount {
+		return nil, nil, ErrInvalidAmount
+	}
+
+	method, err := ownedPaymentMethod(ctx, s.paymentMethodRepo, userID, paymentMethodID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Cards used to be saved for the organization when a member paid one of its deposits;
+	// those are the member's, not the organization's
+	owner, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if owner == nil || !strings.EqualFold(owner.Email, method.Email) {
+		return nil, nil, ErrPaymentMethodNotFound
+	}
+	if !method.Reusable {
+		return nil, nil, ErrPaymentMethodNotReusable
+	}
+
+	provider, ok := s.providers.Get(method.Provider)
+	if !ok {
+		return nil, nil, ErrUnsupportedProvider
+	}
+	charger, ok := provider.(payment.AuthorizationCharger)
+	if !ok {
+		return nil, nil, ErrUnsupportedProvider.WithDetail(payment.DisplayName(method.Provider) + " can't charge saved cards")
+	}
+
+	metadata, err := repository.CreateMetadata(map[string]interface{}{"payment_method_id": method.ID, "payer_id": userID, "payer_email": method.Email})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build deposit metadata: %w", err)
+	}
+	description := fmt.Sprintf("Wallet deposit with %s card ending %s", method.Brand, method.Last4)
+	tx, err := s.createPending(ctx, userID, walletNumber, amount, method.Provider, description, metadata,
+		audit.ActionDepositCharge, gin.H{"payment_method_id": method.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	reference := *tx.Reference
+	metrics.RecordDeposit("initialized", amount)
+
+	charge, err := charger.ChargeAuthorization(ctx, payment.ChargeAuthorizationRequest{
+		Reference:         reference,
+		Email:             method.Email,
+		Amount:            amount,
+		AuthorizationCode: method.AuthorizationCode,
+	})
+	if err != nil {
+		// The card may have been charged even though the call failed, so the deposit stays
+		// pending for the webhook instead of being marked failed
+		s.logger.ErrorContext(ctx, "Saved card charge failed", "provider", method.Provider, "reference", reference, "error", err)
+		return nil, nil, ErrPaymentProvider.WithDetail("Failed to charge the card")
+	}
+
+	if err := s.paymentMethodRepo.MarkUsed(context.WithoutCancel(ctx), method.ID); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to record payment method use", "payment_method_id", method.ID, "error", err)
+	}
+
+	if charge.Status != payment.ChargePending {
+		// The provider answers for this deposit, whatever reference it echoes back
+		charge.Reference = reference
+		if err := s.Settle(context.WithoutCancel(ctx), method.Provider, *charge); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	settled, err := s.txRepo.FindByReference(ctx, reference)
+	if err != nil {
+		return nil, nil, err
+	}
+	return settled, method, nil
+}
+
+// createPending records a pending deposit into the user's wallet with walletNumber, or
+// their default wallet if walletNumber is empty. Unless action is empty, the deposit is audited
+// as action with details added to its state.
+func (s *DepositService) createPending(ctx context.Context, userID uuid.UUID, walletNumber string, amount int64, provider, description string, metadata []byte, action string, details gin.H) (*models.Transaction, error) {
+	reference := fmt.Sprintf("DEP_%s_%s", userID.String()[:8], uuid.New().String()[:8])
+	tx := &models.Transaction{
+		UserID:      userID,
+		Type:        models.TransactionTypeDeposit,
+		Amount:      amount,
+		Status:      models.TransactionStatusPending,
+		Reference:   &reference,
+		Description: &description,
+		Provider:    &provider,
+		Metadata:    metadata,
+	}
+
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		wallet, err := ownWallet(ctx, uow.Wallets, userID, walletNumber)
+		if err != nil {
+			return err
+		}
+		tx.WalletID = wallet.ID
+
+		if err := uow.Transactions.Create(ctx, tx); err != nil {
+			return err
+		}
+		if action == "" {
+			return nil
+		}
+
+		after := gin.H{"reference": reference, "amount": amount, "provider": provider, "status": tx.Status}
+		for key, value := range details {
+			after[key] = value
+		}
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      action,
+			TargetType:  audit.TargetTransaction,
+			TargetID:    tx.ID.String(),
+			After:       after,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Status returns the user's deposit with the given reference.
+// Other users' references are reported as not found.
+func (s *DepositService) Status(ctx context.Context, userID uuid.UUID, reference string) (*models.Transaction, error) {
+	tx, err := s.txRepo.FindByReference(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil || tx.UserID != userID || tx.Type != models.TransactionTypeDeposit {
+		return nil, ErrTransactionNotFound
+	}
+	return tx, nil
+}
+
+// Settle credits the wallet after a successful payment reported by the named provider, or
+// marks the deposit failed if the payment failed or doesn't match the deposit (provider,
+// amount, currency or customer). A successful payment that doesn't match is refunded. The
+// deposit row is locked while this is decided, so concurrent deliveries of the same event
+// settle it once; a deposit that is no longer pending is left alone, except that a payment
+// retried after a failed attempt can still settle it. The card a successful payment was made
+// with is saved for whoever paid: the user, or the member who paid an organization's deposit.
+// A payment through one of the user's payment links is credited to the link's totals instead,
+// and the payer's card isn't saved.
+func (s *DepositService) Settle(ctx context.Context, provider string, charge payment.Charge) error {
+	var tx *models.Transaction
+	var settled models.TransactionStatus
+	var reason string
+	var newCard *models.PaymentMethod
+
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		tx, err = uow.Transactions.FindByReferenceForUpdate(ctx, charge.Reference)
+		if err != nil {
+			return err
+		}
+		if tx == nil || tx.Type != models.TransactionTypeDeposit {
+			return ErrTransactionNotFound.WithDetail("Transaction not found: " + charge.Reference)
+		}
+
+		// Redeliveries and late events must not touch a settled deposit. Providers retry a
+		// declined payment under the same reference, so a failed attempt doesn't settle it
+		// for good.
+		link := linkPaymentOf(tx)
+		retried := tx.Status == models.TransactionStatusFailed && link.FailureReason == failedPaymentReason &&
+			charge.Status == payment.ChargeSuccess
+		if tx.Status != models.TransactionStatusPending && !retried {
+			return nil
+		}
+
+		// The charge comes from whoever the deposit was started for; deposits that predate
+		// recording the payer were always paid by the wallet owner
+		customerEmail := link.PayerEmail
+		if customerEmail == "" {
+			user, err := uow.Users.FindByID(ctx, tx.UserID)
+			if err != nil {
+				return err
+			}
+			if user != nil {
+				customerEmail = user.Email
+			}
+		}
+
+		reason = s.mismatch(ctx, tx, customerEmail, provider, charge)
+		metadata, err := chargeMetadata(provider, charge, reason)
+		if err != nil {
+			return err
+		}
+
+		if reason != "" {
+			settled = models.TransactionStatusFailed
+			if err := uow.Transactions.UpdateStatusAndMetadata(ctx, tx.ID, settled, metadata); err != nil {
+				return err
+			}
+			return s.recordSettle(ctx, uow, tx, gin.H{"status": settled, "reason": reason})
+		}
+
+		settled = models.TransactionStatusSuccess
+		if err := uow.Transactions.UpdateStatusAndMetadata(ctx, tx.ID, settled, metadata); err != nil {
+			return err
+		}
+		if err := uow.Wallets.Credit(ctx, tx.WalletID, tx.Amount); err != nil {
+			return err
+		}
+		if err := s.recordSettle(ctx, uow, tx, gin.H{"status": settled, "amount": tx.Amount}); err != nil {
+			return err
+		}
+
+		if link.PaymentLinkID != nil {
+			return uow.PaymentLinks.RecordPayment(ctx, *link.PaymentLinkID, tx.Amount)
+		}
+		payerID, known := link.payer(tx)
+		if charge.Authorization == nil || !known {
+			return nil
+		}
+		newCard, err = saveCard(ctx, uow, payerID, provider, charge)
+		if err != nil || newCard == nil {
+			return err
+		}
+		return s.auditor.RecordSystem(ctx, uow, audit.Event{
+			OwnerUserID: payerID,
+			Action:      audit.ActionPaymentMethodSave,
+			TargetType:  audit.TargetPaymentMethod,
+			TargetID:    newCard.ID.String(),
+			After:       gin.H{"provider": provider, "brand": newCard.Brand, "last4": newCard.Last4, "reusable": newCard.Reusable, "reference": charge.Reference},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if settled == "" {
+		s.logger.InfoContext(ctx, "Deposit already settled, skipping", "reference", charge.Reference, "status", tx.Status)
+		return nil
+	}
+
+	metrics.RecordDeposit(string(settled), tx.Amount)
+	s.logger.InfoContext(ctx, "Deposit settled", "provider", provider, "reference", charge.Reference, "status", settled, "amount", tx.Amount, "reason", reason)
+
+	// The customer paid, but for nothing this deposit can be credited with
+	if reason != "" && charge.Status == payment.ChargeSuccess {
+		s.refund(ctx, provider, tx, charge, reason)
+	}
+	return nil
+}
+
+// recordSettle audits the outcome of settling a deposit that was in tx's status
+func (s *DepositService) recordSettle(ctx context.Context, uow *repository.UnitOfWork, tx *models.Transaction, after gin.H) error {
+	return s.auditor.RecordSystem(ctx, uow, audit.Event{
+		OwnerUserID: tx.UserID,
+		Action:      audit.ActionDepositSettle,
+		TargetType:  audit.TargetTransaction,
+		TargetID:    tx.ID.String(),
+		Before:      gin.H{"status": tx.Status},
+		After:       after,
+	})
+}
+
+// refund returns a successful payment that was rejected for its deposit to the customer, and
+// records the refund with the deposit. A refund that fails is logged to be made by hand; the
+// deposit has already been marked failed, so retrying the event wouldn't refund it.
+func (s *DepositService) refund(ctx context.Context, providerName string, tx *models.Transaction, charge payment.Charge, reason string) {
+	provider, ok := s.providers.Get(providerName)
+	if !ok {
+		s.logger.ErrorContext(ctx, "Mismatched payment not refunded: provider not configured", "provider", providerName, "reference", charge.Reference)
+		return
+	}
+
+	refund, err := provider.Refund(ctx, charge.Reference, charge.Amount)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to refund mismatched payment", "provider", providerName, "reference", charge.Reference, "amount", charge.Amount, "error", err)
+		return
+	}
+
+	metadata, err := repository.CreateMetadata(map[string]interface{}{
+		"refund": map[string]interface{}{"id": refund.ID, "amount": refund.Amount, "status": refund.Status},
+	})
+	if err == nil {
+		err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+			if err := uow.Transactions.UpdateStatusAndMetadata(ctx, tx.ID, models.TransactionStatusFailed, metadata); err != nil {
+				return err
+			}
+			return s.auditor.RecordSystem(ctx, uow, audit.Event{
+				OwnerUserID: tx.UserID,
+				Action:      audit.ActionDepositRefund,
+				TargetType:  audit.TargetTransaction,
+				TargetID:    tx.ID.String(),
+				After:       gin.H{"refund_id": refund.ID, "amount": refund.Amount, "reason": reason},
+			})
+		})
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to record refund", "reference", charge.Reference, "refund_id", refund.ID, "error", err)
+	}
+
+	s.logger.InfoContext(ctx, "Mismatched payment refunded", "provider", providerName, "reference", charge.Reference, "amount", refund.Amount, "refund_id", refund.ID)
+}
+
+// failedPaymentReason is the failure reason of a deposit whose payment attempt failed
+const failedPaymentReason = "payment " + payment.ChargeFailed
+
+// mismatch returns why the charge can't settle the deposit, or "" if it can
+func (s *DepositService) mismatch(ctx context.Context, tx *models.Transaction, customerEmail, provider string, charge payment.Charge) string {
+	switch {
+	case charge.Status != payment.ChargeSuccess:
+		return "payment " + charge.Status
+	case tx.Provider != nil && *tx.Provider != provider:
+		s.logger.WarnContext(ctx, "Deposit provider mismatch", "reference", charge.Reference, "expected", *tx.Provider, "received", provider)
+		return "provider mismatch"
+	case charge.Amount != tx.Amount:
+		s.logger.WarnContext(ctx, "Deposit amount mismatch", "reference", charge.Reference, "expected", tx.Amount, "received", charge.Amount)
+		return "amount mismatch"
+	case charge.Currency != payment.Currency:
+		s.logger.WarnContext(ctx, "Deposit currency mismatch", "reference", charge.Reference, "expected", payment.Currency, "received", charge.Currency)
+		return "currency mismatch"
+	case customerEmail == "" || !strings.EqualFold(charge.CustomerEmail, customerEmail):
+		s.logger.WarnContext(ctx, "Deposit customer mismatch", "reference", charge.Reference)
+		return "customer mismatch"
+	default:
+		return ""
+	}
+}
+
+// linkPayment is who a deposit is paid by, as recorded in its metadata, the payment link it
+// was paid through, if any, and why it failed, if it did
+type linkPayment struct {
+	PaymentLinkID *uuid.UUID `json:"payment_link_id"`
+	PayerID       *uuid.UUID `json:"payer_id"`
+	PayerEmail    string     `json:"payer_email"`
+	FailureReason string     `json:"failure_reason"`
+}
+
+// linkPaymentOf reads the payer and payment link of a deposit; PaymentLinkID is nil for
+// ordinary deposits
+func linkPaymentOf(tx *models.Transaction) linkPayment {
+	var link linkPayment
+	if len(tx.Metadata) > 0 {
+		// Metadata is always an object, but a deposit that isn't a link payment simply
+		// leaves these fields empty
+		_ = json.Unmarshal(tx.Metadata, &link)
+	}
+	return link
+}
+
+// payer returns the user who paid the deposit tx, and false if that isn't known: deposits that
+// predate recording the payer's ID were paid by the wallet owner unless they recorded an email
+func (l linkPayment) payer(tx *models.Transaction) (uuid.UUID, bool) {
+	switch {
+	case l.PayerID != nil:
+		return *l.PayerID, true
+	case l.PayerEmail == "":
+		return tx.UserID, true
+	default:
+		return uuid.Nil, false
+	}
+}
+
+// saveCard saves the card the charge was paid with, or refreshes it if the user saved it
+// before. It returns the card only if it is new.
+func saveCard(ctx context.Context, uow *repository.UnitOfWork, userID uuid.UUID, provider string, charge payment.Charge) (*models.PaymentMethod, error) {
+	auth := charge.Authorization
+	method := &models.PaymentMethod{
+		UserID:            userID,
+		Provider:          provider,
+		AuthorizationCode: auth.Code,
+		Signature:         auth.Signature,
+		Email:             charge.CustomerEmail,
+		Last4:             auth.Last4,
+		Brand:             auth.Brand,
+		CardType:          optionalString(auth.CardType),
+		Bank:              optionalString(auth.Bank),
+		ExpMonth:          auth.ExpMonth,
+		ExpYear:           auth.ExpYear,
+		Reusable:          auth.Reusable,
+	}
+	// Without a card fingerprint each authorization is its own card
+	if method.Signature == "" {
+		method.Signature = auth.Code
+	}
+
+	created, err := uow.PaymentMethods.Upsert(ctx, method)
+	if err != nil || !created {
+		return nil, err
+	}
+	return method, nil
+}
+
+// optionalString turns an empty string into NULL
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// chargeMetadata records what the provider reported about the payment, under the provider's
+// name, and why it was rejected if it was
+func chargeMetadata(provider string, c payment.Charge, reason string) ([]byte, error) {
+	data := map[string]interface{}{
+		provider: map[string]interface{}{
+			"id":               c.ID,
+			"channel":          c.Channel,
+			"paid_at":          c.PaidAt,
+			"fees":             c.Fees,
+			"currency":         c.Currency,
+			"customer_email":   c.CustomerEmail,
+			"gateway_response": c.GatewayResponse,
+		},
+	}
+	if reason != "" {
+		data["failure_reason"] = reason
+	} else {
+		// A retried payment that succeeds clears the failed attempt's reason
+		data["failure_reason"] = nil
+	}
+
+	metadata, err := repository.CreateMetadata(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build deposit metadata: %w", err)
+	}
+	return metadata, nil
+}
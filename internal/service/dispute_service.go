@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Dispute list roles
+const (
+	DisputeRoleSender    = "sender"    // disputes the caller opened
+	DisputeRoleRecipient = "recipient" // disputes against transfers the caller received
+)
+
+// DisputeService lets the sender of a transfer ask for it to be undone. The recipient
+// approves (returning the money) or rejects the dispute, and an admin can force the
+// reversal either way. A reversal is a new transfer from the recipient back to the sender
+// whose entries point at the ones they compensate; the original entries never change.
+type DisputeService struct {
+	txManager   repository.TxManager
+	disputeRepo repository.DisputeRepository
+	auditor     *audit.Recorder
+	logger      *slog.Logger
+}
+
+func NewDisputeService(txManager repository.TxManager, disputeRepo repository.DisputeRepository, auditor *audit.Recorder, logger *slog.Logger) *DisputeService {
+	return &DisputeService{
+		txManager:   txManager,
+		disputeRepo: disputeRepo,
+		auditor:     auditor,
+		logger:      logger,
+	}
+}
+
+// Open disputes a transfer the user sent. reference is the transfer's reference, as
+// returned by POST /wallet/transfer.
+func (s *DisputeService) Open(ctx context.Context, userID uuid.UUID, reference, reason string) (*models.Dispute, error) {
+	reference = strings.TrimSpace(reference)
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrInvalidDispute.WithDetail("reason is required")
+	}
+
+	var dispute *models.Dispute
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		// Locking the sender's entry serializes disputes of the same transfer
+		debit, err := uow.Transactions.FindByReferenceForUpdate(ctx, reference+"_OUT")
+		if err != nil {
+			return err
+		}
+		if debit == nil || debit.UserID != userID || debit.Type != models.TransactionTypeTransferOut {
+			return ErrTransferNotFound
+		}
+		if debit.Status != models.TransactionStatusSuccess {
+			return ErrInvalidDispute.WithDetail("Only completed transfers can be disputed")
+		}
+		if debit.ReversalOf != nil {
+			return ErrInvalidDispute.WithDetail("A reversal can't be disputed")
+		}
+
+		credit, err := uow.Transactions.FindByReference(ctx, reference+"_IN")
+		if err != nil {
+			return err
+		}
+		if credit == nil {
+			return ErrTransferNotFound
+		}
+
+		// Money moved into a system wallet (e.g. escrow funding) is settled through that
+		// feature; reversing it would pay the sender back while the escrow stays funded
+		recipientWallet, err := uow.Wallets.FindByID(ctx, credit.WalletID)
+		if err != nil {
+			return err
+		}
+		if recipientWallet == nil || recipientWallet.IsSystem {
+			return ErrInvalidDispute.WithDetail("This transfer can't be disputed")
+		}
+
+		existing, err := uow.Disputes.FindActiveByReference(ctx, reference)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return ErrDisputeExists.WithDetail("This transfer is already disputed (" + string(existing.Status) + ")")
+		}
+
+		dispute = &models.Dispute{
+			TransferReference:   reference,
+			DebitTransactionID:  debit.ID,
+			CreditTransactionID: credit.ID,
+			SenderUserID:        debit.UserID,
+			SenderWalletID:      debit.WalletID,
+			RecipientUserID:     credit.UserID,
+			RecipientWalletID:   credit.WalletID,
+			Amount:              debit.Amount,
+			Reason:              reason,
+			Status:              models.DisputeStatusOpen,
+		}
+		if err := uow.Disputes.Create(ctx, dispute); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionDisputeOpen,
+			TargetType:  audit.TargetDispute,
+			TargetID:    dispute.ID.String(),
+			After: map[string]interface{}{
+				"transfer_reference": dispute.TransferReference,
+				"amount":             dispute.Amount,
+				"reason":             dispute.Reason,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Dispute opened", "dispute_id", dispute.ID, "transfer_reference", reference, "amount", dispute.Amount)
+	return dispute, nil
+}
+
+// List returns the disputes the user opened (sender) or that are against transfers they
+// received (recipient), newest first, optionally only those with the given status
+func (s *DisputeService) List(ctx context.Context, userID uuid.UUID, role, status string, limit, offset int) ([]models.Dispute, error) {
+	if err := validateDisputeStatus(status); err != nil {
+		return nil, err
+	}
+
+	switch role {
+	case DisputeRoleSender:
+		return s.disputeRepo.ListBySender(ctx, userID, status, limit, offset)
+	case DisputeRoleRecipient:
+		return s.disputeRepo.ListByRecipient(ctx, userID, status, limit, offset)
+	default:
+		return nil, ErrInvalidDispute.WithDetail("role must be sender or recipient")
+	}
+}
+
+// ListAll returns every dispute, newest first, optionally only those with the given status.
+// It is for admins.
+func (s *DisputeService) ListAll(ctx context.Context, status string, limit, offset int) ([]models.Dispute, error) {
+	if err := validateDisputeStatus(status); err != nil {
+		return nil, err
+	}
+	return s.disputeRepo.List(ctx, status, limit, offset)
+}
+
+// Get returns a dispute the user is the sender or recipient of
+func (s *DisputeService) Get(ctx context.Context, userID, id uuid.UUID) (*models.Dispute, error) {
+	dispute, err := s.disputeRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dispute == nil || (dispute.SenderUserID != userID && dispute.RecipientUserID != userID) {
+		return nil, ErrDisputeNotFound
+	}
+	return dispute, nil
+}
+
+// GetAny returns any dispute. It is for admins.
+func (s *DisputeService) GetAny(ctx context.Context, id uuid.UUID) (*models.Dispute, error) {
+	dispute, err := s.disputeRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dispute == nil {
+		return nil, ErrDisputeNotFound
+	}
+	return dispute, nil
+}
+
+// Approve returns the disputed amount from the recipient's wallet to the sender's. Only the
+// recipient may approve, and their balance must cover the amount.
+func (s *DisputeService) Approve(ctx context.Context, userID, id uuid.UUID) (*models.Dispute, error) {
+	dispute, _, err := s.resolve(ctx, userID, id, audit.ActionDisputeApprove, recipientOnly(userID), []models.DisputeStatus{models.DisputeStatusOpen}, func(uow *repository.UnitOfWork, dispute *models.Dispute) error {
+		return s.reverse(ctx, uow, dispute, userID, models.DisputeResolutionApproved, "")
+	})
+	return dispute, err
+}
+
+// Reject refuses a dispute against a transfer the user received. note optionally says why.
+func (s *DisputeService) Reject(ctx context.Context, userID, id uuid.UUID, note string) (*models.Dispute, error) {
+	dispute, _, err := s.resolve(ctx, userID, id, audit.ActionDisputeReject, recipientOnly(userID), []models.DisputeStatus{models.DisputeStatusOpen}, func(uow *repository.UnitOfWork, dispute *models.Dispute) error {
+		dispute.Status = models.DisputeStatusRejected
+		dispute.ResolutionNote = optionalString(strings.TrimSpace(note))
+		dispute.ResolvedByUserID = &userID
+		return nil
+	})
+	return dispute, err
+}
+
+// Cancel withdraws a dispute the user opened
+func (s *DisputeService) Cancel(ctx context.Context, userID, id uuid.UUID) (*models.Dispute, error) {
+	authorize := func(dispute *models.Dispute) error {
+		switch userID {
+		case dispute.SenderUserID:
+			return nil
+		case dispute.RecipientUserID:
+			return ErrDisputeForbidden.WithDetail("Only the sender can cancel this dispute")
+		default:
+			return ErrDisputeNotFound
+		}
+	}
+
+	dispute, _, err := s.resolve(ctx, userID, id, audit.ActionDisputeCancel, authorize, []models.DisputeStatus{models.DisputeStatusOpen}, func(uow *repository.UnitOfWork, dispute *models.Dispute) error {
+		dispute.Status = models.DisputeStatusCancelled
+		dispute.ResolvedByUserID = &userID
+		return nil
+	})
+	return dispute, err
+}
+
+// Force reverses an open or rejected dispute on behalf of adminID, whatever the recipient
+// says, and returns the status it was in. The recipient's balance must still cover the amount.
+func (s *DisputeService) Force(ctx context.Context, adminID, id uuid.UUID, note string) (*models.Dispute, models.DisputeStatus, error) {
+	anyone := func(*models.Dispute) error { return nil }
+
+	return s.resolve(ctx, adminID, id, audit.ActionDisputeForce, anyone, []models.DisputeStatus{models.DisputeStatusOpen, models.DisputeStatusRejected}, func(uow *repository.UnitOfWork, dispute *models.Dispute) error {
+		return s.reverse(ctx, uow, dispute, adminID, models.DisputeResolutionForced, note)
+	})
+}
+
+// resolve runs fn on a locked dispute the caller may act on (authorize returns nil) and
+// whose status is one of from, then saves and audits the result under userID in the same
+// transaction. It returns the dispute and the status it was in.
+func (s *DisputeService) resolve(ctx context.Context, userID, id uuid.UUID, action string, authorize func(*models.Dispute) error, from []models.DisputeStatus, fn func(uow *repository.UnitOfWork, dispute *models.Dispute) error) (*models.Dispute, models.DisputeStatus, error) {
+	var dispute *models.Dispute
+	var before models.DisputeStatus
+
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		dispute, err = uow.Disputes.FindByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if dispute == nil {
+			return ErrDisputeNotFound
+		}
+		if err := authorize(dispute); err != nil {
+			return err
+		}
+
+		allowed := false
+		for _, status := range from {
+			allowed = allowed || dispute.Status == status
+		}
+		if !allowed {
+			return ErrDisputeState.WithDetail("Dispute is " + string(dispute.Status))
+		}
+
+		before = dispute.Status
+		if err := fn(uow, dispute); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		dispute.ResolvedAt = &now
+		if err := uow.Disputes.Update(ctx, dispute); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      action,
+			TargetType:  audit.TargetDispute,
+			TargetID:    dispute.ID.String(),
+			Before:      map[string]interface{}{"status": before},
+			After: map[string]interface{}{
+				"status":             dispute.Status,
+				"resolution_note":    dispute.ResolutionNote,
+				"reversal_reference": dispute.ReversalReference,
+			},
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.logger.InfoContext(ctx, "Dispute resolved", "dispute_id", dispute.ID, "from", before, "to", dispute.Status, "reversal_reference", dispute.ReversalReference)
+	return dispute, before, nil
+}
+
+// reverse moves the disputed amount from the recipient's wallet back to the sender's and
+// writes the compensating entries, each linked to the original entry on the same wallet.
+// The reversal is audited against the recipient's account, whose wallet it debits.
+func (s *DisputeService) reverse(ctx context.Context, uow *repository.UnitOfWork, dispute *models.Dispute, resolvedBy uuid.UUID, resolution models.DisputeResolution, note string) error {
+	sender, err := uow.Wallets.FindByID(ctx, dispute.SenderWalletID)
+	if err != nil {
+		return err
+	}
+	recipient, err := uow.Wallets.FindByID(ctx, dispute.RecipientWalletID)
+	if err != nil {
+		return err
+	}
+	if sender == nil || recipient == nil {
+		return ErrWalletNotFound.WithDetail("The sender's or recipient's wallet no longer exists")
+	}
+
+	if err := uow.Wallets.LockForUpdate(ctx, sender.ID, recipient.ID); err != nil {
+		return err
+	}
+
+	if err := uow.Wallets.Debit(ctx, recipient.ID, dispute.Amount); err != nil {
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			return ErrInsufficientFunds.WithDetail("The recipient's balance doesn't cover the reversal")
+		}
+		return err
+	}
+	if err := uow.Wallets.Credit(ctx, sender.ID, dispute.Amount); err != nil {
+		return err
+	}
+
+	reference := fmt.Sprintf("REV_%s_%s", dispute.SenderUserID.String()[:8], uuid.New().String()[:8])
+	metadata := map[string]interface{}{
+		"dispute_id":         dispute.ID,
+		"transfer_reference": dispute.TransferReference,
+	}
+
+	debit, err := transferEntry(recipient, sender, models.TransactionTypeTransferOut, dispute.Amount, reference+"_OUT", "Reversal of "+dispute.TransferReference, metadata)
+	if err != nil {
+		return err
+	}
+	debit.ReversalOf = &dispute.CreditTransactionID
+	if err := uow.Transactions.Create(ctx, debit); err != nil {
+		return err
+	}
+
+	credit, err := transferEntry(sender, recipient, models.TransactionTypeTransferIn, dispute.Amount, reference+"_IN", "Reversal of "+dispute.TransferReference, metadata)
+	if err != nil {
+		return err
+	}
+	credit.ReversalOf = &dispute.DebitTransactionID
+	if err := uow.Transactions.Create(ctx, credit); err != nil {
+		return err
+	}
+
+	dispute.Status = models.DisputeStatusReversed
+	dispute.Resolution = &resolution
+	dispute.ResolutionNote = optionalString(strings.TrimSpace(note))
+	dispute.ResolvedByUserID = &resolvedBy
+	dispute.ReversalReference = &reference
+
+	return s.auditor.Record(ctx, uow, audit.Event{
+		OwnerUserID: dispute.RecipientUserID,
+		Action:      audit.ActionTransferReverse,
+		TargetType:  audit.TargetWallet,
+		TargetID:    dispute.SenderWalletID.String(),
+		After: map[string]interface{}{
+			"reference":          reference,
+			"transfer_reference": dispute.TransferReference,
+			"amount":             dispute.Amount,
+			"dispute_id":         dispute.ID,
+			"resolution":         resolution,
+		},
+	})
+}
+
+// recipientOnly lets only the recipient act on a dispute; the sender is told so and anyone
+// else doesn't see it
+func recipientOnly(userID uuid.UUID) func(*models.Dispute) error {
+	return func(dispute *models.Dispute) error {
+		switch userID {
+		case dispute.RecipientUserID:
+			return nil
+		case dispute.SenderUserID:
+			return ErrDisputeForbidden
+		default:
+			return ErrDisputeNotFound
+		}
+	}
+}
+
+// validateDisputeStatus checks a status filter
+func validateDisputeStatus(status string) error {
+	switch models.DisputeStatus(status) {
+	case "", models.DisputeStatusOpen, models.DisputeStatusReversed, models.DisputeStatusRejected, models.DisputeStatusCancelled:
+		return nil
+	default:
+		return ErrInvalidDispute.WithDetail("status must be one of open, reversed, rejected or cancelled")
+	}
+}
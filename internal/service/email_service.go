@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/mail"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+)
+
+const (
+	emailBatchSize   = 20               // Emails claimed per query
+	emailLease       = time.Minute      // How long an attempt owns an email before it is due again
+	emailSendTimeout = 30 * time.Second // Deadline for sending one email
+	emailRetryBase   = 30 * time.Second // Delay before the first retry, doubled on each retry
+	emailRetryMax    = time.Hour
+)
+
+// EmailService sends queued emails in the background. Emails are queued with Queue in the
+// transaction of the change they announce, so they go out only if that change commits, and a
+// mail server that is down delays them rather than losing them.
+type EmailService struct {
+	emailRepo   repository.EmailRepository
+	sender      mail.Sender
+	maxAttempts int
+	wake        chan struct{}
+	logger      *slog.Logger
+}
+
+// NewEmailService creates the service. Emails are marked failed after maxAttempts.
+func NewEmailService(emailRepo repository.EmailRepository, sender mail.Sender, maxAttempts int, logger *slog.Logger) *EmailService {
+	return &EmailService{
+		emailRepo:   emailRepo,
+		sender:      sender,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+		logger:      logger,
+	}
+}
+
+// Queue stores msg to be sent once uow's transaction commits. Call Wake after the commit to
+// send it straight away.
+func (s *EmailService) Queue(ctx context.Context, uow *repository.UnitOfWork, msg mail.Message) error {
+	return uow.Emails.Create(ctx, &models.Email{Recipient: msg.To, Subject: msg.Subject, Body: msg.Body})
+}
+
+// Wake lets the worker send queued emails now instead of on its next poll
+func (s *EmailService) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due emails every interval, or sooner when woken, until ctx is cancelled. beat is
+// called after each round and each email, for the worker's heartbeat.
+func (s *EmailService) Run(ctx context.Context, interval time.Duration, beat func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		beat()
+		s.SendDue(ctx, beat)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// SendDue claims and sends due emails until none are left or ctx is cancelled
+func (s *EmailService) SendDue(ctx context.Context, beat func()) {
+	for ctx.Err() == nil {
+		emails, err := s.emailRepo.ClaimDue(ctx, emailBatchSize, emailLease)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.ErrorContext(ctx, "Failed to claim emails", "error", err)
+			}
+			return
+		}
+		if len(emails) == 0 {
+			return
+		}
+
+		for i := range emails {
+			s.send(ctx, &emails[i])
+			beat()
+		}
+	}
+}
+
+// send makes one attempt at an email and records the outcome. Failures are retried with
+// exponential backoff until maxAttempts. If the outcome isn't saved the lease expires and the
+// email is tried again.
+func (s *EmailService) send(ctx context.Context, email *models.Email) {
+	// Once started, an attempt finishes even if the worker is stopping
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), emailSendTimeout)
+	defer cancel()
+
+	logger := s.logger.With("email_id", email.ID, "attempt", email.Attempts)
+
+	err := s.sender.Send(ctx, mail.Message{To: email.Recipient, Subject: email.Subject, Body: email.Body})
+	switch {
+	case err == nil:
+		err = s.emailRepo.Complete(ctx, email.ID, models.EmailStatusSent, nil)
+
+	case email.Attempts >= s.maxAttempts:
+		logger.ErrorContext(ctx, "Email failed", "error", err)
+		message := err.Error()
+		err = s.emailRepo.Complete(ctx, email.ID, models.EmailStatusFailed, &message)
+
+	default:
+		delay := emailRetryBase << (email.Attempts - 1)
+		if delay <= 0 || delay > emailRetryMax {
+			delay = emailRetryMax
+		}
+		logger.WarnContext(ctx, "Sending email failed, will retry", "error", err, "retry_in", delay)
+		err = s.emailRepo.Retry(ctx, email.ID, err.Error(), time.Now().Add(delay))
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to record email outcome", "error", err)
+	}
+}
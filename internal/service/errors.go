@@ -0,0 +1,141 @@
+package service
+
+// Kind classifies a domain error so the transport layer can choose a status code
+type Kind int
+
+const (
+	KindInternal    Kind = iota // unexpected failure
+	KindInvalid                 // the request breaks a business rule
+	KindNotFound                // the resource doesn't exist or isn't visible to the caller
+	KindForbidden               // the caller may not act on the resource
+	KindConflict                // the resource is in the wrong state for the action
+	KindUnavailable             // an upstream dependency failed
+)
+
+// Error is a domain error with a stable, machine-readable code
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches any error with the same code, so errors.Is still finds a sentinel after WithDetail
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithDetail returns a copy of the error with a more specific message
+func (e *Error) WithDetail(message string) *Error {
+	detailed := *e
+	detailed.Message = message
+	return &detailed
+}
+
+// Wallet errors
+var (
+	ErrWalletNotFound    = &Error{Kind: KindNotFound, Code: "wallet_not_found", Message: "Wallet not found"}
+	ErrRecipientNotFound = &Error{Kind: KindNotFound, Code: "recipient_not_found", Message: "Recipient wallet not found"}
+	ErrInvalidAmount     = &Error{Kind: KindInvalid, Code: "invalid_amount", Message: "Amount must be at least 100 kobo"}
+	ErrSelfTransfer      = &Error{Kind: KindInvalid, Code: "self_transfer", Message: "Cannot transfer to the same wallet"}
+	ErrInsufficientFunds = &Error{Kind: KindInvalid, Code: "insufficient_funds", Message: "Insufficient balance"}
+	ErrInvalidWalletName = &Error{Kind: KindInvalid, Code: "invalid_wallet_name", Message: "Wallet name must be 1 to 50 characters"}
+	ErrWalletNameTaken   = &Error{Kind: KindConflict, Code: "wallet_name_taken", Message: "You already have a wallet with that name"}
+	ErrWalletLimit       = &Error{Kind: KindInvalid, Code: "wallet_limit_reached", Message: "Maximum wallets per user reached"}
+)
+
+// Deposit errors
+var (
+	ErrTransactionNotFound = &Error{Kind: KindNotFound, Code: "transaction_not_found", Message: "Transaction not found"}
+	ErrPaymentProvider     = &Error{Kind: KindUnavailable, Code: "payment_provider_error", Message: "Failed to initialize payment"}
+	ErrUnsupportedProvider = &Error{Kind: KindInvalid, Code: "unsupported_provider", Message: "Payment provider is not supported"}
+)
+
+// Payment method errors
+var (
+	ErrPaymentMethodNotFound    = &Error{Kind: KindNotFound, Code: "payment_method_not_found", Message: "Payment method not found"}
+	ErrPaymentMethodNotReusable = &Error{Kind: KindInvalid, Code: "payment_method_not_reusable", Message: "This card can't be charged again; deposit with checkout instead"}
+)
+
+// Scheduled transfer errors
+var (
+	ErrScheduledTransferNotFound = &Error{Kind: KindNotFound, Code: "scheduled_transfer_not_found", Message: "Scheduled transfer not found"}
+	ErrInvalidSchedule           = &Error{Kind: KindInvalid, Code: "invalid_schedule", Message: "Invalid schedule"}
+	ErrScheduledTransferState    = &Error{Kind: KindConflict, Code: "invalid_scheduled_transfer_state", Message: "Scheduled transfer can't do that in its current state"}
+)
+
+// Payment request errors
+var (
+	ErrInvalidPaymentRequest   = &Error{Kind: KindInvalid, Code: "invalid_payment_request", Message: "Invalid payment request"}
+	ErrPayerNotFound           = &Error{Kind: KindNotFound, Code: "payer_not_found", Message: "Payer wallet not found"}
+	ErrPaymentRequestNotFound  = &Error{Kind: KindNotFound, Code: "payment_request_not_found", Message: "Payment request not found"}
+	ErrPaymentRequestForbidden = &Error{Kind: KindForbidden, Code: "payment_request_forbidden", Message: "Only the payer can answer this payment request"}
+	ErrPaymentRequestState     = &Error{Kind: KindConflict, Code: "invalid_payment_request_state", Message: "Payment request is no longer pending"}
+)
+
+// Payment link errors
+var (
+	ErrPaymentLinkNotFound = &Error{Kind: KindNotFound, Code: "payment_link_not_found", Message: "Payment link not found"}
+	ErrPaymentLinkInactive = &Error{Kind: KindConflict, Code: "payment_link_inactive", Message: "Payment link is no longer accepting payments"}
+)
+
+// Transfer batch errors
+var (
+	ErrInvalidBatch     = &Error{Kind: KindInvalid, Code: "invalid_batch", Message: "Invalid transfer batch"}
+	ErrBatchNotFound    = &Error{Kind: KindNotFound, Code: "batch_not_found", Message: "Transfer batch not found"}
+	ErrBatchItemAborted = &Error{Kind: KindConflict, Code: "batch_aborted", Message: "Not sent because another transfer in the all-or-nothing batch failed"}
+)
+
+// Dispute errors
+var (
+	ErrInvalidDispute   = &Error{Kind: KindInvalid, Code: "invalid_dispute", Message: "Invalid dispute"}
+	ErrTransferNotFound = &Error{Kind: KindNotFound, Code: "transfer_not_found", Message: "Transfer not found"}
+	ErrDisputeNotFound  = &Error{Kind: KindNotFound, Code: "dispute_not_found", Message: "Dispute not found"}
+	ErrDisputeForbidden = &Error{Kind: KindForbidden, Code: "dispute_forbidden", Message: "Only the recipient can approve or reject this dispute"}
+	ErrDisputeExists    = &Error{Kind: KindConflict, Code: "dispute_exists", Message: "This transfer is already disputed"}
+	ErrDisputeState     = &Error{Kind: KindConflict, Code: "invalid_dispute_state", Message: "Dispute can't do that in its current state"}
+)
+
+// Escrow errors
+var (
+	ErrInvalidEscrow   = &Error{Kind: KindInvalid, Code: "invalid_escrow", Message: "Invalid escrow"}
+	ErrEscrowNotFound  = &Error{Kind: KindNotFound, Code: "escrow_not_found", Message: "Escrow not found"}
+	ErrEscrowForbidden = &Error{Kind: KindForbidden, Code: "escrow_forbidden", Message: "You can't do that to this escrow"}
+	ErrEscrowState     = &Error{Kind: KindConflict, Code: "invalid_escrow_state", Message: "Escrow has already been settled"}
+)
+
+// Organization errors
+var (
+	ErrInvalidOrganization   = &Error{Kind: KindInvalid, Code: "invalid_organization", Message: "Invalid organization"}
+	ErrOrganizationNotFound  = &Error{Kind: KindNotFound, Code: "organization_not_found", Message: "Organization not found"}
+	ErrOrganizationForbidden = &Error{Kind: KindForbidden, Code: "organization_forbidden", Message: "Your role in this organization doesn't allow that"}
+	ErrMemberNotFound        = &Error{Kind: KindNotFound, Code: "member_not_found", Message: "Organization member not found"}
+	ErrAlreadyMember         = &Error{Kind: KindConflict, Code: "already_member", Message: "User is already a member of this organization"}
+	ErrLastOwner             = &Error{Kind: KindConflict, Code: "last_owner", Message: "An organization must keep at least one owner"}
+	ErrInvitationNotFound    = &Error{Kind: KindNotFound, Code: "invitation_not_found", Message: "Invitation not found"}
+	ErrInvitationExists      = &Error{Kind: KindConflict, Code: "invitation_exists", Message: "That email already has a pending invitation"}
+	ErrInvitationState       = &Error{Kind: KindConflict, Code: "invalid_invitation_state", Message: "Invitation is no longer pending"}
+)
+
+// API key errors
+var (
+	ErrAPIKeyNotFound     = &Error{Kind: KindNotFound, Code: "api_key_not_found", Message: "API key not found"}
+	ErrAPIKeyNotOwned     = &Error{Kind: KindForbidden, Code: "api_key_not_owned", Message: "You do not own this API key"}
+	ErrAPIKeyNotExpired   = &Error{Kind: KindInvalid, Code: "api_key_not_expired", Message: "API key is not expired yet"}
+	ErrAPIKeyLimitReached = &Error{Kind: KindInvalid, Code: "api_key_limit_reached", Message: "Maximum 5 active API keys allowed per user"}
+	ErrInvalidPermissions = &Error{Kind: KindInvalid, Code: "invalid_permissions", Message: "Invalid permissions"}
+	ErrInvalidExpiry      = &Error{Kind: KindInvalid, Code: "invalid_expiry", Message: "Invalid expiry"}
+)
+
+// Webhook errors
+var (
+	ErrInvalidWebhookPayload  = &Error{Kind: KindInvalid, Code: "invalid_payload", Message: "Invalid payload"}
+	ErrStaleWebhook           = &Error{Kind: KindInvalid, Code: "stale_webhook", Message: "Webhook is outside the replay window"}
+	ErrInvalidWebhookStatus   = &Error{Kind: KindInvalid, Code: "invalid_status", Message: "status must be one of pending, processed, ignored or failed"}
+	ErrWebhookEventNotFound   = &Error{Kind: KindNotFound, Code: "webhook_event_not_found", Message: "Webhook event not found"}
+	ErrWebhookEventInProgress = &Error{Kind: KindConflict, Code: "webhook_event_in_progress", Message: "Webhook event is being processed, try again shortly"}
+)
@@ -0,0 +1,432 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/utils"
+	"github.com/google/uuid"
+)
+
+// Escrow list roles
+const (
+	EscrowRoleBuyer   = "buyer"   // escrows the caller funded
+	EscrowRoleSeller  = "seller"  // escrows the caller is to be paid from
+	EscrowRoleArbiter = "arbiter" // escrows the caller arbitrates
+)
+
+// EscrowService holds a buyer's payment until the deal is done. Creating an escrow moves
+// the amount from the buyer's wallet into the system escrow wallet; settling it moves the
+// amount out again to the seller, back to the buyer or split between them. Every move is an
+// ordinary pair of transfer entries, so the ledger still balances, and every step is kept in
+// the escrow's history.
+type EscrowService struct {
+	txManager  repository.TxManager
+	escrowRepo repository.EscrowRepository
+	walletRepo repository.WalletRepository
+	auditor    *audit.Recorder
+	logger     *slog.Logger
+}
+
+func NewEscrowService(txManager repository.TxManager, escrowRepo repository.EscrowRepository, walletRepo repository.WalletRepository, auditor *audit.Recorder, logger *slog.Logger) *EscrowService {
+	return &EscrowService{
+		txManager:  txManager,
+		escrowRepo: escrowRepo,
+		walletRepo: walletRepo,
+		auditor:    auditor,
+		logger:     logger,
+	}
+}
+
+// EscrowInput describes a new escrow
+type EscrowInput struct {
+	SellerWalletNumber  string
+	ArbiterWalletNumber string // Optional; the arbiter can release, refund or split
+	Amount              int64
+	Description         string
+	Conditions          string // What the seller has to do before the money is released
+	Deadline            string // e.g. 7D; once it passes the buyer can take a refund themselves
+}
+
+// Create funds an escrow from the buyer's wallet
+func (s *EscrowService) Create(ctx context.Context, buyerID uuid.UUID, input EscrowInput) (*models.Escrow, error) {
+	if input.Amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+
+	var deadline *time.Time
+	if input.Deadline != "" {
+		parsed, err := utils.ParseExpiry(input.Deadline)
+		if err != nil {
+			return nil, ErrInvalidEscrow.WithDetail(err.Error())
+		}
+		if !parsed.After(time.Now()) {
+			return nil, ErrInvalidEscrow.WithDetail("deadline must be in the future")
+		}
+		deadline = &parsed
+	}
+
+	seller, err := s.walletRepo.FindByWalletNumber(ctx, input.SellerWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+	if seller == nil {
+		return nil, ErrRecipientNotFound.WithDetail("Seller wallet not found")
+	}
+	if seller.UserID == buyerID {
+		return nil, ErrSelfTransfer
+	}
+
+	var arbiterID *uuid.UUID
+	if input.ArbiterWalletNumber != "" {
+		arbiter, err := s.walletRepo.FindByWalletNumber(ctx, input.ArbiterWalletNumber)
+		if err != nil {
+			return nil, err
+		}
+		if arbiter == nil {
+			return nil, ErrRecipientNotFound.WithDetail("Arbiter wallet not found")
+		}
+		if arbiter.UserID == buyerID || arbiter.UserID == seller.UserID {
+			return nil, ErrInvalidEscrow.WithDetail("The arbiter can't be the buyer or the seller")
+		}
+		arbiterID = &arbiter.UserID
+	}
+
+	var escrow *models.Escrow
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		buyer, err := uow.Wallets.FindByUserID(ctx, buyerID)
+		if err != nil {
+			return err
+		}
+		if buyer == nil {
+			return ErrWalletNotFound
+		}
+		vault, err := s.vault(ctx, uow)
+		if err != nil {
+			return err
+		}
+
+		if err := uow.Wallets.LockForUpdate(ctx, buyer.ID, vault.ID); err != nil {
+			return err
+		}
+
+		escrow = &models.Escrow{
+			ID:                 uuid.New(),
+			BuyerUserID:        buyerID,
+			BuyerWalletID:      buyer.ID,
+			BuyerWalletNumber:  buyer.WalletNumber,
+			SellerUserID:       seller.UserID,
+			SellerWalletID:     seller.ID,
+			SellerWalletNumber: seller.WalletNumber,
+			ArbiterUserID:      arbiterID,
+			Amount:             input.Amount,
+			Description:        optionalString(strings.TrimSpace(input.Description)),
+			Conditions:         optionalString(strings.TrimSpace(input.Conditions)),
+			Deadline:           deadline,
+			Status:             models.EscrowStatusFunded,
+		}
+		escrow.FundingReference = escrowReference(escrow)
+
+		if err := s.move(ctx, uow, escrow, buyer, vault, input.Amount, escrow.FundingReference, "Escrow funding"); err != nil {
+			return err
+		}
+
+		if err := uow.Escrows.Create(ctx, escrow); err != nil {
+			return err
+		}
+		if err := uow.Escrows.CreateEvent(ctx, &models.EscrowEvent{
+			EscrowID:    escrow.ID,
+			Action:      models.EscrowStatusFunded,
+			ActorUserID: &buyerID,
+			BuyerAmount: input.Amount,
+			Reference:   &escrow.FundingReference,
+		}); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: buyerID,
+			Action:      audit.ActionEscrowCreate,
+			TargetType:  audit.TargetEscrow,
+			TargetID:    escrow.ID.String(),
+			After: map[string]interface{}{
+				"seller_wallet_number": escrow.SellerWalletNumber,
+				"arbiter_user_id":      escrow.ArbiterUserID,
+				"amount":               escrow.Amount,
+				"reference":            escrow.FundingReference,
+				"deadline":             escrow.Deadline,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Escrow funded", "escrow_id", escrow.ID, "amount", escrow.Amount, "reference", escrow.FundingReference)
+	return escrow, nil
+}
+
+// List returns the escrows the user funded (buyer), is to be paid from (seller) or
+// arbitrates (arbiter), newest first, optionally only those with the given status
+func (s *EscrowService) List(ctx context.Context, userID uuid.UUID, role, status string, limit, offset int) ([]models.Escrow, error) {
+	switch models.EscrowStatus(status) {
+	case "", models.EscrowStatusFunded, models.EscrowStatusReleased, models.EscrowStatusRefunded, models.EscrowStatusSplit:
+	default:
+		return nil, ErrInvalidEscrow.WithDetail("status must be one of funded, released, refunded or split")
+	}
+
+	switch role {
+	case EscrowRoleBuyer:
+		return s.escrowRepo.ListByBuyer(ctx, userID, status, limit, offset)
+	case EscrowRoleSeller:
+		return s.escrowRepo.ListBySeller(ctx, userID, status, limit, offset)
+	case EscrowRoleArbiter:
+		return s.escrowRepo.ListByArbiter(ctx, userID, status, limit, offset)
+	default:
+		return nil, ErrInvalidEscrow.WithDetail("role must be buyer, seller or arbiter")
+	}
+}
+
+// Get returns an escrow the user is the buyer, seller or arbiter of
+func (s *EscrowService) Get(ctx context.Context, userID, id uuid.UUID) (*models.Escrow, error) {
+	escrow, err := s.escrowRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if escrow == nil || !isEscrowParty(escrow, userID) {
+		return nil, ErrEscrowNotFound
+	}
+	return escrow, nil
+}
+
+// Events returns the history of an escrow the user is a party to, oldest first
+func (s *EscrowService) Events(ctx context.Context, userID, id uuid.UUID) ([]models.EscrowEvent, error) {
+	if _, err := s.Get(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	return s.escrowRepo.ListEvents(ctx, id)
+}
+
+// Release pays the whole escrow to the seller. The buyer or the arbiter may release it.
+func (s *EscrowService) Release(ctx context.Context, userID, id uuid.UUID, note string) (*models.Escrow, error) {
+	authorize := func(escrow *models.Escrow) error {
+		if userID == escrow.BuyerUserID || isArbiter(escrow, userID) {
+			return nil
+		}
+		return escrowForbidden(escrow, userID, "Only the buyer or the arbiter can release this escrow")
+	}
+
+	return s.settle(ctx, userID, id, audit.ActionEscrowRelease, authorize, models.EscrowStatusReleased, note, func(escrow *models.Escrow) (int64, error) {
+		return escrow.Amount, nil
+	})
+}
+
+// Refund returns the whole escrow to the buyer. The seller or the arbiter may refund it, and
+// so may the buyer once the deadline has passed.
+func (s *EscrowService) Refund(ctx context.Context, userID, id uuid.UUID, note string) (*models.Escrow, error) {
+	authorize := func(escrow *models.Escrow) error {
+		if userID == escrow.SellerUserID || isArbiter(escrow, userID) {
+			return nil
+		}
+		if userID == escrow.BuyerUserID && escrow.Deadline != nil && time.Now().After(*escrow.Deadline) {
+			return nil
+		}
+		return escrowForbidden(escrow, userID, "Only the seller or the arbiter can refund this escrow before its deadline")
+	}
+
+	return s.settle(ctx, userID, id, audit.ActionEscrowRefund, authorize, models.EscrowStatusRefunded, note, func(*models.Escrow) (int64, error) {
+		return 0, nil
+	})
+}
+
+// Split pays sellerAmount to the seller and the rest back to the buyer. Only the arbiter may
+// split an escrow, and both sides must get something.
+func (s *EscrowService) Split(ctx context.Context, userID, id uuid.UUID, sellerAmount int64, note string) (*models.Escrow, error) {
+	authorize := func(escrow *models.Escrow) error {
+		if isArbiter(escrow, userID) {
+			return nil
+		}
+		return escrowForbidden(escrow, userID, "Only the arbiter can split this escrow")
+	}
+
+	return s.settle(ctx, userID, id, audit.ActionEscrowSplit, authorize, models.EscrowStatusSplit, note, func(escrow *models.Escrow) (int64, error) {
+		if sellerAmount <= 0 || sellerAmount >= escrow.Amount {
+			return 0, ErrInvalidEscrow.WithDetail(fmt.Sprintf("seller_amount must be between 1 and %d", escrow.Amount-1))
+		}
+		return sellerAmount, nil
+	})
+}
+
+// settle pays out a locked, funded escrow the caller may act on (authorize returns nil):
+// sellerShare gives the seller's part and the buyer gets the rest. The payouts, the new
+// status, the history entry and the audit event are written in one transaction.
+func (s *EscrowService) settle(ctx context.Context, userID, id uuid.UUID, action string, authorize func(*models.Escrow) error, status models.EscrowStatus, note string, sellerShare func(*models.Escrow) (int64, error)) (*models.Escrow, error) {
+	var escrow *models.Escrow
+
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		escrow, err = uow.Escrows.FindByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if escrow == nil {
+			return ErrEscrowNotFound
+		}
+		if err := authorize(escrow); err != nil {
+			return err
+		}
+		if escrow.Status != models.EscrowStatusFunded {
+			return ErrEscrowState.WithDetail("Escrow is " + string(escrow.Status))
+		}
+
+		sellerAmount, err := sellerShare(escrow)
+		if err != nil {
+			return err
+		}
+		buyerAmount := escrow.Amount - sellerAmount
+
+		vault, err := s.vault(ctx, uow)
+		if err != nil {
+			return err
+		}
+		seller, err := uow.Wallets.FindByID(ctx, escrow.SellerWalletID)
+		if err != nil {
+			return err
+		}
+		buyer, err := uow.Wallets.FindByID(ctx, escrow.BuyerWalletID)
+		if err != nil {
+			return err
+		}
+		if seller == nil || buyer == nil {
+			return ErrWalletNotFound.WithDetail("The buyer's or seller's wallet no longer exists")
+		}
+
+		if err := uow.Wallets.LockForUpdate(ctx, vault.ID, seller.ID, buyer.ID); err != nil {
+			return err
+		}
+
+		reference := escrowReference(escrow)
+		if sellerAmount > 0 {
+			if err := s.move(ctx, uow, escrow, vault, seller, sellerAmount, reference+"_S", "Escrow release"); err != nil {
+				return err
+			}
+		}
+		if buyerAmount > 0 {
+			if err := s.move(ctx, uow, escrow, vault, buyer, buyerAmount, reference+"_B", "Escrow refund"); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		escrow.Status = status
+		escrow.ReleasedAmount = sellerAmount
+		escrow.RefundedAmount = buyerAmount
+		escrow.SettledAt = &now
+		if err := uow.Escrows.Update(ctx, escrow); err != nil {
+			return err
+		}
+
+		if err := uow.Escrows.CreateEvent(ctx, &models.EscrowEvent{
+			EscrowID:     escrow.ID,
+			Action:       status,
+			ActorUserID:  &userID,
+			SellerAmount: sellerAmount,
+			BuyerAmount:  buyerAmount,
+			Reference:    &reference,
+			Note:         optionalString(strings.TrimSpace(note)),
+		}); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      action,
+			TargetType:  audit.TargetEscrow,
+			TargetID:    escrow.ID.String(),
+			Before:      map[string]interface{}{"status": models.EscrowStatusFunded},
+			After: map[string]interface{}{
+				"status":          escrow.Status,
+				"released_amount": escrow.ReleasedAmount,
+				"refunded_amount": escrow.RefundedAmount,
+				"note":            note,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Escrow settled", "escrow_id", escrow.ID, "status", escrow.Status, "released", escrow.ReleasedAmount, "refunded", escrow.RefundedAmount)
+	return escrow, nil
+}
+
+// vault returns the system escrow wallet
+func (s *EscrowService) vault(ctx context.Context, uow *repository.UnitOfWork) (*models.Wallet, error) {
+	vault, err := uow.Wallets.FindByUserID(ctx, models.SystemEscrowUserID)
+	if err != nil {
+		return nil, err
+	}
+	if vault == nil {
+		return nil, fmt.Errorf("system escrow wallet is missing")
+	}
+	return vault, nil
+}
+
+// move transfers amount between two locked wallets and writes the pair of entries, tagged
+// with the escrow
+func (s *EscrowService) move(ctx context.Context, uow *repository.UnitOfWork, escrow *models.Escrow, from, to *models.Wallet, amount int64, reference, description string) error {
+	if err := uow.Wallets.Debit(ctx, from.ID, amount); err != nil {
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			return ErrInsufficientFunds
+		}
+		return err
+	}
+	if err := uow.Wallets.Credit(ctx, to.ID, amount); err != nil {
+		return err
+	}
+
+	metadata := map[string]interface{}{"escrow_id": escrow.ID}
+
+	debit, err := transferEntry(from, to, models.TransactionTypeTransferOut, amount, reference+"_OUT", description, metadata)
+	if err != nil {
+		return err
+	}
+	if err := uow.Transactions.Create(ctx, debit); err != nil {
+		return err
+	}
+
+	credit, err := transferEntry(to, from, models.TransactionTypeTransferIn, amount, reference+"_IN", description, metadata)
+	if err != nil {
+		return err
+	}
+	return uow.Transactions.Create(ctx, credit)
+}
+
+// escrowReference generates a reference for money moving in or out of an escrow
+func escrowReference(escrow *models.Escrow) string {
+	return fmt.Sprintf("ESC_%s_%s", escrow.ID.String()[:8], uuid.New().String()[:8])
+}
+
+// isEscrowParty reports whether the user is the buyer, seller or arbiter of an escrow
+func isEscrowParty(escrow *models.Escrow, userID uuid.UUID) bool {
+	return userID == escrow.BuyerUserID || userID == escrow.SellerUserID || isArbiter(escrow, userID)
+}
+
+func isArbiter(escrow *models.Escrow, userID uuid.UUID) bool {
+	return escrow.ArbiterUserID != nil && *escrow.ArbiterUserID == userID
+}
+
+// escrowForbidden tells a party they can't take an action; anyone else doesn't see the escrow
+func escrowForbidden(escrow *models.Escrow, userID uuid.UUID, detail string) error {
+	if isEscrowParty(escrow, userID) {
+		return ErrEscrowForbidden.WithDetail(detail)
+	}
+	return ErrEscrowNotFound
+}
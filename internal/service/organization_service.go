@@ -0,0 +1,640 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/mail"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/google/uuid"
+)
+
+const (
+	// InvitationTTL is how long an invitation can be accepted for
+	InvitationTTL = 7 * 24 * time.Hour
+
+	maxOrganizationNameLength = 100
+)
+
+// OrganizationService manages organizations and who may act for them. Each organization has
+// an account user of its own that owns its wallets and API keys, so members act on the
+// organization's wallets through the ordinary wallet routes with the permissions of their role.
+// Organization events are audited against the organization's account user.
+type OrganizationService struct {
+	txManager     repository.TxManager
+	orgRepo       repository.OrganizationRepository
+	userRepo      repository.UserRepository
+	apiKeyService *APIKeyService
+	emailService  *EmailService
+	publicURL     string
+	auditor       *audit.Recorder
+	logger        *slog.Logger
+}
+
+// NewOrganizationService creates the service. Invitation emails tell invitees to answer at
+// publicURL.
+func NewOrganizationService(txManager repository.TxManager, orgRepo repository.OrganizationRepository, userRepo repository.UserRepository, apiKeyService *APIKeyService, emailService *EmailService, publicURL string, auditor *audit.Recorder, logger *slog.Logger) *OrganizationService {
+	return &OrganizationService{
+		txManager:     txManager,
+		orgRepo:       orgRepo,
+		userRepo:      userRepo,
+		emailService:  emailService,
+		publicURL:     publicURL,
+		apiKeyService: apiKeyService,
+		auditor:       auditor,
+		logger:        logger,
+	}
+}
+
+// Create opens an organization with a default wallet and makes the user its owner
+func (s *OrganizationService) Create(ctx context.Context, userID uuid.UUID, name string) (*models.Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxOrganizationNameLength {
+		return nil, ErrInvalidOrganization.WithDetail("Organization name must be 1 to 100 characters")
+	}
+
+	var org *models.Organization
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		// The account user can never sign in: no Google account has this ID or a .invalid email
+		accountKey := uuid.New().String()
+		account, err := uow.Users.Create(ctx, "organization:"+accountKey, accountKey+"@organization.invalid", name, nil)
+		if err != nil {
+			return err
+		}
+
+		created := &models.Organization{Name: name, AccountUserID: account.ID, CreatedByUserID: &userID}
+		if err := uow.Organizations.Create(ctx, created); err != nil {
+			return err
+		}
+
+		if err := uow.Organizations.AddMember(ctx, &models.OrganizationMember{
+			OrganizationID: created.ID,
+			UserID:         userID,
+			Role:           models.OrganizationRoleOwner,
+		}); err != nil {
+			return err
+		}
+
+		org, err = uow.Organizations.FindByID(ctx, created.ID)
+		if err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: org.AccountUserID,
+			Action:      audit.ActionOrganizationCreate,
+			TargetType:  audit.TargetOrganization,
+			TargetID:    org.ID.String(),
+			After: map[string]interface{}{
+				"name":          org.Name,
+				"wallet_number": org.WalletNumber,
+				"owner_user_id": userID,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	org.Role = models.OrganizationRoleOwner
+	s.logger.InfoContext(ctx, "Organization created", "organization_id", org.ID, "owner_user_id", userID)
+	return org, nil
+}
+
+// List returns the organizations the user belongs to, with the user's role in each
+func (s *OrganizationService) List(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Organization, error) {
+	return s.orgRepo.ListByMember(ctx, userID, limit, offset)
+}
+
+// Get returns an organization the user belongs to, with the user's role
+func (s *OrganizationService) Get(ctx context.Context, orgID, userID uuid.UUID) (*models.Organization, error) {
+	org, member, err := s.membership(ctx, s.orgRepo, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	org.Role = member.Role
+	return org, nil
+}
+
+// Members lists the members of an organization the user belongs to
+func (s *OrganizationService) Members(ctx context.Context, orgID, userID uuid.UUID) ([]models.OrganizationMember, error) {
+	if _, _, err := s.membership(ctx, s.orgRepo, orgID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.orgRepo.ListMembers(ctx, orgID)
+}
+
+// ChangeRole gives a member a new role. Owners and admins may change roles, but only owners
+// may make or unmake owners, and the last owner can't be demoted. It returns the updated member.
+func (s *OrganizationService) ChangeRole(ctx context.Context, orgID, userID, memberUserID uuid.UUID, role models.OrganizationRole) (*models.OrganizationMember, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidOrganization.WithDetail("role must be one of owner, admin, finance or viewer")
+	}
+
+	var after *models.OrganizationMember
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		org, actor, err := s.lockedMembership(ctx, uow, orgID, userID)
+		if err != nil {
+			return err
+		}
+
+		before, err := s.target(ctx, uow, orgID, memberUserID)
+		if err != nil {
+			return err
+		}
+
+		if err := authorizeMemberChange(actor, before, role); err != nil {
+			return err
+		}
+		if err := keepOwner(ctx, uow, before, role); err != nil {
+			return err
+		}
+
+		updated := *before
+		updated.Role = role
+		if err := uow.Organizations.UpdateMemberRole(ctx, &updated); err != nil {
+			return err
+		}
+		after = &updated
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: org.AccountUserID,
+			Action:      audit.ActionMemberRoleChange,
+			TargetType:  audit.TargetMember,
+			TargetID:    memberUserID.String(),
+			Before:      map[string]interface{}{"role": before.Role},
+			After:       map[string]interface{}{"role": after.Role},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Organization member role changed", "organization_id", orgID, "user_id", memberUserID, "role", role)
+	return after, nil
+}
+
+// RemoveMember takes a member out of an organization. Anyone may leave; otherwise the same
+// rules as ChangeRole apply.
+func (s *OrganizationService) RemoveMember(ctx context.Context, orgID, userID, memberUserID uuid.UUID) error {
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		org, actor, err := s.lockedMembership(ctx, uow, orgID, userID)
+		if err != nil {
+			return err
+		}
+
+		removed, err := s.target(ctx, uow, orgID, memberUserID)
+		if err != nil {
+			return err
+		}
+
+		if memberUserID != userID {
+			if err := authorizeMemberChange(actor, removed, ""); err != nil {
+				return err
+			}
+		}
+		if err := keepOwner(ctx, uow, removed, ""); err != nil {
+			return err
+		}
+
+		if err := uow.Organizations.RemoveMember(ctx, orgID, memberUserID); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: org.AccountUserID,
+			Action:      audit.ActionMemberRemove,
+			TargetType:  audit.TargetMember,
+			TargetID:    memberUserID.String(),
+			Before:      map[string]interface{}{"email": removed.Email, "role": removed.Role},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "Organization member removed", "organization_id", orgID, "user_id", memberUserID, "removed_by", userID)
+	return nil
+}
+
+// Invite invites an email address to join the organization with a role and emails the
+// invitation to it. Owners and admins may invite, but only owners may invite owners.
+func (s *OrganizationService) Invite(ctx context.Context, orgID, userID uuid.UUID, email string, role models.OrganizationRole) (*models.OrganizationInvitation, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !strings.Contains(email, "@") {
+		return nil, ErrInvalidOrganization.WithDetail("A valid email is required")
+	}
+	if !role.Valid() {
+		return nil, ErrInvalidOrganization.WithDetail("role must be one of owner, admin, finance or viewer")
+	}
+
+	var invitation *models.OrganizationInvitation
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		org, actor, err := s.lockedMembership(ctx, uow, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if !actor.Role.CanManage() || (role == models.OrganizationRoleOwner && actor.Role != models.OrganizationRoleOwner) {
+			return ErrOrganizationForbidden
+		}
+
+		invitee, err := uow.Users.FindByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		if invitee != nil {
+			member, err := uow.Organizations.FindMember(ctx, org.ID, invitee.ID)
+			if err != nil {
+				return err
+			}
+			if member != nil {
+				return ErrAlreadyMember
+			}
+		}
+
+		pending, err := uow.Organizations.FindPendingInvitation(ctx, org.ID, email)
+		if err != nil {
+			return err
+		}
+		if pending != nil {
+			if time.Now().Before(pending.ExpiresAt) {
+				return ErrInvitationExists
+			}
+			// A lapsed invitation makes way for the new one
+			if err := s.close(ctx, uow, pending, models.InvitationStatusExpired); err != nil {
+				return err
+			}
+		}
+
+		invitation = &models.OrganizationInvitation{
+			OrganizationID:   org.ID,
+			OrganizationName: org.Name,
+			Email:            email,
+			Role:             role,
+			InvitedByUserID:  &userID,
+			Status:           models.InvitationStatusPending,
+			ExpiresAt:        time.Now().Add(InvitationTTL),
+		}
+		if err := uow.Organizations.CreateInvitation(ctx, invitation); err != nil {
+			return err
+		}
+
+		inviter, err := uow.Users.FindByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := s.emailService.Queue(ctx, uow, s.invitationEmail(invitation, inviter)); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: org.AccountUserID,
+			Action:      audit.ActionOrganizationInvite,
+			TargetType:  audit.TargetInvitation,
+			TargetID:    invitation.ID.String(),
+			After: map[string]interface{}{
+				"email":      invitation.Email,
+				"role":       invitation.Role,
+				"expires_at": invitation.ExpiresAt,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.emailService.Wake()
+
+	s.logger.InfoContext(ctx, "Organization invitation created", "organization_id", orgID, "invitation_id", invitation.ID, "role", role)
+	return invitation, nil
+}
+
+// invitationEmail tells the invitee who invited them, to what, and how to answer
+func (s *OrganizationService) invitationEmail(invitation *models.OrganizationInvitation, inviter *models.User) mail.Message {
+	from := "A member"
+	if inviter != nil {
+		from = fmt.Sprintf("%s (%s)", inviter.Name, inviter.Email)
+	}
+	answer := fmt.Sprintf("%s/organizations/invitations/%s", s.publicURL, invitation.ID)
+
+	return mail.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You're invited to join %s", invitation.OrganizationName),
+		Body: fmt.Sprintf(`%s invited you to join %s as %s.
+
+Sign in with this email address, then accept the invitation:
+    POST %s/accept
+or decline it:
+    POST %s/decline
+
+Your pending invitations are listed at GET %s/organizations/invitations.
+This invitation expires on %s.
+`, from, invitation.OrganizationName, invitation.Role, answer, answer, s.publicURL, invitation.ExpiresAt.UTC().Format("2 January 2006 15:04 MST")),
+	}
+}
+
+// Invitations lists an organization's invitations for its owners and admins
+func (s *OrganizationService) Invitations(ctx context.Context, orgID, userID uuid.UUID, status string, limit, offset int) ([]models.OrganizationInvitation, error) {
+	if _, err := s.manager(ctx, orgID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.orgRepo.ListInvitations(ctx, orgID, status, limit, offset)
+}
+
+// RevokeInvitation withdraws a pending invitation
+func (s *OrganizationService) RevokeInvitation(ctx context.Context, orgID, userID, invitationID uuid.UUID) (*models.OrganizationInvitation, error) {
+	var invitation *models.OrganizationInvitation
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		org, actor, err := s.lockedMembership(ctx, uow, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if !actor.Role.CanManage() {
+			return ErrOrganizationForbidden
+		}
+
+		invitation, err = uow.Organizations.FindInvitationByIDForUpdate(ctx, invitationID)
+		if err != nil {
+			return err
+		}
+		if invitation == nil || invitation.OrganizationID != orgID {
+			return ErrInvitationNotFound
+		}
+		if invitation.Status != models.InvitationStatusPending {
+			return ErrInvitationState
+		}
+
+		if err := s.close(ctx, uow, invitation, models.InvitationStatusRevoked); err != nil {
+			return err
+		}
+		return s.recordAnswer(ctx, uow, org, audit.ActionInvitationRevoke, invitation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// PendingInvitations lists the unexpired invitations addressed to the user's email
+func (s *OrganizationService) PendingInvitations(ctx context.Context, userID uuid.UUID) ([]models.OrganizationInvitation, error) {
+	user, err := s.user(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.orgRepo.ListPendingInvitationsByEmail(ctx, strings.ToLower(user.Email))
+}
+
+// AcceptInvitation makes the user a member with the invited role. Only the user signed in
+// with the invited email can accept.
+func (s *OrganizationService) AcceptInvitation(ctx context.Context, userID, invitationID uuid.UUID) (*models.Organization, *models.OrganizationMember, error) {
+	var member *models.OrganizationMember
+	org, invitation, err := s.answer(ctx, userID, invitationID, models.InvitationStatusAccepted, audit.ActionInvitationAccept, func(uow *repository.UnitOfWork, invitation *models.OrganizationInvitation) error {
+		existing, err := uow.Organizations.FindMember(ctx, invitation.OrganizationID, userID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return ErrAlreadyMember
+		}
+
+		member = &models.OrganizationMember{
+			OrganizationID: invitation.OrganizationID,
+			UserID:         userID,
+			Role:           invitation.Role,
+		}
+		return uow.Organizations.AddMember(ctx, member)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Organization invitation accepted", "organization_id", invitation.OrganizationID, "user_id", userID, "role", invitation.Role)
+	return org, member, nil
+}
+
+// DeclineInvitation turns down an invitation addressed to the user's email
+func (s *OrganizationService) DeclineInvitation(ctx context.Context, userID, invitationID uuid.UUID) (*models.OrganizationInvitation, error) {
+	_, invitation, err := s.answer(ctx, userID, invitationID, models.InvitationStatusDeclined, audit.ActionInvitationDecline, nil)
+	return invitation, err
+}
+
+// CreateAPIKey issues an API key that belongs to the organization rather than to the member
+// creating it. It returns the organization too, since the key acts as its account user.
+func (s *OrganizationService) CreateAPIKey(ctx context.Context, orgID, userID uuid.UUID, name string, permissions []string, expiry string) (*models.Organization, *models.APIKey, string, error) {
+	org, err := s.manager(ctx, orgID, userID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	apiKey, rawKey, err := s.apiKeyService.CreateForOrganization(ctx, org, name, permissions, expiry)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return org, apiKey, rawKey, nil
+}
+
+// APIKeys lists the organization's API keys for its owners and admins
+func (s *OrganizationService) APIKeys(ctx context.Context, orgID, userID uuid.UUID) ([]models.APIKey, error) {
+	org, err := s.manager(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.apiKeyService.List(ctx, org.AccountUserID)
+}
+
+// RevokeAPIKey deactivates one of the organization's API keys
+func (s *OrganizationService) RevokeAPIKey(ctx context.Context, orgID, userID, keyID uuid.UUID) error {
+	org, err := s.manager(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.apiKeyService.Revoke(ctx, org.AccountUserID, keyID)
+}
+
+// answer moves a pending invitation addressed to the user to status while it is locked,
+// after running fn (if any) in the same transaction, and audits it as action. It returns the
+// invitation's organization too.
+func (s *OrganizationService) answer(ctx context.Context, userID, invitationID uuid.UUID, status models.InvitationStatus, action string, fn func(uow *repository.UnitOfWork, invitation *models.OrganizationInvitation) error) (*models.Organization, *models.OrganizationInvitation, error) {
+	user, err := s.user(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var org *models.Organization
+	var invitation *models.OrganizationInvitation
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		invitation, err = uow.Organizations.FindInvitationByIDForUpdate(ctx, invitationID)
+		if err != nil {
+			return err
+		}
+		// Other people's invitations are not visible
+		if invitation == nil || !strings.EqualFold(invitation.Email, user.Email) {
+			return ErrInvitationNotFound
+		}
+		if invitation.Status != models.InvitationStatusPending {
+			return ErrInvitationState
+		}
+		if !time.Now().Before(invitation.ExpiresAt) {
+			return ErrInvitationState.WithDetail("Invitation has expired")
+		}
+
+		if fn != nil {
+			if err := fn(uow, invitation); err != nil {
+				return err
+			}
+		}
+
+		if err := s.close(ctx, uow, invitation, status); err != nil {
+			return err
+		}
+
+		org, err = uow.Organizations.FindByID(ctx, invitation.OrganizationID)
+		if err != nil {
+			return err
+		}
+		return s.recordAnswer(ctx, uow, org, action, invitation)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return org, invitation, nil
+}
+
+// recordAnswer audits an invitation leaving the pending state
+func (s *OrganizationService) recordAnswer(ctx context.Context, uow *repository.UnitOfWork, org *models.Organization, action string, invitation *models.OrganizationInvitation) error {
+	return s.auditor.Record(ctx, uow, audit.Event{
+		OwnerUserID: org.AccountUserID,
+		Action:      action,
+		TargetType:  audit.TargetInvitation,
+		TargetID:    invitation.ID.String(),
+		Before:      map[string]interface{}{"status": models.InvitationStatusPending},
+		After: map[string]interface{}{
+			"status": invitation.Status,
+			"email":  invitation.Email,
+			"role":   invitation.Role,
+		},
+	})
+}
+
+// close gives a pending invitation its final status
+func (s *OrganizationService) close(ctx context.Context, uow *repository.UnitOfWork, invitation *models.OrganizationInvitation, status models.InvitationStatus) error {
+	now := time.Now()
+	invitation.Status = status
+	invitation.RespondedAt = &now
+	return uow.Organizations.UpdateInvitation(ctx, invitation)
+}
+
+// manager returns an organization the user may manage
+func (s *OrganizationService) manager(ctx context.Context, orgID, userID uuid.UUID) (*models.Organization, error) {
+	org, member, err := s.membership(ctx, s.orgRepo, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member.Role.CanManage() {
+		return nil, ErrOrganizationForbidden
+	}
+	return org, nil
+}
+
+// membership finds an organization and the user's membership of it. Organizations the
+// user doesn't belong to are not found.
+func (s *OrganizationService) membership(ctx context.Context, orgs repository.OrganizationRepository, orgID, userID uuid.UUID) (*models.Organization, *models.OrganizationMember, error) {
+	org, err := orgs.FindByID(ctx, orgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.member(ctx, orgs, org, userID)
+}
+
+// lockedMembership is membership with the organization row-locked, so membership changes
+// (and the last owner check) happen one at a time
+func (s *OrganizationService) lockedMembership(ctx context.Context, uow *repository.UnitOfWork, orgID, userID uuid.UUID) (*models.Organization, *models.OrganizationMember, error) {
+	org, err := uow.Organizations.FindByIDForUpdate(ctx, orgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.member(ctx, uow.Organizations, org, userID)
+}
+
+func (s *OrganizationService) member(ctx context.Context, orgs repository.OrganizationRepository, org *models.Organization, userID uuid.UUID) (*models.Organization, *models.OrganizationMember, error) {
+	if org == nil {
+		return nil, nil, ErrOrganizationNotFound
+	}
+
+	member, err := orgs.FindMember(ctx, org.ID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member == nil {
+		return nil, nil, ErrOrganizationNotFound
+	}
+
+	return org, member, nil
+}
+
+// target finds the member a change is aimed at
+func (s *OrganizationService) target(ctx context.Context, uow *repository.UnitOfWork, orgID, memberUserID uuid.UUID) (*models.OrganizationMember, error) {
+	member, err := uow.Organizations.FindMember(ctx, orgID, memberUserID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, ErrMemberNotFound
+	}
+	return member, nil
+}
+
+func (s *OrganizationService) user(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvitationNotFound
+	}
+	return user, nil
+}
+
+// authorizeMemberChange checks that actor may give target the role (or remove them, when
+// role is empty). Owners and admins manage members, but only owners touch owners.
+func authorizeMemberChange(actor, target *models.OrganizationMember, role models.OrganizationRole) error {
+	if !actor.Role.CanManage() {
+		return ErrOrganizationForbidden
+	}
+	touchesOwner := target.Role == models.OrganizationRoleOwner || role == models.OrganizationRoleOwner
+	if touchesOwner && actor.Role != models.OrganizationRoleOwner {
+		return ErrOrganizationForbidden.WithDetail("Only owners can add or remove owners")
+	}
+	return nil
+}
+
+// keepOwner stops the last owner from being demoted to role (or removed, when role is empty)
+func keepOwner(ctx context.Context, uow *repository.UnitOfWork, target *models.OrganizationMember, role models.OrganizationRole) error {
+	if target.Role != models.OrganizationRoleOwner || role == models.OrganizationRoleOwner {
+		return nil
+	}
+
+	owners, err := uow.Organizations.CountOwners(ctx, target.OrganizationID)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/qr"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	// paymentLinkCodeLength is the length of the random code in a payment link's URL
+	paymentLinkCodeLength = 12
+
+	// qrModuleSize is how many pixels wide each QR code module is drawn
+	qrModuleSize = 8
+)
+
+// PaymentLinkService manages public links that anyone can pay into a user's wallet through.
+// Paying starts a checkout for a deposit into the owner's wallet; the deposit settles like
+// any other, from the provider's webhook.
+type PaymentLinkService struct {
+	txManager      repository.TxManager
+	linkRepo       repository.PaymentLinkRepository
+	walletRepo     repository.WalletRepository
+	userRepo       repository.UserRepository
+	depositService *DepositService
+	publicURL      string
+	auditor        *audit.Recorder
+	logger         *slog.Logger
+}
+
+func NewPaymentLinkService(txManager repository.TxManager, linkRepo repository.PaymentLinkRepository, walletRepo repository.WalletRepository, userRepo repository.UserRepository, depositService *DepositService, publicURL string, auditor *audit.Recorder, logger *slog.Logger) *PaymentLinkService {
+	return &PaymentLinkService{
+		txManager:      txManager,
+		linkRepo:       linkRepo,
+		walletRepo:     walletRepo,
+		userRepo:       userRepo,
+		depositService: depositService,
+		publicURL:      strings.TrimSuffix(publicURL, "/"),
+		auditor:        auditor,
+		logger:         logger,
+	}
+}
+
+// PaymentLinkInput describes a new payment link
+type PaymentLinkInput struct {
+	Amount      *int64 // Fixed amount; nil lets the payer choose
+	Description string
+	Expiry      string // e.g. 1D, 1M; empty never expires
+}
+
+// Create issues a new payment link into the user's wallet
+func (s *PaymentLinkService) Create(ctx context.Context, userID uuid.UUID, input PaymentLinkInput) (*models.PaymentLink, error) {
+	if input.Amount != nil && *input.Amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+
+	var expiresAt *time.Time
+	if input.Expiry != "" {
+		expiry, err := utils.ParseExpiry(input.Expiry)
+		if err != nil {
+			return nil, ErrInvalidExpiry.WithDetail(err.Error())
+		}
+		if !expiry.After(time.Now()) {
+			return nil, ErrInvalidExpiry.WithDetail("Expiry must be in the future")
+		}
+		expiresAt = &expiry
+	}
+
+	wallet, err := s.walletRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, ErrWalletNotFound
+	}
+
+	link := &models.PaymentLink{
+		UserID:      userID,
+		Code:        utils.GenerateRandomString(paymentLinkCodeLength),
+		Amount:      input.Amount,
+		Description: optionalString(input.Description),
+		ExpiresAt:   expiresAt,
+	}
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := uow.PaymentLinks.Create(ctx, link); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionPaymentLinkCreate,
+			TargetType:  audit.TargetPaymentLink,
+			TargetID:    link.ID.String(),
+			After: map[string]interface{}{
+				"code":       link.Code,
+				"amount":     link.Amount,
+				"expires_at": link.ExpiresAt,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Payment link created", "payment_link_id", link.ID)
+	return s.withURL(link), nil
+}
+
+// List returns the user's payment links, newest first
+func (s *PaymentLinkService) List(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.PaymentLink, error) {
+	links, err := s.linkRepo.ListByUser(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range links {
+		s.withURL(&links[i])
+	}
+	return links, nil
+}
+
+// Deactivate stops one of the user's payment links from accepting new payments. Checkouts
+// already started through it still settle.
+func (s *PaymentLinkService) Deactivate(ctx context.Context, userID, id uuid.UUID) (*models.PaymentLink, error) {
+	link, err := s.linkRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if link == nil || link.UserID != userID {
+		return nil, ErrPaymentLinkNotFound
+	}
+	if !link.IsActive {
+		return nil, ErrPaymentLinkInactive.WithDetail("Payment link is already deactivated")
+	}
+
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := uow.PaymentLinks.Deactivate(ctx, id); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionPaymentLinkDeactivate,
+			TargetType:  audit.TargetPaymentLink,
+			TargetID:    link.ID.String(),
+			Before:      map[string]interface{}{"is_active": true},
+			After:       map[string]interface{}{"is_active": false},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	link.IsActive = false
+	return s.withURL(link), nil
+}
+
+// Lookup returns the link with the given code and the name of the user it pays
+func (s *PaymentLinkService) Lookup(ctx context.Context, code string) (*models.PaymentLink, string, error) {
+	link, err := s.linkRepo.FindByCode(ctx, code)
+	if err != nil {
+		return nil, "", err
+	}
+	if link == nil {
+		return nil, "", ErrPaymentLinkNotFound
+	}
+
+	owner, err := s.userRepo.FindByID(ctx, link.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if owner == nil {
+		return nil, "", ErrPaymentLinkNotFound
+	}
+
+	return s.withURL(link), owner.Name, nil
+}
+
+// Pay starts a checkout through the link with the given code. amount is required unless
+// the link has a fixed amount, in which case it must be zero or that amount.
+func (s *PaymentLinkService) Pay(ctx context.Context, code, email string, amount int64) (*InitializedDeposit, error) {
+	link, err := s.linkRepo.FindByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if link == nil {
+		return nil, ErrPaymentLinkNotFound
+	}
+	if !link.Usable(time.Now()) {
+		return nil, ErrPaymentLinkInactive
+	}
+
+	if link.Amount != nil {
+		if amount != 0 && amount != *link.Amount {
+			return nil, ErrInvalidAmount.WithDetail(fmt.Sprintf("This link only accepts %d kobo", *link.Amount))
+		}
+		amount = *link.Amount
+	}
+
+	deposit, err := s.depositService.InitializeLinkPayment(ctx, link, strings.TrimSpace(email), amount)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Payment link checkout started", "payment_link_id", link.ID, "reference", *deposit.Transaction.Reference, "amount", amount)
+	return deposit, nil
+}
+
+// QRCode renders the URL of the link with the given code as a PNG QR code
+func (s *PaymentLinkService) QRCode(ctx context.Context, code string) ([]byte, error) {
+	link, err := s.linkRepo.FindByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if link == nil {
+		return nil, ErrPaymentLinkNotFound
+	}
+
+	symbol, err := qr.Encode(s.withURL(link).URL)
+	if err != nil {
+		return nil, err
+	}
+	return symbol.PNG(qrModuleSize)
+}
+
+// withURL fills in the link's public URL
+func (s *PaymentLinkService) withURL(link *models.PaymentLink) *models.PaymentLink {
+	link.URL = s.publicURL + "/pay/" + link.Code
+	return link
+}
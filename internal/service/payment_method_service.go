@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/google/uuid"
+)
+
+// PaymentMethodService manages the cards users have saved by paying with them
+type PaymentMethodService struct {
+	txManager         repository.TxManager
+	paymentMethodRepo repository.PaymentMethodRepository
+	auditor           *audit.Recorder
+	logger            *slog.Logger
+}
+
+func NewPaymentMethodService(txManager repository.TxManager, paymentMethodRepo repository.PaymentMethodRepository, auditor *audit.Recorder, logger *slog.Logger) *PaymentMethodService {
+	return &PaymentMethodService{
+		txManager:         txManager,
+		paymentMethodRepo: paymentMethodRepo,
+		auditor:           auditor,
+		logger:            logger,
+	}
+}
+
+// List returns the user's saved cards
+func (s *PaymentMethodService) List(ctx context.Context, userID uuid.UUID) ([]models.PaymentMethod, error) {
+	return s.paymentMethodRepo.ListByUser(ctx, userID)
+}
+
+// Delete forgets one of the user's saved cards
+func (s *PaymentMethodService) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	method, err := ownedPaymentMethod(ctx, s.paymentMethodRepo, userID, id)
+	if err != nil {
+		return err
+	}
+
+	return s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := uow.PaymentMethods.Delete(ctx, id); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionPaymentMethodDelete,
+			TargetType:  audit.TargetPaymentMethod,
+			TargetID:    method.ID.String(),
+			Before:      map[string]interface{}{"provider": method.Provider, "brand": method.Brand, "last4": method.Last4},
+		})
+	})
+}
+
+// ownedPaymentMethod finds the user's saved card. Other users' cards are reported as not found.
+func ownedPaymentMethod(ctx context.Context, repo repository.PaymentMethodRepository, userID, id uuid.UUID) (*models.PaymentMethod, error) {
+	method, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if method == nil || method.UserID != userID {
+		return nil, ErrPaymentMethodNotFound
+	}
+	return method, nil
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/utils"
+	"github.com/google/uuid"
+)
+
+// defaultPaymentRequestExpiry is how long a payment request stays open unless the requester
+// says otherwise
+const defaultPaymentRequestExpiry = "7D"
+
+// Payment request list directions
+const (
+	PaymentRequestsIncoming = "incoming" // addressed to the caller
+	PaymentRequestsOutgoing = "outgoing" // sent by the caller
+)
+
+// PaymentRequestService lets users ask each other for money. Accepting a request pays it
+// through the same transfer path as POST /wallet/transfer, inside a transaction that also
+// locks the request, so a request is paid at most once.
+type PaymentRequestService struct {
+	txManager     repository.TxManager
+	requestRepo   repository.PaymentRequestRepository
+	walletRepo    repository.WalletRepository
+	userRepo      repository.UserRepository
+	walletService *WalletService
+	auditor       *audit.Recorder
+	logger        *slog.Logger
+}
+
+func NewPaymentRequestService(txManager repository.TxManager, requestRepo repository.PaymentRequestRepository, walletRepo repository.WalletRepository, userRepo repository.UserRepository, walletService *WalletService, auditor *audit.Recorder, logger *slog.Logger) *PaymentRequestService {
+	return &PaymentRequestService{
+		txManager:     txManager,
+		requestRepo:   requestRepo,
+		walletRepo:    walletRepo,
+		userRepo:      userRepo,
+		walletService: walletService,
+		auditor:       auditor,
+		logger:        logger,
+	}
+}
+
+// PaymentRequestInput describes a new payment request. Exactly one of WalletNumber and
+// Email addresses the payer.
+type PaymentRequestInput struct {
+	WalletNumber string
+	Email        string // Need not belong to a user yet; they see the request once they sign up
+	Amount       int64
+	Memo         string
+	Expiry       string // e.g. 1H, 7D, 1M; defaults to 7D
+}
+
+// Create validates and stores a payment request from the user
+func (s *PaymentRequestService) Create(ctx context.Context, userID uuid.UUID, input PaymentRequestInput) (*models.PaymentRequest, error) {
+	if input.Amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	if (input.WalletNumber == "") == (email == "") {
+		return nil, ErrInvalidPaymentRequest.WithDetail("Exactly one of wallet_number or email is required")
+	}
+
+	expiry := input.Expiry
+	if expiry == "" {
+		expiry = defaultPaymentRequestExpiry
+	}
+	expiresAt, err := utils.ParseExpiry(expiry)
+	if err != nil {
+		return nil, ErrInvalidExpiry.WithDetail(err.Error())
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, ErrInvalidExpiry.WithDetail("Expiry must be in the future")
+	}
+
+	requester, err := s.walletRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if requester == nil {
+		return nil, ErrWalletNotFound
+	}
+
+	request := &models.PaymentRequest{
+		RequesterUserID:       userID,
+		RequesterWalletNumber: requester.WalletNumber,
+		Amount:                input.Amount,
+		Memo:                  optionalString(input.Memo),
+		Status:                models.PaymentRequestStatusPending,
+		ExpiresAt:             expiresAt,
+	}
+
+	if input.WalletNumber != "" {
+		payer, err := s.walletRepo.FindByWalletNumber(ctx, input.WalletNumber)
+		if err != nil {
+			return nil, err
+		}
+		if payer == nil {
+			return nil, ErrPayerNotFound
+		}
+		request.PayerUserID = &payer.UserID
+		request.PayerWalletNumber = &payer.WalletNumber
+	} else {
+		payer, err := s.userRepo.FindByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if payer != nil {
+			request.PayerUserID = &payer.ID
+		}
+		request.PayerEmail = &email
+	}
+
+	if request.PayerUserID != nil && *request.PayerUserID == userID {
+		return nil, ErrSelfTransfer.WithDetail("Cannot request money from yourself")
+	}
+
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := uow.PaymentRequests.Create(ctx, request); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionPaymentRequestCreate,
+			TargetType:  audit.TargetPaymentRequest,
+			TargetID:    request.ID.String(),
+			After: map[string]interface{}{
+				"payer_wallet_number": request.PayerWalletNumber,
+				"payer_email":         request.PayerEmail,
+				"amount":              request.Amount,
+				"expires_at":          request.ExpiresAt,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Payment request created", "payment_request_id", request.ID, "amount", request.Amount)
+	return request, nil
+}
+
+// List returns the requests addressed to the user (incoming) or sent by them (outgoing),
+// newest first, optionally only those with the given status
+func (s *PaymentRequestService) List(ctx context.Context, userID uuid.UUID, direction, status string, limit, offset int) ([]models.PaymentRequest, error) {
+	switch models.PaymentRequestStatus(status) {
+	case "", models.PaymentRequestStatusPending, models.PaymentRequestStatusAccepted, models.PaymentRequestStatusDeclined,
+		models.PaymentRequestStatusCancelled, models.PaymentRequestStatusExpired:
+	default:
+		return nil, ErrInvalidPaymentRequest.WithDetail("status must be one of pending, accepted, declined, cancelled or expired")
+	}
+
+	switch direction {
+	case PaymentRequestsIncoming:
+		user, err := s.user(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return s.requestRepo.ListByPayer(ctx, userID, strings.ToLower(user.Email), status, limit, offset)
+	case PaymentRequestsOutgoing:
+		return s.requestRepo.ListByRequester(ctx, userID, status, limit, offset)
+	default:
+		return nil, ErrInvalidPaymentRequest.WithDetail("direction must be incoming or outgoing")
+	}
+}
+
+// Get returns a payment request the user sent or that is addressed to them
+func (s *PaymentRequestService) Get(ctx context.Context, userID, id uuid.UUID) (*models.PaymentRequest, error) {
+	user, err := s.user(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := s.requestRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil || (request.RequesterUserID != userID && !isPayer(request, user)) {
+		return nil, ErrPaymentRequestNotFound
+	}
+
+	return request, nil
+}
+
+// Accept pays a request addressed to the user from their wallet. The transfer and the
+// request's new status commit together.
+func (s *PaymentRequestService) Accept(ctx context.Context, userID, id uuid.UUID) (*models.PaymentRequest, error) {
+	var result *TransferResult
+	var amount int64
+
+	request, err := s.respond(ctx, userID, id, true, models.PaymentRequestStatusAccepted, audit.ActionPaymentRequestAccept, func(uow *repository.UnitOfWork, request *models.PaymentRequest) error {
+		amount = request.Amount
+
+		var err error
+		result, err = s.walletService.transfer(ctx, uow, userID, "", request.RequesterWalletNumber, request.Amount, map[string]interface{}{
+			"payment_request_id": request.ID,
+		})
+		if err != nil {
+			return err
+		}
+
+		request.TransferReference = &result.Reference
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionTransferCreate,
+			TargetType:  audit.TargetWallet,
+			TargetID:    result.Recipient.ID.String(),
+			After: map[string]interface{}{
+				"reference":               result.Reference,
+				"amount":                  request.Amount,
+				"sender_wallet_number":    result.Sender.WalletNumber,
+				"recipient_wallet_number": result.Recipient.WalletNumber,
+				"payment_request_id":      request.ID,
+			},
+		})
+	})
+	if err != nil {
+		if amount > 0 {
+			metrics.RecordTransfer("failed", amount)
+		}
+		return nil, err
+	}
+
+	metrics.RecordTransfer("success", request.Amount)
+	s.logger.InfoContext(ctx, "Payment request accepted", "payment_request_id", request.ID, "reference", result.Reference, "amount", request.Amount)
+	return request, nil
+}
+
+// Decline refuses a request addressed to the user
+func (s *PaymentRequestService) Decline(ctx context.Context, userID, id uuid.UUID) (*models.PaymentRequest, error) {
+	return s.respond(ctx, userID, id, true, models.PaymentRequestStatusDeclined, audit.ActionPaymentRequestDecline, nil)
+}
+
+// Cancel withdraws a request the user sent
+func (s *PaymentRequestService) Cancel(ctx context.Context, userID, id uuid.UUID) (*models.PaymentRequest, error) {
+	return s.respond(ctx, userID, id, false, models.PaymentRequestStatusCancelled, audit.ActionPaymentRequestCancel, nil)
+}
+
+// respond moves a pending request to status while it is locked, after running fn (if any)
+// in the same transaction, and audits it as action. byPayer says whether only the payer or
+// only the requester may do this.
+func (s *PaymentRequestService) respond(ctx context.Context, userID, id uuid.UUID, byPayer bool, status models.PaymentRequestStatus, action string, fn func(uow *repository.UnitOfWork, request *models.PaymentRequest) error) (*models.PaymentRequest, error) {
+	user, err := s.user(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var request *models.PaymentRequest
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		request, err = uow.PaymentRequests.FindByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		requester := request != nil && request.RequesterUserID == userID
+		payer := request != nil && isPayer(request, user)
+		switch {
+		case !requester && !payer:
+			return ErrPaymentRequestNotFound
+		case byPayer && !payer:
+			return ErrPaymentRequestForbidden
+		case !byPayer && !requester:
+			return ErrPaymentRequestForbidden.WithDetail("Only the requester can cancel this payment request")
+		}
+
+		if request.Status != models.PaymentRequestStatusPending {
+			return ErrPaymentRequestState.WithDetail("Payment request is " + string(request.Status))
+		}
+
+		if fn != nil {
+			if err := fn(uow, request); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		request.Status = status
+		request.RespondedAt = &now
+		if byPayer {
+			// Requests sent to an email are tied to the account that answered them
+			request.PayerUserID = &userID
+		}
+		if err := uow.PaymentRequests.Update(ctx, request); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      action,
+			TargetType:  audit.TargetPaymentRequest,
+			TargetID:    request.ID.String(),
+			Before:      map[string]interface{}{"status": models.PaymentRequestStatusPending},
+			After: map[string]interface{}{
+				"status":             request.Status,
+				"transfer_reference": request.TransferReference,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// user loads the caller, whose email matches requests sent before they signed up
+func (s *PaymentRequestService) user(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrWalletNotFound
+	}
+	return user, nil
+}
+
+// isPayer reports whether the request is addressed to the user
+func isPayer(request *models.PaymentRequest, user *models.User) bool {
+	if request.PayerUserID != nil {
+		return *request.PayerUserID == user.ID
+	}
+	return request.PayerEmail != nil && *request.PayerEmail == strings.ToLower(user.Email)
+}
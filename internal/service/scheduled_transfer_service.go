@@ -0,0 +1,545 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/schedule"
+	"github.com/google/uuid"
+)
+
+const (
+	// scheduledBatchSize is how many due scheduled transfers are run per batch
+	scheduledBatchSize = 50
+
+	// scheduledRunHistory is how many recent runs are returned with a scheduled transfer
+	scheduledRunHistory = 20
+
+	// pausedByOwner is the pause reason when the owner pauses a scheduled transfer; runs
+	// that fail pause it with the error code instead
+	pausedByOwner = "paused_by_owner"
+)
+
+// ScheduledTransferService manages standing orders and runs them when they fall due.
+// Runs go through the same transfer path as POST /wallet/transfer, inside a transaction
+// that also locks the schedule, so an occurrence is paid at most once even if two
+// schedulers overlap.
+type ScheduledTransferService struct {
+	txManager     repository.TxManager
+	scheduledRepo repository.ScheduledTransferRepository
+	walletRepo    repository.WalletRepository
+	walletService *WalletService
+	auditor       *audit.Recorder
+	retryDelay    time.Duration
+	maxFailures   int
+	logger        *slog.Logger
+}
+
+func NewScheduledTransferService(txManager repository.TxManager, scheduledRepo repository.ScheduledTransferRepository, walletRepo repository.WalletRepository, walletService *WalletService, auditor *audit.Recorder, retryDelay time.Duration, maxFailures int, logger *slog.Logger) *ScheduledTransferService {
+	return &ScheduledTransferService{
+		txManager:     txManager,
+		scheduledRepo: scheduledRepo,
+		walletRepo:    walletRepo,
+		walletService: walletService,
+		auditor:       auditor,
+		retryDelay:    retryDelay,
+		maxFailures:   maxFailures,
+		logger:        logger,
+	}
+}
+
+// ScheduledTransferInput describes a new scheduled transfer. Exactly one of Cron and
+// Interval is set.
+type ScheduledTransferInput struct {
+	FromWalletNumber      string // Optional; the default wallet if empty
+	RecipientWalletNumber string
+	Amount                int64
+	Description           string
+	Cron                  string     // Five-field cron expression, in UTC
+	Interval              string     // e.g. 1D, 2W, 1M; counted from StartAt
+	StartAt               *time.Time // Defaults to now
+	EndAt                 *time.Time
+	MaxOccurrences        *int
+}
+
+// Create validates and stores a scheduled transfer. Its first occurrence is the first time
+// the schedule matches at or after the start.
+func (s *ScheduledTransferService) Create(ctx context.Context, userID uuid.UUID, input ScheduledTransferInput) (*models.ScheduledTransfer, error) {
+	if input.Amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+
+	// Postgres keeps microseconds; truncate so an interval's anchor survives the round trip
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	startAt := now
+	if input.StartAt != nil {
+		startAt = input.StartAt.UTC().Truncate(time.Microsecond)
+		// Allow for clock skew between the client and the server
+		if startAt.Before(now.Add(-time.Minute)) {
+			return nil, ErrInvalidSchedule.WithDetail("start_at must not be in the past")
+		}
+	}
+	if input.EndAt != nil && !input.EndAt.After(startAt) {
+		return nil, ErrInvalidSchedule.WithDetail("end_at must be after start_at")
+	}
+	if input.MaxOccurrences != nil && *input.MaxOccurrences < 1 {
+		return nil, ErrInvalidSchedule.WithDetail("max_occurrences must be at least 1")
+	}
+
+	sched, err := schedule.Parse(input.Cron, input.Interval, startAt)
+	if err != nil {
+		return nil, ErrInvalidSchedule.WithDetail("Invalid schedule: " + err.Error())
+	}
+
+	first := sched.Next(startAt.Add(-time.Nanosecond))
+	if first.IsZero() || (input.EndAt != nil && first.After(*input.EndAt)) {
+		return nil, ErrInvalidSchedule.WithDetail("Schedule has no runs between start_at and end_at")
+	}
+
+	sender, err := ownWallet(ctx, s.walletRepo, userID, input.FromWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := s.walletRepo.FindByWalletNumber(ctx, input.RecipientWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		return nil, ErrRecipientNotFound
+	}
+	if sender.ID == recipient.ID {
+		return nil, ErrSelfTransfer
+	}
+
+	transfer := &models.ScheduledTransfer{
+		UserID:                userID,
+		WalletID:              sender.ID,
+		FromWalletNumber:      sender.WalletNumber,
+		RecipientWalletNumber: recipient.WalletNumber,
+		Amount:                input.Amount,
+		Description:           optionalString(input.Description),
+		Cron:                  optionalString(input.Cron),
+		Interval:              optionalString(input.Interval),
+		StartAt:               startAt,
+		EndAt:                 input.EndAt,
+		MaxOccurrences:        input.MaxOccurrences,
+		Status:                models.ScheduledTransferStatusActive,
+		OccurrenceAt:          &first,
+		NextRunAt:             &first,
+	}
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := uow.ScheduledTransfers.Create(ctx, transfer); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionScheduledTransferCreate,
+			TargetType:  audit.TargetScheduledTransfer,
+			TargetID:    transfer.ID.String(),
+			After: map[string]interface{}{
+				"from_wallet_number":      transfer.FromWalletNumber,
+				"recipient_wallet_number": transfer.RecipientWalletNumber,
+				"amount":                  transfer.Amount,
+				"cron":                    transfer.Cron,
+				"interval":                transfer.Interval,
+				"start_at":                transfer.StartAt,
+				"end_at":                  transfer.EndAt,
+				"max_occurrences":         transfer.MaxOccurrences,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Scheduled transfer created", "scheduled_transfer_id", transfer.ID, "next_run_at", first)
+	return transfer, nil
+}
+
+// List returns the user's scheduled transfers, newest first
+func (s *ScheduledTransferService) List(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.ScheduledTransfer, error) {
+	return s.scheduledRepo.ListByUser(ctx, userID, limit, offset)
+}
+
+// Get returns one of the user's scheduled transfers with its most recent runs
+func (s *ScheduledTransferService) Get(ctx context.Context, userID, id uuid.UUID) (*models.ScheduledTransfer, []models.ScheduledTransferRun, error) {
+	transfer, err := s.scheduledRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if transfer == nil || transfer.UserID != userID {
+		return nil, nil, ErrScheduledTransferNotFound
+	}
+
+	runs, err := s.scheduledRepo.ListRuns(ctx, id, scheduledRunHistory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return transfer, runs, nil
+}
+
+// Pause stops an active scheduled transfer from running until it is resumed
+func (s *ScheduledTransferService) Pause(ctx context.Context, userID, id uuid.UUID) (*models.ScheduledTransfer, error) {
+	return s.update(ctx, userID, id, audit.ActionScheduledTransferPause, func(transfer *models.ScheduledTransfer) error {
+		if transfer.Status != models.ScheduledTransferStatusActive {
+			return ErrScheduledTransferState.WithDetail("Only active scheduled transfers can be paused")
+		}
+		pause(transfer, pausedByOwner)
+		return nil
+	})
+}
+
+// Resume reactivates a paused scheduled transfer from its next occurrence; occurrences
+// missed while paused are skipped. A schedule with no occurrences left completes instead.
+func (s *ScheduledTransferService) Resume(ctx context.Context, userID, id uuid.UUID) (*models.ScheduledTransfer, error) {
+	return s.update(ctx, userID, id, audit.ActionScheduledTransferResume, func(transfer *models.ScheduledTransfer) error {
+		if transfer.Status != models.ScheduledTransferStatusPaused {
+			return ErrScheduledTransferState.WithDetail("Only paused scheduled transfers can be resumed")
+		}
+
+		sched, err := scheduleOf(transfer)
+		if err != nil {
+			return err
+		}
+
+		transfer.Status = models.ScheduledTransferStatusActive
+		transfer.PauseReason = nil
+		transfer.FailureCount = 0
+		s.advance(transfer, sched, transfer.StartAt.Add(-time.Nanosecond), time.Now())
+		return nil
+	})
+}
+
+// Cancel permanently stops a scheduled transfer
+func (s *ScheduledTransferService) Cancel(ctx context.Context, userID, id uuid.UUID) (*models.ScheduledTransfer, error) {
+	return s.update(ctx, userID, id, audit.ActionScheduledTransferCancel, func(transfer *models.ScheduledTransfer) error {
+		switch transfer.Status {
+		case models.ScheduledTransferStatusActive, models.ScheduledTransferStatusPaused:
+		default:
+			return ErrScheduledTransferState.WithDetail("Scheduled transfer is already " + string(transfer.Status))
+		}
+		transfer.Status = models.ScheduledTransferStatusCancelled
+		transfer.OccurrenceAt = nil
+		transfer.NextRunAt = nil
+		return nil
+	})
+}
+
+// update applies fn to one of the user's scheduled transfers while it is locked, so it
+// can't interleave with a run, and audits the change as action
+func (s *ScheduledTransferService) update(ctx context.Context, userID, id uuid.UUID, action string, fn func(transfer *models.ScheduledTransfer) error) (*models.ScheduledTransfer, error) {
+	var transfer *models.ScheduledTransfer
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		transfer, err = uow.ScheduledTransfers.FindByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if transfer == nil || transfer.UserID != userID {
+			return ErrScheduledTransferNotFound
+		}
+
+		if err := fn(transfer); err != nil {
+			return err
+		}
+		if err := uow.ScheduledTransfers.Update(ctx, transfer); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      action,
+			TargetType:  audit.TargetScheduledTransfer,
+			TargetID:    transfer.ID.String(),
+			After: map[string]interface{}{
+				"status":      transfer.Status,
+				"next_run_at": transfer.NextRunAt,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// Run executes due scheduled transfers every interval until ctx is cancelled, but only
+// while isLeader reports that this instance holds the scheduler lock
+func (s *ScheduledTransferService) Run(ctx context.Context, isLeader func(ctx context.Context) bool, interval time.Duration, beat func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer metrics.SetSchedulerLeader(false)
+
+	for {
+		beat()
+		leader := isLeader(ctx)
+		metrics.SetSchedulerLeader(leader)
+		if leader {
+			s.RunDue(ctx, beat)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue runs every due scheduled transfer once. It stops early at the first batch with
+// an unexpected error, leaving the rest for the next round.
+func (s *ScheduledTransferService) RunDue(ctx context.Context, beat func()) {
+	for ctx.Err() == nil {
+		ids, err := s.scheduledRepo.ListDue(ctx, time.Now(), scheduledBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.ErrorContext(ctx, "Failed to list due scheduled transfers", "error", err)
+			}
+			return
+		}
+
+		failed := false
+		for _, id := range ids {
+			if err := s.execute(ctx, id); err != nil {
+				if ctx.Err() == nil {
+					s.logger.ErrorContext(ctx, "Failed to run scheduled transfer", "scheduled_transfer_id", id, "error", err)
+				}
+				failed = true
+			}
+			beat()
+		}
+
+		if failed || len(ids) < scheduledBatchSize {
+			return
+		}
+	}
+}
+
+// execute attempts a due scheduled transfer's current occurrence and records the outcome.
+// A business failure (e.g. insufficient funds) is recorded as a failed run; an unexpected
+// error rolls everything back and is returned, so the occurrence is tried again next round.
+func (s *ScheduledTransferService) execute(ctx context.Context, id uuid.UUID) error {
+	now := time.Now()
+
+	var (
+		transfer *models.ScheduledTransfer
+		run      *models.ScheduledTransferRun
+		result   *TransferResult
+	)
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		transfer, err = uow.ScheduledTransfers.FindByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		// Paused, cancelled or already run since it was listed
+		if transfer == nil || transfer.Status != models.ScheduledTransferStatusActive ||
+			transfer.NextRunAt == nil || transfer.NextRunAt.After(now) {
+			transfer = nil
+			return nil
+		}
+
+		occurrence := *transfer.NextRunAt
+		if transfer.OccurrenceAt != nil {
+			occurrence = *transfer.OccurrenceAt
+		}
+		run = &models.ScheduledTransferRun{ScheduledTransferID: transfer.ID, OccurrenceAt: occurrence}
+		transfer.LastRunAt = &now
+
+		sched, err := scheduleOf(transfer)
+		if err != nil {
+			// Stored schedules were valid when created; don't retry one that no longer parses
+			failRun(run, ErrInvalidSchedule.WithDetail(err.Error()))
+			pause(transfer, ErrInvalidSchedule.Code)
+		} else {
+			result, err = s.walletService.transfer(ctx, uow, transfer.UserID, transfer.FromWalletNumber, transfer.RecipientWalletNumber, transfer.Amount, map[string]interface{}{
+				"scheduled_transfer_id": transfer.ID.String(),
+			})
+
+			var domainErr *Error
+			switch {
+			case err == nil:
+				run.Status = models.ScheduledTransferRunStatusSuccess
+				run.Reference = &result.Reference
+				transfer.Occurrences++
+				transfer.FailureCount = 0
+				s.advance(transfer, sched, occurrence, now)
+			case !errors.As(err, &domainErr) || domainErr.Kind == KindInternal:
+				return err
+			case errors.Is(err, ErrInsufficientFunds):
+				failRun(run, domainErr)
+				transfer.FailureCount++
+				if transfer.FailureCount >= s.maxFailures {
+					pause(transfer, domainErr.Code)
+				} else {
+					s.retry(transfer, sched, occurrence, now)
+				}
+			default:
+				// The recipient is gone or the schedule can't be paid; retrying won't help
+				failRun(run, domainErr)
+				pause(transfer, domainErr.Code)
+			}
+		}
+
+		if err := uow.ScheduledTransfers.CreateRun(ctx, run); err != nil {
+			return err
+		}
+		if err := uow.ScheduledTransfers.Update(ctx, transfer); err != nil {
+			return err
+		}
+		return s.auditRun(ctx, uow, transfer, result)
+	})
+	if err != nil {
+		return err
+	}
+	if transfer == nil {
+		return nil
+	}
+
+	s.recordRun(ctx, transfer, run, result)
+	return nil
+}
+
+// auditRun records the transfer a run made and the schedule being paused, if it was
+func (s *ScheduledTransferService) auditRun(ctx context.Context, uow *repository.UnitOfWork, transfer *models.ScheduledTransfer, result *TransferResult) error {
+	if result != nil {
+		err := s.auditor.RecordSystem(ctx, uow, audit.Event{
+			OwnerUserID: transfer.UserID,
+			Action:      audit.ActionTransferCreate,
+			TargetType:  audit.TargetWallet,
+			TargetID:    result.Recipient.ID.String(),
+			After: map[string]interface{}{
+				"reference":               result.Reference,
+				"amount":                  transfer.Amount,
+				"sender_wallet_number":    result.Sender.WalletNumber,
+				"recipient_wallet_number": result.Recipient.WalletNumber,
+				"scheduled_transfer_id":   transfer.ID,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if transfer.Status != models.ScheduledTransferStatusPaused {
+		return nil
+	}
+	return s.auditor.RecordSystem(ctx, uow, audit.Event{
+		OwnerUserID: transfer.UserID,
+		Action:      audit.ActionScheduledTransferPause,
+		TargetType:  audit.TargetScheduledTransfer,
+		TargetID:    transfer.ID.String(),
+		Before:      map[string]interface{}{"status": models.ScheduledTransferStatusActive},
+		After: map[string]interface{}{
+			"status":        transfer.Status,
+			"reason":        *transfer.PauseReason,
+			"failure_count": transfer.FailureCount,
+		},
+	})
+}
+
+// recordRun reports a committed run in metrics and logs
+func (s *ScheduledTransferService) recordRun(ctx context.Context, transfer *models.ScheduledTransfer, run *models.ScheduledTransferRun, result *TransferResult) {
+	if result != nil {
+		metrics.RecordTransfer("success", transfer.Amount)
+		metrics.RecordScheduledTransferRun(string(models.ScheduledTransferRunStatusSuccess))
+		s.logger.InfoContext(ctx, "Scheduled transfer completed", "scheduled_transfer_id", transfer.ID, "reference", result.Reference, "amount", transfer.Amount)
+	} else {
+		metrics.RecordTransfer("failed", transfer.Amount)
+		metrics.RecordScheduledTransferRun(string(models.ScheduledTransferRunStatusFailed))
+		s.logger.WarnContext(ctx, "Scheduled transfer failed", "scheduled_transfer_id", transfer.ID, "error_code", *run.ErrorCode, "failure_count", transfer.FailureCount)
+	}
+
+	if transfer.Status == models.ScheduledTransferStatusPaused {
+		metrics.RecordScheduledTransferRun("paused")
+		s.logger.WarnContext(ctx, "Scheduled transfer paused", "scheduled_transfer_id", transfer.ID, "reason", *transfer.PauseReason)
+	}
+}
+
+// advance moves a scheduled transfer to its first occurrence after both after and now, so
+// occurrences missed while it couldn't run are skipped rather than paid in a burst. It
+// completes the schedule if no occurrences are left.
+func (s *ScheduledTransferService) advance(transfer *models.ScheduledTransfer, sched schedule.Schedule, after, now time.Time) {
+	next := s.following(transfer, sched, after, now)
+	if next.IsZero() {
+		transfer.Status = models.ScheduledTransferStatusCompleted
+		transfer.OccurrenceAt = nil
+		transfer.NextRunAt = nil
+		return
+	}
+
+	transfer.OccurrenceAt = &next
+	transfer.NextRunAt = &next
+}
+
+// retry schedules another attempt at the current occurrence after the retry delay, or
+// gives up on it if the next occurrence comes first
+func (s *ScheduledTransferService) retry(transfer *models.ScheduledTransfer, sched schedule.Schedule, occurrence, now time.Time) {
+	retryAt := now.Add(s.retryDelay)
+	if next := s.following(transfer, sched, occurrence, now); !next.IsZero() && !next.After(retryAt) {
+		transfer.OccurrenceAt = &next
+		transfer.NextRunAt = &next
+		return
+	}
+
+	transfer.NextRunAt = &retryAt
+}
+
+// following returns the scheduled transfer's first occurrence after both after and now, or
+// the zero time if it has reached its end date or occurrence limit
+func (s *ScheduledTransferService) following(transfer *models.ScheduledTransfer, sched schedule.Schedule, after, now time.Time) time.Time {
+	if transfer.MaxOccurrences != nil && transfer.Occurrences >= *transfer.MaxOccurrences {
+		return time.Time{}
+	}
+
+	if now.After(after) {
+		after = now
+	}
+	next := sched.Next(after)
+	if next.IsZero() || (transfer.EndAt != nil && next.After(*transfer.EndAt)) {
+		return time.Time{}
+	}
+	return next
+}
+
+// failRun marks a run as failed with a domain error
+func failRun(run *models.ScheduledTransferRun, err *Error) {
+	run.Status = models.ScheduledTransferRunStatusFailed
+	run.ErrorCode = &err.Code
+	run.ErrorMessage = &err.Message
+}
+
+// pause stops a scheduled transfer from running until its owner resumes it
+func pause(transfer *models.ScheduledTransfer, reason string) {
+	transfer.Status = models.ScheduledTransferStatusPaused
+	transfer.PauseReason = &reason
+	transfer.NextRunAt = nil
+}
+
+// scheduleOf parses a stored scheduled transfer's schedule
+func scheduleOf(transfer *models.ScheduledTransfer) (schedule.Schedule, error) {
+	var cron, interval string
+	if transfer.Cron != nil {
+		cron = *transfer.Cron
+	}
+	if transfer.Interval != nil {
+		interval = *transfer.Interval
+	}
+
+	sched, err := schedule.Parse(cron, interval, transfer.StartAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schedule of scheduled transfer %s: %w", transfer.ID, err)
+	}
+	return sched, nil
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/google/uuid"
+)
+
+// MinAmount is the smallest deposit or transfer accepted, in kobo (1 Naira)
+const MinAmount int64 = 100
+
+const (
+	// MaxWallets is how many wallets a user can have, the default one included
+	MaxWallets = 10
+
+	// maxWalletNameLength matches the wallets.name column
+	maxWalletNameLength = 50
+)
+
+type WalletService struct {
+	txManager  repository.TxManager
+	walletRepo repository.WalletRepository
+	txRepo     repository.TransactionRepository
+	auditor    *audit.Recorder
+	logger     *slog.Logger
+}
+
+func NewWalletService(txManager repository.TxManager, walletRepo repository.WalletRepository, txRepo repository.TransactionRepository, auditor *audit.Recorder, logger *slog.Logger) *WalletService {
+	return &WalletService{
+		txManager:  txManager,
+		walletRepo: walletRepo,
+		txRepo:     txRepo,
+		auditor:    auditor,
+		logger:     logger,
+	}
+}
+
+// TransferResult holds the ledger entries written for a transfer
+type TransferResult struct {
+	Reference string
+	Sender    *models.Wallet
+	Recipient *models.Wallet
+	Debit     *models.Transaction // transfer_out on the sender's wallet
+	Credit    *models.Transaction // transfer_in on the recipient's wallet
+}
+
+// GetWallet returns the user's wallet with walletNumber, or their default wallet if
+// walletNumber is empty
+func (s *WalletService) GetWallet(ctx context.Context, userID uuid.UUID, walletNumber string) (*models.Wallet, error) {
+	return ownWallet(ctx, s.walletRepo, userID, walletNumber)
+}
+
+// ListWallets returns the user's wallets, the default one first
+func (s *WalletService) ListWallets(ctx context.Context, userID uuid.UUID) ([]models.Wallet, error) {
+	return s.walletRepo.ListByUserID(ctx, userID)
+}
+
+// CreateWallet opens a new, empty wallet for the user. Names are unique per user, ignoring case.
+func (s *WalletService) CreateWallet(ctx context.Context, userID uuid.UUID, name string) (*models.Wallet, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxWalletNameLength {
+		return nil, ErrInvalidWalletName
+	}
+
+	var wallet *models.Wallet
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		primary, err := ownWallet(ctx, uow.Wallets, userID, "")
+		if err != nil {
+			return err
+		}
+		// Locking the default wallet serializes wallet changes for the user
+		if err := uow.Wallets.LockForUpdate(ctx, primary.ID); err != nil {
+			return err
+		}
+
+		wallets, err := uow.Wallets.ListByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if len(wallets) >= MaxWallets {
+			return ErrWalletLimit
+		}
+		for _, existing := range wallets {
+			if strings.EqualFold(existing.Name, name) {
+				return ErrWalletNameTaken
+			}
+		}
+
+		wallet = &models.Wallet{UserID: userID, Name: name}
+		if err := uow.Wallets.Create(ctx, wallet); err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionWalletCreate,
+			TargetType:  audit.TargetWallet,
+			TargetID:    wallet.ID.String(),
+			After: map[string]interface{}{
+				"wallet_number": wallet.WalletNumber,
+				"name":          wallet.Name,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Wallet created", "wallet_id", wallet.ID, "wallet_number", wallet.WalletNumber)
+	return wallet, nil
+}
+
+// SetDefaultWallet makes the user's wallet with walletNumber their default one
+func (s *WalletService) SetDefaultWallet(ctx context.Context, userID uuid.UUID, walletNumber string) (*models.Wallet, error) {
+	var wallet *models.Wallet
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		primary, err := ownWallet(ctx, uow.Wallets, userID, "")
+		if err != nil {
+			return err
+		}
+		wallet, err = ownWallet(ctx, uow.Wallets, userID, walletNumber)
+		if err != nil {
+			return err
+		}
+		if err := uow.Wallets.LockForUpdate(ctx, primary.ID, wallet.ID); err != nil {
+			return err
+		}
+
+		if err := uow.Wallets.SetDefault(ctx, userID, wallet.ID); err != nil {
+			return err
+		}
+		wallet.IsDefault = true
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionWalletSetDefault,
+			TargetType:  audit.TargetWallet,
+			TargetID:    wallet.ID.String(),
+			After: map[string]interface{}{
+				"wallet_number": wallet.WalletNumber,
+				"name":          wallet.Name,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Default wallet changed", "wallet_id", wallet.ID)
+	return wallet, nil
+}
+
+// ListTransactions returns the transaction history of the user's wallet with walletNumber,
+// or of all their wallets if walletNumber is empty, newest first
+func (s *WalletService) ListTransactions(ctx context.Context, userID uuid.UUID, walletNumber string, limit, offset int) ([]models.Transaction, error) {
+	if walletNumber == "" {
+		return s.txRepo.ListByUser(ctx, userID, limit, offset)
+	}
+
+	wallet, err := ownWallet(ctx, s.walletRepo, userID, walletNumber)
+	if err != nil {
+		return nil, err
+	}
+	return s.txRepo.ListByWallet(ctx, wallet.ID, limit, offset)
+}
+
+// Transfer moves amount from the user's wallet with fromWalletNumber (their default wallet if
+// empty) to the wallet with recipientWalletNumber in one database transaction, recording a
+// transfer_out and a transfer_in entry. Either everything is applied or nothing is.
+func (s *WalletService) Transfer(ctx context.Context, userID uuid.UUID, fromWalletNumber, recipientWalletNumber string, amount int64) (*TransferResult, error) {
+	if amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+
+	sender, err := s.GetWallet(ctx, userID, fromWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := s.walletRepo.FindByWalletNumber(ctx, recipientWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		return nil, ErrRecipientNotFound
+	}
+
+	if sender.ID == recipient.ID {
+		return nil, ErrSelfTransfer
+	}
+
+	// Fail fast; the conditional debit in transfer is what actually guards the balance
+	if sender.Balance < amount {
+		metrics.RecordTransfer("failed", amount)
+		return nil, ErrInsufficientFunds
+	}
+
+	var result *TransferResult
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		var err error
+		result, err = s.transfer(ctx, uow, userID, fromWalletNumber, recipientWalletNumber, amount, nil)
+		if err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionTransferCreate,
+			TargetType:  audit.TargetWallet,
+			TargetID:    result.Recipient.ID.String(),
+			After: map[string]interface{}{
+				"reference":               result.Reference,
+				"amount":                  amount,
+				"sender_wallet_number":    result.Sender.WalletNumber,
+				"recipient_wallet_number": result.Recipient.WalletNumber,
+			},
+		})
+	})
+	if err != nil {
+		metrics.RecordTransfer("failed", amount)
+		return nil, err
+	}
+
+	metrics.RecordTransfer("success", amount)
+	s.logger.InfoContext(ctx, "Transfer completed", "reference", result.Reference, "amount", amount)
+	return result, nil
+}
+
+// Move moves amount between two of the user's own wallets. Moves are free and, since the
+// money stays with the user, need no recipient checks; the entries carry "internal": true.
+func (s *WalletService) Move(ctx context.Context, userID uuid.UUID, fromWalletNumber, toWalletNumber string, amount int64) (*TransferResult, error) {
+	if amount < MinAmount {
+		return nil, ErrInvalidAmount
+	}
+
+	var result *TransferResult
+	err := s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		from, err := ownWallet(ctx, uow.Wallets, userID, fromWalletNumber)
+		if err != nil {
+			return err
+		}
+		to, err := ownWallet(ctx, uow.Wallets, userID, toWalletNumber)
+		if err != nil {
+			return err
+		}
+		if from.ID == to.ID {
+			return ErrSelfTransfer
+		}
+
+		reference := fmt.Sprintf("MOV_%s_%s", userID.String()[:8], uuid.New().String()[:8])
+		result, err = s.book(ctx, uow, from, to, amount, reference, "Move to "+to.Name, "Move from "+from.Name, map[string]interface{}{"internal": true})
+		if err != nil {
+			return err
+		}
+
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: userID,
+			Action:      audit.ActionTransferMove,
+			TargetType:  audit.TargetWallet,
+			TargetID:    to.ID.String(),
+			After: map[string]interface{}{
+				"reference":          reference,
+				"amount":             amount,
+				"from_wallet_number": from.WalletNumber,
+				"to_wallet_number":   to.WalletNumber,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "Move completed", "reference", result.Reference, "amount", amount)
+	return result, nil
+}
+
+// transfer performs a transfer from the user's wallet with fromWalletNumber (their default
+// wallet if empty) inside the caller's unit of work. Every domain error is returned before
+// anything is written, so the caller may still commit other work after one. metadata is
+// added to both ledger entries.
+func (s *WalletService) transfer(ctx context.Context, uow *repository.UnitOfWork, userID uuid.UUID, fromWalletNumber, recipientWalletNumber string, amount int64, metadata map[string]interface{}) (*TransferResult, error) {
+	sender, err := ownWallet(ctx, uow.Wallets, userID, fromWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := uow.Wallets.FindByWalletNumber(ctx, recipientWalletNumber)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		return nil, ErrRecipientNotFound
+	}
+
+	if sender.ID == recipient.ID {
+		return nil, ErrSelfTransfer
+	}
+
+	reference := fmt.Sprintf("TRF_%s_%s", sender.UserID.String()[:8], uuid.New().String()[:8])
+	return s.book(ctx, uow, sender, recipient, amount, reference, "Transfer to "+recipient.WalletNumber, "Transfer from "+sender.WalletNumber, metadata)
+}
+
+// book moves amount between two wallets and writes the transfer_out and transfer_in entries,
+// <reference>_OUT and <reference>_IN
+func (s *WalletService) book(ctx context.Context, uow *repository.UnitOfWork, sender, recipient *models.Wallet, amount int64, reference, outDescription, inDescription string, metadata map[string]interface{}) (*TransferResult, error) {
+	if err := uow.Wallets.LockForUpdate(ctx, sender.ID, recipient.ID); err != nil {
+		return nil, err
+	}
+
+	if err := uow.Wallets.Debit(ctx, sender.ID, amount); err != nil {
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			return nil, ErrInsufficientFunds
+		}
+		return nil, err
+	}
+
+	if err := uow.Wallets.Credit(ctx, recipient.ID, amount); err != nil {
+		return nil, err
+	}
+
+	debit, err := transferEntry(sender, recipient, models.TransactionTypeTransferOut, amount, reference+"_OUT", outDescription, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := uow.Transactions.Create(ctx, debit); err != nil {
+		return nil, err
+	}
+
+	credit, err := transferEntry(recipient, sender, models.TransactionTypeTransferIn, amount, reference+"_IN", inDescription, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := uow.Transactions.Create(ctx, credit); err != nil {
+		return nil, err
+	}
+
+	return &TransferResult{Reference: reference, Sender: sender, Recipient: recipient, Debit: debit, Credit: credit}, nil
+}
+
+// ownWallet finds the user's wallet with walletNumber, or their default wallet if walletNumber
+// is empty. Other users' wallets are reported as not found.
+func ownWallet(ctx context.Context, wallets repository.WalletRepository, userID uuid.UUID, walletNumber string) (*models.Wallet, error) {
+	var wallet *models.Wallet
+	var err error
+	if walletNumber == "" {
+		wallet, err = wallets.FindByUserID(ctx, userID)
+	} else {
+		wallet, err = wallets.FindByWalletNumber(ctx, walletNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil || wallet.UserID != userID {
+		return nil, ErrWalletNotFound
+	}
+	return wallet, nil
+}
+
+// transferEntry builds the ledger entry for one side of a transfer
+func transferEntry(wallet, counterparty *models.Wallet, txType models.TransactionType, amount int64, reference, description string, extra map[string]interface{}) (*models.Transaction, error) {
+	fields := map[string]interface{}{
+		"counterparty_wallet_number": counterparty.WalletNumber,
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+
+	metadata, err := repository.CreateMetadata(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build transaction metadata: %w", err)
+	}
+
+	return &models.Transaction{
+		UserID:      wallet.UserID,
+		WalletID:    wallet.ID,
+		Type:        txType,
+		Amount:      amount,
+		Status:      models.TransactionStatusSuccess,
+		Reference:   &reference,
+		Description: &description,
+		Metadata:    models.JSON(metadata),
+	}, nil
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/franzego/stage08/internal/audit"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/payment"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	webhookBatchSize      = 20               // Events claimed per query
+	webhookLease          = time.Minute      // How long an attempt owns an event before it is due again
+	webhookProcessTimeout = 30 * time.Second // Deadline for processing one event
+	webhookRetryBase      = 5 * time.Second  // Delay before the first retry, doubled on each retry
+	webhookRetryMax       = time.Hour
+)
+
+// WebhookService stores verified webhooks and processes them in the background, so the
+// provider gets a fast acknowledgement and failed processing is retried from the store
+// rather than depending on the provider redelivering.
+type WebhookService struct {
+	txManager      repository.TxManager
+	webhookRepo    repository.WebhookEventRepository
+	providers      *payment.Registry
+	depositService *DepositService
+	maxAttempts    int
+	tolerance      time.Duration
+	wake           chan struct{}
+	auditor        *audit.Recorder
+	logger         *slog.Logger
+}
+
+// NewWebhookService creates the service. Events are marked failed after maxAttempts, and
+// events timestamped more than tolerance from now are refused (0 accepts any age).
+func NewWebhookService(txManager repository.TxManager, webhookRepo repository.WebhookEventRepository, providers *payment.Registry, depositService *DepositService, maxAttempts int, tolerance time.Duration, auditor *audit.Recorder, logger *slog.Logger) *WebhookService {
+	return &WebhookService{
+		txManager:      txManager,
+		webhookRepo:    webhookRepo,
+		providers:      providers,
+		depositService: depositService,
+		maxAttempts:    maxAttempts,
+		tolerance:      tolerance,
+		wake:           make(chan struct{}, 1),
+		auditor:        auditor,
+		logger:         logger,
+	}
+}
+
+// Receive stores a webhook from the named provider whose signature has already been verified.
+// Redeliveries of a stored event are dropped; duplicate reports whether that happened. Events
+// outside the replay window are refused, so a captured payload can't be replayed later.
+func (s *WebhookService) Receive(ctx context.Context, provider string, body []byte) (event *models.WebhookEvent, duplicate bool, err error) {
+	parsed, err := s.parse(provider, body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if s.tolerance > 0 && !parsed.Timestamp.IsZero() && absDuration(time.Since(parsed.Timestamp)) > s.tolerance {
+		s.logger.WarnContext(ctx, "Webhook outside replay window", "provider", provider, "event_type", parsed.Type, "timestamp", parsed.Timestamp, "tolerance", s.tolerance)
+		return nil, false, ErrStaleWebhook
+	}
+
+	event, inserted, err := s.store(ctx, provider, parsed, body)
+	if err != nil {
+		return nil, false, err
+	}
+	if !inserted {
+		return event, true, nil
+	}
+
+	// Let the worker pick the event up now instead of on its next poll
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return event, false, nil
+}
+
+// Replay stores a verified webhook from the named provider submitted by an admin, ignoring
+// the replay window, and processes it right away. If the event was already stored it is
+// reprocessed. The replay is audited under adminID with the outcome.
+func (s *WebhookService) Replay(ctx context.Context, adminID uuid.UUID, provider string, body []byte) (*models.WebhookEvent, error) {
+	parsed, err := s.parse(provider, body)
+	if err != nil {
+		return nil, err
+	}
+
+	event, inserted, err := s.store(ctx, provider, parsed, body)
+	if err != nil {
+		return nil, err
+	}
+	if !inserted {
+		event, err = s.webhookRepo.FindByEventID(ctx, event.Provider, event.EventType, event.EventID)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			return nil, ErrWebhookEventNotFound
+		}
+	}
+
+	return s.reprocess(ctx, event.ID, func(ctx context.Context, uow *repository.UnitOfWork, event *models.WebhookEvent) error {
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: adminID,
+			Action:      audit.ActionWebhookReplay,
+			TargetType:  audit.TargetWebhookEvent,
+			TargetID:    event.ID.String(),
+			After:       map[string]interface{}{"provider": event.Provider, "event_type": event.EventType, "status": event.Status, "attempts": event.Attempts, "last_error": event.LastError},
+		})
+	})
+}
+
+// parse decodes a webhook body with the named provider
+func (s *WebhookService) parse(provider string, body []byte) (*payment.Event, error) {
+	p, ok := s.providers.Get(provider)
+	if !ok || provider == "" {
+		return nil, ErrUnsupportedProvider
+	}
+
+	event, err := p.ParseWebhook(body)
+	if err != nil {
+		return nil, ErrInvalidWebhookPayload
+	}
+	return event, nil
+}
+
+// store inserts the event unless it was stored before
+func (s *WebhookService) store(ctx context.Context, provider string, parsed *payment.Event, body []byte) (*models.WebhookEvent, bool, error) {
+	// Providers redeliver an event with the same id; fall back to the body hash without one
+	eventID := parsed.ID
+	if eventID == "" {
+		sum := sha256.Sum256(body)
+		eventID = hex.EncodeToString(sum[:])
+	}
+
+	event := &models.WebhookEvent{
+		Provider:  provider,
+		EventID:   eventID,
+		EventType: parsed.Type,
+		Payload:   models.JSON(body),
+	}
+	inserted, err := s.webhookRepo.Insert(ctx, event)
+	if err != nil {
+		return nil, false, err
+	}
+	return event, inserted, nil
+}
+
+// Run processes due events until ctx is cancelled. It polls every interval, and right away
+// when Receive stores a new event. beat is called whenever the worker makes progress.
+func (s *WebhookService) Run(ctx context.Context, interval time.Duration, beat func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		beat()
+		s.ProcessDue(ctx, beat)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// ProcessDue claims and processes due events until none are left or ctx is cancelled
+func (s *WebhookService) ProcessDue(ctx context.Context, beat func()) {
+	for ctx.Err() == nil {
+		events, err := s.webhookRepo.ClaimDue(ctx, webhookBatchSize, webhookLease)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.ErrorContext(ctx, "Failed to claim webhook events", "error", err)
+			}
+			return
+		}
+		if len(events) == 0 {
+			return
+		}
+
+		for i := range events {
+			s.process(ctx, &events[i], nil)
+			beat()
+		}
+	}
+}
+
+// List lists stored events newest first. An empty status lists every event.
+func (s *WebhookService) List(ctx context.Context, status string, limit, offset int) ([]models.WebhookEvent, int, error) {
+	switch models.WebhookEventStatus(status) {
+	case "", models.WebhookEventStatusPending, models.WebhookEventStatusProcessed,
+		models.WebhookEventStatusIgnored, models.WebhookEventStatusFailed:
+	default:
+		return nil, 0, ErrInvalidWebhookStatus
+	}
+
+	events, err := s.webhookRepo.List(ctx, models.WebhookEventStatus(status), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.webhookRepo.Count(ctx, models.WebhookEventStatus(status))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// Get returns a stored event
+func (s *WebhookService) Get(ctx context.Context, id uuid.UUID) (*models.WebhookEvent, error) {
+	event, err := s.webhookRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, ErrWebhookEventNotFound
+	}
+	return event, nil
+}
+
+// Reprocess runs a stored event again right away, whatever its status, and returns it with
+// the outcome, audited under adminID. Processing is idempotent, so replaying a processed
+// event changes nothing.
+func (s *WebhookService) Reprocess(ctx context.Context, adminID, id uuid.UUID) (*models.WebhookEvent, error) {
+	before, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.reprocess(ctx, id, func(ctx context.Context, uow *repository.UnitOfWork, event *models.WebhookEvent) error {
+		return s.auditor.Record(ctx, uow, audit.Event{
+			OwnerUserID: adminID,
+			Action:      audit.ActionWebhookReprocess,
+			TargetType:  audit.TargetWebhookEvent,
+			TargetID:    event.ID.String(),
+			Before:      map[string]interface{}{"status": before.Status, "attempts": before.Attempts},
+			After:       map[string]interface{}{"status": event.Status, "attempts": event.Attempts, "last_error": event.LastError},
+		})
+	})
+}
+
+// reprocess claims a stored event, processes it right away and returns it with the outcome
+func (s *WebhookService) reprocess(ctx context.Context, id uuid.UUID, record outcomeRecorder) (*models.WebhookEvent, error) {
+	event, err := s.webhookRepo.Claim(ctx, id, webhookLease)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		if _, err := s.Get(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrWebhookEventInProgress
+	}
+
+	if err := s.process(ctx, event, record); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// outcomeRecorder audits a processed event in the transaction that saves its outcome
+type outcomeRecorder func(ctx context.Context, uow *repository.UnitOfWork, event *models.WebhookEvent) error
+
+// process handles one claimed event and saves the outcome, with record's audit event if
+// record is not nil. Transient failures are retried with exponential backoff until
+// maxAttempts; business rule failures are final. It returns the error saving the outcome.
+func (s *WebhookService) process(ctx context.Context, event *models.WebhookEvent, record outcomeRecorder) error {
+	// Once started, an attempt finishes even if the worker is stopping
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webhookProcessTimeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "webhook.process",
+		attribute.String("webhook.provider", event.Provider),
+		attribute.String("webhook.event_type", event.EventType),
+		attribute.String("webhook.event_id", event.EventID),
+		attribute.Int("webhook.attempt", event.Attempts),
+	)
+	defer span.End()
+
+	handled, err := s.handle(ctx, event)
+	tracing.RecordError(span, err)
+
+	logger := s.logger.With("webhook_event_id", event.ID, "event_type", event.EventType, "attempt", event.Attempts)
+
+	var save func(events repository.WebhookEventRepository) error
+	switch {
+	case err == nil && !handled:
+		metrics.RecordWebhookEvent(event.EventType, metrics.WebhookResultIgnored)
+		save = func(events repository.WebhookEventRepository) error {
+			return events.Complete(ctx, event.ID, models.WebhookEventStatusIgnored, nil)
+		}
+
+	case err == nil:
+		metrics.RecordWebhookEvent(event.EventType, metrics.WebhookResultProcessed)
+		save = func(events repository.WebhookEventRepository) error {
+			return events.Complete(ctx, event.ID, models.WebhookEventStatusProcessed, nil)
+		}
+
+	case !retryable(err) || event.Attempts >= s.maxAttempts:
+		metrics.RecordWebhookEvent(event.EventType, metrics.WebhookResultError)
+		logger.ErrorContext(ctx, "Webhook event failed", "error", err)
+		message := err.Error()
+		save = func(events repository.WebhookEventRepository) error {
+			return events.Complete(ctx, event.ID, models.WebhookEventStatusFailed, &message)
+		}
+
+	default:
+		metrics.RecordWebhookEvent(event.EventType, metrics.WebhookResultError)
+		delay := webhookRetryBase << (event.Attempts - 1)
+		if delay <= 0 || delay > webhookRetryMax {
+			delay = webhookRetryMax
+		}
+		logger.WarnContext(ctx, "Webhook event processing failed, will retry", "error", err, "retry_in", delay)
+		message := err.Error()
+		save = func(events repository.WebhookEventRepository) error {
+			return events.Retry(ctx, event.ID, message, time.Now().Add(delay))
+		}
+	}
+
+	if record == nil {
+		// If the outcome isn't saved the lease expires and the event is simply processed again
+		if err := save(s.webhookRepo); err != nil {
+			logger.ErrorContext(ctx, "Failed to record webhook outcome", "error", err)
+			return err
+		}
+		return nil
+	}
+
+	err = s.txManager.WithinTx(ctx, func(uow *repository.UnitOfWork) error {
+		if err := save(uow.WebhookEvents); err != nil {
+			return err
+		}
+
+		saved, err := uow.WebhookEvents.FindByID(ctx, event.ID)
+		if err != nil {
+			return err
+		}
+		if saved == nil {
+			return ErrWebhookEventNotFound
+		}
+		return record(ctx, uow, saved)
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to record webhook outcome", "error", err)
+	}
+	return err
+}
+
+// handle applies the event. It reports false for event types the service doesn't act on.
+func (s *WebhookService) handle(ctx context.Context, event *models.WebhookEvent) (bool, error) {
+	parsed, err := s.parse(event.Provider, event.Payload)
+	if err != nil {
+		return false, err
+	}
+
+	if parsed.Charge == nil {
+		return false, nil
+	}
+	charge, err := s.confirm(ctx, event.Provider, *parsed.Charge)
+	if err != nil {
+		return false, err
+	}
+
+	// Successful and failed payments both settle the deposit; Settle is idempotent
+	if charge.Status == payment.ChargePending {
+		return false, nil
+	}
+	return true, s.depositService.Settle(ctx, event.Provider, charge)
+}
+
+// confirm returns the charge to settle for a webhook. Unless the provider signs its webhooks,
+// anyone holding the webhook secret could write the payload, so the payment is looked up with
+// the provider and settled from its answer.
+func (s *WebhookService) confirm(ctx context.Context, provider string, charge payment.Charge) (payment.Charge, error) {
+	p, ok := s.providers.Get(provider)
+	if !ok {
+		return charge, ErrUnsupportedProvider
+	}
+	if p.SignsWebhooks() {
+		return charge, nil
+	}
+
+	verified, err := p.Verify(ctx, charge.Reference)
+	if err != nil {
+		return charge, ErrPaymentProvider.WithDetail("Failed to verify payment " + charge.Reference + ": " + err.Error())
+	}
+	// The provider answers for the payment the webhook named
+	verified.Reference = charge.Reference
+	return *verified, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// retryable reports whether processing may succeed on a later attempt. Domain errors
+// (e.g. an unknown reference) won't change; database and upstream failures might.
+func retryable(err error) bool {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Kind == KindInternal || domainErr.Kind == KindUnavailable
+	}
+	return true
+}
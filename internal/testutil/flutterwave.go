@@ -0,0 +1,187 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/franzego/stage08/internal/flutterwave"
+)
+
+// FakeFlutterwaveSecret is the secret key the fake Flutterwave expects
+const FakeFlutterwaveSecret = "FLWSECK_TEST-fake"
+
+// FakeFlutterwaveHash is the secret hash sent in the verif-hash header of webhooks
+const FakeFlutterwaveHash = "fake-flutterwave-hash"
+
+// FakeFlutterwave is an in-process stand-in for the Flutterwave v3 API. It implements
+// payment initialization and verification, and builds webhooks. Amounts are kept in kobo.
+type FakeFlutterwave struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	transactions map[string]*FakeTransaction
+	ids          map[string]int64
+	nextID       int64
+}
+
+// NewFakeFlutterwave starts a fake Flutterwave API that is shut down when the test finishes
+func NewFakeFlutterwave(t testing.TB) *FakeFlutterwave {
+	t.Helper()
+
+	f := &FakeFlutterwave{
+		transactions: make(map[string]*FakeTransaction),
+		ids:          make(map[string]int64),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /payments", f.initialize)
+	mux.HandleFunc("GET /transactions/verify_by_reference", f.verify)
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL is the base URL to configure as FLUTTERWAVE_BASE_URL
+func (f *FakeFlutterwave) URL() string {
+	return f.server.URL
+}
+
+// Transaction returns a copy of the transaction with the given reference
+func (f *FakeFlutterwave) Transaction(reference string) (FakeTransaction, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tx, ok := f.transactions[reference]
+	if !ok {
+		return FakeTransaction{}, false
+	}
+	return *tx, true
+}
+
+// ChargeCompleted marks the transaction paid and returns the charge.completed webhook and
+// the verif-hash to send it with
+func (f *FakeFlutterwave) ChargeCompleted(t testing.TB, reference string) (body []byte, hash string) {
+	t.Helper()
+
+	f.mu.Lock()
+	tx, ok := f.transactions[reference]
+	if !ok {
+		f.mu.Unlock()
+		t.Fatalf("fake flutterwave: unknown reference %s", reference)
+	}
+	tx.Status = "successful"
+	tx.PaidAt = time.Now().UTC()
+	snapshot, id := *tx, f.ids[reference]
+	f.mu.Unlock()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"event": "charge.completed",
+		"data":  f.transaction(snapshot, id),
+	})
+	if err != nil {
+		t.Fatalf("marshal webhook: %v", err)
+	}
+	return body, FakeFlutterwaveHash
+}
+
+func (f *FakeFlutterwave) initialize(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	var req struct {
+		TxRef    string  `json:"tx_ref"`
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+		Customer struct {
+			Email string `json:"email"`
+		} `json:"customer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": "error", "message": "Invalid body"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.transactions[req.TxRef]; exists {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": "error", "message": "Duplicate tx_ref"})
+		return
+	}
+
+	f.nextID++
+	f.ids[req.TxRef] = f.nextID
+	f.transactions[req.TxRef] = &FakeTransaction{
+		Reference: req.TxRef,
+		Email:     req.Customer.Email,
+		Amount:    flutterwave.Kobo(req.Amount),
+		Currency:  req.Currency,
+		Status:    "pending",
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"message": "Hosted Link",
+		"data":    map[string]interface{}{"link": f.server.URL + "/checkout/" + req.TxRef},
+	})
+}
+
+func (f *FakeFlutterwave) verify(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	reference := r.URL.Query().Get("tx_ref")
+	f.mu.Lock()
+	tx, ok := f.transactions[reference]
+	var snapshot FakeTransaction
+	if ok {
+		snapshot = *tx
+	}
+	id := f.ids[reference]
+	f.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"status": "error", "message": "No transaction was found for this id"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "message": "Transaction fetched successfully", "data": f.transaction(snapshot, id)})
+}
+
+// transaction renders a transaction the way Flutterwave does, with amounts in naira
+func (f *FakeFlutterwave) transaction(tx FakeTransaction, id int64) map[string]interface{} {
+	naira := float64(tx.Amount) / 100
+	data := map[string]interface{}{
+		"id":                 id,
+		"tx_ref":             tx.Reference,
+		"flw_ref":            "FLW-MOCK-" + tx.Reference,
+		"amount":             naira,
+		"charged_amount":     naira,
+		"app_fee":            naira * 0.014,
+		"currency":           tx.Currency,
+		"status":             tx.Status,
+		"processor_response": "Approved by Financial Institution",
+		"payment_type":       "card",
+		"customer":           map[string]interface{}{"email": tx.Email},
+	}
+	if !tx.PaidAt.IsZero() {
+		data["created_at"] = tx.PaidAt.Format(time.RFC3339)
+	}
+	return data
+}
+
+func (f *FakeFlutterwave) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") != FakeFlutterwaveSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"status": "error", "message": "Invalid authorization key"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,328 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/app"
+	"github.com/franzego/stage08/internal/models"
+	"github.com/franzego/stage08/internal/repository"
+	"github.com/franzego/stage08/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Harness runs the full router against a private database schema, fake payment providers and
+// a fake mail server
+type Harness struct {
+	DB          *sqlx.DB
+	Paystack    *FakePaystack
+	Flutterwave *FakeFlutterwave
+	SMTP        *FakeSMTP
+	Config      *config.Config
+	App         *app.App
+}
+
+// NewHarness builds the application for a test. It is skipped when DATABASE_URL is unset.
+// Options adjust the test configuration before the application is built.
+func NewHarness(t testing.TB, opts ...func(*config.Config)) *Harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := NewDatabase(t)
+	paystack := NewFakePaystack(t)
+	flutterwave := NewFakeFlutterwave(t)
+	smtp := NewFakeSMTP(t)
+	cfg := Config(paystack.URL(), flutterwave.URL())
+	cfg.Mail.SMTPHost, cfg.Mail.SMTPPort = smtp.Host(), smtp.Port()
+	// Advisory locks are shared by every schema in the database, so each test elects its own
+	// scheduler leader
+	cfg.Scheduler.LockKey = randomLockKey(t)
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	application := app.New(cfg, db, Logger())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		application.Workers.Stop(ctx)
+	})
+
+	return &Harness{DB: db, Paystack: paystack, Flutterwave: flutterwave, SMTP: smtp, Config: cfg, App: application}
+}
+
+// Config returns a configuration suitable for tests, talking to Paystack at paystackURL and
+// Flutterwave at flutterwaveURL
+func Config(paystackURL, flutterwaveURL string) *config.Config {
+	return &config.Config{
+		Server: config.ServerConfig{
+			PublicURL:       "http://wallet.test",
+			RequestTimeout:  10 * time.Second,
+			ShutdownTimeout: 5 * time.Second,
+		},
+		JWT: config.JWTConfig{
+			Secret:     "test-jwt-secret",
+			Expiration: time.Hour,
+		},
+		Google: config.GoogleOAuthConfig{
+			Timeout: 5 * time.Second,
+		},
+		Paystack: config.PaystackConfig{
+			SecretKey:    FakePaystackSecret,
+			BaseURL:      paystackURL,
+			Timeout:      5 * time.Second,
+			MaxRetries:   1,
+			RetryBackoff: 10 * time.Millisecond,
+		},
+		Flutterwave: config.FlutterwaveConfig{
+			SecretKey:    FakeFlutterwaveSecret,
+			SecretHash:   FakeFlutterwaveHash,
+			BaseURL:      flutterwaveURL,
+			Timeout:      5 * time.Second,
+			MaxRetries:   1,
+			RetryBackoff: 10 * time.Millisecond,
+		},
+		Payments: config.PaymentsConfig{
+			DefaultProvider: config.ProviderPaystack,
+		},
+		TwoFactor: config.TwoFactorConfig{
+			Issuer:       "Wallet Service Test",
+			ChallengeTTL: 5 * time.Minute,
+			MaxAge:       15 * time.Minute,
+			MaxAttempts:  5,
+			Lockout:      15 * time.Minute,
+		},
+		Tracing: config.TracingConfig{
+			Exporter:    "none",
+			ServiceName: "wallet-service-test",
+		},
+		Health: config.HealthConfig{
+			CheckTimeout: 2 * time.Second,
+		},
+		Webhooks: config.WebhooksConfig{
+			PollInterval: 100 * time.Millisecond,
+			MaxAttempts:  3,
+			Tolerance:    time.Hour,
+		},
+		Scheduler: config.SchedulerConfig{
+			PollInterval: 100 * time.Millisecond,
+			RetryDelay:   100 * time.Millisecond,
+			MaxFailures:  2,
+			LockKey:      config.DefaultSchedulerLockKey,
+		},
+		Batches: config.BatchesConfig{
+			MaxItems:     10,
+			PollInterval: 100 * time.Millisecond,
+		},
+		Mail: config.MailConfig{
+			From:         "Wallet Service Test <no-reply@wallet.test>",
+			PollInterval: 100 * time.Millisecond,
+			MaxAttempts:  3,
+		},
+		Admin: config.AdminConfig{
+			Emails: []string{AdminEmail},
+		},
+	}
+}
+
+// AdminEmail is the email of the user created by CreateAdmin, listed in ADMIN_EMAILS
+const AdminEmail = "admin@example.com"
+
+// User is a signed-up user with a wallet and a session token
+type User struct {
+	*models.User
+	Wallet *models.Wallet
+	Token  string
+}
+
+// CreateUser signs up a user (which also creates their wallet) and issues a JWT
+func (h *Harness) CreateUser(t testing.TB, name string) *User {
+	t.Helper()
+	return h.createUser(t, name, fmt.Sprintf("%s-%s@example.com", name, randomHex(t, 4)))
+}
+
+// CreateUserWithEmail signs up a user with the given email
+func (h *Harness) CreateUserWithEmail(t testing.TB, name, email string) *User {
+	t.Helper()
+	return h.createUser(t, name, email)
+}
+
+// CreateAdmin signs up the admin user. It can only be called once per harness.
+func (h *Harness) CreateAdmin(t testing.TB) *User {
+	t.Helper()
+	return h.createUser(t, "admin", AdminEmail)
+}
+
+func (h *Harness) createUser(t testing.TB, name, email string) *User {
+	t.Helper()
+	ctx := context.Background()
+	logger := Logger()
+
+	user, err := repository.NewUserRepository(h.DB, logger).Create(ctx, "google-"+randomHex(t, 8), email, name, nil)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	wallet, err := repository.NewWalletRepository(h.DB, logger).FindByUserID(ctx, user.ID)
+	if err != nil || wallet == nil {
+		t.Fatalf("find wallet for %s: %v", email, err)
+	}
+
+	token, err := utils.GenerateJWT(user.ID, user.Email, user.Name, h.Config.JWT.Secret, h.Config.JWT.Expiration)
+	if err != nil {
+		t.Fatalf("generate JWT: %v", err)
+	}
+
+	return &User{User: user, Wallet: wallet, Token: token}
+}
+
+// RequestOption customizes a request made with Do
+type RequestOption func(*http.Request)
+
+// Bearer authenticates the request with a JWT
+func Bearer(token string) RequestOption {
+	return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+}
+
+// APIKey authenticates the request with an API key
+func APIKey(key string) RequestOption {
+	return func(r *http.Request) { r.Header.Set("x-api-key", key) }
+}
+
+// Header sets an arbitrary request header
+func Header(name, value string) RequestOption {
+	return func(r *http.Request) { r.Header.Set(name, value) }
+}
+
+// Do sends a request through the full router. body is JSON-encoded unless it is already []byte.
+func (h *Harness) Do(t testing.TB, method, path string, body interface{}, opts ...RequestOption) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("marshal request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	rec := httptest.NewRecorder()
+	h.App.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Webhook delivers a Paystack webhook with the given signature. Accepted webhooks are
+// processed in the background; use WaitForWebhooks before checking their effects.
+func (h *Harness) Webhook(t testing.TB, body []byte, signature string) *httptest.ResponseRecorder {
+	t.Helper()
+	return h.Do(t, http.MethodPost, "/wallet/paystack/webhook", body, Header("x-paystack-signature", signature))
+}
+
+// WaitForWebhooks waits until the background worker has processed every stored webhook
+func (h *Harness) WaitForWebhooks(t testing.TB) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var pending int
+		if err := h.DB.Get(&pending, `SELECT COUNT(*) FROM webhook_events WHERE status = 'pending'`); err != nil {
+			t.Fatalf("count pending webhooks: %v", err)
+		}
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d webhook events still pending", pending)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Deposit initializes a deposit for the user and returns its reference
+func (h *Harness) Deposit(t testing.TB, user *User, amount int64) string {
+	t.Helper()
+
+	rec := h.Do(t, http.MethodPost, "/wallet/deposit", map[string]int64{"amount": amount}, Bearer(user.Token))
+	var resp struct {
+		Reference string `json:"reference"`
+	}
+	ExpectJSON(t, rec, http.StatusOK, &resp)
+	return resp.Reference
+}
+
+// Fund deposits amount into the user's wallet through Paystack checkout and a signed webhook
+func (h *Harness) Fund(t testing.TB, user *User, amount int64) {
+	t.Helper()
+
+	reference := h.Deposit(t, user, amount)
+	body, signature := h.Paystack.ChargeSuccess(t, reference)
+	ExpectStatus(t, h.Webhook(t, body, signature), http.StatusOK)
+	h.WaitForWebhooks(t)
+}
+
+// Balance reads the user's balance through the API
+func (h *Harness) Balance(t testing.TB, user *User) int64 {
+	t.Helper()
+
+	var resp struct {
+		Balance int64 `json:"balance"`
+	}
+	ExpectJSON(t, h.Do(t, http.MethodGet, "/wallet/balance", nil, Bearer(user.Token)), http.StatusOK, &resp)
+	return resp.Balance
+}
+
+// ExpectStatus fails the test unless the response has the wanted status
+func ExpectStatus(t testing.TB, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// ExpectJSON checks the status and decodes the JSON body into out
+func ExpectJSON(t testing.TB, rec *httptest.ResponseRecorder, want int, out interface{}) {
+	t.Helper()
+	ExpectStatus(t, rec, want)
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("decode body %q: %v", rec.Body.String(), err)
+	}
+}
+
+// ExpectProblem checks that the response is a problem document with the wanted status and code
+func ExpectProblem(t testing.TB, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want application/problem+json", ct)
+	}
+
+	var problem struct {
+		Code string `json:"code"`
+	}
+	ExpectJSON(t, rec, status, &problem)
+	if problem.Code != code {
+		t.Fatalf("problem code = %q, want %q; body: %s", problem.Code, code, rec.Body.String())
+	}
+}
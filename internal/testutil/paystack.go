@@ -0,0 +1,416 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/franzego/stage08/internal/paystack"
+)
+
+// FakePaystackSecret is the secret key the fake expects and signs webhooks with
+const FakePaystackSecret = "sk_test_fake"
+
+// FakeTransaction is a transaction the fake Paystack knows about
+type FakeTransaction struct {
+	Reference string
+	Email     string
+	Amount    int64
+	Currency  string
+	Status    string // pending, success, failed or reversed (refunded)
+	PaidAt    time.Time
+}
+
+// FakePaystack is an in-process stand-in for the Paystack API. It implements
+// transaction initialize and verify, charging saved cards and refunds, and builds correctly
+// signed webhooks.
+type FakePaystack struct {
+	server *httptest.Server
+
+	mu             sync.Mutex
+	transactions   map[string]*FakeTransaction
+	authorizations map[string]string // authorization code -> customer email
+	failInitialize bool
+	declineCharges bool
+	nextEventID    int64
+}
+
+// NewFakePaystack starts a fake Paystack API that is shut down when the test finishes
+func NewFakePaystack(t testing.TB) *FakePaystack {
+	t.Helper()
+
+	f := &FakePaystack{
+		transactions:   make(map[string]*FakeTransaction),
+		authorizations: make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /transaction/initialize", f.initialize)
+	mux.HandleFunc("GET /transaction/verify/{reference}", f.verify)
+	mux.HandleFunc("POST /transaction/charge_authorization", f.chargeAuthorization)
+	mux.HandleFunc("POST /refund", f.refund)
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "fake paystack"})
+	})
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL is the base URL to configure as PAYSTACK_BASE_URL
+func (f *FakePaystack) URL() string {
+	return f.server.URL
+}
+
+// FailInitialize makes subsequent initialize calls return a Paystack error
+func (f *FakePaystack) FailInitialize(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failInitialize = fail
+}
+
+// DeclineCharges makes subsequent saved card charges fail as declined by the bank
+func (f *FakePaystack) DeclineCharges(decline bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.declineCharges = decline
+}
+
+// Transaction returns a copy of the transaction with the given reference
+func (f *FakePaystack) Transaction(reference string) (FakeTransaction, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tx, ok := f.transactions[reference]
+	if !ok {
+		return FakeTransaction{}, false
+	}
+	return *tx, true
+}
+
+// Pay marks the transaction as paid, as if the customer completed checkout
+func (f *FakePaystack) Pay(t testing.TB, reference string) FakeTransaction {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tx, ok := f.transactions[reference]
+	if !ok {
+		t.Fatalf("fake paystack: unknown reference %s", reference)
+	}
+	tx.Status = "success"
+	tx.PaidAt = time.Now().UTC()
+	return *tx
+}
+
+// ChargeSuccess returns a signed charge.success webhook for a paid transaction.
+// The amount can be overridden to simulate a tampered or mismatched payment.
+func (f *FakePaystack) ChargeSuccess(t testing.TB, reference string, amount ...int64) (body []byte, signature string) {
+	t.Helper()
+
+	if len(amount) > 0 {
+		return f.ChargeSuccessWith(t, reference, map[string]interface{}{"amount": amount[0]})
+	}
+	return f.ChargeSuccessWith(t, reference, nil)
+}
+
+// ChargeSuccessWith is ChargeSuccess with fields of the event's data replaced by overrides
+// (e.g. "currency" or "customer"), to simulate payments that don't match their deposit.
+func (f *FakePaystack) ChargeSuccessWith(t testing.TB, reference string, overrides map[string]interface{}) (body []byte, signature string) {
+	t.Helper()
+
+	tx := f.Pay(t, reference)
+
+	// Every checkout is paid with a reusable card, which can then be charged again
+	authorizationCode := "AUTH_" + tx.Reference
+	f.mu.Lock()
+	f.nextEventID++
+	eventID := f.nextEventID
+	f.authorizations[authorizationCode] = tx.Email
+	f.mu.Unlock()
+
+	data := map[string]interface{}{
+		"id":               eventID,
+		"reference":        tx.Reference,
+		"amount":           tx.Amount,
+		"currency":         tx.Currency,
+		"status":           "success",
+		"gateway_response": "Successful",
+		"paid_at":          tx.PaidAt.Format(time.RFC3339),
+		"channel":          "card",
+		"fees":             tx.Amount * 15 / 1000,
+		"customer":         map[string]interface{}{"email": tx.Email},
+		"authorization":    fakeAuthorization(authorizationCode, tx.Email),
+	}
+	for key, value := range overrides {
+		data[key] = value
+	}
+	event := map[string]interface{}{"event": "charge.success", "data": data}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshal webhook: %v", err)
+	}
+	return body, SignWebhook(body)
+}
+
+// ChargeFailed returns a signed charge.failed webhook for a transaction whose payment attempt
+// was declined. The customer can still pay it later.
+func (f *FakePaystack) ChargeFailed(t testing.TB, reference string) (body []byte, signature string) {
+	t.Helper()
+
+	f.mu.Lock()
+	tx, ok := f.transactions[reference]
+	if !ok {
+		f.mu.Unlock()
+		t.Fatalf("fake paystack: unknown reference %s", reference)
+	}
+	tx.Status = "failed"
+	f.nextEventID++
+	data := map[string]interface{}{
+		"id":               f.nextEventID,
+		"reference":        tx.Reference,
+		"amount":           tx.Amount,
+		"currency":         tx.Currency,
+		"status":           "failed",
+		"gateway_response": "Declined",
+		"created_at":       time.Now().UTC().Format(time.RFC3339),
+		"channel":          "card",
+		"customer":         map[string]interface{}{"email": tx.Email},
+	}
+	f.mu.Unlock()
+
+	body, err := json.Marshal(map[string]interface{}{"event": "charge.failed", "data": data})
+	if err != nil {
+		t.Fatalf("marshal webhook: %v", err)
+	}
+	return body, SignWebhook(body)
+}
+
+// SignWebhook computes the x-paystack-signature header for body
+func SignWebhook(body []byte) string {
+	return paystack.Sign(FakePaystackSecret, body)
+}
+
+func (f *FakePaystack) initialize(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	var req struct {
+		Email     string `json:"email"`
+		Amount    int64  `json:"amount"`
+		Reference string `json:"reference"`
+		Currency  string `json:"currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Invalid body"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failInitialize {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Initialization failed"})
+		return
+	}
+	if _, exists := f.transactions[req.Reference]; exists {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Duplicate Transaction Reference"})
+		return
+	}
+
+	f.transactions[req.Reference] = &FakeTransaction{
+		Reference: req.Reference,
+		Email:     req.Email,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Status:    "pending",
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  true,
+		"message": "Authorization URL created",
+		"data": map[string]interface{}{
+			"authorization_url": f.server.URL + "/checkout/" + req.Reference,
+			"access_code":       "access_" + req.Reference,
+			"reference":         req.Reference,
+		},
+	})
+}
+
+func (f *FakePaystack) verify(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	f.mu.Lock()
+	tx, ok := f.transactions[r.PathValue("reference")]
+	var snapshot FakeTransaction
+	if ok {
+		snapshot = *tx
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Transaction reference not found"})
+		return
+	}
+
+	data := map[string]interface{}{
+		"reference": snapshot.Reference,
+		"amount":    snapshot.Amount,
+		"currency":  snapshot.Currency,
+		"status":    snapshot.Status,
+		"channel":   "card",
+		"customer":  map[string]interface{}{"email": snapshot.Email},
+	}
+	if !snapshot.PaidAt.IsZero() {
+		data["paid_at"] = snapshot.PaidAt.Format(time.RFC3339)
+	}
+	if snapshot.Status == "success" {
+		data["gateway_response"] = "Successful"
+		data["fees"] = snapshot.Amount * 15 / 1000
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": true, "message": "Verification successful", "data": data})
+}
+
+func (f *FakePaystack) chargeAuthorization(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	var req struct {
+		AuthorizationCode string `json:"authorization_code"`
+		Email             string `json:"email"`
+		Amount            int64  `json:"amount"`
+		Reference         string `json:"reference"`
+		Currency          string `json:"currency"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Invalid body"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if owner, ok := f.authorizations[req.AuthorizationCode]; !ok || !strings.EqualFold(owner, req.Email) {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Invalid authorization code"})
+		return
+	}
+	if _, exists := f.transactions[req.Reference]; exists {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Duplicate Transaction Reference"})
+		return
+	}
+
+	tx := &FakeTransaction{
+		Reference: req.Reference,
+		Email:     req.Email,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Status:    "success",
+		PaidAt:    time.Now().UTC(),
+	}
+	gatewayResponse := "Approved"
+	if f.declineCharges {
+		tx.Status = "failed"
+		gatewayResponse = "Declined"
+	}
+	f.transactions[req.Reference] = tx
+	f.nextEventID++
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  true,
+		"message": "Charge attempted",
+		"data": map[string]interface{}{
+			"id":               f.nextEventID,
+			"reference":        tx.Reference,
+			"amount":           tx.Amount,
+			"currency":         tx.Currency,
+			"status":           tx.Status,
+			"gateway_response": gatewayResponse,
+			"paid_at":          tx.PaidAt.Format(time.RFC3339),
+			"channel":          "card",
+			"fees":             tx.Amount * 15 / 1000,
+			"customer":         map[string]interface{}{"email": tx.Email},
+			"authorization":    fakeAuthorization(req.AuthorizationCode, tx.Email),
+		},
+	})
+}
+
+func (f *FakePaystack) refund(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+
+	var req struct {
+		Transaction string `json:"transaction"`
+		Amount      int64  `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Invalid body"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tx, ok := f.transactions[req.Transaction]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"status": false, "message": "Transaction reference not found"})
+		return
+	}
+	if tx.Status != "success" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"status": false, "message": "Transaction has not been paid"})
+		return
+	}
+	tx.Status = "reversed"
+	f.nextEventID++
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  true,
+		"message": "Refund has been queued for processing",
+		"data":    map[string]interface{}{"id": f.nextEventID, "amount": req.Amount, "status": "pending"},
+	})
+}
+
+// fakeAuthorization is the test card every fake checkout is paid with. Its signature is the
+// same for each customer, so repeat deposits save one card.
+func fakeAuthorization(code, email string) map[string]interface{} {
+	return map[string]interface{}{
+		"authorization_code": code,
+		"signature":          "SIG_" + strings.ToLower(email),
+		"bin":                "408408",
+		"last4":              "4081",
+		"exp_month":          "12",
+		"exp_year":           "2030",
+		"channel":            "card",
+		"card_type":          "visa ",
+		"bank":               "TEST BANK",
+		"country_code":       "NG",
+		"brand":              "visa",
+		"reusable":           true,
+	}
+}
+
+func (f *FakePaystack) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") != FakePaystackSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"status": false, "message": "Invalid key"})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
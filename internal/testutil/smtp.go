@@ -0,0 +1,163 @@
+package testutil
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeEmail is an email received by the fake mail server
+type FakeEmail struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string // Decoded
+}
+
+// FakeSMTP is an in-process mail server that accepts every message without authentication
+// or TLS and keeps it for the test to inspect
+type FakeSMTP struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	emails []FakeEmail
+}
+
+// NewFakeSMTP starts a fake mail server that is shut down when the test finishes
+func NewFakeSMTP(t testing.TB) *FakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake SMTP server: %v", err)
+	}
+	s := &FakeSMTP{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// Host and Port are the address to configure as SMTP_HOST and SMTP_PORT
+func (s *FakeSMTP) Host() string {
+	host, _, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host
+}
+
+func (s *FakeSMTP) Port() int {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// WaitForEmail waits for an email to the address and returns it
+func (s *FakeSMTP) WaitForEmail(t testing.TB, to string) FakeEmail {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s.mu.Lock()
+		for _, email := range s.emails {
+			for _, recipient := range email.To {
+				if strings.EqualFold(recipient, to) {
+					s.mu.Unlock()
+					return email
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		if time.Now().After(deadline) {
+			t.Fatalf("no email to %s", to)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// serve speaks just enough SMTP for net/smtp to deliver a message
+func (s *FakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	var from string
+	var to []string
+	text.PrintfLine("220 fake-smtp ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 fake-smtp")
+		case "MAIL":
+			from = trimPath(arg)
+			to = nil
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			to = append(to, trimPath(arg))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			s.store(from, to, data)
+			text.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *FakeSMTP) store(from string, to []string, data []byte) {
+	email := FakeEmail{From: from, To: to}
+	if msg, err := netmail.ReadMessage(bufio.NewReader(strings.NewReader(string(data)))); err == nil {
+		email.Subject, _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		var body io.Reader = msg.Body
+		if strings.EqualFold(msg.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+			body = quotedprintable.NewReader(body)
+		}
+		decoded, _ := io.ReadAll(body)
+		email.Body = string(decoded)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emails = append(s.emails, email)
+}
+
+// trimPath turns "FROM:<a@b>" or "TO:<a@b>" into a@b
+func trimPath(arg string) string {
+	_, path, _ := strings.Cut(arg, ":")
+	path = strings.TrimSpace(path)
+	if end := strings.IndexByte(path, '>'); end >= 0 {
+		path = path[:end]
+	}
+	return strings.TrimPrefix(path, "<")
+}
@@ -8,11 +8,16 @@ import (
 	"github.com/google/uuid"
 )
 
+// PurposeTwoFactorChallenge marks a short-lived token that can only be exchanged for a session after 2FA
+const PurposeTwoFactorChallenge = "2fa_challenge"
+
 // JWTClaims represents the JWT token claims
 type JWTClaims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	Name   string    `json:"name"`
+	UserID      uuid.UUID        `json:"user_id"`
+	Email       string           `json:"email"`
+	Name        string           `json:"name"`
+	TwoFactorAt *jwt.NumericDate `json:"tfa_at,omitempty"`  // When the user last passed a 2FA check
+	Purpose     string           `json:"purpose,omitempty"` // Empty for session tokens
 	jwt.RegisteredClaims
 }
 
@@ -22,11 +27,38 @@ func GenerateJWT(userID uuid.UUID, email, name, secret string, expiration time.D
 		UserID: userID,
 		Email:  email,
 		Name:   name,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+	}
+	return signJWT(claims, secret, expiration)
+}
+
+// GenerateTwoFactorJWT creates a session token recording when the user passed 2FA
+func GenerateTwoFactorJWT(userID uuid.UUID, email, name string, verifiedAt time.Time, secret string, expiration time.Duration) (string, error) {
+	claims := JWTClaims{
+		UserID:      userID,
+		Email:       email,
+		Name:        name,
+		TwoFactorAt: jwt.NewNumericDate(verifiedAt),
+	}
+	return signJWT(claims, secret, expiration)
+}
+
+// GenerateChallengeJWT creates a token that proves the first login factor (Google) succeeded
+func GenerateChallengeJWT(userID uuid.UUID, email, name, secret string, expiration time.Duration) (string, error) {
+	claims := JWTClaims{
+		UserID:  userID,
+		Email:   email,
+		Name:    name,
+		Purpose: PurposeTwoFactorChallenge,
+	}
+	return signJWT(claims, secret, expiration)
+}
+
+func signJWT(claims JWTClaims, secret string, expiration time.Duration) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.NewString(), // Lets a token be recorded as used, e.g. a login challenge
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		NotBefore: jwt.NewNumericDate(time.Now()),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -38,8 +70,36 @@ func GenerateJWT(userID uuid.UUID, email, name, secret string, expiration time.D
 	return tokenString, nil
 }
 
-// ValidateJWT validates and parses a JWT token
+// ValidateJWT validates and parses a session JWT token
 func ValidateJWT(tokenString, secret string) (*JWTClaims, error) {
+	claims, err := parseJWT(tokenString, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// Challenge tokens must never be accepted as sessions
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
+
+// ValidateChallengeJWT validates a 2FA challenge token issued after Google login
+func ValidateChallengeJWT(tokenString, secret string) (*JWTClaims, error) {
+	claims, err := parseJWT(tokenString, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Purpose != PurposeTwoFactorChallenge {
+		return nil, fmt.Errorf("invalid challenge token")
+	}
+
+	return claims, nil
+}
+
+func parseJWT(tokenString, secret string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// Verify signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 SHA1 test key "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tc := range cases {
+		got, err := TOTPCode(rfcSecret, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tc.unix, err)
+		}
+		if got != tc.want {
+			t.Fatalf("TOTPCode at %d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", time.Unix(59, 0)); err == nil {
+		t.Fatal("TOTPCode with an invalid secret succeeded, want an error")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+	step := at.Unix() / totpPeriod
+
+	cases := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		ok     bool
+	}{
+		{"current step", rfcSecret, "005924", at, true},
+		{"lowercase secret and padding", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", " 005924\n", at, true},
+		{"one step late", rfcSecret, "005924", at.Add(totpPeriod * time.Second), true},
+		{"one step early", rfcSecret, "005924", at.Add(-totpPeriod * time.Second), true},
+		{"two steps late", rfcSecret, "005924", at.Add(2 * totpPeriod * time.Second), false},
+		{"wrong code", rfcSecret, "005925", at, false},
+		{"wrong length", rfcSecret, "05924", at, false},
+		{"invalid secret", "not base32!", "005924", at, false},
+	}
+
+	for _, tc := range cases {
+		matched, ok := ValidateTOTP(tc.secret, tc.code, tc.at)
+		if ok != tc.ok {
+			t.Fatalf("%s: ValidateTOTP ok = %v, want %v", tc.name, ok, tc.ok)
+		}
+		if ok && matched != step {
+			t.Fatalf("%s: ValidateTOTP step = %d, want %d", tc.name, matched, step)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("secret %q has %d characters, want 32 (160 bits)", secret, len(secret))
+	}
+	if _, err := TOTPCode(secret, time.Now()); err != nil {
+		t.Fatalf("generated secret doesn't decode: %v", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+
+	codes := GenerateRecoveryCodes(10)
+	if len(codes) != 10 {
+		t.Fatalf("got %d recovery codes, want 10", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Fatalf("recovery code %q isn't xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Fatalf("recovery code %q generated twice", code)
+		}
+		seen[code] = true
+
+		if got := NormalizeRecoveryCode("  " + code + "\n"); got != code {
+			t.Fatalf("NormalizeRecoveryCode of padded %q = %q", code, got)
+		}
+	}
+
+	if got := NormalizeRecoveryCode(" ABCDE-FGH23 "); got != "abcde-fgh23" {
+		t.Fatalf("NormalizeRecoveryCode = %q, want abcde-fgh23", got)
+	}
+}
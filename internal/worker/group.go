@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Group runs background workers and stops them together on shutdown
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	logger *slog.Logger
+}
+
+func NewGroup(logger *slog.Logger) *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel, logger: logger}
+}
+
+// Go starts a worker. fn must return promptly once ctx is cancelled.
+func (g *Group) Go(name string, fn func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				g.logger.Error("Worker panicked", "worker", name, "panic", r)
+			}
+		}()
+
+		g.logger.Info("Worker started", "worker", name)
+		fn(g.ctx)
+		g.logger.Info("Worker stopped", "worker", name)
+	}()
+}
+
+// Stop cancels every worker and waits for them to return, or for ctx to expire
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("workers did not stop in time: %w", ctx.Err())
+	}
+}
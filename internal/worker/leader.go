@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Leader elects one instance among those sharing a database by holding a Postgres
+// session-level advisory lock. The lock lives on a dedicated connection, so it is released
+// automatically if the instance dies or the connection drops.
+type Leader struct {
+	db     *sqlx.DB
+	key    int64
+	conn   *sql.Conn
+	logger *slog.Logger
+}
+
+func NewLeader(db *sqlx.DB, key int64, logger *slog.Logger) *Leader {
+	return &Leader{db: db, key: key, logger: logger}
+}
+
+// Acquire reports whether this instance is the leader, trying to take the lock if it
+// doesn't hold it yet. Callers poll it before each round of leader-only work. It is not
+// safe for concurrent use.
+func (l *Leader) Acquire(ctx context.Context) bool {
+	if l.conn != nil {
+		// Still leader as long as the connection holding the lock is alive
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true
+		}
+		l.logger.WarnContext(ctx, "Lost leader lock connection", "lock_key", l.key)
+		l.conn.Close()
+		l.conn = nil
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			l.logger.ErrorContext(ctx, "Failed to get connection for leader lock", "error", err)
+		}
+		return false
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&acquired); err != nil || !acquired {
+		if err != nil && ctx.Err() == nil {
+			l.logger.ErrorContext(ctx, "Failed to try leader lock", "error", err)
+		}
+		conn.Close()
+		return false
+	}
+
+	l.conn = conn
+	l.logger.InfoContext(ctx, "Acquired leader lock", "lock_key", l.key)
+	return true
+}
+
+// Release gives up leadership, if held
+func (l *Leader) Release(ctx context.Context) {
+	if l.conn == nil {
+		return
+	}
+
+	if _, err := l.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, l.key); err != nil {
+		l.logger.WarnContext(ctx, "Failed to release leader lock", "error", err)
+		// Discard the connection rather than return it to the pool still holding the lock;
+		// the session ending releases it
+		l.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	l.conn.Close()
+	l.conn = nil
+	l.logger.InfoContext(ctx, "Released leader lock", "lock_key", l.key)
+}
@@ -1,24 +1,26 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/franzego/stage08/config"
+	"github.com/franzego/stage08/internal/app"
 	"github.com/franzego/stage08/internal/database"
-	"github.com/franzego/stage08/internal/handlers"
-	"github.com/franzego/stage08/internal/middleware"
-	"github.com/franzego/stage08/internal/repository"
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
+	"github.com/franzego/stage08/internal/logger"
+	"github.com/franzego/stage08/internal/metrics"
+	"github.com/franzego/stage08/internal/server"
+	"github.com/franzego/stage08/internal/tracing"
 	"github.com/joho/godotenv"
 )
 
 func main() {
 	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using system environment variables")
-	}
+	envErr := godotenv.Load()
 
 	// Load configuration
 	cfg, err := config.Load()
@@ -26,163 +28,69 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
-	// Connect to database
-	db, err := database.Connect(&cfg.Database)
+	// Initialize structured logger
+	appLogger, err := logger.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal("Failed to initialize logger:", err)
 	}
-	defer db.Close()
+	slog.SetDefault(appLogger)
 
-	// Run migrations
-	if err := database.RunMigrations(db); err != nil {
-		log.Fatal("Failed to run migrations:", err)
+	if envErr != nil {
+		appLogger.Info("No .env file found, using system environment variables")
 	}
 
-	// Initialize repositories
-	userRepo := repository.NewUserRepository(db)
-	apiKeyRepo := repository.NewAPIKeyRepository(db)
-	walletRepo := repository.NewWalletRepository(db)
-	txRepo := repository.NewTransactionRepository(db)
-
-	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(userRepo, cfg)
-	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyRepo)
-	walletHandler := handlers.NewWalletHandler(walletRepo, txRepo, db)
-	paystackHandler := handlers.NewPaystackHandler(&cfg.Paystack, walletRepo, txRepo, db)
-
-	// Initialize Gin router
-	router := gin.Default()
-
-	// Enable CORS
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "x-api-key", "x-paystack-signature"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
-
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":  "ok",
-			"message": "Wallet service is running",
-		})
-	})
-
-	// Swagger documentation endpoint
-	router.GET("/swagger.yaml", func(c *gin.Context) {
-		data, err := os.ReadFile("swagger.yaml")
-		if err != nil {
-			c.JSON(404, gin.H{"error": "Swagger file not found"})
-			return
-		}
-		c.Data(200, "application/x-yaml", data)
-	})
-
-	// Swagger UI endpoint
-	router.GET("/swagger", func(c *gin.Context) {
-		c.Header("Content-Type", "text/html")
-		c.String(200, `<!DOCTYPE html>
-<html>
-<head>
-    <title>Wallet Service API Documentation</title>
-    <link rel="stylesheet" type="text/css" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
-</head>
-<body>
-    <div id="swagger-ui"></div>
-    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
-    <script>
-        window.onload = function() {
-            SwaggerUIBundle({
-                url: '/swagger.yaml',
-                dom_id: '#swagger-ui',
-                deepLinking: true,
-                presets: [
-                    SwaggerUIBundle.presets.apis,
-                    SwaggerUIBundle.SwaggerUIStandalonePreset
-                ]
-            })
-        }
-    </script>
-</body>
-</html>`)
-	})
-
-	// Auth routes (no authentication required)
-	authGroup := router.Group("/auth")
-	{
-		authGroup.GET("/google", authHandler.GoogleLogin)
-		authGroup.GET("/google/callback", authHandler.GoogleCallback)
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), &cfg.Tracing)
+	if err != nil {
+		appLogger.Error("Failed to initialize tracing", "error", err)
+		os.Exit(1)
 	}
 
-	// API Key routes (JWT required)
-	keysGroup := router.Group("/keys")
-	keysGroup.Use(middleware.JWTAuth(cfg.JWT.Secret))
-	{
-		keysGroup.POST("/create", apiKeyHandler.CreateAPIKey)
-		keysGroup.POST("/rollover", apiKeyHandler.RolloverAPIKey)
-		keysGroup.GET("/list", apiKeyHandler.ListAPIKeys)
-		keysGroup.POST("/revoke", apiKeyHandler.RevokeAPIKey)
+	// Connect to database
+	db, err := database.Connect(&cfg.Database, appLogger)
+	if err != nil {
+		appLogger.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
 	}
+	metrics.RegisterDBStats(db.DB)
 
-	// Wallet routes (JWT or API key required)
-	walletGroup := router.Group("/wallet")
-	walletGroup.Use(middleware.AuthMiddleware(cfg.JWT.Secret, apiKeyRepo))
-	{
-		// Balance endpoint - requires 'read' permission
-		walletGroup.GET("/balance",
-			middleware.RequirePermission("read"),
-			walletHandler.GetBalance,
-		)
-
-		// Transaction history - requires 'read' permission
-		walletGroup.GET("/transactions",
-			middleware.RequirePermission("read"),
-			walletHandler.GetTransactions,
-		)
+	// Run migrations
+	if err := database.RunMigrations(db, appLogger); err != nil {
+		appLogger.Error("Failed to run migrations", "error", err)
+		os.Exit(1)
+	}
 
-		// Deposit endpoint - requires 'deposit' permission
-		walletGroup.POST("/deposit",
-			middleware.RequirePermission("deposit"),
-			paystackHandler.InitializeDeposit,
-		)
+	// Wire repositories, services, handlers and routes
+	application := app.New(cfg, db, appLogger)
 
-		// Transfer endpoint - requires 'transfer' permission
-		walletGroup.POST("/transfer",
-			middleware.RequirePermission("transfer"),
-			walletHandler.Transfer,
-		)
+	// Serve until SIGINT/SIGTERM, then drain in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// Deposit status check - requires 'read' permission
-		walletGroup.GET("/deposit/:reference/status",
-			middleware.RequirePermission("read"),
-			paystackHandler.GetDepositStatus,
-		)
+	exitCode := 0
+	srv := server.New(&cfg.Server, application.Router)
+	if err := server.Run(ctx, srv, &cfg.Server, appLogger); err != nil {
+		appLogger.Error("Server error", "error", err)
+		exitCode = 1
 	}
 
-	// Paystack webhook (no authentication - validated by signature)
-	router.POST("/wallet/paystack/webhook", paystackHandler.PaystackWebhook)
-
-	// Protected routes (JWT required) - for testing
-	protectedGroup := router.Group("/")
-	protectedGroup.Use(middleware.JWTAuth(cfg.JWT.Secret))
-	{
-		// Test protected endpoint
-		protectedGroup.GET("/me", func(c *gin.Context) {
-			userID, _ := middleware.GetUserID(c)
-			email := middleware.GetUserEmail(c)
+	// Requests have drained; stop workers, flush traces and close the database last
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
 
-			c.JSON(200, gin.H{
-				"user_id": userID,
-				"email":   email,
-			})
-		})
+	if err := application.Workers.Stop(shutdownCtx); err != nil {
+		appLogger.Error("Failed to stop workers", "error", err)
+		exitCode = 1
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		appLogger.Error("Failed to flush traces", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		appLogger.Error("Failed to close database", "error", err)
 	}
 
-	// Start server
-	log.Printf("Server starting on port %s...", cfg.Server.Port)
-	if err := router.Run(":" + cfg.Server.Port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	appLogger.Info("Shutdown complete")
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
 }
@@ -0,0 +1,5 @@
+-- Rollback two-factor authentication tables
+DROP INDEX IF EXISTS idx_recovery_codes_user_hash;
+DROP INDEX IF EXISTS idx_recovery_codes_user_id;
+DROP TABLE IF EXISTS two_factor_recovery_codes;
+DROP TABLE IF EXISTS user_two_factor;
@@ -0,0 +1,6 @@
+-- Rollback audit_events table
+DROP TRIGGER IF EXISTS enforce_audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS prevent_audit_event_mutation();
+DROP INDEX IF EXISTS idx_audit_events_action;
+DROP INDEX IF EXISTS idx_audit_events_owner_created;
+DROP TABLE IF EXISTS audit_events;
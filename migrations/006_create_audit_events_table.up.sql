@@ -0,0 +1,36 @@
+-- Create audit_events table
+-- Append-only record of security and money events. Each row stores the hash of the
+-- previous row so that edits or deletions break the chain and can be detected.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    owner_user_id UUID, -- Account the event belongs to (no FK so history survives user deletion)
+    actor_user_id UUID,
+    actor_api_key_id UUID,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    action VARCHAR(100) NOT NULL, -- e.g., api_key.create, transfer.create
+    target_type VARCHAR(50),
+    target_id VARCHAR(255),
+    before JSON, -- JSON (not JSONB) keeps the exact bytes that were hashed
+    after JSON,
+    prev_hash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_audit_events_owner_created ON audit_events(owner_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action);
+
+-- Reject any modification of existing audit rows
+CREATE OR REPLACE FUNCTION prevent_audit_event_mutation() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS enforce_audit_events_append_only ON audit_events;
+CREATE TRIGGER enforce_audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_audit_event_mutation();
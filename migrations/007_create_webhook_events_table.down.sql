@@ -0,0 +1,4 @@
+-- Rollback webhook_events table
+DROP INDEX IF EXISTS idx_webhook_events_received;
+DROP INDEX IF EXISTS idx_webhook_events_due;
+DROP TABLE IF EXISTS webhook_events;
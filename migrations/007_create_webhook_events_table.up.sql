@@ -0,0 +1,22 @@
+-- Create webhook_events table
+-- Every verified provider webhook is stored before it is processed, so events can be
+-- deduplicated, retried by a background worker and inspected or replayed by admins.
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    provider VARCHAR(20) NOT NULL, -- e.g., paystack
+    event_id VARCHAR(255) NOT NULL, -- Provider's event id, used to drop redeliveries
+    event_type VARCHAR(100) NOT NULL, -- e.g., charge.success
+    payload JSON NOT NULL, -- JSON (not JSONB) keeps the exact bytes that were signed
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, processed, ignored or failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(), -- Also the lease while an attempt runs
+    processed_at TIMESTAMP WITH TIME ZONE,
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, event_type, event_id)
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_webhook_events_due ON webhook_events(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_events_received ON webhook_events(received_at DESC);
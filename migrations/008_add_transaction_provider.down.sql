@@ -0,0 +1,2 @@
+-- Rollback transactions.provider
+ALTER TABLE transactions DROP COLUMN IF EXISTS provider;
@@ -0,0 +1,5 @@
+-- Record which payment provider took a deposit
+-- NULL for transfers; deposits made before providers were configurable went through Paystack
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS provider VARCHAR(20);
+
+UPDATE transactions SET provider = 'paystack' WHERE type = 'deposit' AND provider IS NULL;
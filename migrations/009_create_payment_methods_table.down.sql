@@ -0,0 +1,3 @@
+-- Rollback payment_methods table
+DROP INDEX IF EXISTS idx_payment_methods_user_id;
+DROP TABLE IF EXISTS payment_methods;
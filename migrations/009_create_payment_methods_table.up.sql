@@ -0,0 +1,24 @@
+-- Create payment_methods table
+-- Cards saved from successful deposits, which can be charged again without a checkout.
+CREATE TABLE IF NOT EXISTS payment_methods (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(20) NOT NULL, -- e.g., paystack
+    authorization_code VARCHAR(100) NOT NULL, -- Charges the card; never exposed
+    signature VARCHAR(100) NOT NULL, -- Provider's card fingerprint, so a card is saved once
+    email VARCHAR(255) NOT NULL, -- Customer the card was saved for; charges must use it
+    last4 VARCHAR(4) NOT NULL,
+    brand VARCHAR(30) NOT NULL, -- e.g., visa
+    card_type VARCHAR(30),
+    bank VARCHAR(100),
+    exp_month VARCHAR(2) NOT NULL,
+    exp_year VARCHAR(4) NOT NULL,
+    reusable BOOLEAN NOT NULL DEFAULT false, -- Only reusable cards can be charged again
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, provider, signature)
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_payment_methods_user_id ON payment_methods(user_id);
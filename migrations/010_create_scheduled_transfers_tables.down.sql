@@ -0,0 +1,6 @@
+-- Rollback scheduled_transfers and scheduled_transfer_runs tables
+DROP INDEX IF EXISTS idx_scheduled_transfer_runs_scheduled_transfer_id;
+DROP INDEX IF EXISTS idx_scheduled_transfers_next_run_at;
+DROP INDEX IF EXISTS idx_scheduled_transfers_user_id;
+DROP TABLE IF EXISTS scheduled_transfer_runs;
+DROP TABLE IF EXISTS scheduled_transfers;
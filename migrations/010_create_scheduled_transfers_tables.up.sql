@@ -0,0 +1,41 @@
+-- Create scheduled_transfers and scheduled_transfer_runs tables
+-- Standing orders: transfers the scheduler repeats on a cron or interval schedule.
+CREATE TABLE IF NOT EXISTS scheduled_transfers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_wallet_number VARCHAR(20) NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0), -- in kobo
+    description TEXT,
+    cron_expr VARCHAR(100), -- Exactly one of cron_expr and interval_spec is set
+    interval_spec VARCHAR(10), -- e.g., 1D, 2W, 1M; counted from start_at
+    start_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_at TIMESTAMP WITH TIME ZONE, -- No runs after this time
+    max_occurrences INTEGER, -- Completes after this many successful runs
+    occurrences INTEGER NOT NULL DEFAULT 0, -- Successful runs so far
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, paused, completed, cancelled
+    pause_reason VARCHAR(100),
+    occurrence_at TIMESTAMP WITH TIME ZONE, -- Scheduled time of the occurrence being attempted
+    next_run_at TIMESTAMP WITH TIME ZONE, -- When the scheduler next attempts it (later than occurrence_at while retrying)
+    failure_count INTEGER NOT NULL DEFAULT 0, -- Consecutive insufficient-funds failures
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK ((cron_expr IS NULL) <> (interval_spec IS NULL))
+);
+
+-- One row per attempt, successful or not
+CREATE TABLE IF NOT EXISTS scheduled_transfer_runs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    scheduled_transfer_id UUID NOT NULL REFERENCES scheduled_transfers(id) ON DELETE CASCADE,
+    occurrence_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL, -- success, failed
+    reference VARCHAR(100), -- Transfer reference on success
+    error_code VARCHAR(50),
+    error_message TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_user_id ON scheduled_transfers(user_id);
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_next_run_at ON scheduled_transfers(next_run_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfer_runs_scheduled_transfer_id ON scheduled_transfer_runs(scheduled_transfer_id, created_at DESC);
@@ -0,0 +1,7 @@
+-- Rollback payment_requests and payment_links tables
+DROP INDEX IF EXISTS idx_payment_links_user_id;
+DROP INDEX IF EXISTS idx_payment_requests_payer_email;
+DROP INDEX IF EXISTS idx_payment_requests_payer_user_id;
+DROP INDEX IF EXISTS idx_payment_requests_requester_user_id;
+DROP TABLE IF EXISTS payment_links;
+DROP TABLE IF EXISTS payment_requests;
@@ -0,0 +1,40 @@
+-- Create payment_requests and payment_links tables
+-- Payment requests ask a wallet owner to pay; the payer accepts (which transfers the amount) or declines.
+CREATE TABLE IF NOT EXISTS payment_requests (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    requester_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_wallet_number VARCHAR(20) NOT NULL, -- Where the money goes on accept
+    payer_user_id UUID REFERENCES users(id) ON DELETE CASCADE, -- NULL until someone with payer_email signs up
+    payer_wallet_number VARCHAR(20), -- Set when the request was addressed to a wallet number
+    payer_email VARCHAR(255), -- Set when the request was addressed to an email, lowercase
+    amount BIGINT NOT NULL CHECK (amount > 0), -- in kobo
+    memo TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, accepted, declined, cancelled; pending past expires_at reads as expired
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    transfer_reference VARCHAR(100), -- Transfer that paid an accepted request
+    responded_at TIMESTAMP WITH TIME ZONE, -- When it was accepted, declined or cancelled
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (payer_user_id IS NOT NULL OR payer_email IS NOT NULL)
+);
+
+-- Public links anyone can pay into a wallet through, via a provider checkout
+CREATE TABLE IF NOT EXISTS payment_links (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code VARCHAR(32) UNIQUE NOT NULL, -- Public identifier in the link URL
+    amount BIGINT CHECK (amount > 0), -- Fixed amount in kobo; NULL lets the payer choose
+    description TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    expires_at TIMESTAMP WITH TIME ZONE, -- NULL never expires
+    payment_count INTEGER NOT NULL DEFAULT 0, -- Settled payments
+    total_received BIGINT NOT NULL DEFAULT 0, -- Sum of settled payments, in kobo
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_payment_requests_requester_user_id ON payment_requests(requester_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_payer_user_id ON payment_requests(payer_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_payment_requests_payer_email ON payment_requests(payer_email) WHERE payer_user_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_payment_links_user_id ON payment_links(user_id, created_at DESC);
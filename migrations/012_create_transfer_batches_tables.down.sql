@@ -0,0 +1,6 @@
+-- Rollback transfer_batches and transfer_batch_items tables
+DROP INDEX IF EXISTS idx_transfer_batch_items_pending;
+DROP INDEX IF EXISTS idx_transfer_batches_unfinished;
+DROP INDEX IF EXISTS idx_transfer_batches_user_id;
+DROP TABLE IF EXISTS transfer_batch_items;
+DROP TABLE IF EXISTS transfer_batches;
@@ -0,0 +1,43 @@
+-- Create transfer_batches and transfer_batch_items tables
+-- Bulk transfers: the total is reserved from the sender's wallet on upload, then each item is paid in the background.
+CREATE TABLE IF NOT EXISTS transfer_batches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    wallet_id UUID NOT NULL REFERENCES wallets(id) ON DELETE CASCADE, -- Wallet the transfers are paid from
+    mode VARCHAR(20) NOT NULL, -- all_or_nothing, best_effort
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, processing, completed, failed
+    total_items INTEGER NOT NULL, -- Every uploaded line, valid or not
+    total_amount BIGINT NOT NULL, -- Sum of the valid lines, reserved on upload, in kobo
+    succeeded_count INTEGER NOT NULL DEFAULT 0,
+    failed_count INTEGER NOT NULL DEFAULT 0, -- Includes lines rejected on upload
+    succeeded_amount BIGINT NOT NULL DEFAULT 0,
+    refunded_amount BIGINT NOT NULL DEFAULT 0, -- Reserved for items that failed and returned to the wallet
+    claimed_until TIMESTAMP WITH TIME ZONE, -- Lease held by the worker paying the batch
+    completed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- One row per uploaded line
+CREATE TABLE IF NOT EXISTS transfer_batch_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    batch_id UUID NOT NULL REFERENCES transfer_batches(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL, -- 1-based position in the upload
+    recipient_wallet_number TEXT NOT NULL, -- As uploaded; may not be a valid wallet number
+    amount BIGINT NOT NULL, -- in kobo; as uploaded, so not checked here
+    description TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, success, failed
+    debit_transaction_id UUID REFERENCES transactions(id), -- Pending transfer_out reserving the amount
+    reference VARCHAR(100), -- Transfer reference
+    error_code VARCHAR(50),
+    error_message TEXT,
+    processed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (batch_id, line)
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_transfer_batches_user_id ON transfer_batches(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_transfer_batches_unfinished ON transfer_batches(created_at) WHERE status IN ('pending', 'processing');
+CREATE INDEX IF NOT EXISTS idx_transfer_batch_items_pending ON transfer_batch_items(batch_id, line) WHERE status = 'pending';
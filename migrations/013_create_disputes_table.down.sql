@@ -0,0 +1,8 @@
+-- Rollback disputes table and transactions.reversal_of
+DROP INDEX IF EXISTS idx_transactions_reversal_of;
+DROP INDEX IF EXISTS idx_disputes_status;
+DROP INDEX IF EXISTS idx_disputes_recipient_user_id;
+DROP INDEX IF EXISTS idx_disputes_sender_user_id;
+DROP INDEX IF EXISTS idx_disputes_transfer_reference;
+ALTER TABLE transactions DROP COLUMN IF EXISTS reversal_of;
+DROP TABLE IF EXISTS disputes;
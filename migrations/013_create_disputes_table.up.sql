@@ -0,0 +1,34 @@
+-- Create disputes table and link reversal entries to the transactions they compensate
+-- The sender of a transfer disputes it; the recipient approves (returning the money) or rejects it,
+-- and an admin can force the reversal. The original ledger entries are never changed.
+CREATE TABLE IF NOT EXISTS disputes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transfer_reference VARCHAR(100) NOT NULL, -- Disputed transfer, as returned by POST /wallet/transfer
+    debit_transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE, -- Sender's transfer_out
+    credit_transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE, -- Recipient's transfer_in
+    sender_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    sender_wallet_id UUID NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    recipient_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_wallet_id UUID NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+    amount BIGINT NOT NULL CHECK (amount > 0), -- in kobo
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open', -- open, reversed, rejected, cancelled
+    resolution VARCHAR(20), -- approved (by the recipient) or forced (by an admin), once reversed
+    resolution_note TEXT, -- Recipient's reason for rejecting, or the admin's note
+    resolved_by_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    reversal_reference VARCHAR(100), -- Compensating transfer written when reversed
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Reversal entries point at the entry they compensate
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reversal_of UUID REFERENCES transactions(id) ON DELETE SET NULL;
+
+-- Indexes
+-- Only a cancelled dispute lets the sender dispute the same transfer again
+CREATE UNIQUE INDEX IF NOT EXISTS idx_disputes_transfer_reference ON disputes(transfer_reference) WHERE status <> 'cancelled';
+CREATE INDEX IF NOT EXISTS idx_disputes_sender_user_id ON disputes(sender_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_disputes_recipient_user_id ON disputes(recipient_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_disputes_status ON disputes(status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_transactions_reversal_of ON transactions(reversal_of) WHERE reversal_of IS NOT NULL;
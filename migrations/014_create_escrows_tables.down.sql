@@ -0,0 +1,9 @@
+-- Rollback escrows and escrow_events tables and the system escrow account
+DROP INDEX IF EXISTS idx_escrow_events_escrow_id;
+DROP INDEX IF EXISTS idx_escrows_arbiter_user_id;
+DROP INDEX IF EXISTS idx_escrows_seller_user_id;
+DROP INDEX IF EXISTS idx_escrows_buyer_user_id;
+DROP TABLE IF EXISTS escrow_events;
+DROP TABLE IF EXISTS escrows;
+DELETE FROM users WHERE id = '00000000-0000-4000-8000-00000000e5c0';
+ALTER TABLE wallets DROP COLUMN IF EXISTS is_system;
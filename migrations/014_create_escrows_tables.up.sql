@@ -0,0 +1,55 @@
+-- Create escrows and escrow_events tables, and the system escrow account
+-- An escrow holds a buyer's payment in the system escrow wallet until it is released to the seller,
+-- refunded to the buyer, or split between them.
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS is_system BOOLEAN NOT NULL DEFAULT false; -- Can't be addressed by wallet number
+
+-- The system escrow account. Its google_id and .invalid email can never match a real sign-in.
+INSERT INTO users (id, google_id, email, name)
+VALUES ('00000000-0000-4000-8000-00000000e5c0', 'system:escrow', 'escrow@system.invalid', 'Escrow')
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO wallets (user_id, wallet_number, is_system)
+SELECT '00000000-0000-4000-8000-00000000e5c0', generate_wallet_number(), true
+WHERE NOT EXISTS (SELECT 1 FROM wallets WHERE user_id = '00000000-0000-4000-8000-00000000e5c0');
+
+CREATE TABLE IF NOT EXISTS escrows (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    buyer_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    buyer_wallet_id UUID NOT NULL REFERENCES wallets(id) ON DELETE CASCADE, -- Funded from, and refunded to
+    buyer_wallet_number VARCHAR(20) NOT NULL,
+    seller_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seller_wallet_id UUID NOT NULL REFERENCES wallets(id) ON DELETE CASCADE, -- Released to
+    seller_wallet_number VARCHAR(20) NOT NULL,
+    arbiter_user_id UUID REFERENCES users(id) ON DELETE SET NULL, -- May release, refund or split
+    amount BIGINT NOT NULL CHECK (amount > 0), -- Held, in kobo
+    released_amount BIGINT NOT NULL DEFAULT 0, -- Paid to the seller
+    refunded_amount BIGINT NOT NULL DEFAULT 0, -- Returned to the buyer
+    description TEXT,
+    conditions TEXT, -- Release conditions agreed between buyer and seller
+    deadline TIMESTAMP WITH TIME ZONE, -- After this the buyer may refund an unreleased escrow themselves
+    status VARCHAR(20) NOT NULL DEFAULT 'funded', -- funded, released, refunded, split
+    funding_reference VARCHAR(100) NOT NULL, -- Transfer from the buyer into escrow
+    settled_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (released_amount + refunded_amount <= amount)
+);
+
+-- Append-only history of what happened to each escrow and who did it
+CREATE TABLE IF NOT EXISTS escrow_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    escrow_id UUID NOT NULL REFERENCES escrows(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL, -- funded, released, refunded, split
+    actor_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    seller_amount BIGINT NOT NULL DEFAULT 0, -- Moved to the seller by this event, in kobo
+    buyer_amount BIGINT NOT NULL DEFAULT 0, -- Moved to (or, when funded, from) the buyer
+    reference VARCHAR(100), -- Transfer written by this event
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_escrows_buyer_user_id ON escrows(buyer_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_escrows_seller_user_id ON escrows(seller_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_escrows_arbiter_user_id ON escrows(arbiter_user_id, created_at DESC) WHERE arbiter_user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_escrow_events_escrow_id ON escrow_events(escrow_id, created_at);
@@ -0,0 +1,8 @@
+-- Rollback wallet names and default wallets
+-- Fails while any user still has more than one wallet; move their money and remove the extra
+-- wallets first.
+DROP INDEX IF EXISTS idx_wallets_user_name;
+DROP INDEX IF EXISTS idx_wallets_user_default;
+ALTER TABLE wallets DROP COLUMN IF EXISTS is_default;
+ALTER TABLE wallets DROP COLUMN IF EXISTS name;
+ALTER TABLE wallets ADD CONSTRAINT wallets_user_id_key UNIQUE (user_id);
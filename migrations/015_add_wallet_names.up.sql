@@ -0,0 +1,15 @@
+-- Allow several named wallets per user, one of them the default
+-- Features that don't say which wallet to use (payment links, scheduled transfers, escrow, ...)
+-- use the default one.
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS wallets_user_id_key;
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS name VARCHAR(50) NOT NULL DEFAULT 'Main'; -- Unique per user, ignoring case
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT false; -- Exactly one per user
+
+-- Every wallet created before this migration was its user's only one
+UPDATE wallets w SET is_default = true
+WHERE NOT EXISTS (SELECT 1 FROM wallets d WHERE d.user_id = w.user_id AND d.is_default)
+  AND w.created_at = (SELECT MIN(created_at) FROM wallets o WHERE o.user_id = w.user_id);
+
+-- Indexes
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wallets_user_default ON wallets(user_id) WHERE is_default;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wallets_user_name ON wallets(user_id, LOWER(name));
@@ -0,0 +1,11 @@
+-- Rollback organizations, organization_members and organization_invitations tables
+-- Organization account users and their wallets are left in place.
+DROP INDEX IF EXISTS idx_api_keys_organization_id;
+DROP INDEX IF EXISTS idx_organization_invitations_email;
+DROP INDEX IF EXISTS idx_organization_invitations_pending;
+DROP INDEX IF EXISTS idx_organization_invitations_organization_id;
+DROP INDEX IF EXISTS idx_organization_members_user_id;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,46 @@
+-- Create organizations, organization_members and organization_invitations tables
+-- An organization owns its wallets through an account user of its own, so everything that works
+-- on a user's wallets works on the organization's. Members act on them with the permissions
+-- of their role.
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    account_user_id UUID UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE, -- Owns the organization's wallets and API keys
+    created_by_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'finance', 'viewer')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, user_id)
+);
+
+-- Invitations are addressed to an email; whoever signs in with it can accept
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL, -- Stored lower-case
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'finance', 'viewer')),
+    invited_by_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, accepted, declined, revoked, expired
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    responded_at TIMESTAMP WITH TIME ZONE, -- When it was accepted, declined or revoked
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Keys issued by an organization belong to its account user rather than to the member who created them
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE;
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_organization_invitations_organization_id ON organization_invitations(organization_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organization_invitations_pending ON organization_invitations(organization_id, email) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_organization_invitations_email ON organization_invitations(email) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_api_keys_organization_id ON api_keys(organization_id) WHERE organization_id IS NOT NULL;
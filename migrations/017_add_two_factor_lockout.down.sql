@@ -0,0 +1,4 @@
+-- Rollback second-factor lockout and single-use challenges
+DROP TABLE IF EXISTS used_two_factor_challenges;
+ALTER TABLE user_two_factor DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE user_two_factor DROP COLUMN IF EXISTS failed_attempts;
@@ -0,0 +1,15 @@
+-- Limit guesses at second-factor codes
+-- Failed attempts are counted per user and lock verification for a while once they reach the
+-- limit. Login challenge tokens are recorded when used, so each one completes at most one login.
+ALTER TABLE user_two_factor ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0; -- Since the last successful check
+ALTER TABLE user_two_factor ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE; -- Codes are refused until then
+
+CREATE TABLE IF NOT EXISTS used_two_factor_challenges (
+    id UUID PRIMARY KEY, -- The challenge token's jti
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL, -- Rows are pruned once the token has expired
+    used_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_used_two_factor_challenges_expires_at ON used_two_factor_challenges(expires_at);
@@ -0,0 +1,3 @@
+-- Rollback emails table
+DROP INDEX IF EXISTS idx_emails_due;
+DROP TABLE IF EXISTS emails;
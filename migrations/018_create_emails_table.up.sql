@@ -0,0 +1,20 @@
+-- Create emails table
+-- Outgoing emails are queued here in the transaction that causes them, so an email is sent if
+-- and only if the change it announces commits. A background worker sends them and retries
+-- failures.
+CREATE TABLE IF NOT EXISTS emails (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    recipient VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL, -- Plain text
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, sent or failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(), -- Also the lease while an attempt runs
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_emails_due ON emails(next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+-- Rollback scheduled transfer wallets
+ALTER TABLE scheduled_transfers DROP COLUMN IF EXISTS from_wallet_number;
+ALTER TABLE scheduled_transfers DROP COLUMN IF EXISTS wallet_id;
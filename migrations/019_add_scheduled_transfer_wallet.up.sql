@@ -0,0 +1,12 @@
+-- Pay each scheduled transfer from a chosen wallet
+-- Scheduled transfers used to pay from whichever wallet was the user's default when they ran;
+-- existing ones keep paying from the wallet that is the default now.
+ALTER TABLE scheduled_transfers ADD COLUMN IF NOT EXISTS wallet_id UUID REFERENCES wallets(id) ON DELETE CASCADE; -- Wallet the transfers are paid from
+ALTER TABLE scheduled_transfers ADD COLUMN IF NOT EXISTS from_wallet_number VARCHAR(20);
+
+UPDATE scheduled_transfers s SET wallet_id = w.id, from_wallet_number = w.wallet_number
+FROM wallets w
+WHERE s.wallet_id IS NULL AND w.user_id = s.user_id AND w.is_default;
+
+ALTER TABLE scheduled_transfers ALTER COLUMN wallet_id SET NOT NULL;
+ALTER TABLE scheduled_transfers ALTER COLUMN from_wallet_number SET NOT NULL;
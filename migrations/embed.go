@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migration files so the binary (and tests) can run them
+// regardless of the working directory.
+package migrations
+
+import "embed"
+
+// FS holds every *.sql file in this directory
+//
+//go:embed *.sql
+var FS embed.FS